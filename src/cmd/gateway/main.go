@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"go-oauth2-proxy/src/internal/config"
+	"go-oauth2-proxy/src/internal/lifecycle"
 	"go-oauth2-proxy/src/internal/logger"
 	"go-oauth2-proxy/src/internal/proxy"
+	"go-oauth2-proxy/src/internal/token"
 )
 
 func main() {
@@ -16,11 +23,20 @@ func main() {
 	configPath := flag.String("config", "config.yaml", "Path to configuration file")
 	credsPath := flag.String("credentials", "", "Path to GCP service account JSON file (or set GOOGLE_APPLICATION_CREDENTIALS)")
 	logLevel := flag.String("log-level", "info", "Log level (debug, info, warn, error)")
+	mintAudience := flag.String("mint-token", "", "Mint an ID token for this audience, print it and exit")
+	decode := flag.Bool("decode", false, "With -mint-token, print the token's claims instead of the raw token")
 	flag.Parse()
 
 	// Initialize logger
 	logger.Init(*logLevel)
+
+	if *mintAudience != "" {
+		os.Exit(mintToken(*mintAudience, *credsPath, *decode))
+	}
+
 	logger.Info("Starting Token Gateway")
+	events := lifecycle.NewRecorder()
+	events.Emit(lifecycle.StartupBegin, "version", proxy.Version)
 
 	// Load configuration
 	cfg, err := config.Load(*configPath)
@@ -28,37 +44,60 @@ func main() {
 		logger.Fatal("Failed to load configuration", "error", err)
 	}
 	logger.Info("Configuration loaded", "upstreams", len(cfg.Upstreams))
+	for _, mismatch := range cfg.AudienceMismatches() {
+		logger.Warn("Upstream audience does not match its url; tokens will likely be rejected with 401",
+			"detail", mismatch)
+	}
+	events.Emit(lifecycle.ConfigLoaded, "upstreams", len(cfg.Upstreams))
 
 	// Set credentials path
 	if *credsPath != "" {
 		os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", *credsPath)
 	}
 
-	credsFile := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
-	if credsFile == "" {
-		logger.Fatal("GOOGLE_APPLICATION_CREDENTIALS environment variable not set")
+	// Without a key file, tokens come from Application Default Credentials
+	// (e.g., the metadata server on GCE, Cloud Run or GKE). When those cannot
+	// be found either, say so now; only audiences with their own credentials
+	// file will get tokens.
+	if credsFile := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); credsFile != "" {
+		logger.Info("Using credentials file", "path", credsFile)
+	} else if err := token.CheckDefaultCredentials(context.Background()); err != nil {
+		logger.Error("No credentials available; tokens cannot be minted with the default credentials", "error", err)
+	} else {
+		logger.Info("Using Application Default Credentials",
+			"hint", "set GOOGLE_APPLICATION_CREDENTIALS, or pass -credentials, to use a service account key file")
 	}
-	logger.Info("Using credentials file", "path", credsFile)
 
 	// Create and start proxy server
 	srv, err := proxy.NewServer(cfg)
 	if err != nil {
 		logger.Fatal("Failed to create proxy server", "error", err)
 	}
+	srv.SetLifecycle(events)
+	warmed, err := srv.MintStartupToken(context.Background())
+	if err != nil {
+		logger.Fatal("Failed to mint a token at startup", "error", err)
+	}
+	if warmed {
+		events.Emit(lifecycle.WarmupComplete)
+	}
 
 	// Start server in a goroutine
 	go func() {
 		addr := cfg.Server.GetAddress()
 		logger.Info("Server starting", "address", addr)
-		if err := srv.Start(); err != nil {
+		if err := srv.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			logger.Fatal("Server failed", "error", err)
 		}
 	}()
 
-	// Wait for interrupt signal
+	// Wait for interrupt signal or an admin drain request
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	select {
+	case <-quit:
+	case <-srv.ShutdownRequested():
+	}
 
 	logger.Info("Shutting down server...")
 	if err := srv.Shutdown(); err != nil {
@@ -66,3 +105,38 @@ func main() {
 	}
 	logger.Info("Server stopped")
 }
+
+// mintToken mints a token for the audience through the token manager and
+// prints it (or its claims) to stdout, for verifying credentials and
+// audiences without starting the server. It returns the exit code.
+func mintToken(audience, credsPath string, decode bool) int {
+	// Keep stdout for the token itself
+	logger.SetOutput(os.Stderr)
+
+	if credsPath != "" {
+		os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", credsPath)
+	}
+
+	tm := token.NewManager(context.Background(), os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"), 0)
+	defer tm.Close()
+
+	idToken, err := tm.GetToken(audience)
+	if err != nil {
+		logger.Error("Failed to mint token", "audience", audience, "error", err)
+		return 1
+	}
+
+	if !decode {
+		fmt.Println(idToken)
+		return 0
+	}
+
+	claims, err := token.DecodeClaims(idToken)
+	if err != nil {
+		logger.Error("Failed to decode token", "error", err)
+		return 1
+	}
+	out, _ := json.MarshalIndent(claims, "", "  ")
+	fmt.Println(string(out))
+	return 0
+}
@@ -1,10 +1,22 @@
 package config
 
 import (
+	"crypto/x509"
 	"fmt"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 
+	"golang.org/x/net/http/httpguts"
 	"gopkg.in/yaml.v3"
+
+	"go-oauth2-proxy/src/internal/logger"
 )
 
 // Config represents the application configuration
@@ -13,6 +25,17 @@ type Config struct {
 	Upstreams []UpstreamConfig `yaml:"upstreams"`
 	Logging   LoggingConfig   `yaml:"logging"`
 	Token     TokenConfig     `yaml:"token"`
+	Admin     AdminConfig     `yaml:"admin"`
+
+	Aggregates []AggregateConfig `yaml:"aggregates"`
+
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+
+	Routing RoutingConfig `yaml:"routing"`
+
+	Tee TeeConfig `yaml:"tee"`
+
+	Discovery DiscoveryConfig `yaml:"discovery"`
 }
 
 // ServerConfig holds server settings
@@ -23,27 +46,867 @@ type ServerConfig struct {
 	WriteTimeout int      `yaml:"write_timeout"`  // seconds
 	IdleTimeout  int      `yaml:"idle_timeout"`   // seconds
 	AllowedPaths []string `yaml:"allowed_paths"`  // allowed path patterns (e.g., /run_sse, /apps/*)
+
+	// MaxConnections caps simultaneously open client connections (0 = unlimited).
+	// Connections over the limit wait in the kernel accept queue, whose length
+	// is governed by the OS (net.core.somaxconn on Linux).
+	MaxConnections int `yaml:"max_connections"`
+
+	// TCPKeepAlive is the keep-alive probe period for client connections in
+	// seconds: 0 uses Go's default (15s), a negative value turns probing off.
+	// Linux and macOS apply it to both the idle time and the probe interval;
+	// platforms without those socket options only toggle SO_KEEPALIVE.
+	TCPKeepAlive int `yaml:"tcp_keepalive"`
+
+	// TCPNagle re-enables Nagle's algorithm on client connections. Go sets
+	// TCP_NODELAY on every connection by default, which favours latency;
+	// batching small writes can help bandwidth-bound deployments instead.
+	TCPNagle bool `yaml:"tcp_nagle"`
+
+	// MaxConcurrentRequests caps proxy requests processed at once across all
+	// upstreams (0 = unlimited); requests over the limit get 503 with
+	// Retry-After. Health, metrics and admin endpoints are not limited.
+	MaxConcurrentRequests int `yaml:"max_concurrent_requests"`
+
+	// TrailingSlash controls how trailing slashes are treated when matching
+	// paths: "strict" (default) compares paths as-is, "normalize" strips a
+	// trailing slash from both path and pattern so /apps and /apps/ are equal.
+	TrailingSlash string `yaml:"trailing_slash"`
+
+	// NormalizePaths cleans request paths before they are checked against
+	// allowed_paths and routed: . and .. segments are resolved and duplicate
+	// slashes collapsed, so traversal cannot slip past an allow rule.
+	// Requests whose .. segments climb above the root, or out of the
+	// path_prefix they started under, are rejected with 400.
+	NormalizePaths bool `yaml:"normalize_paths"`
+
+	// MaxHops is the number of times a request may pass through gateways
+	// (tracked in X-Gateway-Hops) before it is rejected as a loop
+	MaxHops int `yaml:"max_hops"`
+
+	// UpstreamNameHeader, when set, names a header carrying the name of the
+	// upstream that served the request (e.g. X-Gateway-Upstream), so backends
+	// can correlate their logs with gateway routing. Any client-supplied
+	// value is replaced. Off by default.
+	UpstreamNameHeader string `yaml:"upstream_name_header"`
+
+	// ClientAuthorization controls requests arriving with their own
+	// Authorization header: "replace" (default) drops it in favour of the
+	// gateway's token, "reject" answers 400 to catch misbehaving clients
+	ClientAuthorization string `yaml:"client_authorization"`
+
+	// DefaultUpstream names the upstream for requests no routing rule
+	// matched (default: the first upstream). DefaultRoute controls how such
+	// requests are treated: "allow" (default) proxies them quietly, "warn"
+	// also logs a warning, "reject" answers 404 instead.
+	DefaultUpstream string `yaml:"default_upstream"`
+	DefaultRoute    string `yaml:"default_route"`
+
+	// DefaultRouteAudience, when set, is the audience tokens are minted for
+	// on default-routed requests instead of the default upstream's own, so
+	// traffic no rule claimed never carries a sensitive audience's token.
+	// DefaultRouteToken "none" forwards such requests with no token at all
+	// (any client Authorization is dropped); "mint" is the default.
+	DefaultRouteAudience string `yaml:"default_route_audience"`
+	DefaultRouteToken    string `yaml:"default_route_token"`
+
+	// HostlessUpstream names the upstream for requests without a Host
+	// header (HTTP/1.0 clients), instead of the usual path-based routing.
+	// HTTP/1.1 requests without a Host are always rejected with 400.
+	HostlessUpstream string `yaml:"hostless_upstream"`
+
+	// ShutdownTimeout bounds how long in-flight requests may drain on
+	// shutdown (seconds, default 30). DrainDelay keeps serving for a while
+	// after readiness flips to not-ready, so load balancers stop routing new
+	// traffic before the listener closes (seconds, default 0).
+	ShutdownTimeout int `yaml:"shutdown_timeout"`
+	DrainDelay      int `yaml:"drain_delay"`
+
+	// StreamingShutdownTimeout lets requests to streaming upstreams drain
+	// for longer than shutdown_timeout (seconds, default: shutdown_timeout).
+	// Other requests still in flight once shutdown_timeout passes are
+	// cancelled, and streams are cancelled once this timeout passes.
+	StreamingShutdownTimeout int `yaml:"streaming_shutdown_timeout"`
+
+	// Expvar publishes the key gateway counters as the "gateway" expvar map,
+	// served at /debug/vars for expvar-based tooling. Like the other debug
+	// endpoints it requires the admin token.
+	Expvar bool `yaml:"expvar"`
+
+	// MetricsSchema selects the default /metrics JSON layout: "v1" (flat,
+	// the default) or "v2" (nested by category). Clients may override it
+	// per request with ?schema=.
+	MetricsSchema string `yaml:"metrics_schema"`
+
+	// MetricsMaxAudiences caps the audiences given their own series in the
+	// per-audience OpenMetrics families (0 = unlimited). Audiences seen
+	// after the cap is reached are folded into a single "other" series.
+	// The JSON /metrics and expvar outputs report only aggregates across
+	// audiences, so they need no cap.
+	MetricsMaxAudiences int `yaml:"metrics_max_audiences"`
+
+	// StartupFailure controls what happens when an upstream fails its
+	// startup checks (transport setup, credentials map entry or token file):
+	// "fail_closed" (default) refuses to start, "fail_open" logs the failure
+	// and skips the upstream, answering 503 for it while the check is
+	// retried every StartupRetryInterval (seconds, default 30) until it passes.
+	StartupFailure       string `yaml:"startup_failure"`
+	StartupRetryInterval int    `yaml:"startup_retry_interval"`
+
+	TLS TLSConfig `yaml:"tls"`
+
+	RootResponse RootResponseConfig `yaml:"root_response"`
+
+	ResponseHeaders ResponseHeadersConfig `yaml:"response_headers"`
 }
 
+// ResponseHeadersConfig controls headers removed from or added to proxied
+// responses to limit what clients learn about the backends
+type ResponseHeadersConfig struct {
+	// Strip lists headers removed from upstream responses
+	// (default Server, X-Powered-By; an empty list strips nothing)
+	Strip []string `yaml:"strip"`
+
+	// Set overwrites headers on every proxied response (e.g., Server: gateway)
+	Set map[string]string `yaml:"set"`
+
+	// SecurityHeaders adds common hardening headers (nosniff, frame denial,
+	// no-referrer, HSTS) unless the upstream already set them
+	SecurityHeaders bool `yaml:"security_headers"`
+}
+
+// RootResponseConfig defines a response served by the gateway itself for
+// GET/HEAD / (e.g., a landing page or probe target) instead of proxying it
+type RootResponseConfig struct {
+	Enabled     bool   `yaml:"enabled"`
+	Status      int    `yaml:"status"`       // default 200
+	ContentType string `yaml:"content_type"` // default text/plain
+	Body        string `yaml:"body"`         // default "OK"
+}
+
+// Default route behaviors
+const (
+	DefaultRouteAllow  = "allow"
+	DefaultRouteWarn   = "warn"
+	DefaultRouteReject = "reject"
+)
+
+// Client Authorization header behaviors
+const (
+	ClientAuthorizationReplace = "replace"
+	ClientAuthorizationReject  = "reject"
+)
+
+// Default route token behaviors
+const (
+	DefaultRouteTokenMint = "mint"
+	DefaultRouteTokenNone = "none"
+)
+
+// Metrics JSON schemas
+const (
+	MetricsSchemaV1 = "v1"
+	MetricsSchemaV2 = "v2"
+)
+
+// Startup failure policies
+const (
+	StartupFailClosed = "fail_closed"
+	StartupFailOpen   = "fail_open"
+)
+
+// Token modes
+const (
+	TokenModeIDToken = "id_token"
+	TokenModeIAP     = "iap"
+)
+
+// IAPClientIDSuffix ends every OAuth client ID, the audience IAP expects
+const IAPClientIDSuffix = ".apps.googleusercontent.com"
+
+// Trailing slash policies
+const (
+	TrailingSlashStrict    = "strict"
+	TrailingSlashNormalize = "normalize"
+)
+
 // UpstreamConfig defines an upstream service
 type UpstreamConfig struct {
 	Name     string `yaml:"name"`
 	URL      string `yaml:"url"`
 	Audience string `yaml:"audience"`
 	Timeout  int    `yaml:"timeout"` // seconds
-	Host     string `yaml:"host"`
+
+	// Host overrides the Host header sent upstream (host or host:port, e.g.
+	// when url is a load balancer IP fronting a Cloud Run service). Empty
+	// sends the host of url.
+	Host string `yaml:"host"`
+
+	// PathPrefix routes requests under this path (e.g., /billing) to the
+	// upstream, ahead of the routing header; the longest matching prefix
+	// wins, and of equal prefixes the first configured. The prefix is
+	// stripped before the path is joined with URL unless PreservePathPrefix
+	// is set.
+	PathPrefix         string `yaml:"path_prefix"`
+	PreservePathPrefix bool   `yaml:"preserve_path_prefix"`
+
+	// Connection phase timeouts (seconds) for the upstream's transport, so a
+	// dead upstream fails fast without limiting how long a body may stream.
+	// ResponseHeaderTimeout defaults to Timeout.
+	DialTimeout           int `yaml:"dial_timeout"`
+	TLSHandshakeTimeout   int `yaml:"tls_handshake_timeout"`
+	ResponseHeaderTimeout int `yaml:"response_header_timeout"`
+
+	// Idle connection pooling for the upstream's transport: connections idle
+	// for IdleConnTimeout (seconds, default 90) are closed, and at most
+	// MaxIdleConns (default 100) are kept for reuse, MaxIdleConnsPerHost
+	// (default 10, where Go's stock transport keeps 2) of them to any one
+	// host. Raise the limits for busy upstreams; shorten the timeout for
+	// backends that drop idle connections early.
+	IdleConnTimeout     int `yaml:"idle_conn_timeout"`
+	MaxIdleConns        int `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost int `yaml:"max_idle_conns_per_host"`
+
+	// DialAddress is a host:port connected to instead of resolving the URL's
+	// host, for upstreams reached through an endpoint DNS does not know
+	// (e.g., Private Service Connect or an internal load balancer). The Host
+	// header and TLS server name still come from the URL.
+	DialAddress string `yaml:"dial_address"`
+
+	// SourceAddress binds connections to this upstream to a local IP, so
+	// they leave through a specific interface
+	SourceAddress string `yaml:"source_address"`
+
+	// ResponseTimeout bounds a whole attempt, from sending the request to the
+	// last body byte (seconds, 0 = unbounded), for upstreams that stall
+	// mid-body. Expiring before the first body byte returns 504; after that
+	// the client connection is closed so a truncated body cannot pass for a
+	// complete one.
+	ResponseTimeout int `yaml:"response_timeout"`
+
+	// TokenFile supplies this upstream's bearer token from a file provisioned
+	// out-of-band (e.g., by a sidecar) instead of minting a Google ID token
+	TokenFile TokenFileConfig `yaml:"token_file"`
+
+	// RefreshBeforeExpiry overrides token.refresh_before_expiry (minutes) for
+	// this upstream's audience, e.g. for IdPs issuing short-lived tokens
+	RefreshBeforeExpiry int `yaml:"refresh_before_expiry"`
+
+	// MinTokenLifetime (seconds) refreshes this upstream's token at request
+	// time whenever less than this much of its life remains, so slow
+	// upstreams never receive a token that expires mid-request. Keep it well
+	// under the token lifetime, or every request mints a new token.
+	MinTokenLifetime int `yaml:"min_token_lifetime"`
+
+	// RetryOnRefused retries a retryable request once, immediately, when
+	// the upstream refuses or resets the connection (e.g., rolling restarts).
+	// Request bodies up to 1 MiB are buffered so they can be resent.
+	RetryOnRefused bool `yaml:"retry_on_refused"`
+
+	// RetryableMethods are the methods any retry path (fallbacks, refused
+	// connection retries) may replay for this upstream; defaults to
+	// DefaultRetryableMethods
+	RetryableMethods []string `yaml:"retryable_methods"`
+
+	// RetryIdempotencyKeys also treats a POST carrying an Idempotency-Key
+	// header as retryable, for upstreams that deduplicate on that key
+	RetryIdempotencyKeys bool `yaml:"retry_idempotency_keys"`
+
+	// AllowedMethods restricts the HTTP methods accepted for this upstream
+	// (e.g., GET and HEAD for a read-only backend); empty allows all
+	AllowedMethods []string `yaml:"allowed_methods"`
+
+	// ResponseTypes restricts the content types of responses forwarded
+	// from this upstream (e.g., only application/json)
+	ResponseTypes ResponseTypesConfig `yaml:"response_types"`
+
+	// SetCookie controls the Set-Cookie headers of upstream responses
+	SetCookie SetCookieConfig `yaml:"set_cookie"`
+
+	// Limits on the response headers accepted from this upstream: the
+	// number of header lines and their total size (names plus values). A
+	// response over either limit is replaced with a 502.
+	MaxResponseHeaders     int `yaml:"max_response_headers"`      // default 256
+	MaxResponseHeaderBytes int `yaml:"max_response_header_bytes"` // default 64 KiB
+
+	DegradedResponse DegradedResponseConfig `yaml:"degraded_response"`
+
+	// Streaming marks long-lived responses (e.g., SSE): the server's
+	// read/write timeouts are lifted for these requests only, responses
+	// are flushed to the client as they arrive, and shutdown drains them
+	// within streaming_shutdown_timeout
+	Streaming bool `yaml:"streaming"`
+
+	// Critical makes /readyz answer 503 while this upstream is unhealthy:
+	// skipped at startup, its circuit open, or its last token fetch failed.
+	// Other upstreams never affect readiness.
+	Critical bool `yaml:"critical"`
+
+	// Query parameters removed before forwarding: StripQueryParams drops the
+	// named parameters, AllowQueryParams drops all others (use one or the other)
+	StripQueryParams []string `yaml:"strip_query_params"`
+	AllowQueryParams []string `yaml:"allow_query_params"`
+
+	// DeriveAudienceFromURL uses the upstream's scheme://host as the audience
+	// when Audience is empty (the usual case for Cloud Run; IAP expects its
+	// OAuth client ID instead, see TokenMode)
+	DeriveAudienceFromURL bool `yaml:"derive_audience_from_url"`
+
+	// TokenMode names the token convention of the upstream: "id_token"
+	// (default) mints a Google ID token for Audience; "iap" is for upstreams
+	// behind Identity-Aware Proxy, whose ID token audience must be the OAuth
+	// client ID of the IAP resource (...apps.googleusercontent.com) rather
+	// than the URL. In iap mode a client ID written as a URL
+	// (https://ID.apps.googleusercontent.com/) is reduced to the bare ID.
+	TokenMode string `yaml:"token_mode"`
+
+	// SkipAudienceCheck suppresses the audience/url host check, for
+	// upstreams deliberately reached under another name (e.g., through a
+	// private endpoint) while tokens are minted for the public one
+	SkipAudienceCheck bool `yaml:"skip_audience_check"`
+
+	// Coalesce collapses identical concurrent GET/HEAD requests into one upstream call
+	Coalesce         bool  `yaml:"coalesce"`
+	CoalesceMaxBytes int64 `yaml:"coalesce_max_bytes"` // max buffered response size shared with waiters
+
+	Cache CacheConfig `yaml:"cache"`
+
+	// CompressRequests gzips request bodies forwarded to this upstream, for
+	// upstreams that accept Content-Encoding: gzip
+	CompressRequests RequestCompressionConfig `yaml:"compress_requests"`
+
+	// TranscodeResponses re-encodes responses whose Content-Encoding (gzip,
+	// deflate or br) the client did not accept into one it does, or into
+	// identity, e.g. br for a client that only accepts gzip
+	TranscodeResponses bool `yaml:"transcode_responses"`
+
+	// CircuitBreaker overrides the global breaker settings for this upstream;
+	// zero values inherit the global setting
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+
+	// OutboundRateLimit caps the requests per second sent to this upstream,
+	// protecting fragile backends regardless of which clients send them
+	OutboundRateLimit OutboundRateLimitConfig `yaml:"outbound_rate_limit"`
+
+	// RetryBudget bounds the retries (refused connection retries and
+	// fallback attempts) sent to this upstream, so sustained failures cannot
+	// multiply the load on it
+	RetryBudget RetryBudgetConfig `yaml:"retry_budget"`
+
+	// FallbackUpstreams are tried in order when this upstream fails (circuit
+	// open, token or connection error, or a 5xx response). Only retryable
+	// requests (see RetryableMethods) with small bodies are retried.
+	FallbackUpstreams []string `yaml:"fallback_upstreams"`
+
+	// PassThrough takes the target from the request (its absolute URL or
+	// TargetHeader) instead of URL, with the audience derived from the
+	// target's scheme://host. Targets must match AllowedHosts.
+	PassThrough  bool     `yaml:"pass_through"`
+	AllowedHosts []string `yaml:"allowed_hosts"` // hostnames, *.domain wildcards or host:port
+	TargetHeader string   `yaml:"target_header"` // e.g. X-Target-URL; stripped before forwarding
+	AllowHTTP    bool     `yaml:"allow_http"`    // permit plain http targets (https only by default)
+
+	// ExactCaseHeaders are set on forwarded requests with their names sent
+	// exactly as written (e.g., x-api-key), for backends that compare header
+	// names case-sensitively. Only HTTP/1.1 preserves casing; HTTP/2
+	// lowercases every header name on the wire.
+	ExactCaseHeaders map[string]string `yaml:"exact_case_headers"`
+
+	Transform TransformConfig `yaml:"transform"`
+
+	// Additional CAs trusted for this upstream's TLS connections (e.g., a
+	// private PKI), from a PEM file or inline PEM (use one or the other).
+	// Environment variables in CAPEM are expanded, e.g. "${UPSTREAM_CA}".
+	CAFile string `yaml:"ca_file"`
+	CAPEM  string `yaml:"ca_pem"`
+
+	// LogLevel overrides logging.level for this upstream's requests (debug,
+	// info, warn, error), e.g. to debug one flaky backend.
+	LogLevel string `yaml:"log_level"`
+}
+
+// CertPool returns the system roots plus the upstream's configured CAs, or
+// nil when none are configured. The CA file is read when CAPEM is empty.
+func (u *UpstreamConfig) CertPool() (*x509.CertPool, error) {
+	pem := []byte(u.CAPEM)
+	if len(pem) == 0 && u.CAFile != "" {
+		data, err := os.ReadFile(u.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca_file: %w", err)
+		}
+		pem = data
+	}
+	if len(pem) == 0 {
+		return nil, nil
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no valid PEM certificates found")
+	}
+	return pool, nil
+}
+
+// TransformConfig copies values between request/response locations without
+// custom code. Each rule copies From to To; a rule whose source is absent is
+// skipped. Request rules read the client's request (headers and path
+// segments) and set upstream request headers; response rules copy upstream
+// response headers.
+type TransformConfig struct {
+	Request  []TransformRule `yaml:"request"`
+	Response []TransformRule `yaml:"response"`
+}
+
+// TransformRule copies a value from one location to another, e.g.
+// from "path:2" to "header:X-Tenant-ID"
+type TransformRule struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+// TokenFileConfig reads an upstream's token from a file, re-reading it when
+// the file changes. The expiry comes from ExpiryPath (RFC 3339 or Unix
+// seconds) when set, otherwise from TTL after each read.
+type TokenFileConfig struct {
+	Path       string `yaml:"path"`
+	ExpiryPath string `yaml:"expiry_path"`
+	TTL        int    `yaml:"ttl"` // seconds (default 3600)
+}
+
+// Transform reference kinds
+const (
+	TransformHeader = "header" // header:<Name>
+	TransformPath   = "path"   // path:<n>, the nth (1-based) segment of the request path
+)
+
+// TransformRef is a parsed transform location
+type TransformRef struct {
+	Kind    string
+	Name    string // header name
+	Segment int    // path segment (1-based)
+}
+
+// ParseTransformRef parses a transform location such as "header:X-Tenant"
+// or "path:2"
+func ParseTransformRef(ref string) (TransformRef, error) {
+	kind, value, ok := strings.Cut(ref, ":")
+	if !ok || value == "" {
+		return TransformRef{}, fmt.Errorf("invalid transform reference %q (want header:<name> or path:<n>)", ref)
+	}
+	switch kind {
+	case TransformHeader:
+		if !httpguts.ValidHeaderFieldName(value) {
+			return TransformRef{}, fmt.Errorf("invalid header name in %q", ref)
+		}
+		return TransformRef{Kind: kind, Name: http.CanonicalHeaderKey(value)}, nil
+	case TransformPath:
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return TransformRef{}, fmt.Errorf("invalid path segment in %q (must be 1 or greater)", ref)
+		}
+		return TransformRef{Kind: kind, Segment: n}, nil
+	}
+	return TransformRef{}, fmt.Errorf("invalid transform reference %q (want header:<name> or path:<n>)", ref)
+}
+
+// protectedTransformHeaders are set by the gateway and cannot be written by
+// transform rules
+var protectedTransformHeaders = map[string]bool{
+	"Authorization":  true,
+	"X-Gateway-Hops": true,
+}
+
+// reservedUpstreamNameHeaders cannot carry the upstream name: the gateway
+// sets them itself, or they are hop-by-hop and never reach the upstream
+var reservedUpstreamNameHeaders = map[string]bool{
+	"Authorization":       true,
+	"Host":                true,
+	"X-Gateway-Hops":      true,
+	"Connection":          true,
+	"Proxy-Connection":    true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+}
+
+// validateTransformRules checks that rules read from allowed kinds and write
+// to headers the gateway does not manage
+func validateTransformRules(rules []TransformRule, fromKinds ...string) error {
+	for i, rule := range rules {
+		from, err := ParseTransformRef(rule.From)
+		if err != nil {
+			return fmt.Errorf("[%d]: %w", i, err)
+		}
+		allowed := false
+		for _, kind := range fromKinds {
+			allowed = allowed || from.Kind == kind
+		}
+		if !allowed {
+			return fmt.Errorf("[%d]: cannot read from %q", i, rule.From)
+		}
+		to, err := ParseTransformRef(rule.To)
+		if err != nil {
+			return fmt.Errorf("[%d]: %w", i, err)
+		}
+		if to.Kind != TransformHeader {
+			return fmt.Errorf("[%d]: can only write to headers, not %q", i, rule.To)
+		}
+		if protectedTransformHeaders[to.Name] {
+			return fmt.Errorf("[%d]: %s is managed by the gateway", i, to.Name)
+		}
+	}
+	return nil
+}
+
+// validateResponseTypes checks the allow-list entries and action
+func validateResponseTypes(rt ResponseTypesConfig) error {
+	for _, allowed := range rt.Allowed {
+		mediaType, params, err := mime.ParseMediaType(allowed)
+		if err != nil || len(params) > 0 || !strings.Contains(mediaType, "/") || strings.HasPrefix(mediaType, "*/") {
+			return fmt.Errorf("invalid media type %q (want type/subtype or type/*)", allowed)
+		}
+	}
+	switch rt.Action {
+	case "", ResponseTypeReject, ResponseTypeStrip:
+	default:
+		return fmt.Errorf("invalid action %q (must be %q or %q)", rt.Action, ResponseTypeReject, ResponseTypeStrip)
+	}
+	return nil
+}
+
+// DefaultRetryableMethods are the idempotent methods retried when an
+// upstream does not set retryable_methods
+var DefaultRetryableMethods = []string{"GET", "HEAD", "PUT", "DELETE", "OPTIONS"}
+
+// DegradedResponseConfig defines a static response served for an upstream
+// when every proxy attempt (including fallbacks) fails, e.g. a maintenance
+// JSON document instead of a bare 502
+type DegradedResponseConfig struct {
+	Enabled     bool   `yaml:"enabled"`
+	Status      int    `yaml:"status"`       // default 503
+	ContentType string `yaml:"content_type"` // default application/json
+	Body        string `yaml:"body"`
+	BodyFile    string `yaml:"body_file"` // read into Body at load time
+}
+
+// ResponseTypesConfig is an allow-list of upstream response media types.
+// Entries are media types such as application/json, or type/* wildcards.
+// Responses with a body of any other type (or none) are handled per Action:
+// "reject" (default) replaces them with a 502, "strip" forwards the status
+// and headers with an empty body. An empty Allowed list allows everything.
+type ResponseTypesConfig struct {
+	Allowed []string `yaml:"allowed"`
+	Action  string   `yaml:"action"`
+}
+
+// Disallowed response type actions
+const (
+	ResponseTypeReject = "reject"
+	ResponseTypeStrip  = "strip"
+)
+
+// SetCookieConfig controls how an upstream's Set-Cookie headers reach
+// clients. Mode "passthrough" (default) forwards them as sent, "strip"
+// removes them (e.g., for stateless APIs), and "rewrite_domain" adapts each
+// cookie to the gateway's external host: its Domain becomes Domain, or is
+// dropped so the cookie belongs to the host the client used, and its Path
+// becomes Path, or is moved under a stripped path_prefix.
+type SetCookieConfig struct {
+	Mode   string `yaml:"mode"`
+	Domain string `yaml:"domain"`
+	Path   string `yaml:"path"`
+}
+
+// Set-Cookie modes
+const (
+	SetCookiePassthrough   = "passthrough"
+	SetCookieStrip         = "strip"
+	SetCookieRewriteDomain = "rewrite_domain"
+)
+
+// validateSetCookie checks the mode and its rewrite settings
+func validateSetCookie(sc SetCookieConfig) error {
+	switch sc.Mode {
+	case "", SetCookiePassthrough, SetCookieStrip:
+		if sc.Domain != "" || sc.Path != "" {
+			return fmt.Errorf("domain and path require mode %q", SetCookieRewriteDomain)
+		}
+	case SetCookieRewriteDomain:
+		if sc.Path != "" && !strings.HasPrefix(sc.Path, "/") {
+			return fmt.Errorf("path %q must start with /", sc.Path)
+		}
+		if strings.ContainsAny(sc.Domain+sc.Path, "; ") {
+			return fmt.Errorf("domain and path must not contain spaces or semicolons")
+		}
+	default:
+		return fmt.Errorf("invalid mode %q (must be %q, %q or %q)",
+			sc.Mode, SetCookiePassthrough, SetCookieStrip, SetCookieRewriteDomain)
+	}
+	return nil
+}
+
+// RequestCompressionConfig controls gzip compression of request bodies.
+// Only POST, PUT and PATCH bodies larger than MinBytes are compressed;
+// bodies that already carry a Content-Encoding or a compressed media type
+// are forwarded as is.
+type RequestCompressionConfig struct {
+	Enabled  bool  `yaml:"enabled"`
+	MinBytes int64 `yaml:"min_bytes"` // default 1024
+}
+
+// CircuitBreakerConfig controls the upstream circuit breaker. The breaker
+// opens after FailureThreshold failures (proxy errors or 5xx responses)
+// within Window, rejects requests with 503 until Cooldown elapses, and then
+// lets a trial request through to decide whether to close again.
+type CircuitBreakerConfig struct {
+	Enabled          bool `yaml:"enabled"` // global only
+	FailureThreshold int  `yaml:"failure_threshold"`
+	Window           int  `yaml:"window"`   // seconds
+	Cooldown         int  `yaml:"cooldown"` // seconds
+}
+
+// BreakerSettings returns the upstream's effective circuit breaker settings,
+// falling back to the global settings for any value not overridden
+func (u *UpstreamConfig) BreakerSettings(global CircuitBreakerConfig) CircuitBreakerConfig {
+	settings := global
+	if u.CircuitBreaker.FailureThreshold > 0 {
+		settings.FailureThreshold = u.CircuitBreaker.FailureThreshold
+	}
+	if u.CircuitBreaker.Window > 0 {
+		settings.Window = u.CircuitBreaker.Window
+	}
+	if u.CircuitBreaker.Cooldown > 0 {
+		settings.Cooldown = u.CircuitBreaker.Cooldown
+	}
+	return settings
+}
+
+// OutboundRateLimitConfig is a token bucket holding Burst tokens, refilled
+// at RPS tokens per second; each attempt sent to the upstream takes one. An
+// attempt finding the bucket empty waits up to MaxWait for a token and is
+// otherwise rejected with 503 (or handed to a fallback upstream).
+type OutboundRateLimitConfig struct {
+	RPS     float64 `yaml:"rps"`      // 0 = unlimited
+	Burst   int     `yaml:"burst"`    // default rps rounded up, at least 1
+	MaxWait int     `yaml:"max_wait"` // milliseconds; 0 rejects without waiting
+}
+
+// RetryBudgetConfig is a bucket holding up to MaxTokens tokens, starting
+// full. Each retry sent to the upstream takes one and each response below
+// 500 from it returns TokenRatio; retries are refused while half or fewer of
+// MaxTokens remain.
+type RetryBudgetConfig struct {
+	MaxTokens  int     `yaml:"max_tokens"`  // 0 = unlimited retries
+	TokenRatio float64 `yaml:"token_ratio"` // default 0.1
+}
+
+// CacheConfig controls in-memory caching of upstream GET responses.
+// Only responses with explicit freshness (Cache-Control max-age or Expires)
+// are cached, and never longer than MaxTTL.
+type CacheConfig struct {
+	Enabled  bool  `yaml:"enabled"`
+	MaxBytes int64 `yaml:"max_bytes"` // total cached body size per upstream
+	MaxTTL   int   `yaml:"max_ttl"`   // seconds, caps upstream-provided freshness
+
+	// Shared must be set with Enabled to confirm that cached responses are
+	// the same for every client, since any caller may be served them.
+	// Requests carrying client credentials are never cached regardless.
+	Shared bool `yaml:"shared"`
+
+	// Paths limits caching to matching path patterns (as in allowed_paths);
+	// empty caches every path
+	Paths []string `yaml:"paths"`
+}
+
+// AggregateConfig defines a route that fans out to several upstreams and
+// merges their JSON responses under named keys
+type AggregateConfig struct {
+	Path    string            `yaml:"path"`    // gateway path serving the aggregate (exact match)
+	Timeout int               `yaml:"timeout"` // seconds, applies to each upstream call
+	Members []AggregateMember `yaml:"members"`
+}
+
+// AggregateMember is one upstream call within an aggregate route
+type AggregateMember struct {
+	Key      string `yaml:"key"`      // key of this response in the merged JSON
+	Upstream string `yaml:"upstream"` // name of a configured upstream
+	Path     string `yaml:"path"`     // path requested on the upstream
+}
+
+// DiscoveryConfig serves an upstream's OIDC discovery document and JWKS at
+// the gateway's own /.well-known/openid-configuration and
+// /.well-known/jwks.json, caching both (off unless Upstream is set)
+type DiscoveryConfig struct {
+	Upstream string `yaml:"upstream"`  // name of the upstream whose discovery is served
+	CacheTTL int    `yaml:"cache_ttl"` // seconds, default 300
+
+	// PublicURL is the base URL clients reach the gateway at (e.g.
+	// https://gateway.example.com), which the served jwks_uri points under.
+	// Required with Upstream: the documents are publicly cacheable, so the
+	// URL cannot come from the request's Host header or scheme.
+	PublicURL string `yaml:"public_url"`
 }
 
 // LoggingConfig holds logging settings
 type LoggingConfig struct {
 	Level  string `yaml:"level"`  // debug, info, warn, error
 	Format string `yaml:"format"` // json, text
+
+	// AccessLogFormat selects the per-request log format: "text" (default,
+	// key=value), "clf" (Common Log Format) or "json" (JSON Lines)
+	AccessLogFormat string `yaml:"access_log_format"`
+
+	// LogHeaders logs every request and upstream header at debug level,
+	// with credentials redacted (off by default)
+	LogHeaders bool `yaml:"log_headers"`
+
+	// StatsInterval logs aggregate gateway stats (requests, errors, token
+	// refreshes, cache size, tokens per state) at info level every this many
+	// seconds, for deployments without a metrics scraper (0 = off)
+	StatsInterval int `yaml:"stats_interval"`
+
+	// SlowRequestThreshold logs a warning, with the time split between token
+	// acquisition and the upstream, for requests taking longer than this
+	// many milliseconds (0 = off). Streaming upstreams are exempt.
+	SlowRequestThreshold int `yaml:"slow_request_threshold"`
 }
 
+// Access log formats
+const (
+	AccessLogText = "text"
+	AccessLogCLF  = "clf"
+	AccessLogJSON = "json"
+)
+
 // TokenConfig holds token management settings
 type TokenConfig struct {
 	RefreshBeforeExpiry int  `yaml:"refresh_before_expiry"` // minutes
 	EnableCache         bool `yaml:"enable_cache"`
+
+	// Expiry boundary controls (seconds). ClockSkew treats tokens as
+	// expiring earlier in case upstream clocks run ahead of ours; ExpiryGrace
+	// delays marking a token EXPIRED in case ours runs ahead, serving it if a
+	// refresh fails until the grace period has passed.
+	ClockSkew   int `yaml:"clock_skew"`
+	ExpiryGrace int `yaml:"expiry_grace"`
+
+	// MaxTokenAge caps how long a token is served after it was minted
+	// (minutes, 0 = until it nears expiry); older tokens are refreshed
+	// however long they remain valid, e.g. to meet a security policy
+	MaxTokenAge int `yaml:"max_token_age"`
+
+	// MaxEntries caps the number of cached audiences; the least recently
+	// used entry is evicted to make room (0 = unlimited)
+	MaxEntries int `yaml:"max_entries"`
+
+	// StartupMintWindow mints a first token before the server starts
+	// listening, retrying with backoff for up to this many seconds while
+	// the metadata server warms up after instance start; startup fails if
+	// none is minted in time (0 = off, tokens are minted on first use)
+	StartupMintWindow int `yaml:"startup_mint_window"`
+
+	// CredentialsMap is a YAML file mapping audiences to service account
+	// credentials files; unmapped audiences use the default credentials
+	CredentialsMap string `yaml:"credentials_map"`
+
+	// StrictAudience turns an upstream whose URL audience names a different
+	// host than its url (see AudienceMismatches) from a startup warning into
+	// a configuration error
+	StrictAudience bool `yaml:"strict_audience"`
+}
+
+// AdminConfig holds settings for the /admin endpoints
+type AdminConfig struct {
+	// Token is the bearer token required on admin requests. Admin endpoints
+	// are disabled when empty. May reference environment variables ($VAR).
+	Token string `yaml:"token"`
+}
+
+// RoutingConfig restricts which upstreams clients may select with the
+// X-Target-Upstream header. When either control is configured, a header that
+// fails it is ignored and the request takes the default route.
+type RoutingConfig struct {
+	// SigningSecret requires X-Target-Upstream-Signature to carry
+	// "t=<unix seconds>,sig=<hex>", where sig is the HMAC-SHA256, keyed with
+	// this secret, of the timestamp, method, path and upstream name joined
+	// by newlines. May reference environment variables ($VAR).
+	SigningSecret string `yaml:"signing_secret"`
+
+	// SignatureMaxAge is how far, in seconds, a signature's timestamp may
+	// be from the gateway's clock (default 60). Each signature is accepted
+	// once, so a signed request cannot be replayed.
+	SignatureMaxAge int `yaml:"signature_max_age"`
+
+	// AllowedClients lists, per client address or CIDR, the upstreams those
+	// clients may select; clients matching no entry cannot select any
+	AllowedClients []RoutingClientConfig `yaml:"allowed_clients"`
+
+	// HostConflict decides what happens when X-Target-Upstream names one
+	// upstream but the request's Host matches another (its host override or
+	// URL host): "honor" (default) follows the header, "warn" follows it and
+	// logs a warning, "reject" answers 400. Conflicts are logged in every mode.
+	HostConflict string `yaml:"host_conflict"`
+}
+
+// Host conflict behaviors
+const (
+	HostConflictHonor  = "honor"
+	HostConflictWarn   = "warn"
+	HostConflictReject = "reject"
+)
+
+// TeeConfig copies a sample of proxied request/response pairs (headers and
+// bodies) to a debug sink for offline analysis, as JSON appended to File or
+// POSTed to URL (set exactly one). Credential headers are always redacted.
+// Pairs are written asynchronously from a bounded queue; when the sink falls
+// behind, pairs are dropped rather than delaying clients.
+type TeeConfig struct {
+	Enabled       bool     `yaml:"enabled"`
+	SampleRate    float64  `yaml:"sample_rate"` // fraction of requests teed, default 0.01
+	File          string   `yaml:"file"`
+	URL           string   `yaml:"url"`
+	MaxBodyBytes  int64    `yaml:"max_body_bytes"` // per body, truncated beyond; default 64 KiB
+	QueueSize     int      `yaml:"queue_size"`     // pairs waiting to be written, default 100
+	RedactHeaders []string `yaml:"redact_headers"` // redacted in addition to credential headers
+}
+
+// RoutingClientConfig allows clients in CIDR (or a single IP) to select the
+// listed upstreams
+type RoutingClientConfig struct {
+	CIDR      string   `yaml:"cidr"`
+	Upstreams []string `yaml:"upstreams"`
+}
+
+// Prefix parses CIDR, treating a bare IP address as a single-host prefix
+func (c RoutingClientConfig) Prefix() (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(c.CIDR); err == nil {
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(c.CIDR)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid cidr %q", c.CIDR)
+	}
+	return prefix.Masked(), nil
+}
+
+// reservedPaths are served by the gateway itself and cannot be used for routes
+var reservedPaths = map[string]bool{
+	"/healthz":      true,
+	"/readyz":       true,
+	"/metrics":      true,
+	"/token-info":   true,
+	"/debug/vars":   true,
+	"/debug/config": true,
+
+	"/.well-known/openid-configuration": true,
+	"/.well-known/jwks.json":            true,
 }
 
 // GetAddress returns the full server address
@@ -57,6 +920,130 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid port: %d", c.Server.Port)
 	}
 
+	if c.Server.MaxConnections < 0 {
+		return fmt.Errorf("invalid max_connections: %d", c.Server.MaxConnections)
+	}
+
+	if c.Server.MaxConcurrentRequests < 0 {
+		return fmt.Errorf("invalid max_concurrent_requests: %d", c.Server.MaxConcurrentRequests)
+	}
+
+	if c.Server.MaxHops < 0 {
+		return fmt.Errorf("invalid max_hops: %d", c.Server.MaxHops)
+	}
+
+	if c.Server.ShutdownTimeout < 0 || c.Server.DrainDelay < 0 || c.Server.StreamingShutdownTimeout < 0 {
+		return fmt.Errorf("shutdown_timeout, streaming_shutdown_timeout and drain_delay must not be negative")
+	}
+
+	if root := c.Server.RootResponse; root.Enabled && (root.Status < 200 || root.Status > 599) {
+		return fmt.Errorf("invalid root_response status: %d", root.Status)
+	}
+
+	if name := c.Server.UpstreamNameHeader; name != "" {
+		if !httpguts.ValidHeaderFieldName(name) {
+			return fmt.Errorf("invalid upstream_name_header: %q", name)
+		}
+		if reservedUpstreamNameHeaders[http.CanonicalHeaderKey(name)] {
+			return fmt.Errorf("invalid upstream_name_header: %q (set by the gateway or hop-by-hop)", name)
+		}
+	}
+
+	switch c.Server.TrailingSlash {
+	case "", TrailingSlashStrict, TrailingSlashNormalize:
+	default:
+		return fmt.Errorf("invalid trailing_slash: %q (must be %q or %q)",
+			c.Server.TrailingSlash, TrailingSlashStrict, TrailingSlashNormalize)
+	}
+
+	if tlsCfg := c.Server.TLS; tlsCfg.Enabled() {
+		if tlsCfg.CertFile == "" || tlsCfg.KeyFile == "" {
+			return fmt.Errorf("server.tls: cert_file and key_file are both required")
+		}
+		if _, err := tlsCfg.Config(); err != nil {
+			return fmt.Errorf("server.tls: %w", err)
+		}
+	}
+
+	switch c.Server.DefaultRoute {
+	case "", DefaultRouteAllow, DefaultRouteWarn, DefaultRouteReject:
+	default:
+		return fmt.Errorf("invalid default_route: %q (must be %q, %q or %q)",
+			c.Server.DefaultRoute, DefaultRouteAllow, DefaultRouteWarn, DefaultRouteReject)
+	}
+
+	switch c.Server.ClientAuthorization {
+	case "", ClientAuthorizationReplace, ClientAuthorizationReject:
+	default:
+		return fmt.Errorf("invalid client_authorization: %q (must be %q or %q)",
+			c.Server.ClientAuthorization, ClientAuthorizationReplace, ClientAuthorizationReject)
+	}
+
+	switch c.Server.DefaultRouteToken {
+	case "", DefaultRouteTokenMint:
+	case DefaultRouteTokenNone:
+		if c.Server.DefaultRouteAudience != "" {
+			return fmt.Errorf("default_route_audience cannot be used with default_route_token %q", DefaultRouteTokenNone)
+		}
+	default:
+		return fmt.Errorf("invalid default_route_token: %q (must be %q or %q)",
+			c.Server.DefaultRouteToken, DefaultRouteTokenMint, DefaultRouteTokenNone)
+	}
+
+	switch c.Server.StartupFailure {
+	case "", StartupFailClosed, StartupFailOpen:
+	default:
+		return fmt.Errorf("invalid startup_failure: %q (must be %q or %q)",
+			c.Server.StartupFailure, StartupFailClosed, StartupFailOpen)
+	}
+	if c.Server.StartupRetryInterval < 0 {
+		return fmt.Errorf("startup_retry_interval must not be negative")
+	}
+
+	if c.Server.MetricsMaxAudiences < 0 {
+		return fmt.Errorf("metrics_max_audiences must not be negative")
+	}
+
+	switch c.Server.MetricsSchema {
+	case "", MetricsSchemaV1, MetricsSchemaV2:
+	default:
+		return fmt.Errorf("invalid metrics_schema: %q (must be %q or %q)",
+			c.Server.MetricsSchema, MetricsSchemaV1, MetricsSchemaV2)
+	}
+
+	switch c.Logging.AccessLogFormat {
+	case "", AccessLogText, AccessLogCLF, AccessLogJSON:
+	default:
+		return fmt.Errorf("invalid access_log_format: %q (must be text, clf or json)", c.Logging.AccessLogFormat)
+	}
+
+	if c.Logging.StatsInterval < 0 {
+		return fmt.Errorf("invalid stats_interval: %d", c.Logging.StatsInterval)
+	}
+	if c.Logging.SlowRequestThreshold < 0 {
+		return fmt.Errorf("invalid slow_request_threshold: %d", c.Logging.SlowRequestThreshold)
+	}
+
+	if c.Token.ClockSkew < 0 || c.Token.ExpiryGrace < 0 {
+		return fmt.Errorf("token: clock_skew and expiry_grace must not be negative")
+	}
+
+	if c.Token.MaxTokenAge < 0 {
+		return fmt.Errorf("token: max_token_age must not be negative")
+	}
+
+	if c.Token.MaxEntries < 0 {
+		return fmt.Errorf("token: max_entries must not be negative")
+	}
+
+	if c.Token.StartupMintWindow < 0 {
+		return fmt.Errorf("token: startup_mint_window must not be negative")
+	}
+
+	if err := validateBreaker(c.CircuitBreaker); err != nil {
+		return fmt.Errorf("circuit_breaker: %w", err)
+	}
+
 	if len(c.Upstreams) == 0 {
 		return fmt.Errorf("no upstreams configured")
 	}
@@ -65,17 +1052,332 @@ func (c *Config) Validate() error {
 		if upstream.Name == "" {
 			return fmt.Errorf("upstream[%d]: name is required", i)
 		}
-		if upstream.URL == "" {
-			return fmt.Errorf("upstream[%d]: url is required", i)
+		if upstream.PassThrough {
+			if len(upstream.AllowedHosts) == 0 {
+				return fmt.Errorf("upstream[%d]: pass_through requires allowed_hosts", i)
+			}
+			if upstream.Cache.Enabled || upstream.Coalesce {
+				return fmt.Errorf("upstream[%d]: cache and coalesce are not supported with pass_through", i)
+			}
+		} else {
+			if upstream.URL == "" {
+				return fmt.Errorf("upstream[%d]: url is required", i)
+			}
+			if host := upstream.Host; host != "" {
+				if u, err := url.Parse("//" + host); err != nil || u.Host != host {
+					return fmt.Errorf("upstream[%d]: invalid host %q (must be host or host:port)", i, host)
+				}
+			}
+			if upstream.Audience == "" && !upstream.DeriveAudienceFromURL && upstream.TokenFile.Path == "" {
+				return fmt.Errorf("upstream[%d]: audience is required", i)
+			}
+		}
+		switch upstream.TokenMode {
+		case "", TokenModeIDToken:
+		case TokenModeIAP:
+			if upstream.PassThrough || upstream.DeriveAudienceFromURL || upstream.TokenFile.Path != "" {
+				return fmt.Errorf("upstream[%d]: token_mode iap cannot be used with pass_through, derive_audience_from_url or token_file", i)
+			}
+			if !IsIAPClientID(upstream.Audience) {
+				return fmt.Errorf("upstream[%d]: token_mode iap needs the IAP OAuth client ID as audience (ending in %s), not %q",
+					i, IAPClientIDSuffix, upstream.Audience)
+			}
+		default:
+			return fmt.Errorf("upstream[%d]: invalid token_mode: %q (must be %q or %q)",
+				i, upstream.TokenMode, TokenModeIDToken, TokenModeIAP)
+		}
+		if upstream.DialTimeout < 0 || upstream.TLSHandshakeTimeout < 0 || upstream.ResponseHeaderTimeout < 0 ||
+			upstream.ResponseTimeout < 0 {
+			return fmt.Errorf("upstream[%d]: timeouts must not be negative", i)
+		}
+		if upstream.IdleConnTimeout < 0 || upstream.MaxIdleConns < 0 || upstream.MaxIdleConnsPerHost < 0 {
+			return fmt.Errorf("upstream[%d]: idle connection settings must not be negative", i)
+		}
+		if upstream.DialAddress != "" {
+			if upstream.PassThrough {
+				return fmt.Errorf("upstream[%d]: dial_address cannot be used with pass_through", i)
+			}
+			if _, port, err := net.SplitHostPort(upstream.DialAddress); err != nil || port == "" {
+				return fmt.Errorf("upstream[%d]: invalid dial_address %q (want host:port)", i, upstream.DialAddress)
+			}
+		}
+		if upstream.SourceAddress != "" {
+			if _, err := netip.ParseAddr(upstream.SourceAddress); err != nil {
+				return fmt.Errorf("upstream[%d]: invalid source_address %q", i, upstream.SourceAddress)
+			}
+		}
+		if degraded := upstream.DegradedResponse; degraded.Enabled && (degraded.Status < 200 || degraded.Status > 599) {
+			return fmt.Errorf("upstream[%d]: invalid degraded_response status: %d", i, degraded.Status)
+		} else if degraded.Enabled && strings.TrimSpace(degraded.ContentType) == "" {
+			return fmt.Errorf("upstream[%d]: degraded_response requires content_type", i)
+		}
+		if len(upstream.StripQueryParams) > 0 && len(upstream.AllowQueryParams) > 0 {
+			return fmt.Errorf("upstream[%d]: strip_query_params and allow_query_params are mutually exclusive", i)
+		}
+		for name := range upstream.ExactCaseHeaders {
+			if !httpguts.ValidHeaderFieldName(name) {
+				return fmt.Errorf("upstream[%d]: invalid exact_case_headers name %q", i, name)
+			}
+		}
+		if err := validateTransformRules(upstream.Transform.Request, TransformHeader, TransformPath); err != nil {
+			return fmt.Errorf("upstream[%d]: transform.request%w", i, err)
+		}
+		if err := validateTransformRules(upstream.Transform.Response, TransformHeader); err != nil {
+			return fmt.Errorf("upstream[%d]: transform.response%w", i, err)
+		}
+		for _, method := range upstream.RetryableMethods {
+			if !httpguts.ValidHeaderFieldName(method) {
+				return fmt.Errorf("upstream[%d]: invalid retryable method %q", i, method)
+			}
+		}
+		if _, ok := logger.ParseLevel(upstream.LogLevel); upstream.LogLevel != "" && !ok {
+			return fmt.Errorf("upstream[%d]: invalid log_level %q (want debug, info, warn or error)", i, upstream.LogLevel)
+		}
+		if tf := upstream.TokenFile; tf.Path == "" && (tf.ExpiryPath != "" || tf.TTL != 0) {
+			return fmt.Errorf("upstream[%d]: token_file requires path", i)
+		} else if tf.TTL < 0 {
+			return fmt.Errorf("upstream[%d]: token_file ttl must not be negative", i)
+		} else if tf.Path != "" && upstream.PassThrough {
+			return fmt.Errorf("upstream[%d]: token_file is not supported with pass_through", i)
+		}
+		if upstream.Cache.Enabled && !upstream.Cache.Shared {
+			return fmt.Errorf("upstream[%d]: cache requires shared: true to confirm responses may be served to every client", i)
+		}
+		if upstream.CAFile != "" && upstream.CAPEM != "" {
+			return fmt.Errorf("upstream[%d]: ca_file and ca_pem are mutually exclusive", i)
+		}
+		if _, err := upstream.CertPool(); err != nil {
+			return fmt.Errorf("upstream[%d]: %w", i, err)
+		}
+		if upstream.RefreshBeforeExpiry < 0 {
+			return fmt.Errorf("upstream[%d]: refresh_before_expiry must not be negative", i)
+		}
+		if upstream.MinTokenLifetime < 0 {
+			return fmt.Errorf("upstream[%d]: min_token_lifetime must not be negative", i)
 		}
-		if upstream.Audience == "" {
-			return fmt.Errorf("upstream[%d]: audience is required", i)
+		if upstream.CoalesceMaxBytes < 0 {
+			return fmt.Errorf("upstream[%d]: coalesce_max_bytes must not be negative", i)
+		}
+		if upstream.Cache.MaxBytes < 0 || upstream.Cache.MaxTTL < 0 {
+			return fmt.Errorf("upstream[%d]: cache limits must not be negative", i)
+		}
+		if err := validateResponseTypes(upstream.ResponseTypes); err != nil {
+			return fmt.Errorf("upstream[%d]: response_types: %w", i, err)
+		}
+		if err := validateSetCookie(upstream.SetCookie); err != nil {
+			return fmt.Errorf("upstream[%d]: set_cookie: %w", i, err)
+		}
+		if upstream.MaxResponseHeaders < 0 || upstream.MaxResponseHeaderBytes < 0 {
+			return fmt.Errorf("upstream[%d]: response header limits must not be negative", i)
+		}
+		if upstream.CompressRequests.MinBytes < 0 {
+			return fmt.Errorf("upstream[%d]: compress_requests.min_bytes must not be negative", i)
+		}
+		if err := validateBreaker(upstream.CircuitBreaker); err != nil {
+			return fmt.Errorf("upstream[%d]: circuit_breaker: %w", i, err)
+		}
+		if rl := upstream.OutboundRateLimit; rl.RPS < 0 || rl.Burst < 0 || rl.MaxWait < 0 {
+			return fmt.Errorf("upstream[%d]: outbound_rate_limit: rps, burst and max_wait must not be negative", i)
+		}
+		if rb := upstream.RetryBudget; rb.MaxTokens < 0 || rb.TokenRatio < 0 {
+			return fmt.Errorf("upstream[%d]: retry_budget: max_tokens and token_ratio must not be negative", i)
+		}
+		if c.CircuitBreaker.Enabled {
+			settings := upstream.BreakerSettings(c.CircuitBreaker)
+			if settings.FailureThreshold < 1 || settings.Window < 1 || settings.Cooldown < 1 {
+				return fmt.Errorf("upstream[%d]: circuit_breaker: failure_threshold, window and cooldown must be positive", i)
+			}
+		}
+	}
+
+	upstreamNames := make(map[string]bool)
+	passThrough := make(map[string]bool)
+	for _, upstream := range c.Upstreams {
+		upstreamNames[upstream.Name] = true
+		passThrough[upstream.Name] = upstream.PassThrough
+	}
+	if name := c.Server.HostlessUpstream; name != "" && !upstreamNames[name] {
+		return fmt.Errorf("unknown hostless_upstream %q", name)
+	}
+	if name := c.Server.DefaultUpstream; name != "" && !upstreamNames[name] {
+		return fmt.Errorf("unknown default_upstream %q", name)
+	}
+	if name := c.Discovery.Upstream; name != "" {
+		if !upstreamNames[name] {
+			return fmt.Errorf("discovery: unknown upstream %q", name)
+		}
+		if passThrough[name] {
+			return fmt.Errorf("discovery: pass_through upstream %q cannot serve discovery", name)
+		}
+		if c.Discovery.PublicURL == "" {
+			return fmt.Errorf("discovery: public_url is required")
+		}
+		public, err := url.Parse(c.Discovery.PublicURL)
+		if err != nil || (public.Scheme != "http" && public.Scheme != "https") || public.Host == "" ||
+			public.RawQuery != "" || public.Fragment != "" {
+			return fmt.Errorf("discovery: invalid public_url: %q (must be an absolute http or https URL without query)", c.Discovery.PublicURL)
+		}
+	}
+	if c.Discovery.CacheTTL < 0 {
+		return fmt.Errorf("discovery: invalid cache_ttl: %d", c.Discovery.CacheTTL)
+	}
+	for i, upstream := range c.Upstreams {
+		seen := make(map[string]bool)
+		for _, name := range upstream.FallbackUpstreams {
+			if !upstreamNames[name] {
+				return fmt.Errorf("upstream[%d]: unknown fallback upstream %q", i, name)
+			}
+			if name == upstream.Name {
+				return fmt.Errorf("upstream[%d]: fallback_upstreams must not include itself", i)
+			}
+			if seen[name] {
+				return fmt.Errorf("upstream[%d]: duplicate fallback upstream %q", i, name)
+			}
+			seen[name] = true
+		}
+	}
+
+	switch c.Routing.HostConflict {
+	case "", HostConflictHonor, HostConflictWarn, HostConflictReject:
+	default:
+		return fmt.Errorf("invalid routing.host_conflict: %q (must be %q, %q or %q)",
+			c.Routing.HostConflict, HostConflictHonor, HostConflictWarn, HostConflictReject)
+	}
+	if c.Routing.SignatureMaxAge < 0 {
+		return fmt.Errorf("routing: signature_max_age must not be negative")
+	}
+
+	for i, client := range c.Routing.AllowedClients {
+		if _, err := client.Prefix(); err != nil {
+			return fmt.Errorf("routing.allowed_clients[%d]: %w", i, err)
+		}
+		for _, name := range client.Upstreams {
+			if !upstreamNames[name] {
+				return fmt.Errorf("routing.allowed_clients[%d]: unknown upstream %q", i, name)
+			}
+		}
+	}
+
+	if err := validateTee(c.Tee); err != nil {
+		return fmt.Errorf("tee: %w", err)
+	}
+
+	for i, upstream := range c.Upstreams {
+		prefix := upstream.PathPrefix
+		if prefix == "" {
+			continue
+		}
+		if !strings.HasPrefix(prefix, "/") || prefix == "/" || strings.HasSuffix(prefix, "/") {
+			return fmt.Errorf("upstream[%d]: path_prefix must start with /, not end with / and not be the root", i)
+		}
+		if reservedPaths[prefix] || prefix == "/admin" || strings.HasPrefix(prefix, "/admin/") {
+			return fmt.Errorf("upstream[%d]: path_prefix %q is reserved", i, prefix)
+		}
+	}
+
+	aggregatePaths := make(map[string]bool)
+	for i, agg := range c.Aggregates {
+		if !strings.HasPrefix(agg.Path, "/") || agg.Path == "/" {
+			return fmt.Errorf("aggregate[%d]: path must start with / and not be the root", i)
+		}
+		if reservedPaths[agg.Path] || strings.HasPrefix(agg.Path, "/admin/") {
+			return fmt.Errorf("aggregate[%d]: path %q is reserved", i, agg.Path)
+		}
+		if aggregatePaths[agg.Path] {
+			return fmt.Errorf("aggregate[%d]: duplicate path %q", i, agg.Path)
+		}
+		aggregatePaths[agg.Path] = true
+		if len(agg.Members) == 0 {
+			return fmt.Errorf("aggregate[%d]: at least one member is required", i)
+		}
+		keys := make(map[string]bool)
+		for j, member := range agg.Members {
+			if member.Key == "" {
+				return fmt.Errorf("aggregate[%d].members[%d]: key is required", i, j)
+			}
+			if keys[member.Key] {
+				return fmt.Errorf("aggregate[%d].members[%d]: duplicate key %q", i, j, member.Key)
+			}
+			keys[member.Key] = true
+			if !upstreamNames[member.Upstream] {
+				return fmt.Errorf("aggregate[%d].members[%d]: unknown upstream %q", i, j, member.Upstream)
+			}
+			if passThrough[member.Upstream] {
+				return fmt.Errorf("aggregate[%d].members[%d]: pass_through upstream %q cannot be aggregated", i, j, member.Upstream)
+			}
+		}
+	}
+
+	if c.Token.StrictAudience {
+		if mismatches := c.AudienceMismatches(); len(mismatches) > 0 {
+			return fmt.Errorf("%s (strict_audience; set skip_audience_check to allow it)", mismatches[0])
 		}
 	}
 
 	return nil
 }
 
+// validateTee checks an enabled tee has a single valid sink and sane limits
+func validateTee(tee TeeConfig) error {
+	if !tee.Enabled {
+		return nil
+	}
+	if (tee.File == "") == (tee.URL == "") {
+		return fmt.Errorf("exactly one of file and url is required")
+	}
+	if tee.URL != "" {
+		u, err := url.Parse(tee.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid url %q", tee.URL)
+		}
+	}
+	if tee.SampleRate <= 0 || tee.SampleRate > 1 {
+		return fmt.Errorf("sample_rate must be in (0, 1], got %v", tee.SampleRate)
+	}
+	if tee.MaxBodyBytes < 0 || tee.QueueSize < 0 {
+		return fmt.Errorf("max_body_bytes and queue_size must not be negative")
+	}
+	return nil
+}
+
+// validateBreaker rejects negative circuit breaker settings
+func validateBreaker(cb CircuitBreakerConfig) error {
+	if cb.FailureThreshold < 0 || cb.Window < 0 || cb.Cooldown < 0 {
+		return fmt.Errorf("failure_threshold, window and cooldown must not be negative")
+	}
+	return nil
+}
+
+// DeriveAudience returns the scheme://host portion of an upstream URL
+func DeriveAudience(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid url: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("url %q must include scheme and host", rawURL)
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
+
+// IsIAPClientID reports whether audience looks like an OAuth client ID
+// (e.g., 1234-abc.apps.googleusercontent.com)
+func IsIAPClientID(audience string) bool {
+	id := strings.TrimSuffix(audience, IAPClientIDSuffix)
+	return id != "" && id != audience && !strings.ContainsAny(id, "/:. ")
+}
+
+// normalizeIAPAudience reduces a client ID written as a URL to the bare ID;
+// other audiences are returned unchanged
+func normalizeIAPAudience(audience string) string {
+	trimmed := strings.TrimSpace(audience)
+	if u, err := url.Parse(trimmed); err == nil && u.Host != "" && strings.Trim(u.Path, "/") == "" &&
+		strings.HasSuffix(u.Host, IAPClientIDSuffix) {
+		return u.Host
+	}
+	return trimmed
+}
+
 // Load reads and parses the configuration file
 func Load(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
@@ -104,22 +1406,172 @@ func Load(path string) (*Config, error) {
 	if config.Server.IdleTimeout == 0 {
 		config.Server.IdleTimeout = 120
 	}
+	if config.Server.MaxHops == 0 {
+		config.Server.MaxHops = 10
+	}
+	if config.Server.ShutdownTimeout == 0 {
+		config.Server.ShutdownTimeout = 30
+	}
+	if config.Server.TrailingSlash == "" {
+		config.Server.TrailingSlash = TrailingSlashStrict
+	}
+	if config.Server.StartupFailure == "" {
+		config.Server.StartupFailure = StartupFailClosed
+	}
+	if config.Server.StartupRetryInterval == 0 {
+		config.Server.StartupRetryInterval = 30
+	}
+	if config.Server.RootResponse.Status == 0 {
+		config.Server.RootResponse.Status = 200
+	}
+	if config.Server.RootResponse.ContentType == "" {
+		config.Server.RootResponse.ContentType = "text/plain"
+	}
+	if config.Server.RootResponse.Body == "" {
+		config.Server.RootResponse.Body = "OK"
+	}
+	if config.Server.ResponseHeaders.Strip == nil {
+		config.Server.ResponseHeaders.Strip = []string{"Server", "X-Powered-By"}
+	}
+	if config.CircuitBreaker.FailureThreshold == 0 {
+		config.CircuitBreaker.FailureThreshold = 5
+	}
+	if config.CircuitBreaker.Window == 0 {
+		config.CircuitBreaker.Window = 60
+	}
+	if config.CircuitBreaker.Cooldown == 0 {
+		config.CircuitBreaker.Cooldown = 30
+	}
 	if config.Logging.Level == "" {
 		config.Logging.Level = "info"
 	}
 	if config.Logging.Format == "" {
 		config.Logging.Format = "text"
 	}
+	if config.Logging.AccessLogFormat == "" {
+		config.Logging.AccessLogFormat = AccessLogText
+	}
 	if config.Token.RefreshBeforeExpiry == 0 {
 		config.Token.RefreshBeforeExpiry = 5 // 5 minutes
 	}
 	config.Token.EnableCache = true // Always enable cache
+	config.Admin.Token = os.ExpandEnv(config.Admin.Token)
+	config.Routing.SigningSecret = os.ExpandEnv(config.Routing.SigningSecret)
+	if config.Routing.SignatureMaxAge == 0 {
+		config.Routing.SignatureMaxAge = 60
+	}
+
+	if config.Tee.SampleRate == 0 {
+		config.Tee.SampleRate = 0.01
+	}
+	if config.Tee.MaxBodyBytes == 0 {
+		config.Tee.MaxBodyBytes = 64 << 10 // 64 KiB
+	}
+	if config.Tee.QueueSize == 0 {
+		config.Tee.QueueSize = 100
+	}
 
 	// Set default timeouts for upstreams
 	for i := range config.Upstreams {
+		config.Upstreams[i].Host = strings.TrimSpace(config.Upstreams[i].Host)
 		if config.Upstreams[i].Timeout == 0 {
 			config.Upstreams[i].Timeout = 30
 		}
+		if config.Upstreams[i].DialTimeout == 0 {
+			config.Upstreams[i].DialTimeout = 10
+		}
+		if config.Upstreams[i].TLSHandshakeTimeout == 0 {
+			config.Upstreams[i].TLSHandshakeTimeout = 10
+		}
+		if config.Upstreams[i].ResponseHeaderTimeout == 0 {
+			config.Upstreams[i].ResponseHeaderTimeout = config.Upstreams[i].Timeout
+		}
+		if config.Upstreams[i].IdleConnTimeout == 0 {
+			config.Upstreams[i].IdleConnTimeout = 90
+		}
+		if config.Upstreams[i].MaxIdleConns == 0 {
+			config.Upstreams[i].MaxIdleConns = 100
+		}
+		if config.Upstreams[i].MaxIdleConnsPerHost == 0 {
+			config.Upstreams[i].MaxIdleConnsPerHost = 10
+		}
+		if config.Upstreams[i].TokenMode == "" {
+			config.Upstreams[i].TokenMode = TokenModeIDToken
+		}
+		if config.Upstreams[i].TokenMode == TokenModeIAP {
+			config.Upstreams[i].Audience = normalizeIAPAudience(config.Upstreams[i].Audience)
+		}
+		if config.Upstreams[i].Audience == "" && config.Upstreams[i].DeriveAudienceFromURL && config.Upstreams[i].URL != "" {
+			audience, err := DeriveAudience(config.Upstreams[i].URL)
+			if err != nil {
+				return nil, fmt.Errorf("upstream[%d]: cannot derive audience: %w", i, err)
+			}
+			config.Upstreams[i].Audience = audience
+		}
+		if tf := &config.Upstreams[i].TokenFile; tf.Path != "" {
+			if tf.TTL == 0 {
+				tf.TTL = 3600
+			}
+			// The audience only keys the token cache for file tokens
+			if config.Upstreams[i].Audience == "" {
+				config.Upstreams[i].Audience = "file:" + tf.Path
+			}
+		}
+		config.Upstreams[i].CAPEM = os.ExpandEnv(config.Upstreams[i].CAPEM)
+		if config.Upstreams[i].RetryableMethods == nil {
+			config.Upstreams[i].RetryableMethods = DefaultRetryableMethods
+		}
+		if config.Upstreams[i].CoalesceMaxBytes == 0 {
+			config.Upstreams[i].CoalesceMaxBytes = 1 << 20 // 1 MiB
+		}
+		if config.Upstreams[i].Cache.MaxBytes == 0 {
+			config.Upstreams[i].Cache.MaxBytes = 10 << 20 // 10 MiB
+		}
+		if config.Upstreams[i].MaxResponseHeaders == 0 {
+			config.Upstreams[i].MaxResponseHeaders = 256
+		}
+		if config.Upstreams[i].MaxResponseHeaderBytes == 0 {
+			config.Upstreams[i].MaxResponseHeaderBytes = 64 << 10 // 64 KiB
+		}
+		if rl := &config.Upstreams[i].OutboundRateLimit; rl.RPS > 0 && rl.Burst == 0 {
+			rl.Burst = max(1, int(math.Ceil(rl.RPS)))
+		}
+		if rb := &config.Upstreams[i].RetryBudget; rb.MaxTokens > 0 && rb.TokenRatio == 0 {
+			rb.TokenRatio = 0.1
+		}
+		if compress := &config.Upstreams[i].CompressRequests; compress.Enabled && compress.MinBytes == 0 {
+			compress.MinBytes = 1024
+		}
+		if degraded := &config.Upstreams[i].DegradedResponse; degraded.Enabled {
+			if degraded.Status == 0 {
+				degraded.Status = 503
+			}
+			if degraded.ContentType == "" {
+				degraded.ContentType = "application/json"
+			}
+			if degraded.BodyFile != "" {
+				body, err := os.ReadFile(degraded.BodyFile)
+				if err != nil {
+					return nil, fmt.Errorf("upstream[%d]: degraded_response: %w", i, err)
+				}
+				degraded.Body = string(body)
+			}
+		}
+		if config.Upstreams[i].Cache.MaxTTL == 0 {
+			config.Upstreams[i].Cache.MaxTTL = 300 // 5 minutes
+		}
+	}
+
+	if config.Discovery.CacheTTL == 0 {
+		config.Discovery.CacheTTL = 300
+	}
+	config.Discovery.PublicURL = strings.TrimSuffix(strings.TrimSpace(config.Discovery.PublicURL), "/")
+
+	// Set default timeouts for aggregates
+	for i := range config.Aggregates {
+		if config.Aggregates[i].Timeout == 0 {
+			config.Aggregates[i].Timeout = 30
+		}
 	}
 
 	if err := config.Validate(); err != nil {
@@ -128,3 +1580,37 @@ func Load(path string) (*Config, error) {
 
 	return &config, nil
 }
+
+// AudienceMismatches lists upstreams whose audience is a URL naming a
+// different host than the upstream's url (or host override), the most
+// common cause of 401s from Cloud Run. Audiences that are not URLs (such as
+// IAP client IDs), pass-through and token_file upstreams, and upstreams
+// with skip_audience_check are not checked.
+func (c *Config) AudienceMismatches() []string {
+	var mismatches []string
+	for _, upstream := range c.Upstreams {
+		if upstream.SkipAudienceCheck || upstream.PassThrough || upstream.TokenFile.Path != "" {
+			continue
+		}
+		audience, err := url.Parse(upstream.Audience)
+		if err != nil || audience.Scheme == "" || audience.Host == "" {
+			continue
+		}
+		target, err := url.Parse(upstream.URL)
+		if err != nil || target.Host == "" {
+			continue
+		}
+		if strings.EqualFold(audience.Hostname(), target.Hostname()) ||
+			(upstream.Host != "" && strings.EqualFold(audience.Hostname(), hostname(upstream.Host))) {
+			continue
+		}
+		mismatches = append(mismatches, fmt.Sprintf("upstream %s: audience host %q does not match url host %q",
+			upstream.Name, audience.Hostname(), target.Hostname()))
+	}
+	return mismatches
+}
+
+// hostname strips any port from a host[:port] value
+func hostname(host string) string {
+	return (&url.URL{Host: host}).Hostname()
+}
@@ -0,0 +1,1197 @@
+package config
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// writeConfig writes the YAML to a temp file and returns its path
+func writeConfig(t *testing.T, yaml string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestDeriveAudience(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    string
+		wantErr bool
+	}{
+		{"https://svc-abc.a.run.app", "https://svc-abc.a.run.app", false},
+		{"https://svc-abc.a.run.app/", "https://svc-abc.a.run.app", false},
+		{"https://svc-abc.a.run.app/api/v1?x=1", "https://svc-abc.a.run.app", false},
+		{"http://localhost:8081/path", "http://localhost:8081", false},
+		{"svc-abc.a.run.app", "", true},
+		{"://bad", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			got, err := DeriveAudience(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DeriveAudience(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("DeriveAudience(%q) = %q, want %q", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadDerivesAudience(t *testing.T) {
+	path := writeConfig(t, `
+upstreams:
+  - name: derived
+    url: https://svc-abc.a.run.app/api
+    derive_audience_from_url: true
+  - name: explicit
+    url: https://svc-def.a.run.app/api
+    audience: https://custom-audience
+    derive_audience_from_url: true
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if got := cfg.Upstreams[0].Audience; got != "https://svc-abc.a.run.app" {
+		t.Errorf("derived audience = %q, want %q", got, "https://svc-abc.a.run.app")
+	}
+	// An explicit audience always takes precedence over derivation
+	if got := cfg.Upstreams[1].Audience; got != "https://custom-audience" {
+		t.Errorf("explicit audience = %q, want %q", got, "https://custom-audience")
+	}
+}
+
+func TestLoadRequiresAudienceWithoutDerivation(t *testing.T) {
+	path := writeConfig(t, `
+upstreams:
+  - name: missing
+    url: https://svc-abc.a.run.app
+`)
+
+	if _, err := Load(path); err == nil {
+		t.Fatal("Load() expected error for missing audience")
+	}
+}
+
+func TestLoadDeriveAudienceInvalidURL(t *testing.T) {
+	path := writeConfig(t, `
+upstreams:
+  - name: bad
+    url: svc-abc.a.run.app
+    derive_audience_from_url: true
+`)
+
+	if _, err := Load(path); err == nil {
+		t.Fatal("Load() expected error for underivable audience")
+	}
+}
+
+func TestLoadUpstreamHost(t *testing.T) {
+	path := writeConfig(t, `
+upstreams:
+  - name: overridden
+    url: https://10.0.0.5
+    audience: https://svc-abc.a.run.app
+    host: " svc-abc.a.run.app "
+  - name: default
+    url: https://svc-def.a.run.app
+    audience: https://svc-def.a.run.app
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.Upstreams[0].Host; got != "svc-abc.a.run.app" {
+		t.Errorf("host = %q, want svc-abc.a.run.app", got)
+	}
+	if got := cfg.Upstreams[1].Host; got != "" {
+		t.Errorf("host = %q, want empty so the url host is sent", got)
+	}
+
+	for _, host := range []string{"https://svc-abc.a.run.app", "svc-abc.a.run.app/path", "svc abc"} {
+		path := writeConfig(t, `
+upstreams:
+  - name: bad
+    url: https://10.0.0.5
+    audience: https://svc-abc.a.run.app
+    host: "`+host+`"
+`)
+		if _, err := Load(path); err == nil {
+			t.Errorf("Load() expected error for host %q", host)
+		}
+	}
+}
+
+func TestLoadTrailingSlashPolicy(t *testing.T) {
+	path := writeConfig(t, `
+upstreams:
+  - name: api
+    url: https://svc.a.run.app
+    audience: https://svc.a.run.app
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Server.TrailingSlash != TrailingSlashStrict {
+		t.Errorf("default trailing_slash = %q, want %q", cfg.Server.TrailingSlash, TrailingSlashStrict)
+	}
+
+	path = writeConfig(t, `
+server:
+  trailing_slash: sometimes
+upstreams:
+  - name: api
+    url: https://svc.a.run.app
+    audience: https://svc.a.run.app
+`)
+	if _, err := Load(path); err == nil {
+		t.Error("Load() expected error for invalid trailing_slash")
+	}
+}
+
+func TestValidateUpstreamNameHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		wantErr bool
+	}{
+		{"unset", "", false},
+		{"custom", "X-Gateway-Upstream", false},
+		{"invalid name", "X Gateway Upstream", true},
+		{"authorization", "authorization", true},
+		{"host", "Host", true},
+		{"hop-by-hop", "Connection", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Server:    ServerConfig{Port: 8080, UpstreamNameHeader: tt.header},
+				Upstreams: []UpstreamConfig{{Name: "api", URL: "https://svc", Audience: "https://svc"}},
+			}
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateAggregates(t *testing.T) {
+	base := func() *Config {
+		return &Config{
+			Server:    ServerConfig{Port: 8080},
+			Upstreams: []UpstreamConfig{{Name: "api", URL: "https://svc", Audience: "https://svc"}},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		agg     AggregateConfig
+		wantErr bool
+	}{
+		{"valid", AggregateConfig{Path: "/dash", Members: []AggregateMember{{Key: "a", Upstream: "api"}}}, false},
+		{"reserved path", AggregateConfig{Path: "/metrics", Members: []AggregateMember{{Key: "a", Upstream: "api"}}}, true},
+		{"admin path", AggregateConfig{Path: "/admin/x", Members: []AggregateMember{{Key: "a", Upstream: "api"}}}, true},
+		{"no members", AggregateConfig{Path: "/dash"}, true},
+		{"unknown upstream", AggregateConfig{Path: "/dash", Members: []AggregateMember{{Key: "a", Upstream: "nope"}}}, true},
+		{"duplicate key", AggregateConfig{Path: "/dash", Members: []AggregateMember{
+			{Key: "a", Upstream: "api"}, {Key: "a", Upstream: "api"},
+		}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base()
+			cfg.Aggregates = []AggregateConfig{tt.agg}
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBreakerSettingsPrecedence(t *testing.T) {
+	global := CircuitBreakerConfig{Enabled: true, FailureThreshold: 5, Window: 60, Cooldown: 30}
+
+	inherit := UpstreamConfig{}
+	if got := inherit.BreakerSettings(global); got != global {
+		t.Errorf("inherited settings = %+v, want %+v", got, global)
+	}
+
+	override := UpstreamConfig{CircuitBreaker: CircuitBreakerConfig{FailureThreshold: 2, Cooldown: 120}}
+	want := CircuitBreakerConfig{Enabled: true, FailureThreshold: 2, Window: 60, Cooldown: 120}
+	if got := override.BreakerSettings(global); got != want {
+		t.Errorf("overridden settings = %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadValidatesBreaker(t *testing.T) {
+	path := writeConfig(t, `
+circuit_breaker:
+  enabled: true
+upstreams:
+  - name: api
+    url: https://svc.a.run.app
+    audience: https://svc.a.run.app
+    circuit_breaker:
+      failure_threshold: -1
+`)
+	if _, err := Load(path); err == nil {
+		t.Error("Load() expected error for negative breaker override")
+	}
+
+	path = writeConfig(t, `
+circuit_breaker:
+  enabled: true
+upstreams:
+  - name: api
+    url: https://svc.a.run.app
+    audience: https://svc.a.run.app
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cb := cfg.CircuitBreaker; cb.FailureThreshold != 5 || cb.Window != 60 || cb.Cooldown != 30 {
+		t.Errorf("default breaker = %+v", cb)
+	}
+}
+
+func TestValidateFallbackUpstreams(t *testing.T) {
+	tests := []struct {
+		name      string
+		fallbacks []string
+		wantErr   bool
+	}{
+		{"valid", []string{"backup"}, false},
+		{"unknown", []string{"nope"}, true},
+		{"self", []string{"api"}, true},
+		{"duplicate", []string{"backup", "backup"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Server: ServerConfig{Port: 8080},
+				Upstreams: []UpstreamConfig{
+					{Name: "api", URL: "https://svc", Audience: "https://svc", FallbackUpstreams: tt.fallbacks},
+					{Name: "backup", URL: "https://backup", Audience: "https://backup"},
+				},
+			}
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadResponseHeaderDefaults(t *testing.T) {
+	path := writeConfig(t, `
+upstreams:
+  - name: api
+    url: https://svc.a.run.app
+    audience: https://svc.a.run.app
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.Server.ResponseHeaders.Strip; len(got) != 2 || got[0] != "Server" || got[1] != "X-Powered-By" {
+		t.Errorf("default strip = %v, want [Server X-Powered-By]", got)
+	}
+
+	path = writeConfig(t, `
+server:
+  response_headers:
+    strip: []
+upstreams:
+  - name: api
+    url: https://svc.a.run.app
+    audience: https://svc.a.run.app
+`)
+	cfg, err = Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.Server.ResponseHeaders.Strip; len(got) != 0 {
+		t.Errorf("explicit empty strip = %v, want none", got)
+	}
+}
+
+func TestLoadUpstreamTimeoutDefaults(t *testing.T) {
+	path := writeConfig(t, `
+upstreams:
+  - name: api
+    url: https://svc.a.run.app
+    audience: https://svc.a.run.app
+    timeout: 45
+    dial_timeout: 2
+    max_idle_conns_per_host: 32
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	u := cfg.Upstreams[0]
+	if u.DialTimeout != 2 || u.TLSHandshakeTimeout != 10 || u.ResponseHeaderTimeout != 45 {
+		t.Errorf("timeouts = dial %d, tls %d, header %d, want 2, 10, 45",
+			u.DialTimeout, u.TLSHandshakeTimeout, u.ResponseHeaderTimeout)
+	}
+	if u.IdleConnTimeout != 90 || u.MaxIdleConns != 100 || u.MaxIdleConnsPerHost != 32 {
+		t.Errorf("idle conns = timeout %d, max %d, per host %d, want 90, 100, 32",
+			u.IdleConnTimeout, u.MaxIdleConns, u.MaxIdleConnsPerHost)
+	}
+
+	path = writeConfig(t, `
+upstreams:
+  - name: api
+    url: https://svc.a.run.app
+    audience: https://svc.a.run.app
+`)
+	if cfg, err := Load(path); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	} else if got := cfg.Upstreams[0].MaxIdleConnsPerHost; got != 10 {
+		t.Errorf("default max_idle_conns_per_host = %d, want 10 (not Go's stock 2)", got)
+	}
+
+	u.MaxIdleConns = -1
+	cfg.Upstreams[0] = u
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() expected error for negative max_idle_conns")
+	}
+}
+
+func TestValidateCacheRequiresShared(t *testing.T) {
+	cfg := &Config{
+		Server: ServerConfig{Port: 8080},
+		Upstreams: []UpstreamConfig{{Name: "api", URL: "https://svc", Audience: "https://svc",
+			Cache: CacheConfig{Enabled: true}}},
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() expected error for a cache not marked shared")
+	}
+
+	cfg.Upstreams[0].Cache.Shared = true
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
+
+func TestValidatePassThrough(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream UpstreamConfig
+		wantErr  bool
+	}{
+		{"valid", UpstreamConfig{Name: "egress", PassThrough: true, AllowedHosts: []string{"*.run.app"}}, false},
+		{"no allow-list", UpstreamConfig{Name: "egress", PassThrough: true}, true},
+		{"with cache", UpstreamConfig{Name: "egress", PassThrough: true, AllowedHosts: []string{"a.com"},
+			Cache: CacheConfig{Enabled: true}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Server: ServerConfig{Port: 8080}, Upstreams: []UpstreamConfig{tt.upstream}}
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadDegradedResponse(t *testing.T) {
+	bodyFile := filepath.Join(t.TempDir(), "maintenance.json")
+	if err := os.WriteFile(bodyFile, []byte(`{"status":"maintenance"}`), 0o600); err != nil {
+		t.Fatalf("failed to write body file: %v", err)
+	}
+
+	path := writeConfig(t, `
+upstreams:
+  - name: api
+    url: https://svc.a.run.app
+    audience: https://svc.a.run.app
+    degraded_response:
+      enabled: true
+      body_file: `+bodyFile+`
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	degraded := cfg.Upstreams[0].DegradedResponse
+	if degraded.Status != 503 || degraded.ContentType != "application/json" || degraded.Body != `{"status":"maintenance"}` {
+		t.Errorf("degraded response = %+v", degraded)
+	}
+
+	path = writeConfig(t, `
+upstreams:
+  - name: api
+    url: https://svc.a.run.app
+    audience: https://svc.a.run.app
+    degraded_response:
+      enabled: true
+      body_file: /nonexistent/maintenance.json
+`)
+	if _, err := Load(path); err == nil {
+		t.Error("Load() expected error for missing body_file")
+	}
+}
+
+func TestValidateDegradedResponseContentType(t *testing.T) {
+	cfg := &Config{
+		Server: ServerConfig{Port: 8080},
+		Upstreams: []UpstreamConfig{{Name: "api", URL: "https://svc", Audience: "https://svc",
+			DegradedResponse: DegradedResponseConfig{Enabled: true, Status: 503, Body: "{}"}}},
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() expected error for an empty degraded_response content_type")
+	}
+}
+
+func TestValidateQueryParamModesExclusive(t *testing.T) {
+	cfg := &Config{
+		Server: ServerConfig{Port: 8080},
+		Upstreams: []UpstreamConfig{{Name: "api", URL: "https://svc", Audience: "https://svc",
+			StripQueryParams: []string{"a"}, AllowQueryParams: []string{"b"}}},
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() expected error when both strip and allow lists are set")
+	}
+}
+
+// testCAPEM is a self-signed certificate used only to exercise PEM parsing
+const testCAPEM = `-----BEGIN CERTIFICATE-----
+MIIBVTCB/aADAgECAgEBMAoGCCqGSM49BAMCMBIxEDAOBgNVBAMTB3Rlc3QtY2Ew
+IBcNNzAwMTAxMDAwMDAwWhgPMjA5OTAxMDEwMDAwMDBaMBIxEDAOBgNVBAMTB3Rl
+c3QtY2EwWTATBgcqhkjOPQIBBggqhkjOPQMBBwNCAARAk+Qlj7+iqwAUrQq9biXA
+j5Md/OvRRi0CrvO0IYM/297ay+8HIUPXfTzs5IbvWRtRrFyVbV2ixG91IsoAiAA0
+o0IwQDAOBgNVHQ8BAf8EBAMCAgQwDwYDVR0TAQH/BAUwAwEB/zAdBgNVHQ4EFgQU
+AWqz5KjxGvPo/0ejTojd+LojR2IwCgYIKoZIzj0EAwIDRwAwRAIgSUFlDdWhEHLp
+5E0A/r9bY+isJINMEhcaFv3ucbB7GR0CIHOQ/4q6oYGscOxu2uOyPdp9hh+xP7Ig
+TD/snOqVTEmA
+-----END CERTIFICATE-----
+`
+
+func TestLoadUpstreamCA(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, []byte(testCAPEM), 0o600); err != nil {
+		t.Fatalf("failed to write ca file: %v", err)
+	}
+	t.Setenv("TEST_UPSTREAM_CA", testCAPEM)
+
+	tests := []struct {
+		name    string
+		ca      string
+		wantErr bool
+	}{
+		{"file", "ca_file: " + caFile, false},
+		{"inline from env", `ca_pem: "${TEST_UPSTREAM_CA}"`, false},
+		{"missing file", "ca_file: /nonexistent/ca.pem", true},
+		{"invalid inline", `ca_pem: "not a certificate"`, true},
+		{"both", "ca_file: " + caFile + "\n    ca_pem: \"${TEST_UPSTREAM_CA}\"", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfig(t, `
+upstreams:
+  - name: api
+    url: https://svc.internal
+    audience: https://svc.internal
+    `+tt.ca+`
+`)
+			cfg, err := Load(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			pool, err := cfg.Upstreams[0].CertPool()
+			if err != nil || pool == nil {
+				t.Errorf("CertPool() = %v, %v; want a pool", pool, err)
+			}
+		})
+	}
+}
+
+func TestValidateExactCaseHeaders(t *testing.T) {
+	cfg := &Config{
+		Server: ServerConfig{Port: 8080},
+		Upstreams: []UpstreamConfig{{Name: "api", URL: "https://svc", Audience: "https://svc",
+			ExactCaseHeaders: map[string]string{"x-api-key": "v"}}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	cfg.Upstreams[0].ExactCaseHeaders = map[string]string{"x api key": "v"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() expected error for an invalid header name")
+	}
+}
+
+func TestValidateUpstreamLogLevel(t *testing.T) {
+	cfg := &Config{
+		Server:    ServerConfig{Port: 8080},
+		Upstreams: []UpstreamConfig{{Name: "api", URL: "https://svc", Audience: "https://svc", LogLevel: "DEBUG"}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	cfg.Upstreams[0].LogLevel = "verbose"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() expected error for an unknown log_level")
+	}
+}
+
+func TestValidateResponseTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		types   ResponseTypesConfig
+		wantErr bool
+	}{
+		{"exact and wildcard", ResponseTypesConfig{Allowed: []string{"application/json", "text/*"}}, false},
+		{"strip action", ResponseTypesConfig{Allowed: []string{"application/json"}, Action: ResponseTypeStrip}, false},
+		{"missing subtype", ResponseTypesConfig{Allowed: []string{"json"}}, true},
+		{"parameters", ResponseTypesConfig{Allowed: []string{"text/plain; charset=utf-8"}}, true},
+		{"any type", ResponseTypesConfig{Allowed: []string{"*/*"}}, true},
+		{"unknown action", ResponseTypesConfig{Allowed: []string{"application/json"}, Action: "drop"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Server:    ServerConfig{Port: 8080},
+				Upstreams: []UpstreamConfig{{Name: "api", URL: "https://svc", Audience: "https://svc", ResponseTypes: tt.types}},
+			}
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidatePathPrefix(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefixes []string
+		wantErr  bool
+	}{
+		{"valid", []string{"/billing", "/billing/v2"}, false},
+		{"no leading slash", []string{"billing"}, true},
+		{"trailing slash", []string{"/billing/"}, true},
+		{"root", []string{"/"}, true},
+		{"reserved", []string{"/metrics"}, true},
+		{"discovery", []string{"/.well-known/jwks.json"}, true},
+		{"debug config", []string{"/debug/config"}, true},
+		{"admin", []string{"/admin"}, true},
+		{"duplicate", []string{"/billing", "/billing"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Server: ServerConfig{Port: 8080}}
+			for i, prefix := range tt.prefixes {
+				cfg.Upstreams = append(cfg.Upstreams, UpstreamConfig{
+					Name: "api" + strconv.Itoa(i), URL: "https://svc", Audience: "https://svc", PathPrefix: prefix,
+				})
+			}
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateHostlessUpstream(t *testing.T) {
+	cfg := &Config{
+		Server:    ServerConfig{Port: 8080, HostlessUpstream: "api"},
+		Upstreams: []UpstreamConfig{{Name: "api", URL: "https://svc", Audience: "https://svc"}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	cfg.Server.HostlessUpstream = "missing"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() expected error for an unknown hostless_upstream")
+	}
+}
+
+func TestValidateDefaultRoute(t *testing.T) {
+	cfg := &Config{
+		Server:    ServerConfig{Port: 8080, DefaultUpstream: "api", DefaultRoute: DefaultRouteWarn},
+		Upstreams: []UpstreamConfig{{Name: "api", URL: "https://svc", Audience: "https://svc"}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	cfg.Server.DefaultRoute = "drop"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() expected error for an unknown default_route")
+	}
+
+	cfg.Server.DefaultRoute = DefaultRouteReject
+	cfg.Server.DefaultUpstream = "missing"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() expected error for an unknown default_upstream")
+	}
+}
+
+func TestValidateDefaultRouteToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    string
+		audience string
+		wantErr  bool
+	}{
+		{"default", "", "", false},
+		{"fallback audience", "", "https://public", false},
+		{"mint with audience", DefaultRouteTokenMint, "https://public", false},
+		{"none", DefaultRouteTokenNone, "", false},
+		{"none with audience", DefaultRouteTokenNone, "https://public", true},
+		{"unknown", "anonymous", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Server:    ServerConfig{Port: 8080, DefaultRouteToken: tt.token, DefaultRouteAudience: tt.audience},
+				Upstreams: []UpstreamConfig{{Name: "api", URL: "https://svc", Audience: "https://svc"}},
+			}
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateClientAuthorization(t *testing.T) {
+	for _, mode := range []string{"", ClientAuthorizationReplace, ClientAuthorizationReject} {
+		cfg := &Config{
+			Server:    ServerConfig{Port: 8080, ClientAuthorization: mode},
+			Upstreams: []UpstreamConfig{{Name: "api", URL: "https://svc", Audience: "https://svc"}},
+		}
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Validate(%q) error = %v", mode, err)
+		}
+	}
+
+	cfg := &Config{
+		Server:    ServerConfig{Port: 8080, ClientAuthorization: "merge"},
+		Upstreams: []UpstreamConfig{{Name: "api", URL: "https://svc", Audience: "https://svc"}},
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() expected error for an unknown client_authorization")
+	}
+}
+
+func TestValidateDiscovery(t *testing.T) {
+	tests := []struct {
+		name      string
+		discovery DiscoveryConfig
+		wantErr   bool
+	}{
+		{"off", DiscoveryConfig{}, false},
+		{"upstream", DiscoveryConfig{Upstream: "idp", CacheTTL: 300, PublicURL: "https://gw.example.com"}, false},
+		{"public_url with path", DiscoveryConfig{Upstream: "idp", PublicURL: "https://gw.example.com/auth"}, false},
+		{"unknown upstream", DiscoveryConfig{Upstream: "missing", PublicURL: "https://gw.example.com"}, true},
+		{"pass-through upstream", DiscoveryConfig{Upstream: "raw", PublicURL: "https://gw.example.com"}, true},
+		{"negative cache_ttl", DiscoveryConfig{Upstream: "idp", CacheTTL: -1, PublicURL: "https://gw.example.com"}, true},
+		{"no public_url", DiscoveryConfig{Upstream: "idp"}, true},
+		{"relative public_url", DiscoveryConfig{Upstream: "idp", PublicURL: "gw.example.com"}, true},
+		{"public_url with query", DiscoveryConfig{Upstream: "idp", PublicURL: "https://gw.example.com?x=1"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Server: ServerConfig{Port: 8080},
+				Upstreams: []UpstreamConfig{
+					{Name: "idp", URL: "https://idp", Audience: "https://idp"},
+					{Name: "raw", PassThrough: true, AllowedHosts: []string{"*.run.app"}},
+				},
+				Discovery: tt.discovery,
+			}
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateRoutingHostConflict(t *testing.T) {
+	cfg := &Config{
+		Server:    ServerConfig{Port: 8080},
+		Upstreams: []UpstreamConfig{{Name: "api", URL: "https://svc", Audience: "https://svc"}},
+		Routing:   RoutingConfig{HostConflict: HostConflictReject},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	cfg.Routing.HostConflict = "ignore"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() expected error for an unknown host_conflict")
+	}
+}
+
+func TestLoadRoutingSignatureMaxAge(t *testing.T) {
+	path := writeConfig(t, `
+upstreams:
+  - name: api
+    url: https://svc.a.run.app
+    audience: https://svc.a.run.app
+routing:
+  signing_secret: s3cret
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.Routing.SignatureMaxAge; got != 60 {
+		t.Errorf("default signature_max_age = %d, want 60", got)
+	}
+
+	cfg.Routing.SignatureMaxAge = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() expected error for negative signature_max_age")
+	}
+}
+
+func TestValidateRoutingAllowedClients(t *testing.T) {
+	tests := []struct {
+		name    string
+		client  RoutingClientConfig
+		wantErr bool
+	}{
+		{"cidr", RoutingClientConfig{CIDR: "10.0.0.0/8", Upstreams: []string{"api"}}, false},
+		{"single ip", RoutingClientConfig{CIDR: "2001:db8::1", Upstreams: []string{"api"}}, false},
+		{"bad cidr", RoutingClientConfig{CIDR: "10.0.0.0/33", Upstreams: []string{"api"}}, true},
+		{"unknown upstream", RoutingClientConfig{CIDR: "10.0.0.0/8", Upstreams: []string{"missing"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Server:    ServerConfig{Port: 8080},
+				Upstreams: []UpstreamConfig{{Name: "api", URL: "https://svc", Audience: "https://svc"}},
+				Routing:   RoutingConfig{AllowedClients: []RoutingClientConfig{tt.client}},
+			}
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateTransformRules(t *testing.T) {
+	tests := []struct {
+		name      string
+		transform TransformConfig
+		wantErr   bool
+	}{
+		{"path to header", TransformConfig{Request: []TransformRule{{From: "path:1", To: "header:X-Tenant"}}}, false},
+		{"header to header", TransformConfig{Response: []TransformRule{{From: "header:X-A", To: "header:X-B"}}}, false},
+		{"unknown kind", TransformConfig{Request: []TransformRule{{From: "query:tenant", To: "header:X-Tenant"}}}, true},
+		{"segment zero", TransformConfig{Request: []TransformRule{{From: "path:0", To: "header:X-Tenant"}}}, true},
+		{"write to path", TransformConfig{Request: []TransformRule{{From: "header:X-A", To: "path:1"}}}, true},
+		{"response from path", TransformConfig{Response: []TransformRule{{From: "path:1", To: "header:X-B"}}}, true},
+		{"invalid header", TransformConfig{Request: []TransformRule{{From: "header:X A", To: "header:X-B"}}}, true},
+		{"protected header", TransformConfig{Request: []TransformRule{{From: "path:1", To: "header:authorization"}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Server:    ServerConfig{Port: 8080},
+				Upstreams: []UpstreamConfig{{Name: "api", URL: "https://svc", Audience: "https://svc", Transform: tt.transform}},
+			}
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadRetryableMethods(t *testing.T) {
+	path := writeConfig(t, `
+upstreams:
+  - name: default
+    url: https://svc.a.run.app
+    audience: https://svc.a.run.app
+  - name: never
+    url: https://svc.a.run.app
+    audience: https://svc.a.run.app
+    retryable_methods: []
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.Upstreams[0].RetryableMethods; len(got) != len(DefaultRetryableMethods) {
+		t.Errorf("default retryable_methods = %v, want %v", got, DefaultRetryableMethods)
+	}
+	// An explicit empty list disables retries rather than taking the default
+	if got := cfg.Upstreams[1].RetryableMethods; got == nil || len(got) != 0 {
+		t.Errorf("empty retryable_methods = %#v, want an empty list", got)
+	}
+}
+
+func TestLoadTokenFile(t *testing.T) {
+	path := writeConfig(t, `
+upstreams:
+  - name: sidecar
+    url: https://partner.example.com
+    token_file:
+      path: /var/run/tokens/partner
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	upstream := cfg.Upstreams[0]
+	if upstream.TokenFile.TTL != 3600 {
+		t.Errorf("ttl = %d, want default 3600", upstream.TokenFile.TTL)
+	}
+	if upstream.Audience != "file:/var/run/tokens/partner" {
+		t.Errorf("audience = %q, want the file cache key", upstream.Audience)
+	}
+
+	cfg.Upstreams[0].TokenFile = TokenFileConfig{ExpiryPath: "/var/run/tokens/expiry"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() expected error for token_file without path")
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	cfg, err := (&TLSConfig{
+		MinVersion:       "1.3",
+		CipherSuites:     []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"},
+		CurvePreferences: []string{"X25519", "P384"},
+	}).Config()
+	if err != nil {
+		t.Fatalf("Config() error = %v", err)
+	}
+	if cfg.MinVersion != tls.VersionTLS13 {
+		t.Errorf("MinVersion = %x, want TLS 1.3", cfg.MinVersion)
+	}
+	wantSuites := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256}
+	if !slices.Equal(cfg.CipherSuites, wantSuites) {
+		t.Errorf("CipherSuites = %v, want %v", cfg.CipherSuites, wantSuites)
+	}
+	if want := []tls.CurveID{tls.X25519, tls.CurveP384}; !slices.Equal(cfg.CurvePreferences, want) {
+		t.Errorf("CurvePreferences = %v, want %v", cfg.CurvePreferences, want)
+	}
+
+	// Empty lists fall back to the defaults
+	cfg, err = (&TLSConfig{}).Config()
+	if err != nil {
+		t.Fatalf("Config() defaults error = %v", err)
+	}
+	if cfg.MinVersion != tls.VersionTLS12 || len(cfg.CipherSuites) != len(DefaultTLSCipherSuites) ||
+		len(cfg.CurvePreferences) != len(DefaultTLSCurves) {
+		t.Errorf("defaults = %+v", cfg)
+	}
+}
+
+func TestTLSConfigRejectsUnknownNames(t *testing.T) {
+	tests := []struct {
+		name string
+		tls  TLSConfig
+	}{
+		{"unknown suite", TLSConfig{CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_MADE_UP"}}},
+		{"insecure suite", TLSConfig{CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_RC4_128_SHA"}}},
+		{"no http2 suite", TLSConfig{CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}}},
+		{"unknown curve", TLSConfig{CurvePreferences: []string{"P192"}}},
+		{"unknown version", TLSConfig{MinVersion: "1.1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.tls.Config(); err == nil {
+				t.Error("Config() expected an error")
+			}
+		})
+	}
+}
+
+func TestValidateServerTLS(t *testing.T) {
+	cfg := &Config{
+		Server:    ServerConfig{Port: 8080, TLS: TLSConfig{CertFile: "cert.pem"}},
+		Upstreams: []UpstreamConfig{{Name: "api", URL: "https://svc", Audience: "https://svc"}},
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() expected error for cert_file without key_file")
+	}
+
+	cfg.Server.TLS.KeyFile = "key.pem"
+	cfg.Server.TLS.CipherSuites = []string{"TLS_RSA_WITH_3DES_EDE_CBC_SHA"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() expected error for an insecure cipher suite")
+	}
+}
+
+func TestAudienceMismatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream UpstreamConfig
+		want     bool
+	}{
+		{"matching host", UpstreamConfig{URL: "https://svc.a.run.app/v1", Audience: "https://svc.a.run.app"}, false},
+		{"host case and port ignored", UpstreamConfig{URL: "https://SVC.a.run.app:443", Audience: "https://svc.a.run.app"}, false},
+		{"host override", UpstreamConfig{URL: "https://10.0.0.5", Host: "svc.a.run.app", Audience: "https://svc.a.run.app"}, false},
+		{"client id audience", UpstreamConfig{URL: "https://iap.example.com", Audience: "1234.apps.googleusercontent.com"}, false},
+		{"mismatched host", UpstreamConfig{URL: "https://billing.a.run.app", Audience: "https://orders.a.run.app"}, true},
+		{"suppressed", UpstreamConfig{URL: "https://billing.a.run.app", Audience: "https://orders.a.run.app", SkipAudienceCheck: true}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.upstream.Name = "api"
+			cfg := &Config{Server: ServerConfig{Port: 8080}, Upstreams: []UpstreamConfig{tt.upstream}}
+			if got := cfg.AudienceMismatches(); (len(got) > 0) != tt.want {
+				t.Errorf("AudienceMismatches() = %v, want mismatch %v", got, tt.want)
+			}
+			// A mismatch is only a warning unless strict_audience is set
+			if err := cfg.Validate(); err != nil {
+				t.Errorf("Validate() error = %v", err)
+			}
+			cfg.Token.StrictAudience = true
+			if err := cfg.Validate(); (err != nil) != tt.want {
+				t.Errorf("strict Validate() error = %v, wantErr %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadOutboundRateLimit(t *testing.T) {
+	path := writeConfig(t, `
+upstreams:
+  - name: fragile
+    url: https://svc.a.run.app
+    audience: https://svc.a.run.app
+    outbound_rate_limit:
+      rps: 2.5
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.Upstreams[0].OutboundRateLimit.Burst; got != 3 {
+		t.Errorf("default burst = %d, want rps rounded up (3)", got)
+	}
+
+	cfg.Upstreams[0].OutboundRateLimit.MaxWait = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() expected error for negative max_wait")
+	}
+}
+
+func TestLoadRetryBudget(t *testing.T) {
+	path := writeConfig(t, `
+upstreams:
+  - name: fragile
+    url: https://svc.a.run.app
+    audience: https://svc.a.run.app
+    retry_budget:
+      max_tokens: 10
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.Upstreams[0].RetryBudget.TokenRatio; got != 0.1 {
+		t.Errorf("default token_ratio = %v, want 0.1", got)
+	}
+
+	cfg.Upstreams[0].RetryBudget.MaxTokens = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() expected error for negative max_tokens")
+	}
+}
+
+func TestLoadResponseHeaderLimitDefaults(t *testing.T) {
+	path := writeConfig(t, `
+upstreams:
+  - name: api
+    url: https://svc.a.run.app
+    audience: https://svc.a.run.app
+    max_response_headers: 50
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	u := cfg.Upstreams[0]
+	if u.MaxResponseHeaders != 50 || u.MaxResponseHeaderBytes != 64<<10 {
+		t.Errorf("limits = %d headers, %d bytes, want 50 and the 64 KiB default",
+			u.MaxResponseHeaders, u.MaxResponseHeaderBytes)
+	}
+}
+
+func TestValidateTee(t *testing.T) {
+	tests := []struct {
+		name    string
+		tee     TeeConfig
+		wantErr bool
+	}{
+		{"disabled", TeeConfig{}, false},
+		{"file", TeeConfig{Enabled: true, SampleRate: 0.1, File: "/tmp/tee.jsonl"}, false},
+		{"url", TeeConfig{Enabled: true, SampleRate: 1, URL: "https://collector.internal/tee"}, false},
+		{"no sink", TeeConfig{Enabled: true, SampleRate: 0.1}, true},
+		{"both sinks", TeeConfig{Enabled: true, SampleRate: 0.1, File: "/tmp/tee.jsonl", URL: "https://collector"}, true},
+		{"bad url", TeeConfig{Enabled: true, SampleRate: 0.1, URL: "collector:9000"}, true},
+		{"sample rate over 1", TeeConfig{Enabled: true, SampleRate: 1.5, File: "/tmp/tee.jsonl"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Server:    ServerConfig{Port: 8080},
+				Upstreams: []UpstreamConfig{{Name: "api", URL: "https://svc", Audience: "https://svc"}},
+				Tee:       tt.tee,
+			}
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateDialOverrides(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream UpstreamConfig
+		wantErr  bool
+	}{
+		{"dial address", UpstreamConfig{DialAddress: "10.128.0.42:443"}, false},
+		{"source address", UpstreamConfig{SourceAddress: "10.0.0.5"}, false},
+		{"dial address without port", UpstreamConfig{DialAddress: "10.128.0.42"}, true},
+		{"source address not an ip", UpstreamConfig{SourceAddress: "eth0"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.upstream.Name, tt.upstream.URL, tt.upstream.Audience = "api", "https://svc", "https://svc"
+			cfg := &Config{Server: ServerConfig{Port: 8080}, Upstreams: []UpstreamConfig{tt.upstream}}
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadStartupFailure(t *testing.T) {
+	path := writeConfig(t, `
+upstreams:
+  - name: api
+    url: https://svc.a.run.app
+    audience: https://svc.a.run.app
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Server.StartupFailure != StartupFailClosed || cfg.Server.StartupRetryInterval != 30 {
+		t.Errorf("defaults = %q every %ds, want fail_closed every 30s",
+			cfg.Server.StartupFailure, cfg.Server.StartupRetryInterval)
+	}
+
+	cfg.Server.StartupFailure = "fail_sometimes"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() expected error for unknown startup_failure")
+	}
+}
+
+func TestValidateIAPTokenMode(t *testing.T) {
+	const clientID = "1234567890-abcdef.apps.googleusercontent.com"
+	tests := []struct {
+		name     string
+		upstream UpstreamConfig
+		wantErr  bool
+	}{
+		{"client id", UpstreamConfig{Audience: clientID}, false},
+		{"url audience", UpstreamConfig{Audience: "https://app.example.com"}, true},
+		{"bare suffix", UpstreamConfig{Audience: IAPClientIDSuffix}, true},
+		{"derived audience", UpstreamConfig{Audience: clientID, DeriveAudienceFromURL: true}, true},
+		{"pass-through", UpstreamConfig{Audience: clientID, PassThrough: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := tt.upstream
+			upstream.Name, upstream.URL, upstream.TokenMode = "app", "https://app.example.com", TokenModeIAP
+			cfg := &Config{Server: ServerConfig{Port: 8080}, Upstreams: []UpstreamConfig{upstream}}
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	cfg := &Config{Server: ServerConfig{Port: 8080}, Upstreams: []UpstreamConfig{{
+		Name: "app", URL: "https://app.example.com", Audience: clientID, TokenMode: "oidc"}}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() expected error for unknown token_mode")
+	}
+}
+
+func TestLoadIAPAudienceNormalized(t *testing.T) {
+	path := writeConfig(t, `
+upstreams:
+  - name: app
+    url: https://app.example.com
+    audience: https://1234567890-abcdef.apps.googleusercontent.com/
+    token_mode: iap
+  - name: api
+    url: https://svc.a.run.app
+    audience: https://svc.a.run.app/
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.Upstreams[0].Audience; got != "1234567890-abcdef.apps.googleusercontent.com" {
+		t.Errorf("iap audience = %q, want the bare client ID", got)
+	}
+	if got := cfg.Upstreams[1]; got.Audience != "https://svc.a.run.app/" || got.TokenMode != TokenModeIDToken {
+		t.Errorf("id_token upstream = %q (mode %q), want it untouched", got.Audience, got.TokenMode)
+	}
+}
+
+func TestValidateSetCookie(t *testing.T) {
+	tests := []struct {
+		name    string
+		sc      SetCookieConfig
+		wantErr bool
+	}{
+		{"default", SetCookieConfig{}, false},
+		{"strip", SetCookieConfig{Mode: SetCookieStrip}, false},
+		{"rewrite", SetCookieConfig{Mode: SetCookieRewriteDomain, Domain: "gw.example.com", Path: "/app"}, false},
+		{"domain without rewrite", SetCookieConfig{Mode: SetCookieStrip, Domain: "gw.example.com"}, true},
+		{"relative path", SetCookieConfig{Mode: SetCookieRewriteDomain, Path: "app"}, true},
+		{"unknown mode", SetCookieConfig{Mode: "rewrite-domain"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateSetCookie(tt.sc); (err != nil) != tt.wantErr {
+				t.Errorf("validateSetCookie() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
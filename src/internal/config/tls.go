@@ -0,0 +1,127 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"slices"
+)
+
+// TLSConfig enables TLS on the server listener when CertFile and KeyFile are
+// set. CipherSuites and CurvePreferences take the names used by crypto/tls
+// (e.g., TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, X25519); empty lists use
+// DefaultTLSCipherSuites and DefaultTLSCurves. TLS 1.3 suites are not
+// configurable and are always enabled when MinVersion allows TLS 1.3.
+type TLSConfig struct {
+	CertFile         string   `yaml:"cert_file"`
+	KeyFile          string   `yaml:"key_file"`
+	MinVersion       string   `yaml:"min_version"` // "1.2" (default) or "1.3"
+	CipherSuites     []string `yaml:"cipher_suites"`
+	CurvePreferences []string `yaml:"curve_preferences"`
+}
+
+// DefaultTLSCipherSuites are the TLS 1.2 suites offered when none are
+// configured: forward-secret AEAD suites only
+var DefaultTLSCipherSuites = []string{
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+}
+
+// DefaultTLSCurves are the key exchange groups offered when none are configured
+var DefaultTLSCurves = []string{"X25519MLKEM768", "X25519", "P256", "P384"}
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"X25519MLKEM768": tls.X25519MLKEM768,
+	"X25519":         tls.X25519,
+	"P256":           tls.CurveP256,
+	"P384":           tls.CurveP384,
+	"P521":           tls.CurveP521,
+}
+
+// Enabled reports whether the listener serves TLS
+func (t *TLSConfig) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+// Config maps the configured names to a tls.Config (without certificates),
+// rejecting unknown or insecure cipher suites and unknown curves
+func (t *TLSConfig) Config() (*tls.Config, error) {
+	version := uint16(tls.VersionTLS12)
+	if t.MinVersion != "" {
+		v, ok := tlsVersions[t.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid min_version %q (want 1.2 or 1.3)", t.MinVersion)
+		}
+		version = v
+	}
+
+	suiteNames := t.CipherSuites
+	if len(suiteNames) == 0 {
+		suiteNames = DefaultTLSCipherSuites
+	}
+	suites, err := cipherSuiteIDs(suiteNames)
+	if err != nil {
+		return nil, err
+	}
+	if version < tls.VersionTLS13 && !slices.ContainsFunc(suites, http2Suite) {
+		return nil, fmt.Errorf("cipher_suites must include TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 (required by HTTP/2)")
+	}
+
+	curveNames := t.CurvePreferences
+	if len(curveNames) == 0 {
+		curveNames = DefaultTLSCurves
+	}
+	curves := make([]tls.CurveID, 0, len(curveNames))
+	for _, name := range curveNames {
+		id, ok := tlsCurves[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown curve %q", name)
+		}
+		curves = append(curves, id)
+	}
+
+	return &tls.Config{
+		MinVersion:       version,
+		CipherSuites:     suites,
+		CurvePreferences: curves,
+	}, nil
+}
+
+// http2Suite reports whether id is one of the TLS 1.2 suites HTTP/2 requires
+func http2Suite(id uint16) bool {
+	return id == tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 || id == tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256
+}
+
+// cipherSuiteIDs maps cipher suite names to their IDs; suites crypto/tls
+// considers insecure are rejected along with unknown names
+func cipherSuiteIDs(names []string) ([]uint16, error) {
+	secure := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		secure[suite.Name] = suite.ID
+	}
+	insecure := make(map[string]bool)
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecure[suite.Name] = true
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := secure[name]
+		switch {
+		case insecure[name]:
+			return nil, fmt.Errorf("cipher suite %q is insecure", name)
+		case !ok:
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,73 @@
+// Package lifecycle records the gateway's startup and shutdown milestones as
+// distinct, machine-parseable log events, and keeps the current state for
+// metrics so rollout tooling can detect readiness and completion precisely.
+package lifecycle
+
+import (
+	"sync"
+	"time"
+
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+// Event is a lifecycle milestone
+type Event string
+
+const (
+	StartupBegin   Event = "startup_begin"   // process started
+	ConfigLoaded   Event = "config_loaded"   // configuration loaded and validated
+	WarmupComplete Event = "warmup_complete" // first token minted within startup_mint_window; skipped when tokens are minted lazily
+	Listening      Event = "listening"       // accepting connections
+	Draining       Event = "draining"        // not ready; in-flight requests finishing
+	Stopped        Event = "stopped"         // shutdown complete
+)
+
+// Events lists every event in the order they occur
+var Events = []Event{StartupBegin, ConfigLoaded, WarmupComplete, Listening, Draining, Stopped}
+
+// Record is an emitted event and when it happened
+type Record struct {
+	Event Event
+	Time  time.Time
+}
+
+// Recorder emits lifecycle events and remembers them
+type Recorder struct {
+	mu      sync.Mutex
+	history []Record
+	now     func() time.Time
+}
+
+// NewRecorder returns an empty recorder
+func NewRecorder() *Recorder {
+	return &Recorder{now: time.Now}
+}
+
+// Emit logs the event with its timestamp (plus any extra key/value pairs)
+// and makes it the current state
+func (r *Recorder) Emit(event Event, keysAndValues ...interface{}) {
+	r.mu.Lock()
+	record := Record{Event: event, Time: r.now()}
+	r.history = append(r.history, record)
+	r.mu.Unlock()
+
+	fields := append([]interface{}{"event", string(event), "ts", record.Time.UTC().Format(time.RFC3339Nano)}, keysAndValues...)
+	logger.Info("Lifecycle event", fields...)
+}
+
+// Current returns the most recent event, or false if none was emitted
+func (r *Recorder) Current() (Record, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.history) == 0 {
+		return Record{}, false
+	}
+	return r.history[len(r.history)-1], true
+}
+
+// History returns every emitted event in order
+func (r *Recorder) History() []Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Record(nil), r.history...)
+}
@@ -0,0 +1,58 @@
+package lifecycle
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+func TestRecorderSequence(t *testing.T) {
+	logger.Init("info")
+	var buf bytes.Buffer
+	logger.SetOutput(&buf)
+	t.Cleanup(func() { logger.SetOutput(os.Stdout) })
+
+	r := NewRecorder()
+	if _, ok := r.Current(); ok {
+		t.Fatal("Current() reported a state before any event")
+	}
+
+	clock := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	r.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	for _, event := range Events {
+		r.Emit(event, "detail", "x")
+	}
+
+	history := r.History()
+	if len(history) != len(Events) {
+		t.Fatalf("history has %d events, want %d", len(history), len(Events))
+	}
+	for i, record := range history {
+		if record.Event != Events[i] {
+			t.Errorf("history[%d] = %s, want %s", i, record.Event, Events[i])
+		}
+		if i > 0 && !record.Time.After(history[i-1].Time) {
+			t.Errorf("history[%d] not after its predecessor", i)
+		}
+	}
+	if current, _ := r.Current(); current.Event != Stopped {
+		t.Errorf("Current() = %s, want %s", current.Event, Stopped)
+	}
+
+	// Each event is a distinct log line with its name and timestamp
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(Events) {
+		t.Fatalf("logged %d lines, want %d:\n%s", len(lines), len(Events), buf.String())
+	}
+	want := "event=startup_begin ts=2026-01-02T03:04:06Z detail=x"
+	if !strings.Contains(lines[0], want) {
+		t.Errorf("first line = %q, want it to contain %q", lines[0], want)
+	}
+}
@@ -2,9 +2,11 @@ package logger
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,27 +21,93 @@ const (
 )
 
 var (
-	currentLevel Level = INFO
-	logger       *log.Logger
+	currentLevel atomic.Int32                // a Level; may change while other goroutines log
+	logger       = log.New(os.Stdout, "", 0) // replaced by Init; set so logging before it is safe
 )
 
+func init() {
+	currentLevel.Store(int32(INFO))
+}
+
+// enabled reports whether the global level lets messages at level through
+func enabled(level Level) bool {
+	return Level(currentLevel.Load()) <= level
+}
+
 func Init(levelStr string) {
 	logger = log.New(os.Stdout, "", 0)
 	SetLevel(levelStr)
 }
 
+// SetOutput redirects log output (e.g., to capture logs in tests)
+func SetOutput(w io.Writer) {
+	logger.SetOutput(w)
+}
+
 func SetLevel(levelStr string) {
+	level, _ := ParseLevel(levelStr)
+	currentLevel.Store(int32(level))
+}
+
+// ParseLevel converts debug, info, warn or error to a Level; anything else
+// yields INFO and false
+func ParseLevel(levelStr string) (Level, bool) {
 	switch strings.ToLower(levelStr) {
 	case "debug":
-		currentLevel = DEBUG
+		return DEBUG, true
 	case "info":
-		currentLevel = INFO
+		return INFO, true
 	case "warn":
-		currentLevel = WARN
+		return WARN, true
 	case "error":
-		currentLevel = ERROR
+		return ERROR, true
 	default:
-		currentLevel = INFO
+		return INFO, false
+	}
+}
+
+// Scoped logs at its own level instead of the global one, e.g. to raise the
+// verbosity of a single upstream's requests
+type Scoped struct {
+	level    Level
+	override bool
+}
+
+// WithLevel returns a scoped logger for levelStr; an empty or unknown level
+// follows the global level
+func WithLevel(levelStr string) *Scoped {
+	level, ok := ParseLevel(levelStr)
+	return &Scoped{level: level, override: ok}
+}
+
+func (s *Scoped) enabled(level Level) bool {
+	if s.override {
+		return s.level <= level
+	}
+	return enabled(level)
+}
+
+func (s *Scoped) Debug(msg string, keysAndValues ...interface{}) {
+	if s.enabled(DEBUG) {
+		logger.Println(formatMessage("DEBUG", msg, keysAndValues...))
+	}
+}
+
+func (s *Scoped) Info(msg string, keysAndValues ...interface{}) {
+	if s.enabled(INFO) {
+		logger.Println(formatMessage("INFO", msg, keysAndValues...))
+	}
+}
+
+func (s *Scoped) Warn(msg string, keysAndValues ...interface{}) {
+	if s.enabled(WARN) {
+		logger.Println(formatMessage("WARN", msg, keysAndValues...))
+	}
+}
+
+func (s *Scoped) Error(msg string, keysAndValues ...interface{}) {
+	if s.enabled(ERROR) {
+		logger.Println(formatMessage("ERROR", msg, keysAndValues...))
 	}
 }
 
@@ -67,26 +135,33 @@ func format(v interface{}) string {
 	}
 }
 
+// Write emits a preformatted line (such as an access log entry) at info level
+func Write(line string) {
+	if enabled(INFO) {
+		logger.Println(line)
+	}
+}
+
 func Debug(msg string, keysAndValues ...interface{}) {
-	if currentLevel <= DEBUG {
+	if enabled(DEBUG) {
 		logger.Println(formatMessage("DEBUG", msg, keysAndValues...))
 	}
 }
 
 func Info(msg string, keysAndValues ...interface{}) {
-	if currentLevel <= INFO {
+	if enabled(INFO) {
 		logger.Println(formatMessage("INFO", msg, keysAndValues...))
 	}
 }
 
 func Warn(msg string, keysAndValues ...interface{}) {
-	if currentLevel <= WARN {
+	if enabled(WARN) {
 		logger.Println(formatMessage("WARN", msg, keysAndValues...))
 	}
 }
 
 func Error(msg string, keysAndValues ...interface{}) {
-	if currentLevel <= ERROR {
+	if enabled(ERROR) {
 		logger.Println(formatMessage("ERROR", msg, keysAndValues...))
 	}
 }
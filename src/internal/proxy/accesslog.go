@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+// requestIDHeader carries the request ID to upstreams and back to clients
+const requestIDHeader = "X-Request-ID"
+
+// requestInfo collects per-request details for the access log
+type requestInfo struct {
+	requestID string
+	upstream  string
+
+	// Time spent acquiring tokens and proxying, summed over fallback attempts
+	tokenWait    time.Duration
+	upstreamTime time.Duration
+}
+
+type requestInfoKey struct{}
+
+// getRequestInfo returns the request's access log details, if tracked
+func getRequestInfo(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// newRequestID generates a random request ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// accessLogEntry is the JSON Lines access log schema. The field names are a
+// contract with log shippers and must not change.
+type accessLogEntry struct {
+	Timestamp  string `json:"ts"`          // RFC 3339 with milliseconds, UTC
+	Method     string `json:"method"`      // HTTP method
+	Path       string `json:"path"`        // request path without query
+	Status     int    `json:"status"`      // response status code
+	DurationMs int64  `json:"duration_ms"` // total handling time
+	Upstream   string `json:"upstream"`    // upstream name, empty if not proxied
+	ClientIP   string `json:"client_ip"`   // remote address without port
+	RequestID  string `json:"request_id"`  // X-Request-ID value
+	Bytes      int64  `json:"bytes"`       // response body bytes written
+}
+
+// logAccess writes the access log entry for a completed request
+func (s *Server) logAccess(r *http.Request, rw *responseWriter, info *requestInfo, duration time.Duration) {
+	switch s.config.Logging.AccessLogFormat {
+	case config.AccessLogJSON:
+		entry := accessLogEntry{
+			Timestamp:  time.Now().UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Status:     rw.statusCode,
+			DurationMs: duration.Milliseconds(),
+			Upstream:   info.upstream,
+			ClientIP:   clientIP(r),
+			RequestID:  info.requestID,
+			Bytes:      rw.bytesWritten,
+		}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			logger.Error("Failed to encode access log", "error", err)
+			return
+		}
+		logger.Write(string(line))
+
+	case config.AccessLogCLF:
+		logger.Write(fmt.Sprintf("%s - - [%s] %q %d %d",
+			clientIP(r),
+			time.Now().Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method+" "+r.URL.RequestURI()+" "+r.Proto,
+			rw.statusCode,
+			rw.bytesWritten))
+
+	default:
+		logger.Info("Request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"remote_addr", r.RemoteAddr,
+			"status", rw.statusCode,
+			"duration_ms", duration.Milliseconds(),
+			"user_agent", r.Header.Get("User-Agent"))
+	}
+}
+
+// logSlowRequest warns about a request slower than
+// logging.slow_request_threshold, splitting its time between token
+// acquisition, the upstream and the gateway itself
+func (s *Server) logSlowRequest(r *http.Request, rw *responseWriter, info *requestInfo, duration time.Duration) {
+	threshold := time.Duration(s.config.Logging.SlowRequestThreshold) * time.Millisecond
+	if threshold <= 0 || duration <= threshold {
+		return
+	}
+	if upstream := s.upstreamMap[info.upstream]; upstream != nil && upstream.Streaming {
+		return
+	}
+	s.metrics.slowRequests.Add(1)
+	logger.Warn("Slow request",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"upstream", info.upstream,
+		"status", rw.statusCode,
+		"duration_ms", duration.Milliseconds(),
+		"token_ms", info.tokenWait.Milliseconds(),
+		"upstream_ms", info.upstreamTime.Milliseconds(),
+		"gateway_ms", (duration - info.tokenWait - info.upstreamTime).Milliseconds(),
+		"threshold_ms", threshold.Milliseconds(),
+		"request_id", info.requestID)
+}
+
+// clientIP returns the remote address without its port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,216 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+// captureLogs redirects log output to a buffer for the rest of the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	logger.SetOutput(&buf)
+	t.Cleanup(func() { logger.SetOutput(os.Stdout) })
+	return &buf
+}
+
+func TestAccessLogJSONShape(t *testing.T) {
+	var upstreamRequestID string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequestID = r.Header.Get(requestIDHeader)
+		w.Write([]byte("hello"))
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: upstream.URL})
+	srv.config.Logging.AccessLogFormat = config.AccessLogJSON
+	logger.SetLevel("info")
+	buf := captureLogs(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/data?x=1", nil)
+	req.Header.Set(requestIDHeader, "req-123")
+	rec := serve(srv, req)
+
+	if got := rec.Header().Get(requestIDHeader); got != "req-123" {
+		t.Errorf("response X-Request-ID = %q, want %q", got, "req-123")
+	}
+	if upstreamRequestID != "req-123" {
+		t.Errorf("upstream X-Request-ID = %q, want %q", upstreamRequestID, "req-123")
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &raw); err != nil {
+		t.Fatalf("access log is not JSON: %v\n%s", err, buf.String())
+	}
+
+	keys := make([]string, 0, len(raw))
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	want := []string{"bytes", "client_ip", "duration_ms", "method", "path", "request_id", "status", "ts", "upstream"}
+	if strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Errorf("access log keys = %v, want %v", keys, want)
+	}
+
+	var entry accessLogEntry
+	json.Unmarshal([]byte(lines[len(lines)-1]), &entry)
+	if entry.Method != "GET" || entry.Path != "/data" || entry.Status != 200 ||
+		entry.Upstream != "api" || entry.RequestID != "req-123" || entry.Bytes != 5 || entry.ClientIP != "192.0.2.1" {
+		t.Errorf("unexpected access log entry: %+v", entry)
+	}
+}
+
+func TestAccessLogRequestIDOnCacheHit(t *testing.T) {
+	srv, hits, _ := newCachingServer(t, "public, max-age=60", 300)
+	srv.config.Logging.AccessLogFormat = config.AccessLogJSON
+	logger.SetLevel("info")
+	buf := captureLogs(t)
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		rec := serve(srv, httptest.NewRequest(http.MethodGet, "/data", nil))
+		ids = append(ids, rec.Header().Get(requestIDHeader))
+	}
+	if got := atomic.LoadInt32(hits); got != 1 {
+		t.Fatalf("upstream hits = %d, want 1", got)
+	}
+
+	var logged []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry accessLogEntry
+		if json.Unmarshal([]byte(line), &entry) == nil {
+			logged = append(logged, entry.RequestID)
+		}
+	}
+	if len(logged) != 2 {
+		t.Fatalf("access log entries = %d, want 2\n%s", len(logged), buf.String())
+	}
+	for i := range ids {
+		if ids[i] == "" || ids[i] != logged[i] {
+			t.Errorf("response %d X-Request-ID = %q, want its access log request_id %q", i, ids[i], logged[i])
+		}
+	}
+	if ids[0] == ids[1] {
+		t.Errorf("cache hit reused the first request's X-Request-ID %q", ids[0])
+	}
+}
+
+func TestAccessLogCLF(t *testing.T) {
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: "http://127.0.0.1:1", Audience: "aud"})
+	srv.config.Logging.AccessLogFormat = config.AccessLogCLF
+	logger.SetLevel("info")
+	buf := captureLogs(t)
+
+	serve(srv, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	clf := regexp.MustCompile(`^192\.0\.2\.1 - - \[[^\]]+\] "GET /healthz HTTP/1\.1" 200 2$`)
+	line := strings.TrimSpace(buf.String())
+	if !clf.MatchString(line) {
+		t.Errorf("CLF line = %q", line)
+	}
+}
+
+func TestRequestIDGenerated(t *testing.T) {
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: "http://127.0.0.1:1", Audience: "aud"})
+
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if id := rec.Header().Get(requestIDHeader); len(id) != 16 {
+		t.Errorf("generated X-Request-ID = %q, want 16 hex chars", id)
+	}
+}
+
+func TestUpstreamLogLevelOverride(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	srv := newTestServer(t,
+		config.UpstreamConfig{Name: "healthy", URL: upstream.URL, Audience: "a"},
+		config.UpstreamConfig{Name: "flaky", URL: upstream.URL, Audience: "a", LogLevel: "debug"},
+	)
+	logger.SetLevel("info")
+	t.Cleanup(func() { logger.SetLevel("error") })
+	buf := captureLogs(t)
+
+	for _, name := range []string{"healthy", "flaky"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(targetUpstreamHeader, name)
+		serve(srv, req)
+	}
+
+	var debugLines []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.Contains(line, "[DEBUG]") {
+			debugLines = append(debugLines, line)
+		}
+	}
+	if len(debugLines) == 0 {
+		t.Fatal("no debug logs for the upstream with log_level: debug")
+	}
+	for _, line := range debugLines {
+		if !strings.Contains(line, "upstream=flaky") {
+			t.Errorf("unexpected debug log: %s", line)
+		}
+	}
+}
+
+func TestSlowRequestLogged(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(60 * time.Millisecond)
+		}
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: "a"})
+	srv.config.Logging.SlowRequestThreshold = 30
+	logger.SetLevel("warn")
+	t.Cleanup(func() { logger.SetLevel("error") })
+	buf := captureLogs(t)
+
+	serve(srv, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	if strings.Contains(buf.String(), "Slow request") || srv.metrics.slowRequests.Load() != 0 {
+		t.Fatalf("fast request reported as slow: %s", buf.String())
+	}
+
+	serve(srv, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	if got := srv.metrics.slowRequests.Load(); got != 1 {
+		t.Errorf("slow_requests = %d, want 1", got)
+	}
+	line := buf.String()
+	if !strings.Contains(line, "[WARN] Slow request") || !strings.Contains(line, "path=/slow") ||
+		!strings.Contains(line, "upstream=api") || !strings.Contains(line, "threshold_ms=30") {
+		t.Fatalf("slow request log = %q", line)
+	}
+	match := regexp.MustCompile(`upstream_ms=(\d+)`).FindStringSubmatch(line)
+	if match == nil {
+		t.Fatalf("no upstream_ms in %q", line)
+	}
+	if ms, _ := strconv.Atoi(match[1]); ms < 60 {
+		t.Errorf("upstream_ms = %d, want the upstream's 60ms", ms)
+	}
+	if !strings.Contains(line, "token_ms=") {
+		t.Errorf("no token_ms in %q", line)
+	}
+
+	// Off when the threshold is unset
+	srv.config.Logging.SlowRequestThreshold = 0
+	serve(srv, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	if got := srv.metrics.slowRequests.Load(); got != 1 {
+		t.Errorf("slow_requests = %d with the threshold off, want 1", got)
+	}
+}
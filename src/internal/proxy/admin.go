@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+// requireAdmin guards an admin handler with the configured bearer token.
+// Admin endpoints are hidden entirely when no token is configured.
+func (s *Server) requireAdmin(method string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		adminToken := s.config.Admin.Token
+		if adminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(adminToken)) != 1 {
+			logger.Warn("Unauthorized admin request", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// handleMetricsReset zeroes the cumulative counters and returns their prior values
+func (s *Server) handleMetricsReset(w http.ResponseWriter, r *http.Request) {
+	stats := s.tokenManager.ResetStats()
+	previous := s.metrics.reset()
+	previous["tokens_refreshed"] = int64(stats.TotalRefreshed)
+	previous["tokens_rejected"] = int64(stats.TotalRejected)
+	previous["tokens_errors"] = int64(stats.TotalErrors)
+	previous["token_cache_hits"] = stats.CacheHits
+	previous["token_cache_misses"] = stats.CacheMisses
+	previous["token_cache_evictions"] = stats.Evictions
+
+	logger.Info("Metrics reset", "remote_addr", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"reset":    true,
+		"previous": previous,
+	})
+}
+
+// handleDrain asks the process to shut down gracefully, as on SIGTERM. The
+// request is acknowledged immediately; the owner of the server (see
+// ShutdownRequested) runs Shutdown while this response is delivered.
+func (s *Server) handleDrain(w http.ResponseWriter, r *http.Request) {
+	s.drainOnce.Do(func() {
+		logger.Info("Drain requested", "remote_addr", r.RemoteAddr)
+		close(s.drainRequested)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"draining": true,
+	})
+}
+
+// explainRequest describes the sample request routed by /admin/explain. Host
+// defaults to the admin request's own Host; set it to "" for a Host-less
+// client. RemoteAddr (ip:port) defaults to the admin caller's address.
+type explainRequest struct {
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	Host       *string           `json:"host"`
+	Headers    map[string]string `json:"headers"`
+	RemoteAddr string            `json:"remote_addr"`
+}
+
+// handleExplain reports how a sample request would be routed, without
+// proxying it: the matching rule, upstream, audience and path policy
+func (s *Server) handleExplain(w http.ResponseWriter, r *http.Request) {
+	var sample explainRequest
+	if err := json.NewDecoder(r.Body).Decode(&sample); err != nil {
+		http.Error(w, "Invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if sample.Method == "" {
+		sample.Method = http.MethodGet
+	}
+	req, err := http.NewRequest(sample.Method, sample.Path, nil)
+	if err != nil || (!strings.HasPrefix(sample.Path, "/") && !req.URL.IsAbs()) {
+		http.Error(w, "path must be an absolute path or URL", http.StatusBadRequest)
+		return
+	}
+	for name, value := range sample.Headers {
+		req.Header.Set(name, value)
+	}
+	if sample.Host != nil {
+		req.Host = *sample.Host
+	} else if !req.URL.IsAbs() {
+		req.Host = r.Host
+	}
+	req.RemoteAddr = r.RemoteAddr
+	if sample.RemoteAddr != "" {
+		req.RemoteAddr = sample.RemoteAddr
+	}
+	req = req.WithContext(context.WithValue(req.Context(), dryRunKey{}, true))
+
+	result := map[string]interface{}{
+		"method":       req.Method,
+		"path":         req.URL.Path,
+		"path_allowed": s.isPathAllowed(req.URL.Path),
+	}
+	upstream, rule := s.routeUpstream(req)
+	if upstream != nil {
+		result["rule"] = rule
+		result["upstream"] = upstream.Name
+		result["method_allowed"] = upstreamAllowsMethod(upstream, req.Method)
+		audience := upstream.Audience
+		if upstream.PassThrough {
+			if target, _, err := s.resolvePassThroughTarget(req, upstream); err != nil {
+				result["error"] = err.Error()
+				audience = ""
+			} else {
+				result["target"] = target.String()
+				audience = target.Scheme + "://" + target.Host
+			}
+		}
+
+		// Requests no routing rule matched follow the default route policy,
+		// as they would when proxied
+		switch {
+		case rule == routeDefault && s.config.Server.DefaultRoute == config.DefaultRouteReject:
+			result["rejected"] = true
+		case rule == routeDefault && audience != "":
+			if fallback, mint := s.defaultRouteAudience(audience); mint {
+				result["audience"] = fallback
+			} else {
+				result["audience"] = "none"
+			}
+		case audience != "":
+			result["audience"] = audience
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// preloadRequest lists the audiences refreshed by /admin/preload
+type preloadRequest struct {
+	Audiences []string `json:"audiences"`
+}
+
+// preloadResult reports the forced refresh of one audience
+type preloadResult struct {
+	Audience  string `json:"audience"`
+	OK        bool   `json:"ok"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+	ValidFor  string `json:"valid_for,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// handlePreload mints fresh tokens for the listed audiences, bypassing the
+// refresh-before-expiry check, so they carry their full lifetime into an
+// expected traffic burst. Audiences are refreshed concurrently and each
+// result is reported; a failed refresh keeps the previously cached token.
+func (s *Server) handlePreload(w http.ResponseWriter, r *http.Request) {
+	var req preloadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Audiences) == 0 {
+		http.Error(w, "audiences must list at least one audience", http.StatusBadRequest)
+		return
+	}
+
+	results := make([]preloadResult, len(req.Audiences))
+	var wg sync.WaitGroup
+	for i, audience := range req.Audiences {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := preloadResult{Audience: audience}
+			meta, err := s.tokenManager.Refresh(audience)
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				result.OK = true
+				result.ExpiresAt = meta.ExpiresAt.UTC().Format(time.RFC3339)
+				result.ValidFor = time.Until(meta.ExpiresAt).Round(time.Second).String()
+			}
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, result := range results {
+		if !result.OK {
+			failed++
+		}
+	}
+	logger.Info("Tokens preloaded",
+		"audiences", len(results),
+		"failed", failed,
+		"remote_addr", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"refreshed": len(results) - failed,
+		"failed":    failed,
+		"results":   results,
+	})
+}
@@ -0,0 +1,250 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+// adminRequest builds an admin request carrying the given bearer token
+func adminRequest(method, path, token string) *http.Request {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func TestAdminAuth(t *testing.T) {
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: "http://127.0.0.1:1", Audience: "aud"})
+
+	// Disabled without a configured token
+	if rec := serve(srv, adminRequest(http.MethodPost, "/admin/metrics/reset", "anything")); rec.Code != http.StatusNotFound {
+		t.Errorf("disabled: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	srv.config.Admin.Token = "s3cret"
+
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		want   int
+	}{
+		{"missing token", http.MethodPost, "", http.StatusUnauthorized},
+		{"wrong token", http.MethodPost, "wrong", http.StatusUnauthorized},
+		{"wrong method", http.MethodGet, "s3cret", http.StatusMethodNotAllowed},
+		{"authorized", http.MethodPost, "s3cret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(srv, adminRequest(tt.method, "/admin/metrics/reset", tt.token))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricsReset(t *testing.T) {
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: "http://127.0.0.1:1", Audience: "aud"})
+	srv.config.Admin.Token = "s3cret"
+
+	srv.metrics.proxyErrors.Add(3)
+	srv.metrics.cacheHits.Add(5)
+
+	rec := serve(srv, adminRequest(http.MethodPost, "/admin/metrics/reset", "s3cret"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var body struct {
+		Previous map[string]int64 `json:"previous"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if body.Previous["proxy_errors"] != 3 || body.Previous["cache_hits"] != 5 {
+		t.Errorf("previous = %v, want proxy_errors=3 cache_hits=5", body.Previous)
+	}
+
+	if got := srv.metrics.proxyErrors.Load(); got != 0 {
+		t.Errorf("proxy_errors after reset = %d, want 0", got)
+	}
+	if got := srv.metrics.cacheHits.Load(); got != 0 {
+		t.Errorf("cache_hits after reset = %d, want 0", got)
+	}
+}
+
+func TestAdminDrain(t *testing.T) {
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: "http://127.0.0.1:1", Audience: "aud"})
+	srv.config.Admin.Token = "s3cret"
+
+	if rec := serve(srv, adminRequest(http.MethodPost, "/admin/drain", "wrong")); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthorized: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	select {
+	case <-srv.ShutdownRequested():
+		t.Fatal("unauthorized request triggered a drain")
+	default:
+	}
+
+	// Repeated requests are acknowledged the same way
+	for i := 0; i < 2; i++ {
+		if rec := serve(srv, adminRequest(http.MethodPost, "/admin/drain", "s3cret")); rec.Code != http.StatusAccepted {
+			t.Fatalf("request %d: status = %d, want %d", i, rec.Code, http.StatusAccepted)
+		}
+	}
+	select {
+	case <-srv.ShutdownRequested():
+	default:
+		t.Fatal("drain was not requested")
+	}
+
+	// The process owner responds by running the usual shutdown sequence
+	if err := srv.Shutdown(); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if rec := serve(srv, httptest.NewRequest(http.MethodGet, "/readyz", nil)); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("readyz after drain = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestAdminExplain(t *testing.T) {
+	srv := newTestServerWithConfig(t, &config.Config{
+		Server: config.ServerConfig{AllowedPaths: []string{"/api/**", "/billing/**"}, HostlessUpstream: "legacy"},
+		Upstreams: []config.UpstreamConfig{
+			{Name: "api", URL: "http://127.0.0.1:1", Audience: "https://api"},
+			{Name: "billing", URL: "http://127.0.0.1:2", Audience: "https://billing", PathPrefix: "/billing", AllowedMethods: []string{"GET"}},
+			{Name: "legacy", URL: "http://127.0.0.1:3", Audience: "https://legacy"},
+			{Name: "egress", PassThrough: true, AllowedHosts: []string{"*.example.com"}, TargetHeader: "X-Target-URL"},
+		},
+	})
+	srv.config.Admin.Token = "s3cret"
+
+	tests := []struct {
+		name   string
+		sample string
+		want   map[string]interface{}
+	}{
+		{"default", `{"path":"/api/users"}`,
+			map[string]interface{}{"rule": "default", "upstream": "api", "audience": "https://api", "path_allowed": true, "method_allowed": true}},
+		{"prefix", `{"method":"POST","path":"/billing/invoices"}`,
+			map[string]interface{}{"rule": "prefix", "upstream": "billing", "audience": "https://billing", "method_allowed": false}},
+		{"header", `{"path":"/api/users","headers":{"X-Target-Upstream":"legacy"}}`,
+			map[string]interface{}{"rule": "header", "upstream": "legacy"}},
+		{"hostless", `{"path":"/api/users","host":""}`,
+			map[string]interface{}{"rule": "hostless", "upstream": "legacy"}},
+		{"path not allowed", `{"path":"/other"}`,
+			map[string]interface{}{"rule": "default", "path_allowed": false}},
+		{"pass-through", `{"path":"/api/x","headers":{"X-Target-Upstream":"egress","X-Target-URL":"https://svc.example.com/v1"}}`,
+			map[string]interface{}{"rule": "header", "upstream": "egress", "audience": "https://svc.example.com", "target": "https://svc.example.com/v1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := adminRequest(http.MethodPost, "/admin/explain", "s3cret")
+			req.Body = io.NopCloser(strings.NewReader(tt.sample))
+			rec := serve(srv, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+
+			var got map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+			for key, want := range tt.want {
+				if got[key] != want {
+					t.Errorf("%s = %v, want %v (response %v)", key, got[key], want, got)
+				}
+			}
+		})
+	}
+
+	req := adminRequest(http.MethodPost, "/admin/explain", "s3cret")
+	req.Body = io.NopCloser(strings.NewReader(`{"path":"relative"}`))
+	if rec := serve(srv, req); rec.Code != http.StatusBadRequest {
+		t.Errorf("relative path: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// Default-routed requests the gateway would refuse are reported as such
+	srv.config.Server.DefaultRoute = config.DefaultRouteReject
+	req = adminRequest(http.MethodPost, "/admin/explain", "s3cret")
+	req.Body = io.NopCloser(strings.NewReader(`{"path":"/api/users"}`))
+	var got map[string]interface{}
+	json.Unmarshal(serve(srv, req).Body.Bytes(), &got)
+	if got["rejected"] != true || got["audience"] != nil {
+		t.Errorf("default_route reject: response %v, want rejected and no audience", got)
+	}
+}
+
+func TestAdminPreload(t *testing.T) {
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: "http://127.0.0.1:1", Audience: "aud"})
+	srv.config.Admin.Token = "s3cret"
+
+	var minted atomic.Int64
+	srv.tokenManager.SetSourceFunc(func(ctx context.Context, audience string) (oauth2.TokenSource, error) {
+		if audience == "broken" {
+			return nil, errors.New("no credentials")
+		}
+		return oauth2.StaticTokenSource(&oauth2.Token{
+			AccessToken: fmt.Sprintf("tok-%d", minted.Add(1)),
+			Expiry:      time.Now().Add(time.Hour),
+		}), nil
+	})
+	if _, err := srv.tokenManager.GetToken("aud"); err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+
+	req := adminRequest(http.MethodPost, "/admin/preload", "s3cret")
+	req.Body = io.NopCloser(strings.NewReader(`{"audiences":["aud","other","broken"]}`))
+	rec := serve(srv, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp struct {
+		Refreshed int             `json:"refreshed"`
+		Failed    int             `json:"failed"`
+		Results   []preloadResult `json:"results"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.Refreshed != 2 || resp.Failed != 1 || len(resp.Results) != 3 {
+		t.Fatalf("response = %+v, want 2 refreshed and 1 failed", resp)
+	}
+	for i, audience := range []string{"aud", "other", "broken"} {
+		result := resp.Results[i]
+		if result.Audience != audience || result.OK != (audience != "broken") {
+			t.Errorf("result %d = %+v", i, result)
+		}
+	}
+	if resp.Results[0].ExpiresAt == "" || !strings.Contains(resp.Results[2].Error, "no credentials") {
+		t.Errorf("results = %+v, want expiry for refreshed and error for failed", resp.Results)
+	}
+
+	// The valid cached token was replaced rather than reused
+	if meta := srv.tokenManager.GetMetadata("aud"); meta.Token == "tok-1" || meta.RefreshCount != 2 {
+		t.Errorf("aud metadata = %+v, want a freshly minted token", meta)
+	}
+
+	req = adminRequest(http.MethodPost, "/admin/preload", "s3cret")
+	req.Body = io.NopCloser(strings.NewReader(`{"audiences":[]}`))
+	if rec := serve(srv, req); rec.Code != http.StatusBadRequest {
+		t.Errorf("empty audiences status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+// maxAggregateMemberBytes bounds each member response held in memory
+const maxAggregateMemberBytes = 10 << 20 // 10 MiB
+
+// aggregateStatus reports the outcome of one member call
+type aggregateStatus struct {
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// aggregateResponse is the merged response of an aggregate route
+type aggregateResponse struct {
+	Results map[string]json.RawMessage  `json:"results"`
+	Status  map[string]*aggregateStatus `json:"status"`
+}
+
+// handleAggregate returns a handler that fans out to the aggregate's
+// members concurrently and merges their JSON responses. Failed members are
+// reported in the status map; the route fails only if every member fails.
+func (s *Server) handleAggregate(agg config.AggregateConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		resp := aggregateResponse{
+			Results: make(map[string]json.RawMessage),
+			Status:  make(map[string]*aggregateStatus),
+		}
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, member := range agg.Members {
+			wg.Add(1)
+			go func(member config.AggregateMember) {
+				defer wg.Done()
+				start := time.Now()
+				body, status := s.fetchAggregateMember(r, agg, member)
+				status.DurationMs = time.Since(start).Milliseconds()
+
+				mu.Lock()
+				defer mu.Unlock()
+				resp.Status[member.Key] = status
+				if body != nil {
+					resp.Results[member.Key] = body
+				}
+			}(member)
+		}
+		wg.Wait()
+
+		statusCode := http.StatusOK
+		if len(resp.Results) == 0 {
+			statusCode = http.StatusBadGateway
+		}
+
+		logger.Debug("Aggregate completed",
+			"path", agg.Path,
+			"members", len(agg.Members),
+			"succeeded", len(resp.Results))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// fetchAggregateMember calls one member upstream with its own token and
+// returns its JSON body, or nil with an error status
+func (s *Server) fetchAggregateMember(r *http.Request, agg config.AggregateConfig, member config.AggregateMember) (json.RawMessage, *aggregateStatus) {
+	upstream := s.upstreamMap[member.Upstream]
+	if _, skipped := s.skipReason(upstream.Name); skipped {
+		return nil, &aggregateStatus{Error: "upstream failed its startup checks"}
+	}
+
+	token, err := s.tokenManager.GetToken(upstream.Audience)
+	if err != nil {
+		return nil, &aggregateStatus{Error: fmt.Sprintf("authentication error: %v", err)}
+	}
+
+	targetURL := s.upstreamURL(upstream)
+	targetURL.Path = singleJoiningSlash(targetURL.Path, member.Path)
+	targetURL.RawQuery = r.URL.RawQuery
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(agg.Timeout)*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL.String(), nil)
+	if err != nil {
+		return nil, &aggregateStatus{Error: err.Error()}
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if upstream.Host != "" {
+		req.Host = upstream.Host
+	}
+
+	client := &http.Client{Transport: s.transport(upstream)}
+	res, err := client.Do(req)
+	if err != nil {
+		logger.Warn("Aggregate member failed", "key", member.Key, "upstream", upstream.Name, "error", err)
+		return nil, &aggregateStatus{Error: err.Error()}
+	}
+	defer res.Body.Close()
+
+	status := &aggregateStatus{StatusCode: res.StatusCode}
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		s.tokenManager.MarkRejected(upstream.Audience)
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		status.Error = fmt.Sprintf("upstream returned status %d", res.StatusCode)
+		return nil, status
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxAggregateMemberBytes+1))
+	if err != nil {
+		status.Error = err.Error()
+		return nil, status
+	}
+	if len(body) > maxAggregateMemberBytes {
+		status.Error = "response too large"
+		return nil, status
+	}
+	if !json.Valid(body) {
+		status.Error = "response is not valid JSON"
+		return nil, status
+	}
+
+	return body, status
+}
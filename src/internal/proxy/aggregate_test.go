@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+// newJSONUpstream returns an upstream replying with the given status and body
+func newJSONUpstream(t *testing.T, status int, body string) *httptest.Server {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+func TestAggregateMergesResponses(t *testing.T) {
+	users := newJSONUpstream(t, http.StatusOK, `{"count":2}`)
+	orders := newJSONUpstream(t, http.StatusOK, `[1,2,3]`)
+	broken := newJSONUpstream(t, http.StatusInternalServerError, `{"error": "down"}`)
+
+	srv := newTestServerWithConfig(t, &config.Config{
+		Upstreams: []config.UpstreamConfig{
+			{Name: "users", URL: users.URL, Audience: users.URL},
+			{Name: "orders", URL: orders.URL, Audience: orders.URL},
+			{Name: "broken", URL: broken.URL, Audience: broken.URL},
+		},
+		Aggregates: []config.AggregateConfig{{
+			Path:    "/dashboard",
+			Timeout: 5,
+			Members: []config.AggregateMember{
+				{Key: "users", Upstream: "users", Path: "/users"},
+				{Key: "orders", Upstream: "orders", Path: "/orders"},
+				{Key: "broken", Upstream: "broken", Path: "/"},
+			},
+		}},
+	})
+
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/dashboard", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var resp aggregateResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+
+	if got := string(resp.Results["users"]); got != `{"count":2}` {
+		t.Errorf("results.users = %s", got)
+	}
+	if got := string(resp.Results["orders"]); got != `[1,2,3]` {
+		t.Errorf("results.orders = %s", got)
+	}
+	if _, ok := resp.Results["broken"]; ok {
+		t.Error("failed member should not appear in results")
+	}
+
+	if st := resp.Status["users"]; st == nil || st.StatusCode != http.StatusOK || st.Error != "" {
+		t.Errorf("status.users = %+v", st)
+	}
+	if st := resp.Status["broken"]; st == nil || st.StatusCode != http.StatusInternalServerError || st.Error == "" {
+		t.Errorf("status.broken = %+v", st)
+	}
+}
+
+func TestAggregateAllMembersFail(t *testing.T) {
+	notJSON := newJSONUpstream(t, http.StatusOK, `<html>`)
+
+	srv := newTestServerWithConfig(t, &config.Config{
+		Upstreams: []config.UpstreamConfig{
+			{Name: "html", URL: notJSON.URL, Audience: notJSON.URL},
+			{Name: "down", URL: "http://127.0.0.1:1", Audience: "down"},
+		},
+		Aggregates: []config.AggregateConfig{{
+			Path:    "/dashboard",
+			Timeout: 5,
+			Members: []config.AggregateMember{
+				{Key: "html", Upstream: "html", Path: "/"},
+				{Key: "down", Upstream: "down", Path: "/"},
+			},
+		}},
+	})
+
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/dashboard", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadGateway)
+	}
+
+	var resp aggregateResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(resp.Status) != 2 || resp.Status["html"].Error == "" || resp.Status["down"].Error == "" {
+		t.Errorf("status = %+v, want errors for both members", resp.Status)
+	}
+}
+
+func TestAggregateRejectsNonGet(t *testing.T) {
+	srv := newTestServerWithConfig(t, &config.Config{
+		Upstreams: []config.UpstreamConfig{{Name: "api", URL: "http://127.0.0.1:1", Audience: "aud"}},
+		Aggregates: []config.AggregateConfig{{
+			Path:    "/dashboard",
+			Members: []config.AggregateMember{{Key: "api", Upstream: "api", Path: "/"}},
+		}},
+	})
+
+	rec := serve(srv, httptest.NewRequest(http.MethodPost, "/dashboard", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
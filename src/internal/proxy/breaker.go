@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+// breakerState is the state of a circuit breaker
+type breakerState string
+
+const (
+	breakerClosed   breakerState = "closed"    // requests flow normally
+	breakerOpen     breakerState = "open"      // requests are rejected
+	breakerHalfOpen breakerState = "half-open" // a trial request is in flight
+)
+
+// circuitBreaker stops sending requests to an upstream after repeated
+// failures, giving it time to recover
+type circuitBreaker struct {
+	name     string
+	settings config.CircuitBreakerConfig
+
+	mu          sync.Mutex
+	state       breakerState
+	failures    []time.Time // failure times within the window
+	openedAt    time.Time
+	trialSentAt time.Time
+	now         func() time.Time
+}
+
+func newCircuitBreaker(name string, settings config.CircuitBreakerConfig) *circuitBreaker {
+	return &circuitBreaker{
+		name:     name,
+		settings: settings,
+		state:    breakerClosed,
+		now:      time.Now,
+	}
+}
+
+func (b *circuitBreaker) window() time.Duration {
+	return time.Duration(b.settings.Window) * time.Second
+}
+
+func (b *circuitBreaker) cooldown() time.Duration {
+	return time.Duration(b.settings.Cooldown) * time.Second
+}
+
+// allow reports whether a request may be sent to the upstream. After the
+// cooldown, a single trial request is let through; another trial is allowed
+// if the previous one never reported back within a further cooldown.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) < b.cooldown() {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.trialSentAt = now
+		logger.Info("Circuit breaker half-open, sending trial request", "upstream", b.name)
+		return true
+	case breakerHalfOpen:
+		if now.Sub(b.trialSentAt) < b.cooldown() {
+			return false
+		}
+		b.trialSentAt = now
+		return true
+	default:
+		return true
+	}
+}
+
+// success records a successful upstream response
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != breakerClosed {
+		logger.Info("Circuit breaker closed", "upstream", b.name)
+	}
+	b.state = breakerClosed
+	b.failures = b.failures[:0]
+}
+
+// failure records a failed upstream call, opening the breaker once the
+// threshold is reached within the window
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if b.state == breakerHalfOpen {
+		b.open(now)
+		return
+	}
+
+	cutoff := now.Add(-b.window())
+	kept := b.failures[:0]
+	for _, t := range b.failures {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	b.failures = append(kept, now)
+
+	if b.state == breakerClosed && len(b.failures) >= b.settings.FailureThreshold {
+		b.open(now)
+	}
+}
+
+// open trips the breaker; the caller must hold b.mu
+func (b *circuitBreaker) open(now time.Time) {
+	b.state = breakerOpen
+	b.openedAt = now
+	b.failures = b.failures[:0]
+	logger.Warn("Circuit breaker opened",
+		"upstream", b.name,
+		"failure_threshold", b.settings.FailureThreshold,
+		"cooldown", b.cooldown().String())
+}
+
+// retryAfter returns the remaining cooldown while the breaker is open
+func (b *circuitBreaker) retryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != breakerOpen {
+		return 0
+	}
+	if remaining := b.cooldown() - b.now().Sub(b.openedAt); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// snapshot returns the breaker's settings and state for reporting
+func (b *circuitBreaker) snapshot() map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return map[string]interface{}{
+		"state":             b.state,
+		"recent_failures":   len(b.failures),
+		"failure_threshold": b.settings.FailureThreshold,
+		"window_seconds":    b.settings.Window,
+		"cooldown_seconds":  b.settings.Cooldown,
+	}
+}
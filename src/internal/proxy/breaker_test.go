@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+// newTestBreaker returns a breaker driven by a fake clock
+func newTestBreaker(threshold, window, cooldown int) (*circuitBreaker, *time.Time) {
+	b := newCircuitBreaker("api", config.CircuitBreakerConfig{
+		Enabled:          true,
+		FailureThreshold: threshold,
+		Window:           window,
+		Cooldown:         cooldown,
+	})
+	now := time.Now()
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func TestBreakerOpensAtThreshold(t *testing.T) {
+	b, _ := newTestBreaker(3, 60, 30)
+
+	b.failure()
+	b.failure()
+	if !b.allow() {
+		t.Fatal("breaker should stay closed below the threshold")
+	}
+	b.failure()
+	if b.allow() {
+		t.Fatal("breaker should open at the threshold")
+	}
+	if b.retryAfter() <= 0 {
+		t.Error("retryAfter should be positive while open")
+	}
+}
+
+func TestBreakerWindowExpiresFailures(t *testing.T) {
+	b, now := newTestBreaker(2, 10, 30)
+
+	b.failure()
+	*now = now.Add(11 * time.Second)
+	b.failure()
+
+	if !b.allow() {
+		t.Error("failures outside the window should not open the breaker")
+	}
+}
+
+func TestBreakerHalfOpenRecovery(t *testing.T) {
+	b, now := newTestBreaker(1, 60, 30)
+	b.failure()
+
+	*now = now.Add(29 * time.Second)
+	if b.allow() {
+		t.Fatal("breaker should reject during cooldown")
+	}
+
+	*now = now.Add(2 * time.Second)
+	if !b.allow() {
+		t.Fatal("breaker should allow a trial after cooldown")
+	}
+	if b.allow() {
+		t.Fatal("breaker should allow only one trial at a time")
+	}
+
+	b.success()
+	if !b.allow() || b.state != breakerClosed {
+		t.Errorf("breaker should close after a successful trial, state = %s", b.state)
+	}
+}
+
+func TestBreakerHalfOpenFailureReopens(t *testing.T) {
+	b, now := newTestBreaker(1, 60, 30)
+	b.failure()
+
+	*now = now.Add(31 * time.Second)
+	b.allow()
+	b.failure()
+
+	if b.state != breakerOpen || b.allow() {
+		t.Errorf("breaker should reopen after a failed trial, state = %s", b.state)
+	}
+}
+
+func TestBreakerRejectsWithServiceUnavailable(t *testing.T) {
+	var hits int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+
+	srv := newTestServerWithConfig(t, &config.Config{
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: true, FailureThreshold: 5, Window: 60, Cooldown: 30},
+		Upstreams: []config.UpstreamConfig{{
+			Name:           "api",
+			URL:            upstream.URL,
+			Audience:       upstream.URL,
+			CircuitBreaker: config.CircuitBreakerConfig{FailureThreshold: 2},
+		}},
+	})
+
+	for i := 0; i < 2; i++ {
+		if rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil)); rec.Code != http.StatusInternalServerError {
+			t.Fatalf("request %d: status = %d, want %d", i, rec.Code, http.StatusInternalServerError)
+		}
+	}
+
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("missing Retry-After header")
+	}
+	if got := atomic.LoadInt32(&hits); got != 2 {
+		t.Errorf("upstream hits = %d, want 2", got)
+	}
+}
+
+func TestBreakerOverridesReported(t *testing.T) {
+	srv := newTestServerWithConfig(t, &config.Config{
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: true, FailureThreshold: 5, Window: 60, Cooldown: 30},
+		Upstreams: []config.UpstreamConfig{
+			{Name: "default", URL: "http://127.0.0.1:1", Audience: "a"},
+			{Name: "fragile", URL: "http://127.0.0.1:1", Audience: "b",
+				CircuitBreaker: config.CircuitBreakerConfig{FailureThreshold: 2, Cooldown: 120}},
+		},
+	})
+
+	def := srv.breakers["default"].snapshot()
+	if def["failure_threshold"] != 5 || def["window_seconds"] != 60 || def["cooldown_seconds"] != 30 {
+		t.Errorf("default breaker = %v, want global settings", def)
+	}
+	fragile := srv.breakers["fragile"].snapshot()
+	if fragile["failure_threshold"] != 2 || fragile["window_seconds"] != 60 || fragile["cooldown_seconds"] != 120 {
+		t.Errorf("fragile breaker = %v, want threshold=2 window=60 cooldown=120", fragile)
+	}
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+)
+
+// bufferedResponse is a complete upstream response held in memory so it can
+// be replayed to other clients
+type bufferedResponse struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+	trailer    http.Header // sent after the body
+	complete   bool        // false if the body overflowed the buffer or the client went away
+}
+
+// writeTo replays the response to w
+func (b *bufferedResponse) writeTo(w http.ResponseWriter, r *http.Request) {
+	for k, vv := range b.header {
+		w.Header()[k] = append([]string(nil), vv...)
+	}
+	w.WriteHeader(b.statusCode)
+	if r.Method != http.MethodHead && !bodilessStatus(b.statusCode) {
+		w.Write(b.body)
+		for k, vv := range b.trailer {
+			w.Header()[http.TrailerPrefix+k] = append([]string(nil), vv...)
+		}
+	}
+}
+
+// teeRecorder writes through to the client while keeping a bounded copy of
+// the response
+type teeRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	header      http.Header
+	body        bytes.Buffer
+	maxBytes    int64
+	overflowed  bool
+	wroteHeader bool
+}
+
+func newTeeRecorder(w http.ResponseWriter, maxBytes int64) *teeRecorder {
+	return &teeRecorder{ResponseWriter: w, statusCode: http.StatusOK, maxBytes: maxBytes}
+}
+
+func (t *teeRecorder) WriteHeader(code int) {
+	if !t.wroteHeader {
+		t.wroteHeader = true
+		t.statusCode = code
+		t.header = t.ResponseWriter.Header().Clone()
+		// The request ID belongs to this request; replays keep their own
+		t.header.Del(requestIDHeader)
+	}
+	t.ResponseWriter.WriteHeader(code)
+}
+
+func (t *teeRecorder) Write(b []byte) (int, error) {
+	if !t.wroteHeader {
+		t.WriteHeader(http.StatusOK)
+	}
+	if !t.overflowed {
+		if int64(t.body.Len()+len(b)) > t.maxBytes {
+			t.overflowed = true
+			t.body = bytes.Buffer{}
+		} else {
+			t.body.Write(b)
+		}
+	}
+	return t.ResponseWriter.Write(b)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (t *teeRecorder) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+// result snapshots the recorded response. A response is incomplete if the
+// client went away, since the upstream call was likely aborted.
+func (t *teeRecorder) result(r *http.Request) *bufferedResponse {
+	return &bufferedResponse{
+		statusCode: t.statusCode,
+		header:     t.header,
+		body:       t.body.Bytes(),
+		trailer:    t.trailer(),
+		complete:   t.wroteHeader && !t.overflowed && r.Context().Err() == nil,
+	}
+}
+
+// trailer collects the trailer values set after the body was written:
+// those announced in the Trailer header and any set with http.TrailerPrefix
+func (t *teeRecorder) trailer() http.Header {
+	if !t.wroteHeader {
+		return nil
+	}
+	trailer := make(http.Header)
+	final := t.ResponseWriter.Header()
+	for _, value := range t.header.Values("Trailer") {
+		for _, name := range strings.Split(value, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if vv := final.Values(name); len(vv) > 0 {
+				trailer[name] = append([]string(nil), vv...)
+			}
+		}
+	}
+	for k, vv := range final {
+		if name, ok := strings.CutPrefix(k, http.TrailerPrefix); ok {
+			trailer[http.CanonicalHeaderKey(name)] = append([]string(nil), vv...)
+		}
+	}
+	if len(trailer) == 0 {
+		return nil
+	}
+	return trailer
+}
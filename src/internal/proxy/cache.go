@@ -0,0 +1,248 @@
+package proxy
+
+import (
+	"container/list"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+// responseCache is a size-bounded LRU cache of upstream GET responses
+type responseCache struct {
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	lru      *list.List
+	size     int64
+	maxBytes int64
+	maxTTL   time.Duration
+	now      func() time.Time
+}
+
+// cacheEntry is a cached response along with the request header values it
+// was negotiated with (per the response's Vary header)
+type cacheEntry struct {
+	key       string
+	varyNames []string
+	varyVals  []string
+	resp      *bufferedResponse
+	storedAt  time.Time
+	expiresAt time.Time
+}
+
+func newResponseCache(cfg config.CacheConfig) *responseCache {
+	return &responseCache{
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		maxBytes: cfg.MaxBytes,
+		maxTTL:   time.Duration(cfg.MaxTTL) * time.Second,
+		now:      time.Now,
+	}
+}
+
+// isCacheableRequest reports whether the request may be served from the
+// cache. HEAD requests are answered from cached GET responses but never
+// populate the cache themselves, since they carry no body. Requests carrying
+// client credentials bypass the cache: their responses may be specific to
+// that identity, and the cache key is shared by every client of the upstream.
+// Range requests bypass it too: only full 200 responses are stored, and
+// answering a range with a cached full body would defeat resumed downloads,
+// so the range is left to the upstream to honor or ignore.
+func isCacheableRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != "" {
+		return false
+	}
+	if r.Header.Get("Range") != "" {
+		return false
+	}
+	cc := parseCacheControl(r.Header.Get("Cache-Control"))
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+	if _, ok := cc["no-cache"]; ok {
+		return false
+	}
+	return r.Header.Get("Pragma") != "no-cache"
+}
+
+// cacheKey identifies the cached resource for a request proxied with the
+// token scope's token (see Server.tokenScope); HEAD shares the GET entry
+func cacheKey(r *http.Request, scope string) string {
+	return http.MethodGet + " " + scope + " " + r.URL.RequestURI()
+}
+
+// get returns a fresh cached response for the request, if any
+func (c *responseCache) get(r *http.Request, scope string) (*bufferedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[cacheKey(r, scope)]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+
+	now := c.now()
+	if !now.Before(entry.expiresAt) {
+		c.remove(elem)
+		return nil, false
+	}
+	for i, name := range entry.varyNames {
+		if strings.Join(r.Header.Values(name), ",") != entry.varyVals[i] {
+			return nil, false
+		}
+	}
+	c.lru.MoveToFront(elem)
+
+	resp := *entry.resp
+	resp.header = entry.resp.header.Clone()
+	resp.header.Set("Age", strconv.Itoa(int(now.Sub(entry.storedAt).Seconds())))
+	return &resp, true
+}
+
+// put stores the response if it is complete and cacheable. Partial (206)
+// responses are never stored, as the cache holds full representations.
+func (c *responseCache) put(r *http.Request, scope string, resp *bufferedResponse) {
+	if r.Method != http.MethodGet || !resp.complete || resp.statusCode != http.StatusOK {
+		return
+	}
+	// A response setting a cookie belongs to one client
+	if resp.header.Get("Set-Cookie") != "" {
+		return
+	}
+	ttl, ok := responseTTL(resp.header, c.now())
+	if !ok {
+		return
+	}
+	if ttl > c.maxTTL {
+		ttl = c.maxTTL
+	}
+
+	size := int64(len(resp.body))
+	if size > c.maxBytes {
+		return
+	}
+
+	varyNames := varyHeaders(resp.header)
+	if varyNames == nil {
+		return
+	}
+	varyVals := make([]string, len(varyNames))
+	for i, name := range varyNames {
+		varyVals[i] = strings.Join(r.Header.Values(name), ",")
+	}
+
+	now := c.now()
+	entry := &cacheEntry{
+		key:       cacheKey(r, scope),
+		varyNames: varyNames,
+		varyVals:  varyVals,
+		resp:      resp,
+		storedAt:  now,
+		expiresAt: now.Add(ttl),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, exists := c.entries[entry.key]; exists {
+		c.remove(elem)
+	}
+	for c.size+size > c.maxBytes && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.size += size
+}
+
+// purge drops every entry
+func (c *responseCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.size = 0
+}
+
+// usage returns the number of cached responses and their body bytes
+func (c *responseCache) usage() (entries int, bytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len(), c.size
+}
+
+// remove drops an element; the caller must hold c.mu
+func (c *responseCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.resp.body))
+}
+
+// responseTTL returns how long a response may be cached based on its
+// Cache-Control and Expires headers
+func responseTTL(h http.Header, now time.Time) (time.Duration, bool) {
+	cc := parseCacheControl(h.Get("Cache-Control"))
+	for _, directive := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := cc[directive]; ok {
+			return 0, false
+		}
+	}
+
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[directive]; ok {
+			secs, err := strconv.Atoi(v)
+			if err != nil || secs <= 0 {
+				return 0, false
+			}
+			return time.Duration(secs) * time.Second, true
+		}
+	}
+
+	if expires := h.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil || !t.After(now) {
+			return 0, false
+		}
+		return t.Sub(now), true
+	}
+
+	return 0, false
+}
+
+// parseCacheControl splits a Cache-Control header into lowercased directives
+func parseCacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, val, _ := strings.Cut(part, "=")
+		directives[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(val), `"`)
+	}
+	return directives
+}
+
+// varyHeaders returns the request headers named by the response's Vary
+// header, or nil if the response varies on everything (Vary: *)
+func varyHeaders(h http.Header) []string {
+	names := []string{}
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return nil
+			}
+			if name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,374 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+// newCachingServer returns a server with a caching upstream whose responses
+// carry the given Cache-Control header, plus a fake clock for the cache
+func newCachingServer(t *testing.T, cacheControl string, maxTTL int) (*Server, *int32, *time.Time) {
+	t.Helper()
+	var hits int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&hits, 1)
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		w.Header().Set("Vary", "Accept")
+		w.Write([]byte("response-" + string(rune('0'+n))))
+	}))
+	t.Cleanup(upstream.Close)
+
+	srv := newTestServer(t, config.UpstreamConfig{
+		Name:     "api",
+		URL:      upstream.URL,
+		Audience: upstream.URL,
+		Cache:    config.CacheConfig{Enabled: true, Shared: true, MaxBytes: 1024, MaxTTL: maxTTL},
+	})
+
+	now := time.Now()
+	srv.caches["api"].now = func() time.Time { return now }
+	return srv, &hits, &now
+}
+
+func TestCacheServesFreshResponse(t *testing.T) {
+	srv, hits, _ := newCachingServer(t, "public, max-age=60", 300)
+
+	first := serve(srv, httptest.NewRequest(http.MethodGet, "/data", nil))
+	second := serve(srv, httptest.NewRequest(http.MethodGet, "/data", nil))
+
+	if got := atomic.LoadInt32(hits); got != 1 {
+		t.Errorf("upstream hits = %d, want 1", got)
+	}
+	if second.Body.String() != first.Body.String() {
+		t.Errorf("cached body = %q, want %q", second.Body.String(), first.Body.String())
+	}
+	if second.Header().Get("Age") == "" {
+		t.Error("cached response missing Age header")
+	}
+	if got := srv.metrics.cacheHits.Load(); got != 1 {
+		t.Errorf("cache_hits = %d, want 1", got)
+	}
+	if got := srv.metrics.cacheMisses.Load(); got != 1 {
+		t.Errorf("cache_misses = %d, want 1", got)
+	}
+}
+
+func TestCacheExpiresAfterTTL(t *testing.T) {
+	srv, hits, now := newCachingServer(t, "max-age=30", 300)
+
+	serve(srv, httptest.NewRequest(http.MethodGet, "/data", nil))
+	*now = now.Add(29 * time.Second)
+	serve(srv, httptest.NewRequest(http.MethodGet, "/data", nil))
+	if got := atomic.LoadInt32(hits); got != 1 {
+		t.Fatalf("upstream hits before expiry = %d, want 1", got)
+	}
+
+	*now = now.Add(2 * time.Second)
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/data", nil))
+	if got := atomic.LoadInt32(hits); got != 2 {
+		t.Errorf("upstream hits after expiry = %d, want 2", got)
+	}
+	if rec.Body.String() != "response-2" {
+		t.Errorf("body = %q, want %q", rec.Body.String(), "response-2")
+	}
+}
+
+func TestCacheTTLCappedByMaxTTL(t *testing.T) {
+	srv, hits, now := newCachingServer(t, "max-age=3600", 10)
+
+	serve(srv, httptest.NewRequest(http.MethodGet, "/data", nil))
+	*now = now.Add(11 * time.Second)
+	serve(srv, httptest.NewRequest(http.MethodGet, "/data", nil))
+
+	if got := atomic.LoadInt32(hits); got != 2 {
+		t.Errorf("upstream hits = %d, want 2", got)
+	}
+}
+
+func TestCacheBypass(t *testing.T) {
+	tests := []struct {
+		name          string
+		responseCC    string
+		requestHeader http.Header
+	}{
+		{"response no-store", "no-store", nil},
+		{"response private", "private, max-age=60", nil},
+		{"response without freshness", "", nil},
+		{"request no-cache", "max-age=60", http.Header{"Cache-Control": {"no-cache"}}},
+		{"request pragma no-cache", "max-age=60", http.Header{"Pragma": {"no-cache"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, hits, _ := newCachingServer(t, tt.responseCC, 300)
+
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/data", nil)
+				for k, v := range tt.requestHeader {
+					req.Header[k] = v
+				}
+				serve(srv, req)
+			}
+
+			if got := atomic.LoadInt32(hits); got != 2 {
+				t.Errorf("upstream hits = %d, want 2", got)
+			}
+		})
+	}
+}
+
+func TestCacheVariesOnHeaders(t *testing.T) {
+	srv, hits, _ := newCachingServer(t, "max-age=60", 300)
+
+	jsonReq := httptest.NewRequest(http.MethodGet, "/data", nil)
+	jsonReq.Header.Set("Accept", "application/json")
+	serve(srv, jsonReq)
+
+	xmlReq := httptest.NewRequest(http.MethodGet, "/data", nil)
+	xmlReq.Header.Set("Accept", "application/xml")
+	serve(srv, xmlReq)
+
+	if got := atomic.LoadInt32(hits); got != 2 {
+		t.Errorf("upstream hits = %d, want 2", got)
+	}
+}
+
+func TestCacheStillEnforcesPathFiltering(t *testing.T) {
+	srv, hits, _ := newCachingServer(t, "max-age=60", 300)
+	srv.config.Server.AllowedPaths = []string{"/data"}
+
+	serve(srv, httptest.NewRequest(http.MethodGet, "/data", nil))
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/secret", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if got := atomic.LoadInt32(hits); got != 1 {
+		t.Errorf("upstream hits = %d, want 1", got)
+	}
+}
+
+func TestCacheNeverSharesAcrossIdentities(t *testing.T) {
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s-" + r.URL.Query().Get("user")})
+		}
+		w.Write([]byte("cookie=" + r.Header.Get("Cookie") + " client-auth=" + r.Header.Get("X-Client-Auth")))
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, config.UpstreamConfig{
+		Name: "api", URL: upstream.URL, Audience: "a",
+		Cache: config.CacheConfig{Enabled: true, Shared: true, MaxBytes: 1024, MaxTTL: 300},
+	})
+
+	get := func(path string, header http.Header) string {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		return serve(srv, req).Body.String()
+	}
+
+	for _, user := range []string{"alice", "bob"} {
+		if got, want := get("/me", http.Header{"Cookie": {"session=" + user}}), "cookie=session="+user+" client-auth="; got != want {
+			t.Errorf("%s with cookie: body = %q, want %q", user, got, want)
+		}
+		get("/profile", http.Header{"Authorization": {"Bearer " + user}})
+	}
+	if got := hits.Load(); got != 4 {
+		t.Errorf("upstream hits = %d, want 4 (credentialed requests bypass the cache)", got)
+	}
+
+	// Responses that set a cookie are never stored
+	get("/login?user=alice", nil)
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/login?user=alice", nil))
+	if got := hits.Load(); got != 6 {
+		t.Errorf("upstream hits = %d, want 6", got)
+	}
+	if rec.Header().Get("Age") != "" {
+		t.Error("response with Set-Cookie was served from the cache")
+	}
+	if got := srv.metrics.cacheHits.Load(); got != 0 {
+		t.Errorf("cache_hits = %d, want 0", got)
+	}
+}
+
+func TestCacheSeparatesDefaultRouteToken(t *testing.T) {
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer upstream.Close()
+
+	srv := newTestServerWithConfig(t, &config.Config{
+		Server: config.ServerConfig{
+			DefaultRouteToken:    config.DefaultRouteTokenMint,
+			DefaultRouteAudience: "public",
+		},
+		Upstreams: []config.UpstreamConfig{{
+			Name: "api", URL: upstream.URL, Audience: "api",
+			Cache: config.CacheConfig{Enabled: true, MaxBytes: 1024, MaxTTL: 60},
+		}},
+	})
+	srv.tokenManager.SetSourceFunc(func(ctx context.Context, audience string) (oauth2.TokenSource, error) {
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token-for-" + audience, Expiry: time.Now().Add(time.Hour)}), nil
+	})
+
+	get := func(routed bool) string {
+		req := httptest.NewRequest(http.MethodGet, "/data", nil)
+		if routed {
+			req.Header.Set(targetUpstreamHeader, "api")
+		}
+		return serve(srv, req).Body.String()
+	}
+
+	// A response fetched with the upstream's token is not served to
+	// default-routed requests, minted the default route's, nor the reverse
+	for i := 0; i < 2; i++ {
+		if got := get(true); got != "Bearer token-for-api" {
+			t.Errorf("routed body = %q, want the upstream's token", got)
+		}
+		if got := get(false); got != "Bearer token-for-public" {
+			t.Errorf("default-routed body = %q, want the default route's token", got)
+		}
+	}
+	if got := hits.Load(); got != 2 {
+		t.Errorf("upstream hits = %d, want one per token scope", got)
+	}
+}
+
+func TestCachePaths(t *testing.T) {
+	srv, hits, _ := newCachingServer(t, "max-age=60", 300)
+	srv.config.Upstreams[0].Cache.Paths = []string{"/public/**"}
+
+	for i := 0; i < 2; i++ {
+		serve(srv, httptest.NewRequest(http.MethodGet, "/public/logo", nil))
+		serve(srv, httptest.NewRequest(http.MethodGet, "/account", nil))
+	}
+
+	if got := atomic.LoadInt32(hits); got != 3 {
+		t.Errorf("upstream hits = %d, want 3 (only /public/** cached)", got)
+	}
+}
+
+func TestResponseCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newResponseCache(config.CacheConfig{MaxBytes: 10, MaxTTL: 60})
+	store := func(path, body string) {
+		cache.put(httptest.NewRequest(http.MethodGet, path, nil), "", &bufferedResponse{
+			statusCode: http.StatusOK,
+			header:     http.Header{"Cache-Control": {"max-age=60"}},
+			body:       []byte(body),
+			complete:   true,
+		})
+	}
+	cached := func(path string) bool {
+		_, ok := cache.get(httptest.NewRequest(http.MethodGet, path, nil), "")
+		return ok
+	}
+
+	store("/a", "aaaa")
+	store("/b", "bbbb")
+	cached("/a") // touch /a so /b is least recently used
+	store("/c", "cccc")
+
+	if !cached("/a") {
+		t.Error("/a should still be cached")
+	}
+	if cached("/b") {
+		t.Error("/b should have been evicted")
+	}
+	if !cached("/c") {
+		t.Error("/c should be cached")
+	}
+	if cache.size > 10 {
+		t.Errorf("cache size = %d, want <= 10", cache.size)
+	}
+}
+
+func TestCacheRangeRequests(t *testing.T) {
+	const content = "0123456789"
+	tests := []struct {
+		name         string
+		supportRange bool
+		wantStatus   int
+		wantBody     string
+	}{
+		{"range-supporting upstream", true, http.StatusPartialContent, "2345"},
+		{"upstream ignoring ranges", false, http.StatusOK, content},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits, ranged int32
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&hits, 1)
+				if r.Header.Get("Range") != "" {
+					atomic.AddInt32(&ranged, 1)
+				}
+				w.Header().Set("Cache-Control", "public, max-age=60")
+				if !tt.supportRange {
+					w.Write([]byte(content))
+					return
+				}
+				http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+			}))
+			defer upstream.Close()
+
+			srv := newTestServer(t, config.UpstreamConfig{
+				Name:     "api",
+				URL:      upstream.URL,
+				Audience: upstream.URL,
+				Cache:    config.CacheConfig{Enabled: true, Shared: true, MaxBytes: 1024, MaxTTL: 300},
+			})
+
+			rangeRequest := func() *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodGet, "/file", nil)
+				req.Header.Set("Range", "bytes=2-5")
+				return serve(srv, req)
+			}
+
+			// The full response is cached, but ranges still go upstream
+			serve(srv, httptest.NewRequest(http.MethodGet, "/file", nil))
+			for range 2 {
+				rec := rangeRequest()
+				if rec.Code != tt.wantStatus || rec.Body.String() != tt.wantBody {
+					t.Fatalf("range response = %d %q, want %d %q", rec.Code, rec.Body.String(), tt.wantStatus, tt.wantBody)
+				}
+				if tt.supportRange && rec.Header().Get("Content-Range") != "bytes 2-5/10" {
+					t.Errorf("Content-Range = %q, want bytes 2-5/10", rec.Header().Get("Content-Range"))
+				}
+				if rec.Header().Get("Age") != "" {
+					t.Error("range request answered from the cache")
+				}
+			}
+			if got := atomic.LoadInt32(&ranged); got != 2 {
+				t.Errorf("range requests forwarded = %d, want 2", got)
+			}
+
+			// Neither a 206 nor a full answer to a range replaced the cached entry
+			if rec := serve(srv, httptest.NewRequest(http.MethodGet, "/file", nil)); rec.Body.String() != content || rec.Header().Get("Age") == "" {
+				t.Errorf("full request = %q (Age %q), want the cached full body", rec.Body.String(), rec.Header().Get("Age"))
+			}
+			if got := atomic.LoadInt32(&hits); got != 3 {
+				t.Errorf("upstream hits = %d, want 3", got)
+			}
+		})
+	}
+}
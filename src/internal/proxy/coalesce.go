@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"golang.org/x/sync/singleflight"
+
+	"go-oauth2-proxy/src/internal/config"
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+// Request headers that can change the upstream response and must be part of
+// the coalescing signature
+var coalesceHeaders = []string{
+	"Accept",
+	"Accept-Encoding",
+	"Accept-Language",
+	"Range",
+}
+
+// coalescer collapses identical in-flight requests into a single upstream call
+type coalescer struct {
+	group singleflight.Group
+}
+
+// isCoalescable reports whether the request may share an upstream response.
+// As with the response cache, requests carrying client credentials are never
+// shared: their responses may be specific to that identity.
+func isCoalescable(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	return r.Header.Get("Authorization") == "" && r.Header.Get("Cookie") == ""
+}
+
+// isShareable reports whether a leader's response may be replayed to the
+// waiters: it must be complete, and not one meant for a single client, such
+// as one setting a cookie or marked private or no-store
+func isShareable(resp *bufferedResponse) bool {
+	if !resp.complete || resp.header.Get("Set-Cookie") != "" {
+		return false
+	}
+	cc := parseCacheControl(resp.header.Get("Cache-Control"))
+	if _, ok := cc["private"]; ok {
+		return false
+	}
+	_, noStore := cc["no-store"]
+	return !noStore
+}
+
+// coalesceKey builds the request signature used to detect identical requests,
+// including the token scope they are proxied with (see Server.tokenScope)
+func coalesceKey(r *http.Request, upstream *config.UpstreamConfig, scope string) string {
+	var b strings.Builder
+	b.WriteString(upstream.Name)
+	b.WriteString("\n")
+	b.WriteString(scope)
+	b.WriteString("\n")
+	b.WriteString(r.Method)
+	b.WriteString("\n")
+	b.WriteString(r.URL.RequestURI())
+	for _, h := range coalesceHeaders {
+		b.WriteString("\n")
+		b.WriteString(h)
+		b.WriteString(":")
+		b.WriteString(strings.Join(r.Header.Values(h), ","))
+	}
+	return b.String()
+}
+
+// serve proxies the request through next, sharing the response with any
+// identical requests that arrive while it is in flight. The first caller
+// streams directly to its client; waiters replay the buffered copy, or fall
+// back to their own upstream call if the response cannot be shared (see
+// isShareable).
+func (c *coalescer) serve(w http.ResponseWriter, r *http.Request, upstream *config.UpstreamConfig, scope string, next http.Handler) {
+	key := coalesceKey(r, upstream, scope)
+
+	leader := false
+	var aborted interface{}
+	v, _, shared := c.group.Do(key, func() (result interface{}, err error) {
+		leader = true
+		rec := newTeeRecorder(w, upstream.CoalesceMaxBytes)
+		// A leader aborting mid-response (the reverse proxy panics with
+		// http.ErrAbortHandler) must not take its waiters down with it:
+		// they get an incomplete response and proxy independently
+		defer func() {
+			if aborted = recover(); aborted != nil {
+				result = &bufferedResponse{}
+			}
+		}()
+		next.ServeHTTP(rec, r)
+		return rec.result(r), nil
+	})
+	if leader {
+		if aborted != nil {
+			panic(aborted)
+		}
+		return
+	}
+
+	resp := v.(*bufferedResponse)
+	if !isShareable(resp) {
+		logger.Debug("Coalesced response not shareable, proxying independently",
+			"upstream", upstream.Name,
+			"path", r.URL.Path)
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	logger.Debug("Serving coalesced response",
+		"upstream", upstream.Name,
+		"path", r.URL.Path,
+		"shared", shared)
+
+	resp.writeTo(w, r)
+}
@@ -0,0 +1,317 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+// newBlockingUpstream returns an upstream that counts hits and blocks each
+// request until release is closed
+func newBlockingUpstream(t *testing.T, body string) (*httptest.Server, *int32, chan struct{}) {
+	t.Helper()
+	var hits int32
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		<-release
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream, &hits, release
+}
+
+// runConcurrent issues n identical requests and waits for the first upstream
+// hit before releasing the upstream
+func runConcurrent(t *testing.T, srv *Server, n int, method string, hits *int32, release chan struct{}) []*httptest.ResponseRecorder {
+	t.Helper()
+	recs := make([]*httptest.ResponseRecorder, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			recs[i] = serve(srv, httptest.NewRequest(method, "/data", nil))
+		}(i)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(hits) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	// Give the remaining requests time to join the in-flight call
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	return recs
+}
+
+func TestCoalesceIdenticalGets(t *testing.T) {
+	upstream, hits, release := newBlockingUpstream(t, "shared-body")
+	srv := newTestServer(t, config.UpstreamConfig{
+		Name:             "api",
+		URL:              upstream.URL,
+		Audience:         upstream.URL,
+		Coalesce:         true,
+		CoalesceMaxBytes: 1024,
+	})
+
+	recs := runConcurrent(t, srv, 10, http.MethodGet, hits, release)
+
+	if got := atomic.LoadInt32(hits); got != 1 {
+		t.Errorf("upstream hits = %d, want 1", got)
+	}
+	for i, rec := range recs {
+		if rec.Code != http.StatusOK {
+			t.Errorf("request %d: status = %d, want %d", i, rec.Code, http.StatusOK)
+		}
+		if rec.Body.String() != "shared-body" {
+			t.Errorf("request %d: body = %q, want %q", i, rec.Body.String(), "shared-body")
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "text/plain" {
+			t.Errorf("request %d: Content-Type = %q, want text/plain", i, ct)
+		}
+	}
+}
+
+func TestCoalesceDisabled(t *testing.T) {
+	upstream, hits, release := newBlockingUpstream(t, "body")
+	srv := newTestServer(t, config.UpstreamConfig{
+		Name:     "api",
+		URL:      upstream.URL,
+		Audience: upstream.URL,
+	})
+
+	runConcurrent(t, srv, 3, http.MethodGet, hits, release)
+
+	if got := atomic.LoadInt32(hits); got != 3 {
+		t.Errorf("upstream hits = %d, want 3", got)
+	}
+}
+
+func TestCoalesceOversizedResponseNotShared(t *testing.T) {
+	body := strings.Repeat("x", 64)
+	upstream, hits, release := newBlockingUpstream(t, body)
+	srv := newTestServer(t, config.UpstreamConfig{
+		Name:             "api",
+		URL:              upstream.URL,
+		Audience:         upstream.URL,
+		Coalesce:         true,
+		CoalesceMaxBytes: 16,
+	})
+
+	recs := runConcurrent(t, srv, 3, http.MethodGet, hits, release)
+
+	// Waiters fall back to their own upstream calls
+	if got := atomic.LoadInt32(hits); got != 3 {
+		t.Errorf("upstream hits = %d, want 3", got)
+	}
+	for i, rec := range recs {
+		if rec.Body.String() != body {
+			t.Errorf("request %d: body length = %d, want %d", i, rec.Body.Len(), len(body))
+		}
+	}
+}
+
+func TestCoalescePerClientResponsesNotShared(t *testing.T) {
+	for name, header := range map[string][2]string{
+		"set-cookie": {"Set-Cookie", "session=abc"},
+		"private":    {"Cache-Control", "private, max-age=60"},
+		"no-store":   {"Cache-Control", "no-store"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var hits int32
+			release := make(chan struct{})
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&hits, 1)
+				<-release
+				w.Header().Set(header[0], header[1])
+				w.Write([]byte("mine"))
+			}))
+			defer upstream.Close()
+			srv := newTestServer(t, config.UpstreamConfig{
+				Name:             "api",
+				URL:              upstream.URL,
+				Audience:         upstream.URL,
+				Coalesce:         true,
+				CoalesceMaxBytes: 1024,
+			})
+
+			recs := runConcurrent(t, srv, 3, http.MethodGet, &hits, release)
+
+			// Waiters make their own upstream calls rather than replaying it
+			if got := atomic.LoadInt32(&hits); got != 3 {
+				t.Errorf("upstream hits = %d, want 3", got)
+			}
+			for i, rec := range recs {
+				if rec.Code != http.StatusOK || rec.Body.String() != "mine" {
+					t.Errorf("request %d: %d %q, want 200 mine", i, rec.Code, rec.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestCoalesceLeaderAbortNotShared(t *testing.T) {
+	var calls int32
+	leading := make(chan struct{})
+	release := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(leading)
+			<-release
+			w.Write([]byte("part"))
+			panic(http.ErrAbortHandler)
+		}
+		w.Write([]byte("whole"))
+	})
+	c := &coalescer{}
+	upstream := &config.UpstreamConfig{Name: "api", CoalesceMaxBytes: 1024}
+
+	leaderPanic := make(chan interface{}, 1)
+	go func() {
+		defer func() { leaderPanic <- recover() }()
+		c.serve(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/data", nil), upstream, "", next)
+	}()
+	<-leading
+
+	recs := make([]*httptest.ResponseRecorder, 2)
+	var wg sync.WaitGroup
+	for i := range recs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			recs[i] = httptest.NewRecorder()
+			c.serve(recs[i], httptest.NewRequest(http.MethodGet, "/data", nil), upstream, "", next)
+		}(i)
+	}
+	// Give the waiters time to join the in-flight call
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// The leader's client is aborted as before; the waiters are not
+	if p := <-leaderPanic; p != http.ErrAbortHandler {
+		t.Errorf("leader panic = %v, want http.ErrAbortHandler", p)
+	}
+	for i, rec := range recs {
+		if rec.Body.String() != "whole" {
+			t.Errorf("waiter %d: body = %q, want its own upstream call's", i, rec.Body.String())
+		}
+	}
+	if got := atomic.LoadInt32(&calls); got != 3 {
+		t.Errorf("calls = %d, want 3", got)
+	}
+}
+
+func TestCoalesceCredentialedRequestsNotShared(t *testing.T) {
+	var hits int32
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		<-release
+		cookie, _ := r.Cookie("user")
+		w.Write([]byte("hello " + cookie.Value))
+	}))
+	defer upstream.Close()
+	srv := newTestServer(t, config.UpstreamConfig{
+		Name:             "api",
+		URL:              upstream.URL,
+		Audience:         upstream.URL,
+		Coalesce:         true,
+		CoalesceMaxBytes: 1024,
+	})
+
+	users := []string{"alice", "bob"}
+	recs := make([]*httptest.ResponseRecorder, len(users))
+	var wg sync.WaitGroup
+	for i, user := range users {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/me", nil)
+			req.AddCookie(&http.Cookie{Name: "user", Value: user})
+			recs[i] = serve(srv, req)
+		}()
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&hits) < int32(len(users)) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&hits); got != 2 {
+		t.Errorf("upstream hits = %d, want one per client", got)
+	}
+	for i, user := range users {
+		if got := recs[i].Body.String(); got != "hello "+user {
+			t.Errorf("%s got %q", user, got)
+		}
+	}
+}
+
+func TestCoalesceKey(t *testing.T) {
+	upstream := &config.UpstreamConfig{Name: "api"}
+
+	a := httptest.NewRequest(http.MethodGet, "/data?x=1", nil)
+	b := httptest.NewRequest(http.MethodGet, "/data?x=1", nil)
+	if coalesceKey(a, upstream, "") != coalesceKey(b, upstream, "") {
+		t.Error("identical requests should share a key")
+	}
+
+	c := httptest.NewRequest(http.MethodGet, "/data?x=2", nil)
+	if coalesceKey(a, upstream, "") == coalesceKey(c, upstream, "") {
+		t.Error("different queries should not share a key")
+	}
+
+	d := httptest.NewRequest(http.MethodGet, "/data?x=1", nil)
+	d.Header.Set("Accept", "application/json")
+	if coalesceKey(a, upstream, "") == coalesceKey(d, upstream, "") {
+		t.Error("different Accept headers should not share a key")
+	}
+
+	e := httptest.NewRequest(http.MethodHead, "/data?x=1", nil)
+	if coalesceKey(a, upstream, "") == coalesceKey(e, upstream, "") {
+		t.Error("different methods should not share a key")
+	}
+
+	if coalesceKey(a, upstream, "api") == coalesceKey(b, upstream, "public") {
+		t.Error("requests proxied with different tokens should not share a key")
+	}
+}
+
+func TestIsCoalescable(t *testing.T) {
+	tests := []struct {
+		method string
+		header string
+		want   bool
+	}{
+		{http.MethodGet, "", true},
+		{http.MethodHead, "", true},
+		{http.MethodPost, "", false},
+		{http.MethodPut, "", false},
+		{http.MethodDelete, "", false},
+		{http.MethodGet, "Authorization", false},
+		{http.MethodGet, "Cookie", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+tt.header, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, "x")
+			}
+			if got := isCoalescable(r); got != tt.want {
+				t.Errorf("isCoalescable(%s %s) = %v, want %v", tt.method, tt.header, got, tt.want)
+			}
+		})
+	}
+}
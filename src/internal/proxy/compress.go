@@ -0,0 +1,231 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+// compressRequestBody gzips the outgoing request body when the upstream
+// opted in and the body is worth compressing. The body is compressed while
+// it streams to the upstream, so it is sent chunked without a Content-Length.
+// It reports whether the body was compressed.
+func compressRequestBody(req *http.Request, cfg config.RequestCompressionConfig) bool {
+	if !cfg.Enabled || req.Body == nil || req.Body == http.NoBody ||
+		!carriesBody(req.Method) || !compressibleRequest(req.Header) {
+		return false
+	}
+
+	// Small bodies of known length are not worth the CPU
+	if req.ContentLength >= 0 && req.ContentLength <= cfg.MinBytes {
+		return false
+	}
+
+	// For bodies of unknown length, read just past the threshold to decide
+	body := io.Reader(req.Body)
+	if req.ContentLength < 0 {
+		head, err := io.ReadAll(io.LimitReader(req.Body, cfg.MinBytes+1))
+		body = io.MultiReader(bytes.NewReader(head), req.Body)
+		if err != nil || int64(len(head)) <= cfg.MinBytes {
+			req.Body = readCloser{body, req.Body}
+			return false
+		}
+	}
+
+	// The transport closes the pipe reader when it is done with the body,
+	// which unblocks the writer if the upstream stops reading early
+	pr, pw := io.Pipe()
+	go func(src io.ReadCloser) {
+		zw := gzip.NewWriter(pw)
+		_, err := io.Copy(zw, body)
+		if closeErr := zw.Close(); err == nil {
+			err = closeErr
+		}
+		src.Close()
+		pw.CloseWithError(err)
+	}(req.Body)
+
+	req.Body = pr
+	req.GetBody = nil
+	req.ContentLength = -1
+	req.Header.Del("Content-Length")
+	req.Header.Set("Content-Encoding", "gzip")
+	return true
+}
+
+// readCloser pairs a reader with the Closer of the body it reads from
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// carriesBody reports whether requests with the method usually have a body
+func carriesBody(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		return true
+	}
+	return false
+}
+
+// compressibleRequest reports whether a body with these headers may be
+// compressed: it must not be encoded already, nor be a media type that is
+// itself compressed (images, archives, ...)
+func compressibleRequest(h http.Header) bool {
+	if enc := h.Get("Content-Encoding"); enc != "" && !strings.EqualFold(enc, "identity") {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	switch {
+	case strings.HasPrefix(mediaType, "image/") && mediaType != "image/svg+xml",
+		strings.HasPrefix(mediaType, "audio/"),
+		strings.HasPrefix(mediaType, "video/"):
+		return false
+	}
+	switch mediaType {
+	case "application/gzip", "application/x-gzip", "application/zip",
+		"application/zstd", "application/x-bzip2", "application/x-xz",
+		"application/x-7z-compressed":
+		return false
+	}
+	return true
+}
+
+// transcodeEncodings are the content codings the gateway can re-encode a
+// response into, in order of preference
+var transcodeEncodings = []string{"gzip", "br"}
+
+// transcodeResponse re-encodes a response whose Content-Encoding the client
+// did not accept into the first of transcodeEncodings it does, or identity.
+// The body is decoded and re-encoded as it streams, so the length is no
+// longer known. Partial and body-less responses, and codings the gateway
+// cannot decode (including stacked codings), are left as they are. It
+// reports whether the response was transcoded.
+func transcodeResponse(resp *http.Response, acceptEncoding string) bool {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" || acceptsEncoding(acceptEncoding, encoding) {
+		return false
+	}
+	if resp.StatusCode == http.StatusPartialContent || !hasResponseBody(resp) {
+		return false
+	}
+	newDecoder, ok := contentDecoders[encoding]
+	if !ok {
+		return false
+	}
+
+	target := "identity"
+	for _, candidate := range transcodeEncodings {
+		if acceptsEncoding(acceptEncoding, candidate) {
+			target = candidate
+			break
+		}
+	}
+
+	// Closing the pipe reader (when the proxy is done with the body)
+	// unblocks the encoder, and closing the upstream body unblocks the decoder
+	pr, pw := io.Pipe()
+	go func(src io.Reader) {
+		decoded, err := newDecoder(src)
+		if err == nil {
+			var dst io.WriteCloser = nopWriteCloser{pw}
+			switch target {
+			case "gzip":
+				dst = gzip.NewWriter(pw)
+			case "br":
+				dst = brotli.NewWriter(pw)
+			}
+			_, err = io.Copy(dst, decoded)
+			if closeErr := dst.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		pw.CloseWithError(err)
+	}(resp.Body)
+	resp.Body = readCloser{pr, closers{pr, resp.Body}}
+
+	if target == "identity" {
+		resp.Header.Del("Content-Encoding")
+	} else {
+		resp.Header.Set("Content-Encoding", target)
+	}
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Header.Add("Vary", "Accept-Encoding")
+	// The bytes changed, so a strong validator no longer applies
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		resp.Header.Set("ETag", "W/"+etag)
+	}
+	return true
+}
+
+// contentDecoders decode the response content codings transcodeResponse
+// understands; deflate is the zlib format, per RFC 9110
+var contentDecoders = map[string]func(io.Reader) (io.Reader, error){
+	"gzip":    func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	"x-gzip":  func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	"deflate": func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) },
+	"br":      func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header value allows
+// the content coding: an explicit entry wins, then "*"; identity is
+// acceptable unless excluded, and x-gzip is treated as gzip
+func acceptsEncoding(header, coding string) bool {
+	if coding == "x-gzip" {
+		coding = "gzip"
+	}
+	wildcard := -1.0
+	for _, entry := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(entry, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "x-gzip" {
+			name = "gzip"
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		switch name {
+		case coding:
+			return q > 0
+		case "*":
+			wildcard = q
+		}
+	}
+	if wildcard >= 0 {
+		return wildcard > 0
+	}
+	return coding == "identity"
+}
+
+// nopWriteCloser adds a no-op Close to a writer
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// closers closes each of its members, returning the first error
+type closers []io.Closer
+
+func (c closers) Close() error {
+	var first error
+	for _, closer := range c {
+		if err := closer.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+func TestCompressRequestBody(t *testing.T) {
+	// The upstream decodes gzipped bodies and reports what it received
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := io.Reader(r.Body)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			body = zr
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "encoding=%s length=%d body=%s",
+			r.Header.Get("Content-Encoding"), r.ContentLength, data)
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, config.UpstreamConfig{
+		Name: "api", URL: upstream.URL, Audience: "a",
+		CompressRequests: config.RequestCompressionConfig{Enabled: true, MinBytes: 16},
+	})
+
+	large := strings.Repeat("a", 64)
+	tests := []struct {
+		name        string
+		method      string
+		body        io.Reader
+		contentType string
+		encoding    string
+		want        string
+	}{
+		{"large body", http.MethodPost, strings.NewReader(large), "application/json", "",
+			"encoding=gzip length=-1 body=" + large},
+		{"large body of unknown length", http.MethodPut, io.MultiReader(strings.NewReader(large)), "", "",
+			"encoding=gzip length=-1 body=" + large},
+		{"small body", http.MethodPost, strings.NewReader("tiny"), "", "",
+			"encoding= length=4 body=tiny"},
+		{"small body of unknown length", http.MethodPatch, io.MultiReader(strings.NewReader("tiny")), "", "",
+			"encoding= length=-1 body=tiny"},
+		{"method without body", http.MethodDelete, strings.NewReader(large), "", "",
+			"encoding= length=64 body=" + large},
+		{"compressed media type", http.MethodPost, strings.NewReader(large), "image/png", "",
+			"encoding= length=64 body=" + large},
+		{"identity encoding", http.MethodPost, strings.NewReader(large), "", "identity",
+			"encoding=gzip length=-1 body=" + large},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/upload", tt.body)
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+			rec := serve(srv, req)
+			if got := rec.Body.String(); got != tt.want {
+				t.Errorf("upstream saw %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompressRequestBodySkipsEncodedBodies(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("a", 64)))
+	req.Header.Set("Content-Encoding", "br")
+	if compressRequestBody(req, config.RequestCompressionConfig{Enabled: true}) {
+		t.Error("compressRequestBody() compressed an already encoded body")
+	}
+	if got := req.Header.Get("Content-Encoding"); got != "br" {
+		t.Errorf("Content-Encoding = %q, want br", got)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("a", 64)))
+	if compressRequestBody(req, config.RequestCompressionConfig{}) {
+		t.Error("compressRequestBody() compressed with compression disabled")
+	}
+}
+
+// newBrotliUpstream returns an upstream that always sends a br-encoded body,
+// whatever the client accepts
+func newBrotliUpstream(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		bw := brotli.NewWriter(&buf)
+		io.WriteString(bw, body)
+		bw.Close()
+		w.Header().Set("Content-Encoding", "br")
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(buf.Bytes())
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+func TestTranscodeResponses(t *testing.T) {
+	body := strings.Repeat("hello transcoding ", 100)
+	upstream := newBrotliUpstream(t, body)
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: "a", TranscodeResponses: true})
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		wantEncoding   string
+	}{
+		{"br to gzip", "gzip, deflate", "gzip"},
+		{"br to identity", "", ""},
+		{"br to identity when br refused", "br;q=0, identity", ""},
+		{"br accepted", "gzip, br", "br"},
+		{"br accepted by wildcard", "*", "br"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rec := serve(srv, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+			if got := rec.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+
+			var decoded io.Reader = rec.Body
+			switch tt.wantEncoding {
+			case "gzip":
+				zr, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatalf("gzip.NewReader() error = %v", err)
+				}
+				decoded = zr
+			case "br":
+				decoded = brotli.NewReader(rec.Body)
+			}
+			data, err := io.ReadAll(decoded)
+			if err != nil || string(data) != body {
+				t.Fatalf("decoded body = %d bytes, %v; want the original %d bytes", len(data), err, len(body))
+			}
+
+			transcoded := tt.wantEncoding != "br"
+			if got := rec.Header().Get("ETag"); transcoded != (got == `W/"v1"`) {
+				t.Errorf("ETag = %q, want weakened only when transcoded", got)
+			}
+			if transcoded && rec.Header().Get("Content-Length") != "" {
+				t.Error("Content-Length should be dropped when transcoding")
+			}
+		})
+	}
+}
+
+func TestTranscodeResponsesDisabled(t *testing.T) {
+	upstream := newBrotliUpstream(t, "payload")
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: "a"})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	if got := serve(srv, req).Header().Get("Content-Encoding"); got != "br" {
+		t.Errorf("Content-Encoding = %q, want the upstream's br untouched", got)
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header, coding string
+		want           bool
+	}{
+		{"gzip, br", "br", true},
+		{"gzip;q=0.5", "gzip", true},
+		{"gzip;q=0", "gzip", false},
+		{"x-gzip", "gzip", true},
+		{"*;q=0", "br", false},
+		{"*, br;q=0", "br", false},
+		{"", "identity", true},
+		{"identity;q=0", "identity", false},
+		{"gzip", "deflate", false},
+	}
+	for _, tt := range tests {
+		if got := acceptsEncoding(tt.header, tt.coding); got != tt.want {
+			t.Errorf("acceptsEncoding(%q, %q) = %v, want %v", tt.header, tt.coding, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+// responseTypeAllowed reports whether the upstream response may be
+// forwarded under the upstream's response_types allow-list. Responses
+// without a body pass regardless of their Content-Type.
+func responseTypeAllowed(resp *http.Response, cfg config.ResponseTypesConfig) bool {
+	if len(cfg.Allowed) == 0 || !hasResponseBody(resp) {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, allowed := range cfg.Allowed {
+		allowed = strings.ToLower(allowed)
+		if mediaType == allowed ||
+			(strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasResponseBody reports whether the response may carry a body
+func hasResponseBody(resp *http.Response) bool {
+	if bodilessStatus(resp.StatusCode) || (resp.Request != nil && resp.Request.Method == http.MethodHead) {
+		return false
+	}
+	return resp.ContentLength != 0
+}
+
+// bodilessStatus reports whether responses with this status never carry a
+// body: 1xx, 204 and 304
+func bodilessStatus(code int) bool {
+	return (code >= 100 && code < 200) || code == http.StatusNoContent || code == http.StatusNotModified
+}
+
+// replaceDisallowedResponse rewrites a response whose type is not allowed:
+// "strip" keeps the status and headers but drops the body, "reject" (the
+// default) replaces the whole response with a 502
+func replaceDisallowedResponse(resp *http.Response, cfg config.ResponseTypesConfig) {
+	resp.Body.Close()
+
+	if cfg.Action == config.ResponseTypeStrip {
+		for _, h := range []string{"Content-Type", "Content-Encoding", "Content-Range", "Transfer-Encoding"} {
+			resp.Header.Del(h)
+		}
+		resp.Body = http.NoBody
+		resp.ContentLength = 0
+		resp.Header.Set("Content-Length", "0")
+		return
+	}
+
+	body := "Bad Gateway: upstream response content type not allowed\n"
+	resp.StatusCode = http.StatusBadGateway
+	resp.Status = strconv.Itoa(http.StatusBadGateway) + " " + http.StatusText(http.StatusBadGateway)
+	resp.Header = http.Header{
+		"Content-Type":           {"text/plain; charset=utf-8"},
+		"X-Content-Type-Options": {"nosniff"},
+		"Content-Length":         {strconv.Itoa(len(body))},
+	}
+	resp.Body = io.NopCloser(strings.NewReader(body))
+	resp.ContentLength = int64(len(body))
+}
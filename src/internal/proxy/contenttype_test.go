@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+func TestResponseTypesAllowList(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.URL.Query().Get("type"); ct != "" {
+			w.Header().Set("Content-Type", ct)
+		} else {
+			w.Header()["Content-Type"] = nil // suppress content sniffing
+		}
+		w.Header().Set("X-Upstream", "yes")
+		if r.URL.Query().Get("empty") != "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "payload")
+	}))
+	defer upstream.Close()
+
+	allowed := []string{"application/json", "text/*"}
+	tests := []struct {
+		name       string
+		action     string
+		query      string
+		wantStatus int
+		wantBody   string
+		wantHeader bool // upstream headers kept
+	}{
+		{"exact match", "", "type=application/json", http.StatusCreated, "payload", true},
+		{"match with parameters", "", "type=application/json%3B+charset=utf-8", http.StatusCreated, "payload", true},
+		{"case insensitive", "", "type=Application/JSON", http.StatusCreated, "payload", true},
+		{"wildcard", "", "type=text/csv", http.StatusCreated, "payload", true},
+		{"disallowed rejected", "", "type=image/png", http.StatusBadGateway, "Bad Gateway: upstream response content type not allowed\n", false},
+		{"explicit reject", config.ResponseTypeReject, "type=application/xml", http.StatusBadGateway, "Bad Gateway: upstream response content type not allowed\n", false},
+		{"no content type rejected", "", "", http.StatusBadGateway, "Bad Gateway: upstream response content type not allowed\n", false},
+		{"disallowed stripped", config.ResponseTypeStrip, "type=application/xml", http.StatusCreated, "", true},
+		{"no body passes", "", "type=application/xml&empty=1", http.StatusNoContent, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, config.UpstreamConfig{
+				Name: "api", URL: upstream.URL, Audience: "a",
+				ResponseTypes: config.ResponseTypesConfig{Allowed: allowed, Action: tt.action},
+			})
+			rec := serve(srv, httptest.NewRequest(http.MethodGet, "/data?"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Body.String(); got != tt.wantBody {
+				t.Errorf("body = %q, want %q", got, tt.wantBody)
+			}
+			if got := rec.Header().Get("X-Upstream") == "yes"; got != tt.wantHeader {
+				t.Errorf("upstream headers kept = %v, want %v", got, tt.wantHeader)
+			}
+			if tt.action == config.ResponseTypeStrip && rec.Header().Get("Content-Type") != "" {
+				t.Errorf("Content-Type = %q, want it removed with the body", rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestResponseTypesUnrestricted(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		io.WriteString(w, "blob")
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: "a"})
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "blob" {
+		t.Errorf("response = %d %q, want 200 blob without an allow-list", rec.Code, rec.Body.String())
+	}
+}
+
+func TestBodilessResponsesUnchanged(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream", "yes")
+		if r.URL.Path == "/not-modified" {
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	// Every body-handling feature is on: none may wait for, rewrite or
+	// replay a body these responses cannot have
+	srv := newTestServerWithConfig(t, &config.Config{
+		Upstreams: []config.UpstreamConfig{{
+			Name: "api", URL: upstream.URL, Audience: "a",
+			ResponseTimeout:    1,
+			TranscodeResponses: true,
+			ResponseTypes:      config.ResponseTypesConfig{Allowed: []string{"application/json"}},
+			Cache:              config.CacheConfig{Enabled: true, MaxBytes: 1024, MaxTTL: 60},
+			Coalesce:           true,
+			CoalesceMaxBytes:   1024,
+		}},
+	})
+	// A real server, so the Content-Length it would add is visible
+	gateway := httptest.NewServer(srv.httpServer.Handler)
+	defer gateway.Close()
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/no-content", http.StatusNoContent},
+		{"/not-modified", http.StatusNotModified},
+	}
+	for _, tt := range tests {
+		for attempt := 1; attempt <= 2; attempt++ {
+			req, _ := http.NewRequest(http.MethodGet, gateway.URL+tt.path, nil)
+			req.Header.Set("Accept-Encoding", "identity")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("GET %s error = %v", tt.path, err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.status || len(body) != 0 {
+				t.Errorf("%s #%d: status = %d body = %q, want %d and no body", tt.path, attempt, resp.StatusCode, body, tt.status)
+			}
+			if got := resp.Header.Values("Content-Length"); len(got) != 0 {
+				t.Errorf("%s #%d: Content-Length = %q, want none", tt.path, attempt, got)
+			}
+			if resp.Header.Get("X-Upstream") != "yes" {
+				t.Errorf("%s #%d: upstream headers dropped", tt.path, attempt)
+			}
+			if tt.status == http.StatusNotModified &&
+				(resp.Header.Get("ETag") != `"v1"` || resp.Header.Get("Content-Encoding") != "gzip") {
+				t.Errorf("%s #%d: ETag = %q Content-Encoding = %q, want them unchanged", tt.path, attempt,
+					resp.Header.Get("ETag"), resp.Header.Get("Content-Encoding"))
+			}
+		}
+	}
+	if got := srv.metrics.cacheHits.Load(); got != 0 {
+		t.Errorf("cache hits = %d, want bodiless responses never cached", got)
+	}
+}
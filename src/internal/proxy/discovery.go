@@ -0,0 +1,227 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+const (
+	discoveryPath = "/.well-known/openid-configuration"
+	jwksPath      = "/.well-known/jwks.json"
+
+	// maxDiscoveryBytes bounds a discovery document or key set held in memory
+	maxDiscoveryBytes = 1 << 20 // 1 MiB
+)
+
+// discoveryDoc is a cached discovery document or key set
+type discoveryDoc struct {
+	body    []byte
+	fetched time.Time
+}
+
+// discoveryCache holds the discovery upstream's documents, keyed by the
+// gateway path serving them. The mutex is held across fetches, so
+// concurrent misses wait for a single upstream call.
+type discoveryCache struct {
+	upstream  *config.UpstreamConfig
+	ttl       time.Duration
+	publicURL string // discovery.public_url, without a trailing slash
+
+	mu      sync.Mutex
+	docs    map[string]*discoveryDoc
+	jwksURI *url.URL // from the discovery document
+}
+
+func newDiscoveryCache(upstream *config.UpstreamConfig, ttl time.Duration, publicURL string) *discoveryCache {
+	return &discoveryCache{upstream: upstream, ttl: ttl, publicURL: publicURL, docs: make(map[string]*discoveryDoc)}
+}
+
+// handleDiscovery serves the discovery upstream's OIDC discovery document,
+// with jwks_uri pointing back at the gateway's discovery.public_url, and its
+// key set. Both are cached for discovery.cache_ttl; when a refresh fails the
+// stale copy is served rather than an error.
+func (s *Server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// The request's Host is client-supplied; never build jwks_uri from it
+	if s.discovery.publicURL == "" {
+		logger.Warn("Discovery requested without discovery.public_url", "path", r.URL.Path)
+		http.Error(w, "Service Unavailable: discovery.public_url not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	doc, err := s.discoveryDocument(r.Context(), r.URL.Path)
+	if err != nil {
+		logger.Warn("Discovery fetch failed", "upstream", s.discovery.upstream.Name, "path", r.URL.Path, "error", err)
+		http.Error(w, "Bad Gateway: discovery unavailable", http.StatusBadGateway)
+		return
+	}
+
+	body := doc.body
+	if r.URL.Path == discoveryPath {
+		if body, err = rewriteJWKSURI(body, s.discovery.publicURL+jwksPath); err != nil {
+			logger.Warn("Discovery document rewrite failed", "upstream", s.discovery.upstream.Name, "error", err)
+			http.Error(w, "Bad Gateway: discovery unavailable", http.StatusBadGateway)
+			return
+		}
+	}
+
+	maxAge := max(s.discovery.ttl-time.Since(doc.fetched), 0)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+	w.Write(body)
+}
+
+// discoveryDocument returns the document served at path, fetching it when
+// the cached copy has expired. The key set's location comes from the
+// discovery document, which is loaded first if needed.
+func (s *Server) discoveryDocument(ctx context.Context, path string) (*discoveryDoc, error) {
+	d := s.discovery
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if path == jwksPath && d.jwksURI == nil {
+		if _, err := s.loadDiscoveryDoc(ctx, discoveryPath); err != nil {
+			return nil, err
+		}
+	}
+	return s.loadDiscoveryDoc(ctx, path)
+}
+
+// loadDiscoveryDoc returns a fresh copy of the document at path, falling
+// back to a stale one when the fetch fails. d.mu must be held.
+func (s *Server) loadDiscoveryDoc(ctx context.Context, path string) (*discoveryDoc, error) {
+	d := s.discovery
+	cached := d.docs[path]
+	if cached != nil && time.Since(cached.fetched) < d.ttl {
+		return cached, nil
+	}
+
+	target := d.jwksURI
+	if path == discoveryPath {
+		target = s.upstreamURL(d.upstream)
+		target.Path = singleJoiningSlash(target.Path, discoveryPath)
+		target.RawQuery = ""
+	}
+
+	body, err := s.fetchDiscovery(ctx, target)
+	if err == nil && path == discoveryPath {
+		var jwksURI *url.URL
+		if jwksURI, err = parseJWKSURI(body); err == nil {
+			d.jwksURI = jwksURI
+		}
+	}
+	if err != nil {
+		if cached != nil {
+			logger.Warn("Discovery refresh failed, serving stale copy",
+				"upstream", d.upstream.Name, "path", path, "error", err)
+			return cached, nil
+		}
+		return nil, err
+	}
+
+	logger.Debug("Discovery document refreshed", "upstream", d.upstream.Name, "path", path)
+	doc := &discoveryDoc{body: body, fetched: time.Now()}
+	d.docs[path] = doc
+	return doc, nil
+}
+
+// fetchDiscovery GETs a JSON document. Requests to the discovery upstream
+// itself go through its transport with its token; a key set hosted
+// elsewhere (such as the identity provider's) is fetched without one.
+func (s *Server) fetchDiscovery(ctx context.Context, target *url.URL) ([]byte, error) {
+	upstream := s.discovery.upstream
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(upstream.Timeout)*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	client := &http.Client{}
+	if target.Host == s.upstreamURL(upstream).Host {
+		if _, skipped := s.skipReason(upstream.Name); skipped {
+			return nil, errors.New("upstream failed its startup checks")
+		}
+		token, err := s.tokenManager.GetToken(upstream.Audience)
+		if err != nil {
+			return nil, fmt.Errorf("authentication error: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		if upstream.Host != "" {
+			req.Host = upstream.Host
+		}
+		client.Transport = s.transport(upstream)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		s.tokenManager.MarkRejected(upstream.Audience)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %d", target.Redacted(), res.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxDiscoveryBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxDiscoveryBytes {
+		return nil, fmt.Errorf("%s: response too large", target.Redacted())
+	}
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("%s: response is not valid JSON", target.Redacted())
+	}
+	return body, nil
+}
+
+// parseJWKSURI extracts the absolute jwks_uri of a discovery document
+func parseJWKSURI(body []byte) (*url.URL, error) {
+	var doc struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("discovery document: %w", err)
+	}
+	if doc.JWKSURI == "" {
+		return nil, errors.New("discovery document has no jwks_uri")
+	}
+	jwksURI, err := url.Parse(doc.JWKSURI)
+	if err != nil {
+		return nil, fmt.Errorf("discovery document: jwks_uri: %w", err)
+	}
+	if !jwksURI.IsAbs() || jwksURI.Host == "" {
+		return nil, fmt.Errorf("discovery document: jwks_uri %q is not an absolute URL", doc.JWKSURI)
+	}
+	return jwksURI, nil
+}
+
+// rewriteJWKSURI points a discovery document's jwks_uri at the gateway
+func rewriteJWKSURI(body []byte, jwksURI string) ([]byte, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	doc["jwks_uri"], _ = json.Marshal(jwksURI)
+	return json.Marshal(doc)
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+func TestDiscoveryPassthroughCached(t *testing.T) {
+	var discoveryHits, jwksHits atomic.Int32
+	var failing atomic.Bool
+	var upstream *httptest.Server
+	upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			t.Errorf("%s: Authorization = %q, want the gateway's token", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		if failing.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case discoveryPath:
+			discoveryHits.Add(1)
+			json.NewEncoder(w).Encode(map[string]string{
+				"issuer":   "https://issuer.example.com",
+				"jwks_uri": upstream.URL + "/keys",
+			})
+		case "/keys":
+			jwksHits.Add(1)
+			w.Write([]byte(`{"keys":[{"kid":"k1"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	srv := newTestServerWithConfig(t, &config.Config{
+		Upstreams: []config.UpstreamConfig{{Name: "idp", URL: upstream.URL, Audience: "a", Timeout: 5}},
+		Discovery: config.DiscoveryConfig{Upstream: "idp", CacheTTL: 60, PublicURL: "https://gateway.example.com"},
+	})
+
+	for range 2 {
+		// A forged Host never makes it into the publicly cacheable document
+		req := httptest.NewRequest(http.MethodGet, "http://attacker.example.com"+discoveryPath, nil)
+		rec := serve(srv, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("discovery status = %d, want 200", rec.Code)
+		}
+		var doc map[string]string
+		if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+			t.Fatalf("decode discovery: %v", err)
+		}
+		if doc["issuer"] != "https://issuer.example.com" || doc["jwks_uri"] != "https://gateway.example.com"+jwksPath {
+			t.Errorf("discovery = %v, want issuer kept and jwks_uri on the gateway", doc)
+		}
+		if cc := rec.Header().Get("Cache-Control"); cc != "public, max-age=60" && cc != "public, max-age=59" {
+			t.Errorf("Cache-Control = %q, want public with the remaining TTL", cc)
+		}
+	}
+	if got := discoveryHits.Load(); got != 1 {
+		t.Errorf("discovery fetched %d times, want 1", got)
+	}
+
+	for range 2 {
+		rec := serve(srv, httptest.NewRequest(http.MethodGet, jwksPath, nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != `{"keys":[{"kid":"k1"}]}` {
+			t.Fatalf("jwks = %d %q", rec.Code, rec.Body.String())
+		}
+	}
+	if got := jwksHits.Load(); got != 1 {
+		t.Errorf("jwks fetched %d times, want 1", got)
+	}
+
+	// Once expired, a failed refresh serves the stale copy
+	failing.Store(true)
+	srv.discovery.docs[jwksPath].fetched = time.Now().Add(-time.Hour)
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, jwksPath, nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"keys":[{"kid":"k1"}]}` {
+		t.Errorf("stale jwks = %d %q, want the cached copy", rec.Code, rec.Body.String())
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != "public, max-age=0" {
+		t.Errorf("stale Cache-Control = %q, want max-age=0", cc)
+	}
+
+	rec = serve(srv, httptest.NewRequest(http.MethodPost, discoveryPath, nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want 405", rec.Code)
+	}
+}
+
+func TestDiscoveryUnavailable(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"issuer":"https://issuer.example.com"}`))
+	}))
+	defer upstream.Close()
+
+	srv := newTestServerWithConfig(t, &config.Config{
+		Upstreams: []config.UpstreamConfig{{Name: "idp", URL: upstream.URL, Audience: "a", Timeout: 5}},
+		Discovery: config.DiscoveryConfig{Upstream: "idp", CacheTTL: 60, PublicURL: "https://gateway.example.com"},
+	})
+
+	// No jwks_uri to follow
+	if rec := serve(srv, httptest.NewRequest(http.MethodGet, jwksPath, nil)); rec.Code != http.StatusBadGateway {
+		t.Errorf("jwks status = %d, want 502", rec.Code)
+	}
+}
+
+func TestDiscoveryRequiresPublicURL(t *testing.T) {
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer upstream.Close()
+
+	srv := newTestServerWithConfig(t, &config.Config{
+		Upstreams: []config.UpstreamConfig{{Name: "idp", URL: upstream.URL, Audience: "a", Timeout: 5}},
+		Discovery: config.DiscoveryConfig{Upstream: "idp", CacheTTL: 60},
+	})
+	if rec := serve(srv, httptest.NewRequest(http.MethodGet, discoveryPath, nil)); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("discovery status = %d, want 503 without a public_url", rec.Code)
+	}
+	if hits.Load() != 0 {
+		t.Error("discovery fetched without a public_url")
+	}
+}
+
+func TestDiscoveryOffByDefault(t *testing.T) {
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: "a"})
+	serve(srv, httptest.NewRequest(http.MethodGet, discoveryPath, nil))
+	if hits.Load() != 1 || srv.discovery != nil {
+		t.Error("discovery path not proxied like any other when discovery is off")
+	}
+}
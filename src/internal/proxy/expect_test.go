@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+// sendExpectContinue sends a PUT with Expect: 100-continue over a raw
+// connection, waiting for the interim response before sending the body as
+// curl does. It returns the final response and whether 100 was received.
+func sendExpectContinue(t *testing.T, addr, body string) (*http.Response, bool) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprintf(conn, "PUT /upload HTTP/1.1\r\nHost: %s\r\nContent-Length: %d\r\nExpect: 100-continue\r\n\r\n", addr, len(body))
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusContinue {
+		return resp, false
+	}
+
+	io.WriteString(conn, body)
+	resp, err = http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("read final response: %v", err)
+	}
+	return resp, true
+}
+
+func TestExpectContinue(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "expect=%q body=%s", r.Header.Get("Expect"), body)
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name      string
+		fallbacks []string
+		want      string
+	}{
+		// Streamed: the expectation is forwarded and the upstream's 100 relayed
+		{"streamed", nil, `expect="100-continue" body=payload`},
+		// Buffered for fallback: the gateway answers 100 itself
+		{"buffered", []string{"backup"}, `expect="" body=payload`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t,
+				config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: "a", FallbackUpstreams: tt.fallbacks},
+				config.UpstreamConfig{Name: "backup", URL: upstream.URL, Audience: "b"},
+			)
+			gateway := httptest.NewServer(srv.httpServer.Handler)
+			defer gateway.Close()
+
+			resp, continued := sendExpectContinue(t, strings.TrimPrefix(gateway.URL, "http://"), "payload")
+			if !continued {
+				t.Fatalf("status = %d, want an interim 100 Continue", resp.StatusCode)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("final status = %d, want %d (exactly one interim response)", resp.StatusCode, http.StatusOK)
+			}
+			if body, _ := io.ReadAll(resp.Body); string(body) != tt.want {
+				t.Errorf("body = %s, want %s", body, tt.want)
+			}
+		})
+	}
+}
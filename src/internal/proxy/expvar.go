@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"expvar"
+	"sync"
+	"sync/atomic"
+)
+
+// expvarName is the expvar map holding the gateway counters
+const expvarName = "gateway"
+
+var (
+	expvarOnce   sync.Once
+	expvarServer atomic.Pointer[Server]
+)
+
+// publishExpvar exposes the server's key counters as the "gateway" expvar
+// map. expvar names are process-global, so the map is published once and
+// reads from the most recently published server. Values are computed on
+// read from the same collectors that back /metrics.
+func publishExpvar(s *Server) {
+	expvarServer.Store(s)
+	expvarOnce.Do(func() {
+		vars := expvar.NewMap(expvarName)
+		for name, value := range map[string]func(s *Server) int64{
+			"requests":           (*Server).totalRequests,
+			"proxy_errors":       func(s *Server) int64 { return s.metrics.proxyErrors.Load() },
+			"client_disconnects": func(s *Server) int64 { return s.metrics.clientDisconnects.Load() },
+			"default_routed":     func(s *Server) int64 { return s.metrics.defaultRouted.Load() },
+			"slow_requests":      func(s *Server) int64 { return s.metrics.slowRequests.Load() },
+			"token_refreshes":    func(s *Server) int64 { return int64(s.tokenManager.GetStats().TotalRefreshed) },
+			"token_rejections":   func(s *Server) int64 { return int64(s.tokenManager.GetStats().TotalRejected) },
+			"token_errors":       func(s *Server) int64 { return int64(s.tokenManager.GetStats().TotalErrors) },
+			"tokens_cached":      func(s *Server) int64 { return int64(s.tokenManager.GetStats().TotalCached) },
+		} {
+			vars.Set(name, expvar.Func(func() any {
+				return value(expvarServer.Load())
+			}))
+		}
+	})
+}
+
+// totalRequests sums the requests proxied across all upstreams
+func (s *Server) totalRequests() int64 {
+	var total int64
+	for _, t := range s.metrics.traffic {
+		total += t.requests.Load()
+	}
+	return total
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+// maxReplayBodyBytes bounds the request body buffered so it can be resent
+// to a fallback upstream, or to the same upstream after a refused
+// connection. Request bodies are otherwise always streamed to the upstream:
+// only retryable requests to upstreams with fallbacks or retry_on_refused
+// are buffered, and bodies over this cap are streamed without either, so
+// memory per request stays bounded regardless of upload size.
+const maxReplayBodyBytes = 1 << 20 // 1 MiB
+
+// errFallback signals from ModifyResponse that the response should be
+// discarded in favour of the next upstream in the chain
+var errFallback = errors.New("upstream failed, falling back")
+
+// upstreamChain returns the upstream followed by those of its configured
+// fallbacks that accept the method
+func (s *Server) upstreamChain(upstream *config.UpstreamConfig, method string) []*config.UpstreamConfig {
+	chain := []*config.UpstreamConfig{upstream}
+	for _, name := range upstream.FallbackUpstreams {
+		if fallback, exists := s.upstreamMap[name]; exists && upstreamAllowsMethod(fallback, method) {
+			chain = append(chain, fallback)
+		}
+	}
+	return chain
+}
+
+// idempotencyKeyHeader marks a request the client has made safe to replay
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// isRetryable reports whether the request may be sent more than once to the
+// upstream: its method must be one of the upstream's retryable methods, or,
+// where the upstream opts in, it must be a POST carrying an Idempotency-Key
+func isRetryable(upstream *config.UpstreamConfig, r *http.Request) bool {
+	methods := upstream.RetryableMethods
+	if methods == nil {
+		methods = config.DefaultRetryableMethods
+	}
+	for _, method := range methods {
+		if strings.EqualFold(method, r.Method) {
+			return true
+		}
+	}
+	return upstream.RetryIdempotencyKeys && r.Method == http.MethodPost && r.Header.Get(idempotencyKeyHeader) != ""
+}
+
+// prepareReplay reports whether the request can be sent to more than one
+// upstream: it must be retryable for upstream and any body must fit the replay
+// buffer. The body is buffered and returned so it can be rewound with
+// resetBody; if it is too large, the original stream is restored intact. An
+// error means the body could only be partly read, so the request must not
+// be proxied at all.
+func prepareReplay(r *http.Request, upstream *config.UpstreamConfig) ([]byte, bool, error) {
+	if !isRetryable(upstream, r) {
+		return nil, false, nil
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true, nil
+	}
+	if r.ContentLength > maxReplayBodyBytes {
+		return nil, false, nil
+	}
+
+	// Reading the body makes the server answer Expect: 100-continue itself,
+	// so the expectation must not also be forwarded: the upstream's interim
+	// 100 would reach the client as a second one
+	r.Header.Del("Expect")
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxReplayBodyBytes+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(body) > maxReplayBodyBytes {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return nil, false, nil
+	}
+
+	r.Body.Close()
+	resetBody(r, body)
+	return body, true, nil
+}
+
+// resetBody rewinds a buffered request body for another attempt. GetBody
+// is set too, so a refused connection can be retried with the body intact
+// (see refusedRetryTransport).
+func resetBody(r *http.Request, body []byte) {
+	if body != nil {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+// newStatusUpstream returns an upstream that answers every request with the
+// given status and body, counting the requests it receives
+func newStatusUpstream(t *testing.T, status int, body string) (*httptest.Server, *int32) {
+	t.Helper()
+	var hits int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream, &hits
+}
+
+func TestFallbackOnServerError(t *testing.T) {
+	primary, primaryHits := newStatusUpstream(t, http.StatusInternalServerError, "primary")
+	backup, _ := newStatusUpstream(t, http.StatusOK, "backup")
+
+	srv := newTestServer(t,
+		config.UpstreamConfig{Name: "primary", URL: primary.URL, Audience: "a", FallbackUpstreams: []string{"backup"}},
+		config.UpstreamConfig{Name: "backup", URL: backup.URL, Audience: "b"},
+	)
+
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "backup" {
+		t.Errorf("got %d %q, want 200 from backup", rec.Code, rec.Body.String())
+	}
+	if got := atomic.LoadInt32(primaryHits); got != 1 {
+		t.Errorf("primary hits = %d, want 1", got)
+	}
+}
+
+func TestFallbackOnConnectionError(t *testing.T) {
+	backup, _ := newStatusUpstream(t, http.StatusOK, "backup")
+
+	srv := newTestServer(t,
+		config.UpstreamConfig{Name: "primary", URL: "http://127.0.0.1:1", Audience: "a", FallbackUpstreams: []string{"backup"}},
+		config.UpstreamConfig{Name: "backup", URL: backup.URL, Audience: "b"},
+	)
+
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "backup" {
+		t.Errorf("got %d %q, want 200 from backup", rec.Code, rec.Body.String())
+	}
+}
+
+func TestFallbackLastUpstreamErrorReturned(t *testing.T) {
+	primary, _ := newStatusUpstream(t, http.StatusInternalServerError, "primary")
+	backup, _ := newStatusUpstream(t, http.StatusServiceUnavailable, "backup")
+
+	srv := newTestServer(t,
+		config.UpstreamConfig{Name: "primary", URL: primary.URL, Audience: "a", FallbackUpstreams: []string{"backup"}},
+		config.UpstreamConfig{Name: "backup", URL: backup.URL, Audience: "b"},
+	)
+
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "backup" {
+		t.Errorf("got %d %q, want the last upstream's 503", rec.Code, rec.Body.String())
+	}
+}
+
+func TestFallbackStopsWhenRetryBudgetExhausted(t *testing.T) {
+	primary, _ := newStatusUpstream(t, http.StatusInternalServerError, "primary")
+	backup, backupHits := newStatusUpstream(t, http.StatusServiceUnavailable, "backup")
+
+	srv := newTestServer(t,
+		config.UpstreamConfig{Name: "primary", URL: primary.URL, Audience: "a", FallbackUpstreams: []string{"backup"}},
+		config.UpstreamConfig{Name: "backup", URL: backup.URL, Audience: "b",
+			RetryBudget: config.RetryBudgetConfig{MaxTokens: 2, TokenRatio: 0.1}},
+	)
+
+	serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "retry budget exhausted") {
+		t.Errorf("got %d %q, want 503 for the exhausted retry budget", rec.Code, rec.Body.String())
+	}
+	if got := atomic.LoadInt32(backupHits); got != 1 {
+		t.Errorf("backup hits = %d, want 1", got)
+	}
+}
+
+func TestFallbackReplaysBody(t *testing.T) {
+	primary, _ := newStatusUpstream(t, http.StatusBadGateway, "")
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}))
+	defer backup.Close()
+
+	srv := newTestServer(t,
+		config.UpstreamConfig{Name: "primary", URL: primary.URL, Audience: "a", FallbackUpstreams: []string{"backup"}},
+		config.UpstreamConfig{Name: "backup", URL: backup.URL, Audience: "b"},
+	)
+
+	rec := serve(srv, httptest.NewRequest(http.MethodPut, "/", strings.NewReader("payload")))
+	if rec.Code != http.StatusOK || rec.Body.String() != "payload" {
+		t.Errorf("got %d %q, want the body replayed to backup", rec.Code, rec.Body.String())
+	}
+}
+
+func TestFallbackSkipsNonIdempotent(t *testing.T) {
+	primary, _ := newStatusUpstream(t, http.StatusInternalServerError, "primary")
+	backup, backupHits := newStatusUpstream(t, http.StatusOK, "backup")
+
+	srv := newTestServer(t,
+		config.UpstreamConfig{Name: "primary", URL: primary.URL, Audience: "a", FallbackUpstreams: []string{"backup"}},
+		config.UpstreamConfig{Name: "backup", URL: backup.URL, Audience: "b"},
+	)
+
+	rec := serve(srv, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("payload")))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if got := atomic.LoadInt32(backupHits); got != 0 {
+		t.Errorf("backup hits = %d, want 0 for POST", got)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name    string
+		methods []string
+		keys    bool
+		method  string
+		key     string
+		want    bool
+	}{
+		{"default GET", nil, false, http.MethodGet, "", true},
+		{"default DELETE", nil, false, http.MethodDelete, "", true},
+		{"default POST", nil, false, http.MethodPost, "", false},
+		{"default PATCH", nil, false, http.MethodPatch, "", false},
+		{"POST with idempotency key", nil, true, http.MethodPost, "k-123", true},
+		{"idempotency key not opted in", nil, false, http.MethodPost, "k-123", false},
+		{"never retry ignores idempotency key", []string{}, false, http.MethodPost, "k-123", false},
+		{"PATCH ignores idempotency key", nil, true, http.MethodPatch, "k-123", false},
+		{"custom list", []string{"get", "POST"}, false, http.MethodPost, "", true},
+		{"custom list excludes PUT", []string{"GET"}, false, http.MethodPut, "", false},
+		{"never retry", []string{}, false, http.MethodGet, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			if tt.key != "" {
+				req.Header.Set(idempotencyKeyHeader, tt.key)
+			}
+			upstream := &config.UpstreamConfig{RetryableMethods: tt.methods, RetryIdempotencyKeys: tt.keys}
+			if got := isRetryable(upstream, req); got != tt.want {
+				t.Errorf("isRetryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFallbackHonorsRetryableMethods(t *testing.T) {
+	tests := []struct {
+		name      string
+		methods   []string
+		method    string
+		key       string
+		wantFalls bool
+	}{
+		{"POST listed as retryable", []string{"GET", "POST"}, http.MethodPost, "", true},
+		{"GET not listed", []string{"POST"}, http.MethodGet, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary, _ := newStatusUpstream(t, http.StatusInternalServerError, "primary")
+			backup, backupHits := newStatusUpstream(t, http.StatusOK, "backup")
+			srv := newTestServer(t,
+				config.UpstreamConfig{Name: "primary", URL: primary.URL, Audience: "a",
+					FallbackUpstreams: []string{"backup"}, RetryableMethods: tt.methods},
+				config.UpstreamConfig{Name: "backup", URL: backup.URL, Audience: "b"},
+			)
+
+			req := httptest.NewRequest(tt.method, "/", strings.NewReader("payload"))
+			if tt.key != "" {
+				req.Header.Set(idempotencyKeyHeader, tt.key)
+			}
+			serve(srv, req)
+			if fell := atomic.LoadInt32(backupHits) == 1; fell != tt.wantFalls {
+				t.Errorf("fell back = %v, want %v", fell, tt.wantFalls)
+			}
+		})
+	}
+}
+
+func TestFallbackWhenCircuitOpen(t *testing.T) {
+	primary, primaryHits := newStatusUpstream(t, http.StatusOK, "primary")
+	backup, _ := newStatusUpstream(t, http.StatusOK, "backup")
+
+	srv := newTestServerWithConfig(t, &config.Config{
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: true, FailureThreshold: 1, Window: 60, Cooldown: 30},
+		Upstreams: []config.UpstreamConfig{
+			{Name: "primary", URL: primary.URL, Audience: "a", FallbackUpstreams: []string{"backup"}},
+			{Name: "backup", URL: backup.URL, Audience: "b"},
+		},
+	})
+	srv.breakers["primary"].failure()
+
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "backup" {
+		t.Errorf("got %d %q, want 200 from backup", rec.Code, rec.Body.String())
+	}
+	if got := atomic.LoadInt32(primaryHits); got != 0 {
+		t.Errorf("primary hits = %d, want 0 while circuit is open", got)
+	}
+}
+
+func TestFallbackSkipsUpstreamDisallowingMethod(t *testing.T) {
+	primary, _ := newStatusUpstream(t, http.StatusInternalServerError, "primary")
+	backup, backupHits := newStatusUpstream(t, http.StatusOK, "backup")
+
+	srv := newTestServer(t,
+		config.UpstreamConfig{Name: "primary", URL: primary.URL, Audience: "a", FallbackUpstreams: []string{"backup"}},
+		config.UpstreamConfig{Name: "backup", URL: backup.URL, Audience: "b", AllowedMethods: []string{"GET"}},
+	)
+
+	if rec := serve(srv, httptest.NewRequest(http.MethodDelete, "/", nil)); rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if got := atomic.LoadInt32(backupHits); got != 0 {
+		t.Errorf("backup hits = %d, want 0", got)
+	}
+}
+
+// maintenance is a degraded response used by the degraded-path tests
+var maintenance = config.DegradedResponseConfig{
+	Enabled:     true,
+	Status:      http.StatusServiceUnavailable,
+	ContentType: "application/json",
+	Body:        `{"status":"maintenance"}`,
+}
+
+func TestDegradedResponseServed(t *testing.T) {
+	failing, _ := newStatusUpstream(t, http.StatusBadGateway, "bad gateway")
+	healthy, _ := newStatusUpstream(t, http.StatusOK, "backup")
+
+	tests := []struct {
+		name      string
+		primary   string
+		fallbacks []string
+		wantBody  string
+	}{
+		{"connection error", "http://127.0.0.1:1", nil, maintenance.Body},
+		{"server error", failing.URL, nil, maintenance.Body},
+		{"fallback also fails", failing.URL, []string{"broken"}, maintenance.Body},
+		{"fallback succeeds", failing.URL, []string{"backup"}, "backup"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t,
+				config.UpstreamConfig{Name: "primary", URL: tt.primary, Audience: "a",
+					FallbackUpstreams: tt.fallbacks, DegradedResponse: maintenance},
+				config.UpstreamConfig{Name: "broken", URL: "http://127.0.0.1:1", Audience: "b"},
+				config.UpstreamConfig{Name: "backup", URL: healthy.URL, Audience: "c"},
+			)
+
+			rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Body.String() != tt.wantBody {
+				t.Fatalf("body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+			if tt.wantBody == maintenance.Body {
+				if rec.Code != http.StatusServiceUnavailable {
+					t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+				}
+				if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+					t.Errorf("Content-Type = %q, want application/json", ct)
+				}
+			}
+		})
+	}
+}
+
+func TestDegradedResponseSniffsMissingContentType(t *testing.T) {
+	page := config.DegradedResponseConfig{Enabled: true, Status: http.StatusServiceUnavailable,
+		Body: "<html><body>Down for maintenance</body></html>"}
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: "http://127.0.0.1:1", Audience: "a", DegradedResponse: page})
+
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want the sniffed text/html", ct)
+	}
+}
+
+func TestDegradedResponseWhenCircuitOpen(t *testing.T) {
+	upstream, hits := newStatusUpstream(t, http.StatusOK, "ok")
+	srv := newTestServerWithConfig(t, &config.Config{
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: true, FailureThreshold: 1, Window: 60, Cooldown: 30},
+		Upstreams: []config.UpstreamConfig{
+			{Name: "api", URL: upstream.URL, Audience: "a", DegradedResponse: maintenance},
+		},
+	})
+	srv.breakers["api"].failure()
+
+	rec := serve(srv, httptest.NewRequest(http.MethodHead, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Body.Len() != 0 {
+		t.Errorf("got %d with %d body bytes, want 503 without body for HEAD", rec.Code, rec.Body.Len())
+	}
+	if got := atomic.LoadInt32(hits); got != 0 {
+		t.Errorf("upstream hits = %d, want 0", got)
+	}
+}
+
+func TestPrepareReplayLargeBody(t *testing.T) {
+	body := strings.Repeat("x", maxReplayBodyBytes+10)
+	req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body))
+	req.ContentLength = -1
+
+	if _, ok, _ := prepareReplay(req, &config.UpstreamConfig{}); ok {
+		t.Fatal("prepareReplay() should reject bodies over the limit")
+	}
+	got, _ := io.ReadAll(req.Body)
+	if string(got) != body {
+		t.Errorf("body not restored after oversized read: got %d bytes, want %d", len(got), len(body))
+	}
+}
+
+func TestFallbackRefusesPartlyReadBody(t *testing.T) {
+	primary, hits := newStatusUpstream(t, http.StatusOK, "primary")
+	backup, _ := newStatusUpstream(t, http.StatusOK, "backup")
+	srv := newTestServer(t,
+		config.UpstreamConfig{Name: "primary", URL: primary.URL, Audience: "a", FallbackUpstreams: []string{"backup"}},
+		config.UpstreamConfig{Name: "backup", URL: backup.URL, Audience: "b"},
+	)
+
+	tests := []struct {
+		name   string
+		cancel bool
+		want   int
+	}{
+		{"read error", false, http.StatusBadRequest},
+		{"client abort", true, statusClientClosedRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errors.New("connection reset")))
+			req := httptest.NewRequest(http.MethodPut, "/", body)
+			if tt.cancel {
+				ctx, cancel := context.WithCancel(req.Context())
+				cancel()
+				req = req.WithContext(ctx)
+			}
+
+			if rec := serve(srv, req); rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if got := atomic.LoadInt32(hits); got != 0 {
+				t.Errorf("upstream hits = %d, want 0", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+// newHeadAwareUpstream counts requests per method and always declares a
+// Content-Length, writing the body only for GET
+func newHeadAwareUpstream(t *testing.T, gets, heads *int32) *httptest.Server {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Length", "5")
+		switch r.Method {
+		case http.MethodHead:
+			atomic.AddInt32(heads, 1)
+		case http.MethodGet:
+			atomic.AddInt32(gets, 1)
+			w.Write([]byte("hello"))
+		}
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+func TestHeadProxiedWithoutBody(t *testing.T) {
+	var gets, heads int32
+	upstream := newHeadAwareUpstream(t, &gets, &heads)
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: upstream.URL})
+
+	rec := serve(srv, httptest.NewRequest(http.MethodHead, "/data", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d (token should be injected)", rec.Code, http.StatusOK)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("HEAD response body length = %d, want 0", rec.Body.Len())
+	}
+	if got := rec.Header().Get("Content-Length"); got != "5" {
+		t.Errorf("Content-Length = %q, want %q", got, "5")
+	}
+	if heads != 1 || gets != 0 {
+		t.Errorf("upstream saw %d HEAD / %d GET, want 1 / 0", heads, gets)
+	}
+}
+
+func TestHeadPathFiltering(t *testing.T) {
+	var gets, heads int32
+	upstream := newHeadAwareUpstream(t, &gets, &heads)
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: upstream.URL})
+	srv.config.Server.AllowedPaths = []string{"/data"}
+
+	rec := serve(srv, httptest.NewRequest(http.MethodHead, "/secret", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if heads != 0 {
+		t.Errorf("upstream saw %d HEAD requests, want 0", heads)
+	}
+}
+
+func TestHeadServedFromCachedGet(t *testing.T) {
+	var gets, heads int32
+	upstream := newHeadAwareUpstream(t, &gets, &heads)
+	srv := newTestServer(t, config.UpstreamConfig{
+		Name:     "api",
+		URL:      upstream.URL,
+		Audience: upstream.URL,
+		Cache:    config.CacheConfig{Enabled: true, MaxBytes: 1024, MaxTTL: 60},
+	})
+
+	serve(srv, httptest.NewRequest(http.MethodGet, "/data", nil))
+	rec := serve(srv, httptest.NewRequest(http.MethodHead, "/data", nil))
+
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("HEAD status = %d body = %q, want 200 with empty body", rec.Code, rec.Body.String())
+	}
+	if heads != 0 || gets != 1 {
+		t.Errorf("upstream saw %d HEAD / %d GET, want 0 / 1", heads, gets)
+	}
+}
+
+func TestHeadDoesNotPopulateCache(t *testing.T) {
+	var gets, heads int32
+	upstream := newHeadAwareUpstream(t, &gets, &heads)
+	srv := newTestServer(t, config.UpstreamConfig{
+		Name:     "api",
+		URL:      upstream.URL,
+		Audience: upstream.URL,
+		Cache:    config.CacheConfig{Enabled: true, MaxBytes: 1024, MaxTTL: 60},
+	})
+
+	serve(srv, httptest.NewRequest(http.MethodHead, "/data", nil))
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/data", nil))
+
+	if rec.Body.String() != "hello" {
+		t.Errorf("GET body = %q, want %q (must not be served from a HEAD response)", rec.Body.String(), "hello")
+	}
+	if heads != 1 || gets != 1 {
+		t.Errorf("upstream saw %d HEAD / %d GET, want 1 / 1", heads, gets)
+	}
+}
+
+func TestHeadCoalesced(t *testing.T) {
+	upstream, hits, release := newBlockingUpstream(t, "body")
+	srv := newTestServer(t, config.UpstreamConfig{
+		Name:             "api",
+		URL:              upstream.URL,
+		Audience:         upstream.URL,
+		Coalesce:         true,
+		CoalesceMaxBytes: 1024,
+	})
+
+	recs := runConcurrent(t, srv, 5, http.MethodHead, hits, release)
+
+	if got := atomic.LoadInt32(hits); got != 1 {
+		t.Errorf("upstream hits = %d, want 1", got)
+	}
+	for i, rec := range recs {
+		if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+			t.Errorf("request %d: status = %d body length = %d, want 200 and empty", i, rec.Code, rec.Body.Len())
+		}
+	}
+}
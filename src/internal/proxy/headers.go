@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"go-oauth2-proxy/src/internal/config"
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+// securityHeaders are added to proxied responses when security_headers is
+// enabled and the upstream has not set them itself
+var securityHeaders = map[string]string{
+	"X-Content-Type-Options":    "nosniff",
+	"X-Frame-Options":           "DENY",
+	"Referrer-Policy":           "no-referrer",
+	"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+}
+
+// applyResponseHeaders strips fingerprinting headers from an upstream
+// response and applies the configured overrides and security headers
+func (s *Server) applyResponseHeaders(h http.Header) {
+	cfg := s.config.Server.ResponseHeaders
+
+	for _, name := range cfg.Strip {
+		h.Del(name)
+	}
+	for name, value := range cfg.Set {
+		h.Set(name, value)
+	}
+	if cfg.SecurityHeaders {
+		for name, value := range securityHeaders {
+			if h.Get(name) == "" {
+				h.Set(name, value)
+			}
+		}
+	}
+}
+
+// applySetCookie strips or rewrites an upstream response's Set-Cookie
+// headers per the upstream's set_cookie mode. Each header carries a single
+// cookie, so every value is rewritten on its own.
+func applySetCookie(h http.Header, upstream *config.UpstreamConfig) {
+	switch upstream.SetCookie.Mode {
+	case config.SetCookieStrip:
+		h.Del("Set-Cookie")
+	case config.SetCookieRewriteDomain:
+		cookies := h.Values("Set-Cookie")
+		if len(cookies) == 0 {
+			return
+		}
+		rewritten := make([]string, len(cookies))
+		for i, cookie := range cookies {
+			rewritten[i] = rewriteCookie(cookie, upstream)
+		}
+		h["Set-Cookie"] = rewritten
+	}
+}
+
+// rewriteCookie replaces a Set-Cookie value's Domain and Path attributes for
+// the gateway's external host, keeping the value and other attributes as
+// sent. Without a configured path, a cookie path is moved under the path
+// prefix the gateway strips from this upstream's requests.
+func rewriteCookie(cookie string, upstream *config.UpstreamConfig) string {
+	settings := upstream.SetCookie
+	parts := strings.Split(cookie, ";")
+	kept := []string{parts[0]}
+	path := ""
+	for _, attr := range parts[1:] {
+		name, value, _ := strings.Cut(strings.TrimSpace(attr), "=")
+		switch strings.ToLower(name) {
+		case "domain":
+			continue
+		case "path":
+			path = value
+			continue
+		}
+		kept = append(kept, attr)
+	}
+
+	switch {
+	case settings.Path != "":
+		path = settings.Path
+	case path != "" && upstream.PathPrefix != "" && !upstream.PreservePathPrefix:
+		if path == "/" {
+			path = upstream.PathPrefix
+		} else {
+			path = singleJoiningSlash(upstream.PathPrefix, path)
+		}
+	}
+
+	if settings.Domain != "" {
+		kept = append(kept, " Domain="+settings.Domain)
+	}
+	if path != "" {
+		kept = append(kept, " Path="+path)
+	}
+	return strings.Join(kept, ";")
+}
+
+// checkResponseHeaderLimits reports an error when an upstream response has
+// more header lines, or more header bytes (names plus values), than the
+// upstream allows; a zero limit is not enforced
+func checkResponseHeaderLimits(h http.Header, upstream *config.UpstreamConfig) error {
+	count, size := 0, 0
+	for name, values := range h {
+		for _, value := range values {
+			count++
+			size += len(name) + len(value)
+		}
+	}
+	if limit := upstream.MaxResponseHeaders; limit > 0 && count > limit {
+		return fmt.Errorf("upstream response has %d headers, over the limit of %d", count, limit)
+	}
+	if limit := upstream.MaxResponseHeaderBytes; limit > 0 && size > limit {
+		return fmt.Errorf("upstream response headers total %d bytes, over the limit of %d", size, limit)
+	}
+	return nil
+}
+
+// setExactCaseHeaders sets headers under their literal names, bypassing
+// canonicalization, and removes any canonical-form duplicate so the upstream
+// sees a single value
+func setExactCaseHeaders(h http.Header, headers map[string]string) {
+	for name, value := range headers {
+		h.Del(name)
+		h[name] = []string{value}
+	}
+}
+
+// sensitiveHeaders are redacted when headers are logged
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// logHeaders logs each header at debug level when log_headers is enabled
+func (s *Server) logHeaders(msg string, h http.Header) {
+	if !s.config.Logging.LogHeaders {
+		return
+	}
+
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, value := range h[name] {
+			if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+				value = "[REDACTED]"
+			}
+			logger.Debug(msg, "header", name, "value", value)
+		}
+	}
+}
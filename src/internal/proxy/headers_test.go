@@ -0,0 +1,237 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-oauth2-proxy/src/internal/config"
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+// newFingerprintingUpstream returns an upstream that advertises its stack
+func newFingerprintingUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "gunicorn/20.1.0")
+		w.Header().Set("X-Powered-By", "Express")
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+func TestResponseHeadersStripped(t *testing.T) {
+	upstream := newFingerprintingUpstream(t)
+	cfg := &config.Config{Upstreams: []config.UpstreamConfig{{Name: "api", URL: upstream.URL, Audience: "a"}}}
+	cfg.Server.ResponseHeaders.Strip = []string{"Server", "x-powered-by"}
+	srv := newTestServerWithConfig(t, cfg)
+
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+	for _, name := range []string{"Server", "X-Powered-By"} {
+		if got := rec.Header().Get(name); got != "" {
+			t.Errorf("%s = %q, want stripped", name, got)
+		}
+	}
+	if got := rec.Header().Get("X-Frame-Options"); got != "SAMEORIGIN" {
+		t.Errorf("X-Frame-Options = %q, want upstream value kept", got)
+	}
+}
+
+func TestResponseHeadersSetAndSecurity(t *testing.T) {
+	upstream := newFingerprintingUpstream(t)
+	cfg := &config.Config{Upstreams: []config.UpstreamConfig{{Name: "api", URL: upstream.URL, Audience: "a"}}}
+	cfg.Server.ResponseHeaders = config.ResponseHeadersConfig{
+		Set:             map[string]string{"Server": "gateway"},
+		SecurityHeaders: true,
+	}
+	srv := newTestServerWithConfig(t, cfg)
+
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Header().Get("Server"); got != "gateway" {
+		t.Errorf("Server = %q, want rewritten to %q", got, "gateway")
+	}
+	if got := rec.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("X-Content-Type-Options = %q, want nosniff", got)
+	}
+	// Security headers never override the upstream's own choice
+	if got := rec.Header().Get("X-Frame-Options"); got != "SAMEORIGIN" {
+		t.Errorf("X-Frame-Options = %q, want upstream value kept", got)
+	}
+}
+
+func TestHeaderLogging(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	for _, enabled := range []bool{false, true} {
+		srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: "a"})
+		srv.config.Logging.LogHeaders = enabled
+		logger.SetLevel("debug")
+		buf := captureLogs(t)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Custom", "visible")
+		req.Header.Set("Cookie", "session=secret")
+		serve(srv, req)
+		logger.SetLevel("error")
+
+		logs := buf.String()
+		if !strings.Contains(logs, "Upstream request") {
+			t.Fatalf("log_headers=%v: debug logging missing", enabled)
+		}
+		if got := strings.Contains(logs, "header=X-Custom value=visible"); got != enabled {
+			t.Errorf("log_headers=%v: header logged = %v", enabled, got)
+		}
+		if strings.Contains(logs, "secret") || strings.Contains(logs, "test-token") {
+			t.Errorf("log_headers=%v: credentials leaked into logs", enabled)
+		}
+		if enabled && !strings.Contains(logs, "header=Authorization value=[REDACTED]") {
+			t.Error("injected Authorization header not redacted")
+		}
+	}
+}
+
+func TestExactCaseHeadersOnTheWire(t *testing.T) {
+	// A raw listener captures the request head as sent, before any server
+	// canonicalizes the header names
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	head := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil || line == "\r\n" {
+				break
+			}
+			lines = append(lines, strings.TrimRight(line, "\r\n"))
+		}
+		head <- strings.Join(lines, "\n")
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nok")
+	}()
+
+	srv := newTestServer(t, config.UpstreamConfig{
+		Name:             "api",
+		URL:              "http://" + ln.Addr().String(),
+		Audience:         "a",
+		ExactCaseHeaders: map[string]string{"x-api-key": "secret", "X-TENANT": "acme"},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Api-Key", "client-supplied")
+	if rec := serve(srv, req); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	got := <-head
+	for _, want := range []string{"\nx-api-key: secret", "\nX-TENANT: acme"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("request head missing %q:\n%s", strings.TrimPrefix(want, "\n"), got)
+		}
+	}
+	if strings.Contains(got, "X-Api-Key") {
+		t.Errorf("canonical duplicate forwarded:\n%s", got)
+	}
+}
+
+func TestResponseHeaderLimits(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 50; i++ {
+			w.Header().Add("X-Junk", strings.Repeat("x", 10))
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name         string
+		count, bytes int
+		wantStatus   int
+	}{
+		{"within limits", 256, 64 << 10, http.StatusOK},
+		{"too many headers", 20, 64 << 10, http.StatusBadGateway},
+		{"headers too large", 256, 200, http.StatusBadGateway},
+		{"unlimited", 0, 0, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServerWithConfig(t, &config.Config{
+				Upstreams: []config.UpstreamConfig{{
+					Name: "api", URL: upstream.URL, Audience: "a",
+					MaxResponseHeaders: tt.count, MaxResponseHeaderBytes: tt.bytes,
+				}},
+			})
+
+			rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusBadGateway && len(rec.Header().Values("X-Junk")) > 0 {
+				t.Error("rejected response headers should not reach the client")
+			}
+		})
+	}
+}
+
+func TestSetCookieModes(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Set-Cookie", "session=abc; Domain=backend.internal; Path=/; HttpOnly; Secure")
+		w.Header().Add("Set-Cookie", "prefs=dark; path=/settings; SameSite=Lax")
+		w.Header().Add("Set-Cookie", "tracker=1")
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name      string
+		setCookie config.SetCookieConfig
+		want      []string
+	}{
+		{"passthrough", config.SetCookieConfig{}, []string{
+			"session=abc; Domain=backend.internal; Path=/; HttpOnly; Secure",
+			"prefs=dark; path=/settings; SameSite=Lax",
+			"tracker=1",
+		}},
+		{"strip", config.SetCookieConfig{Mode: config.SetCookieStrip}, nil},
+		{"rewrite under path prefix", config.SetCookieConfig{Mode: config.SetCookieRewriteDomain}, []string{
+			"session=abc; HttpOnly; Secure; Path=/app",
+			"prefs=dark; SameSite=Lax; Path=/app/settings",
+			"tracker=1",
+		}},
+		{"rewrite to configured domain and path", config.SetCookieConfig{
+			Mode: config.SetCookieRewriteDomain, Domain: "gateway.example.com", Path: "/",
+		}, []string{
+			"session=abc; HttpOnly; Secure; Domain=gateway.example.com; Path=/",
+			"prefs=dark; SameSite=Lax; Domain=gateway.example.com; Path=/",
+			"tracker=1; Domain=gateway.example.com; Path=/",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, config.UpstreamConfig{
+				Name: "app", URL: upstream.URL, Audience: "a", PathPrefix: "/app", SetCookie: tt.setCookie,
+			})
+			rec := serve(srv, httptest.NewRequest(http.MethodGet, "/app/login", nil))
+			got := rec.Result().Header.Values("Set-Cookie")
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("Set-Cookie =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
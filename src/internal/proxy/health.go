@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+	"go-oauth2-proxy/src/internal/token"
+)
+
+// healthReport is the JSON body of /healthz and /readyz
+type healthReport struct {
+	Status        string           `json:"status"`
+	Ready         bool             `json:"ready"`
+	Version       string           `json:"version"`
+	UptimeSeconds int64            `json:"uptime_seconds"`
+	Upstreams     []upstreamHealth `json:"upstreams"`
+}
+
+// tokenErrorReason prefixes the reason an upstream whose last token fetch
+// failed is unhealthy
+const tokenErrorReason = "token error: "
+
+// upstreamHealth summarizes the token held for an upstream
+type upstreamHealth struct {
+	Name       string           `json:"name"`
+	TokenState token.TokenState `json:"token_state,omitempty"`
+	ExpiresIn  string           `json:"expires_in,omitempty"`
+	LastError  string           `json:"last_error,omitempty"`
+	Critical   bool             `json:"critical,omitempty"`
+	Unhealthy  string           `json:"unhealthy,omitempty"` // why, when unhealthy
+}
+
+// upstreamUnhealthy reports why the upstream is unhealthy, or "" if it is
+// healthy, from state the gateway already tracks: skipped at startup, an
+// open circuit, or a failed last token fetch
+func (s *Server) upstreamUnhealthy(upstream *config.UpstreamConfig) string {
+	if reason, skipped := s.skipReason(upstream.Name); skipped {
+		return "skipped at startup: " + reason
+	}
+	if breaker := s.breakers[upstream.Name]; breaker != nil && breaker.retryAfter() > 0 {
+		return "circuit open"
+	}
+	if !upstream.PassThrough {
+		if meta := s.tokenManager.GetMetadata(upstream.Audience); meta != nil && meta.State == token.StateError {
+			return tokenErrorReason + meta.LastError
+		}
+	}
+	return ""
+}
+
+// unhealthyCritical returns the critical upstreams that are unhealthy. A
+// failed token fetch is retried in the background: with the gateway out of
+// rotation, no request would come along to retry it.
+func (s *Server) unhealthyCritical() []string {
+	var names []string
+	for i := range s.config.Upstreams {
+		upstream := &s.config.Upstreams[i]
+		if !upstream.Critical {
+			continue
+		}
+		if reason := s.upstreamUnhealthy(upstream); reason != "" {
+			names = append(names, upstream.Name)
+			if strings.HasPrefix(reason, tokenErrorReason) {
+				s.tokenManager.RefreshInBackground(upstream.Audience)
+			}
+		}
+	}
+	return names
+}
+
+// wantsJSON reports whether the client asked for JSON; plain text remains
+// the default for probes and clients that send no Accept header
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// writeHealthJSON writes the structured health report
+func (s *Server) writeHealthJSON(w http.ResponseWriter, status string, code int) {
+	report := healthReport{
+		Status:        status,
+		Ready:         !s.draining.Load() && len(s.unhealthyCritical()) == 0,
+		Version:       Version,
+		UptimeSeconds: int64(time.Since(s.started).Seconds()),
+		Upstreams:     make([]upstreamHealth, 0, len(s.config.Upstreams)),
+	}
+
+	for i := range s.config.Upstreams {
+		upstream := &s.config.Upstreams[i]
+		health := upstreamHealth{
+			Name:       upstream.Name,
+			TokenState: token.StateNew,
+			Critical:   upstream.Critical,
+			Unhealthy:  s.upstreamUnhealthy(upstream),
+		}
+		if upstream.PassThrough {
+			// Tokens are per target host; there is no single entry to report
+			health.TokenState = ""
+		} else if meta := s.tokenManager.GetMetadata(upstream.Audience); meta != nil {
+			health.TokenState = meta.State
+			if !meta.ExpiresAt.IsZero() {
+				health.ExpiresIn = time.Until(meta.ExpiresAt).Round(time.Second).String()
+			}
+			health.LastError = meta.LastError
+		}
+		report.Upstreams = append(report.Upstreams, health)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(report)
+}
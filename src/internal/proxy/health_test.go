@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"go-oauth2-proxy/src/internal/config"
+	"go-oauth2-proxy/src/internal/token"
+)
+
+func TestHealthPlainText(t *testing.T) {
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: "http://127.0.0.1:1", Audience: "a"})
+
+	for path, want := range map[string]string{"/healthz": "OK", "/readyz": "READY"} {
+		for _, accept := range []string{"", "text/plain", "*/*"} {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.Header.Set("Accept", accept)
+			rec := serve(srv, req)
+			if rec.Body.String() != want || rec.Header().Get("Content-Type") != "text/plain" {
+				t.Errorf("%s (Accept %q) = %q %q, want plain %q", path, accept,
+					rec.Header().Get("Content-Type"), rec.Body.String(), want)
+			}
+		}
+	}
+}
+
+func TestHealthJSON(t *testing.T) {
+	upstream, _ := newStatusUpstream(t, http.StatusOK, "ok")
+	srv := newTestServer(t,
+		config.UpstreamConfig{Name: "used", URL: upstream.URL, Audience: "a"},
+		config.UpstreamConfig{Name: "unused", URL: upstream.URL, Audience: "b"},
+	)
+	serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	for path, status := range map[string]string{"/healthz": "ok", "/readyz": "ready"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept", "application/json")
+		rec := serve(srv, req)
+
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("%s Content-Type = %q, want application/json", path, ct)
+		}
+		var report healthReport
+		if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+			t.Fatalf("%s: decode: %v", path, err)
+		}
+		if report.Status != status || !report.Ready || report.Version != Version {
+			t.Errorf("%s report = %+v", path, report)
+		}
+		if len(report.Upstreams) != 2 {
+			t.Fatalf("%s upstreams = %+v, want 2", path, report.Upstreams)
+		}
+		if got := report.Upstreams[0]; got.Name != "used" || got.TokenState != token.StateCached || got.ExpiresIn == "" {
+			t.Errorf("%s used upstream = %+v, want a cached token", path, got)
+		}
+		if got := report.Upstreams[1]; got.TokenState != token.StateNew {
+			t.Errorf("%s unused upstream = %+v, want state NEW", path, got)
+		}
+	}
+}
+
+func TestReadinessCriticalUpstreams(t *testing.T) {
+	upstream, _ := newStatusUpstream(t, http.StatusOK, "ok")
+	srv := newTestServer(t,
+		config.UpstreamConfig{Name: "core", URL: upstream.URL, Audience: "core", Critical: true},
+		config.UpstreamConfig{Name: "extra", URL: upstream.URL, Audience: "extra"},
+	)
+	failing := func(ctx context.Context, audience string) (oauth2.TokenSource, error) {
+		return nil, errors.New("metadata server unavailable")
+	}
+	ready := func() *httptest.ResponseRecorder {
+		return serve(srv, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	}
+	route := func(name string) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(targetUpstreamHeader, name)
+		serve(srv, req)
+	}
+
+	// A non-critical upstream failing its token fetch leaves readiness alone
+	srv.tokenManager.SetAudienceSource("extra", failing)
+	route("extra")
+	if rec := ready(); rec.Code != http.StatusOK {
+		t.Fatalf("non-critical failure: /readyz = %d %q, want 200", rec.Code, rec.Body.String())
+	}
+
+	// A critical one takes the gateway out of rotation
+	srv.tokenManager.SetAudienceSource("core", failing)
+	route("core")
+	rec := ready()
+	if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "UNHEALTHY: core" {
+		t.Fatalf("critical failure: /readyz = %d %q, want 503 naming core", rec.Code, rec.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	req.Header.Set("Accept", "application/json")
+	var report healthReport
+	if err := json.NewDecoder(serve(srv, req).Body).Decode(&report); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if report.Status != "unhealthy" || report.Ready {
+		t.Errorf("report status = %q ready = %v, want unhealthy and not ready", report.Status, report.Ready)
+	}
+	if got := report.Upstreams[0]; !got.Critical || got.Unhealthy == "" {
+		t.Errorf("core = %+v, want critical and unhealthy", got)
+	}
+	if got := report.Upstreams[1]; got.Critical || got.Unhealthy == "" {
+		t.Errorf("extra = %+v, want non-critical and unhealthy", got)
+	}
+
+	// Readiness returns once the critical upstream's token recovers, with
+	// no traffic routed to it: the probes re-mint it in the background
+	srv.tokenManager.SetAudienceSource("core", func(ctx context.Context, audience string) (oauth2.TokenSource, error) {
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "t", Expiry: time.Now().Add(time.Hour)}), nil
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for rec := ready(); rec.Code != http.StatusOK; rec = ready() {
+		if time.Now().After(deadline) {
+			t.Fatalf("after recovery: /readyz = %d %q, want 200", rec.Code, rec.Body.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+// sendRaw writes a raw request to addr and returns the response status and body
+func sendRaw(t *testing.T, addr, request string) (int, string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	io.WriteString(conn, request)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestHostlessRequests(t *testing.T) {
+	newUpstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name+" host="+r.Host)
+		}))
+	}
+	api, legacy := newUpstream("api"), newUpstream("legacy")
+	defer api.Close()
+	defer legacy.Close()
+
+	srv := newTestServerWithConfig(t, &config.Config{
+		Server: config.ServerConfig{HostlessUpstream: "legacy"},
+		Upstreams: []config.UpstreamConfig{
+			{Name: "api", URL: api.URL, Audience: "a"},
+			{Name: "legacy", URL: legacy.URL, Audience: "b"},
+		},
+	})
+	gateway := httptest.NewServer(srv.httpServer.Handler)
+	defer gateway.Close()
+	addr := strings.TrimPrefix(gateway.URL, "http://")
+	legacyHost := strings.TrimPrefix(legacy.URL, "http://")
+	apiHost := strings.TrimPrefix(api.URL, "http://")
+
+	tests := []struct {
+		name       string
+		request    string
+		wantStatus int
+		wantBody   string
+	}{
+		{"http/1.0 without host", "GET /x HTTP/1.0\r\n\r\n", http.StatusOK, "legacy host=" + legacyHost},
+		{"http/1.0 with host", "GET /x HTTP/1.0\r\nHost: gateway\r\n\r\n", http.StatusOK, "api host=" + apiHost},
+		{"http/1.1 with host", "GET /x HTTP/1.1\r\nHost: gateway\r\nConnection: close\r\n\r\n", http.StatusOK, "api host=" + apiHost},
+		{"http/1.1 without host", "GET /x HTTP/1.1\r\nConnection: close\r\n\r\n", http.StatusBadRequest, ""},
+		{"malformed host", "GET /x HTTP/1.1\r\nHost: bad host\r\nConnection: close\r\n\r\n", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := sendRaw(t, addr, tt.request)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if tt.wantBody != "" && body != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestUpstreamHostOverride(t *testing.T) {
+	newUpstream := func() *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.Host)
+		}))
+	}
+	overridden, plain := newUpstream(), newUpstream()
+	defer overridden.Close()
+	defer plain.Close()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := `
+upstreams:
+  - name: overridden
+    url: ` + overridden.URL + `
+    audience: https://svc-abc.a.run.app
+    host: svc-abc.a.run.app
+  - name: plain
+    url: ` + plain.URL + `
+    audience: https://svc-def.a.run.app
+`
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	srv := newTestServerWithConfig(t, cfg)
+
+	for _, tt := range []struct{ upstream, want string }{
+		{"overridden", "svc-abc.a.run.app"},
+		{"plain", strings.TrimPrefix(plain.URL, "http://")},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(targetUpstreamHeader, tt.upstream)
+		if rec := serve(srv, req); rec.Body.String() != tt.want {
+			t.Errorf("%s: upstream Host = %q, want %q", tt.upstream, rec.Body.String(), tt.want)
+		}
+	}
+}
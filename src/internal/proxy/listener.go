@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/netutil"
+
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+// countingListener tracks the number of open connections it has accepted
+type countingListener struct {
+	net.Listener
+	active *atomic.Int64
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.active.Add(1)
+	return &countedConn{Conn: conn, active: l.active}, nil
+}
+
+// countedConn decrements the active count exactly once when closed
+type countedConn struct {
+	net.Conn
+	active *atomic.Int64
+	once   sync.Once
+}
+
+func (c *countedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { c.active.Add(-1) })
+	return err
+}
+
+// nagleListener turns Nagle's algorithm back on for accepted connections,
+// which Go disables (TCP_NODELAY) by default
+type nagleListener struct {
+	net.Listener
+}
+
+func (l nagleListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		if err := tcp.SetNoDelay(false); err != nil {
+			logger.Debug("Failed to enable Nagle's algorithm", "remote_addr", conn.RemoteAddr().String(), "error", err)
+		}
+	}
+	return conn, nil
+}
+
+// listenConfig carries the configured keep-alive period for client
+// connections, used both before the first probe and between probes
+func (s *Server) listenConfig() *net.ListenConfig {
+	period := time.Duration(s.config.Server.TCPKeepAlive) * time.Second
+	lc := &net.ListenConfig{KeepAlive: period}
+	if period > 0 {
+		lc.KeepAliveConfig = net.KeepAliveConfig{Enable: true, Idle: period, Interval: period}
+	}
+	return lc
+}
+
+// wrapListener applies the configured TCP options, connection limit and tracking
+func (s *Server) wrapListener(ln net.Listener) net.Listener {
+	if s.config.Server.TCPNagle {
+		ln = nagleListener{Listener: ln}
+	}
+	if s.config.Server.MaxConnections > 0 {
+		ln = netutil.LimitListener(ln, s.config.Server.MaxConnections)
+	}
+	return &countingListener{Listener: ln, active: &s.metrics.activeConnections}
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+// acceptedSockopt dials a listener built from the server's TCP options and
+// reads an int socket option from the accepted connection
+func acceptedSockopt(t *testing.T, srv *Server, level, opt int) int {
+	t.Helper()
+	ln, err := srv.listenConfig().Listen(t.Context(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	wrapped := srv.wrapListener(ln)
+	defer wrapped.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer client.Close()
+
+	conn, err := wrapped.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	defer conn.Close()
+
+	raw, err := conn.(*countedConn).Conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatalf("syscall conn: %v", err)
+	}
+	var value int
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		value, sockErr = syscall.GetsockoptInt(int(fd), level, opt)
+	}); err != nil {
+		t.Fatalf("control: %v", err)
+	}
+	if sockErr != nil {
+		t.Fatalf("getsockopt: %v", sockErr)
+	}
+	return value
+}
+
+func TestListenerTCPOptions(t *testing.T) {
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: "http://127.0.0.1:1", Audience: "aud"})
+
+	if got := acceptedSockopt(t, srv, syscall.IPPROTO_TCP, syscall.TCP_NODELAY); got == 0 {
+		t.Error("TCP_NODELAY off by default, want Go's default of on")
+	}
+	srv.config.Server.TCPNagle = true
+	if got := acceptedSockopt(t, srv, syscall.IPPROTO_TCP, syscall.TCP_NODELAY); got != 0 {
+		t.Error("TCP_NODELAY still on with tcp_nagle")
+	}
+
+	srv.config.Server.TCPKeepAlive = 42
+	if got := acceptedSockopt(t, srv, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE); got != 42 {
+		t.Errorf("TCP_KEEPIDLE = %d, want 42", got)
+	}
+	if got := acceptedSockopt(t, srv, syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL); got != 42 {
+		t.Errorf("TCP_KEEPINTVL = %d, want 42", got)
+	}
+
+	srv.config.Server.TCPKeepAlive = -1
+	if got := acceptedSockopt(t, srv, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE); got != 0 {
+		t.Error("SO_KEEPALIVE on with tcp_keepalive -1, want off")
+	}
+}
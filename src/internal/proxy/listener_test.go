@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+func TestMaxConnectionsThrottlesExtraConnections(t *testing.T) {
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: "http://127.0.0.1:1", Audience: "aud"})
+	srv.config.Server.MaxConnections = 1
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go srv.serve(ln)
+	defer srv.Shutdown()
+
+	addr := ln.Addr().String()
+
+	// Hold the only connection slot open with a keep-alive request
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /healthz HTTP/1.1\r\nHost: gateway\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+	resp.Body.Close()
+
+	if got := srv.metrics.activeConnections.Load(); got != 1 {
+		t.Errorf("active connections = %d, want 1", got)
+	}
+
+	client := &http.Client{
+		Timeout:   300 * time.Millisecond,
+		Transport: &http.Transport{DisableKeepAlives: true},
+	}
+	if _, err := client.Get("http://" + addr + "/healthz"); err == nil {
+		t.Fatal("expected request over the connection limit to be throttled")
+	}
+
+	// Releasing the slot lets new connections through
+	conn.Close()
+	client.Timeout = 2 * time.Second
+	resp, err = client.Get("http://" + addr + "/healthz")
+	if err != nil {
+		t.Fatalf("request after slot released: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestServeTLSRestrictsCipherSuites(t *testing.T) {
+	certFile, keyFile := writeSelfSignedCert(t)
+	srv := newTestServerWithConfig(t, &config.Config{
+		Server: config.ServerConfig{TLS: config.TLSConfig{
+			CertFile:     certFile,
+			KeyFile:      keyFile,
+			CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+		}},
+		Upstreams: []config.UpstreamConfig{{Name: "api", URL: "http://127.0.0.1:1", Audience: "aud"}},
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go srv.serve(ln)
+	defer srv.Shutdown()
+
+	get := func(suite uint16) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			MaxVersion:         tls.VersionTLS12,
+			CipherSuites:       []uint16{suite},
+		}}}
+		return client.Get("https://" + ln.Addr().String() + "/healthz")
+	}
+
+	resp, err := get(tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256)
+	if err != nil {
+		t.Fatalf("request with allowed suite: %v", err)
+	}
+	resp.Body.Close()
+	if resp.TLS.CipherSuite != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("cipher suite = %s", tls.CipherSuiteName(resp.TLS.CipherSuite))
+	}
+
+	if _, err := get(tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384); err == nil {
+		t.Error("expected handshake failure for a suite outside cipher_suites")
+	}
+}
+
+// writeSelfSignedCert writes an ECDSA certificate for 127.0.0.1 and its key
+// as PEM files, returning their paths
+func writeSelfSignedCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return certFile, keyFile
+}
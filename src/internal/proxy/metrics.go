@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// proxyMetrics holds in-process counters for proxied requests
+type proxyMetrics struct {
+	proxyErrors           atomic.Int64 // upstream failures (dial, TLS, timeouts, ...)
+	clientDisconnects     atomic.Int64 // requests aborted because the client went away
+	activeConnections     atomic.Int64 // currently open client connections
+	inFlight              atomic.Int64 // proxy requests currently being processed
+	cacheHits             atomic.Int64 // responses served from the response cache
+	cacheMisses           atomic.Int64 // cacheable requests forwarded to the upstream
+	connectRetries        atomic.Int64 // requests retried after a refused/reset connection
+	retriesDenied         atomic.Int64 // retries refused by an exhausted retry budget
+	defaultRouted         atomic.Int64 // requests no routing rule matched
+	concurrencyRejections atomic.Int64 // requests shed by max_concurrent_requests
+	slowRequests          atomic.Int64 // requests over logging.slow_request_threshold
+
+	// traffic is keyed by upstream name; built once at startup so lookups
+	// need no locking. Not cleared by reset, as it feeds chargeback.
+	traffic map[string]*upstreamTraffic
+}
+
+// upstreamTraffic counts the requests and body bytes handled per upstream,
+// and splits each attempt's latency into token acquisition and proxying
+type upstreamTraffic struct {
+	requests atomic.Int64
+	bytesIn  atomic.Int64 // request body bytes received from clients
+	bytesOut atomic.Int64 // response body bytes sent to clients
+
+	tokenWait    *histogram // time spent in GetToken
+	upstreamTime *histogram // time spent proxying to the upstream
+}
+
+func newProxyMetrics(upstreams []string) *proxyMetrics {
+	m := &proxyMetrics{traffic: make(map[string]*upstreamTraffic)}
+	for _, name := range upstreams {
+		m.traffic[name] = &upstreamTraffic{tokenWait: newHistogram(), upstreamTime: newHistogram()}
+	}
+	return m
+}
+
+// recordLatency attributes an attempt's token wait and upstream time to
+// its upstream; a zero upstream duration (token failure) is not recorded
+func (m *proxyMetrics) recordLatency(upstream string, tokenWait, upstreamTime time.Duration) {
+	t := m.traffic[upstream]
+	if t == nil {
+		return
+	}
+	t.tokenWait.observe(tokenWait)
+	if upstreamTime > 0 {
+		t.upstreamTime.observe(upstreamTime)
+	}
+}
+
+// latencySnapshot returns the per-upstream latency histograms
+func (m *proxyMetrics) latencySnapshot() map[string]map[string]histogramSnapshot {
+	snapshot := make(map[string]map[string]histogramSnapshot, len(m.traffic))
+	for name, t := range m.traffic {
+		snapshot[name] = map[string]histogramSnapshot{
+			"token_wait": t.tokenWait.snapshot(),
+			"upstream":   t.upstreamTime.snapshot(),
+		}
+	}
+	return snapshot
+}
+
+// latencyBuckets are the upper bounds, in seconds, of the latency histograms
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// histogram counts observations into latencyBuckets without locking
+type histogram struct {
+	buckets []atomic.Int64 // per bucket, plus a final +Inf bucket
+	count   atomic.Int64
+	sumNano atomic.Int64
+}
+
+func newHistogram() *histogram {
+	return &histogram{buckets: make([]atomic.Int64, len(latencyBuckets)+1)}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBuckets) && d.Seconds() > latencyBuckets[i] {
+		i++
+	}
+	h.buckets[i].Add(1)
+	h.count.Add(1)
+	h.sumNano.Add(int64(d))
+}
+
+// histogramSnapshot is a histogram's state with cumulative bucket counts
+// keyed by upper bound ("0.005" ... "+Inf")
+type histogramSnapshot struct {
+	Buckets map[string]int64 `json:"buckets"`
+	Count   int64            `json:"count"`
+	Sum     float64          `json:"sum"` // seconds
+}
+
+func (h *histogram) snapshot() histogramSnapshot {
+	snap := histogramSnapshot{
+		Buckets: make(map[string]int64, len(h.buckets)),
+		Count:   h.count.Load(),
+		Sum:     time.Duration(h.sumNano.Load()).Seconds(),
+	}
+	var cumulative int64
+	for i := range h.buckets {
+		cumulative += h.buckets[i].Load()
+		snap.Buckets[bucketLabel(i)] = cumulative
+	}
+	return snap
+}
+
+// bucketLabel formats the upper bound of bucket i as in OpenMetrics le labels
+func bucketLabel(i int) string {
+	if i == len(latencyBuckets) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(latencyBuckets[i], 'g', -1, 64)
+}
+
+// recordTraffic attributes a completed request's body sizes to its upstream
+func (m *proxyMetrics) recordTraffic(upstream string, bytesIn, bytesOut int64) {
+	t := m.traffic[upstream]
+	if t == nil {
+		return
+	}
+	t.requests.Add(1)
+	t.bytesIn.Add(bytesIn)
+	t.bytesOut.Add(bytesOut)
+}
+
+// trafficSnapshot returns the per-upstream traffic counters
+func (m *proxyMetrics) trafficSnapshot() map[string]map[string]int64 {
+	snapshot := make(map[string]map[string]int64, len(m.traffic))
+	for name, t := range m.traffic {
+		snapshot[name] = map[string]int64{
+			"requests":  t.requests.Load(),
+			"bytes_in":  t.bytesIn.Load(),
+			"bytes_out": t.bytesOut.Load(),
+		}
+	}
+	return snapshot
+}
+
+// reset zeroes the cumulative counters and returns their prior values.
+// Gauges such as active connections are left untouched.
+func (m *proxyMetrics) reset() map[string]int64 {
+	return map[string]int64{
+		"proxy_errors":           m.proxyErrors.Swap(0),
+		"client_disconnects":     m.clientDisconnects.Swap(0),
+		"cache_hits":             m.cacheHits.Swap(0),
+		"cache_misses":           m.cacheMisses.Swap(0),
+		"connect_retries":        m.connectRetries.Swap(0),
+		"retries_denied":         m.retriesDenied.Swap(0),
+		"default_routed":         m.defaultRouted.Swap(0),
+		"concurrency_rejections": m.concurrencyRejections.Swap(0),
+		"slow_requests":          m.slowRequests.Swap(0),
+	}
+}
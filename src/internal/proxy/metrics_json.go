@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"time"
+)
+
+// metricsV1 is the original flat /metrics JSON schema; keys are stable so
+// existing scrapers keep working
+func (s *Server) metricsV1() map[string]interface{} {
+	stats := s.tokenManager.GetStats()
+
+	metrics := map[string]interface{}{
+		"tokens_cached":          stats.TotalCached,
+		"tokens_refreshed":       stats.TotalRefreshed,
+		"tokens_rejected":        stats.TotalRejected,
+		"tokens_errors":          stats.TotalErrors,
+		"token_cache_hits":       stats.CacheHits,
+		"token_cache_misses":     stats.CacheMisses,
+		"token_cache_ratio":      stats.HitRatio(),
+		"token_cache_evictions":  stats.Evictions,
+		"upstreams_count":        len(s.config.Upstreams),
+		"proxy_errors":           s.metrics.proxyErrors.Load(),
+		"client_disconnects":     s.metrics.clientDisconnects.Load(),
+		"connections_active":     s.metrics.activeConnections.Load(),
+		"requests_in_flight":     s.metrics.inFlight.Load(),
+		"cache_hits":             s.metrics.cacheHits.Load(),
+		"cache_misses":           s.metrics.cacheMisses.Load(),
+		"connect_retries":        s.metrics.connectRetries.Load(),
+		"retries_denied":         s.metrics.retriesDenied.Load(),
+		"default_routed":         s.metrics.defaultRouted.Load(),
+		"concurrency_rejections": s.metrics.concurrencyRejections.Load(),
+		"slow_requests":          s.metrics.slowRequests.Load(),
+		"upstream_traffic":       s.metrics.trafficSnapshot(),
+		"upstream_latency":       s.metrics.latencySnapshot(),
+	}
+	if current, ok := s.lifecycle.Current(); ok {
+		metrics["lifecycle_state"] = current.Event
+		metrics["lifecycle_state_since"] = current.Time.UTC().Format(time.RFC3339Nano)
+	}
+	if s.config.Server.MaxConnections > 0 {
+		metrics["connections_max"] = s.config.Server.MaxConnections
+	}
+	if s.requestSlots != nil {
+		metrics["requests_max_concurrent"] = cap(s.requestSlots)
+	}
+	if len(s.limiters) > 0 {
+		metrics["upstream_rate_limits"] = s.rateLimitSnapshot()
+	}
+
+	if stats.TotalCached > 0 {
+		metrics["oldest_token_age"] = time.Since(stats.OldestToken).String()
+		metrics["newest_token_age"] = time.Since(stats.NewestToken).String()
+	}
+
+	return metrics
+}
+
+// metricsV2 groups the same values by category, with a schema marker
+func (s *Server) metricsV2() map[string]interface{} {
+	stats := s.tokenManager.GetStats()
+
+	tokens := map[string]interface{}{
+		"cached":    stats.TotalCached,
+		"refreshed": stats.TotalRefreshed,
+		"rejected":  stats.TotalRejected,
+		"errors":    stats.TotalErrors,
+		"cache": map[string]interface{}{
+			"hits":      stats.CacheHits,
+			"misses":    stats.CacheMisses,
+			"ratio":     stats.HitRatio(),
+			"evictions": stats.Evictions,
+		},
+	}
+	if stats.TotalCached > 0 {
+		tokens["oldest_age"] = time.Since(stats.OldestToken).String()
+		tokens["newest_age"] = time.Since(stats.NewestToken).String()
+	}
+
+	connections := map[string]interface{}{
+		"active": s.metrics.activeConnections.Load(),
+	}
+	if s.config.Server.MaxConnections > 0 {
+		connections["max"] = s.config.Server.MaxConnections
+	}
+
+	concurrency := map[string]interface{}{
+		"in_flight":  s.metrics.inFlight.Load(),
+		"rejections": s.metrics.concurrencyRejections.Load(),
+	}
+	if s.requestSlots != nil {
+		concurrency["max"] = cap(s.requestSlots)
+	}
+
+	upstreams := map[string]interface{}{
+		"count":   len(s.config.Upstreams),
+		"traffic": s.metrics.trafficSnapshot(),
+		"latency": s.metrics.latencySnapshot(),
+	}
+	if len(s.limiters) > 0 {
+		upstreams["rate_limits"] = s.rateLimitSnapshot()
+	}
+
+	metrics := map[string]interface{}{
+		"schema":    "v2",
+		"tokens":    tokens,
+		"upstreams": upstreams,
+		"proxy": map[string]interface{}{
+			"errors":             s.metrics.proxyErrors.Load(),
+			"client_disconnects": s.metrics.clientDisconnects.Load(),
+			"connect_retries":    s.metrics.connectRetries.Load(),
+			"retries_denied":     s.metrics.retriesDenied.Load(),
+			"default_routed":     s.metrics.defaultRouted.Load(),
+			"slow_requests":      s.metrics.slowRequests.Load(),
+		},
+		"response_cache": map[string]interface{}{
+			"hits":   s.metrics.cacheHits.Load(),
+			"misses": s.metrics.cacheMisses.Load(),
+		},
+		"connections": connections,
+		"concurrency": concurrency,
+	}
+	if current, ok := s.lifecycle.Current(); ok {
+		metrics["lifecycle"] = map[string]interface{}{
+			"state": current.Event,
+			"since": current.Time.UTC().Format(time.RFC3339Nano),
+		}
+	}
+
+	return metrics
+}
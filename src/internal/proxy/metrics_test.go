@@ -0,0 +1,278 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+func TestUpstreamTrafficAccounting(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(strings.Repeat("o", 250)))
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t,
+		config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: "a"},
+		config.UpstreamConfig{Name: "idle", URL: upstream.URL, Audience: "b"},
+	)
+
+	serve(srv, httptest.NewRequest(http.MethodPut, "/", strings.NewReader(strings.Repeat("i", 100))))
+	serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	var metrics struct {
+		Traffic map[string]map[string]int64 `json:"upstream_traffic"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&metrics); err != nil {
+		t.Fatalf("decode metrics: %v", err)
+	}
+
+	want := map[string]map[string]int64{
+		"api":  {"requests": 2, "bytes_in": 100, "bytes_out": 500},
+		"idle": {"requests": 0, "bytes_in": 0, "bytes_out": 0},
+	}
+	for name, counters := range want {
+		for key, value := range counters {
+			if got := metrics.Traffic[name][key]; got != value {
+				t.Errorf("%s %s = %d, want %d", name, key, got, value)
+			}
+		}
+	}
+}
+
+func TestUpstreamTrafficOpenMetrics(t *testing.T) {
+	upstream, _ := newStatusUpstream(t, http.StatusOK, "12345")
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: "a"})
+	serve(srv, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("abc")))
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text")
+	body := serve(srv, req).Body.String()
+	parseOpenMetrics(t, body)
+
+	for _, want := range []string{
+		`gateway_upstream_requests_total{upstream="api"} 1`,
+		`gateway_upstream_request_bytes_total{upstream="api"} 3`,
+		`gateway_upstream_response_bytes_total{upstream="api"} 5`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("missing sample %s", want)
+		}
+	}
+}
+
+// getMetricsJSON fetches /metrics with the given query and decodes it
+func getMetricsJSON(t *testing.T, srv *Server, query string) map[string]interface{} {
+	t.Helper()
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/metrics"+query, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /metrics%s: status = %d", query, rec.Code)
+	}
+	var metrics map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&metrics); err != nil {
+		t.Fatalf("decode metrics: %v", err)
+	}
+	return metrics
+}
+
+func TestMetricsJSONSchemaV1(t *testing.T) {
+	upstream, _ := newStatusUpstream(t, http.StatusOK, "ok")
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: "a"})
+	serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	for _, query := range []string{"", "?schema=v1"} {
+		metrics := getMetricsJSON(t, srv, query)
+		for _, key := range []string{"tokens_cached", "token_cache_hits", "proxy_errors", "connections_active", "upstream_traffic"} {
+			if _, exists := metrics[key]; !exists {
+				t.Errorf("%q: missing v1 key %q", query, key)
+			}
+		}
+		if metrics["tokens_cached"] != 1.0 {
+			t.Errorf("%q: tokens_cached = %v, want 1", query, metrics["tokens_cached"])
+		}
+		if _, exists := metrics["schema"]; exists {
+			t.Errorf("%q: v1 should not carry a schema marker", query)
+		}
+	}
+}
+
+func TestMetricsJSONSchemaV2(t *testing.T) {
+	upstream, _ := newStatusUpstream(t, http.StatusOK, "ok")
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: "a"})
+	serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	metrics := getMetricsJSON(t, srv, "?schema=v2")
+	if metrics["schema"] != "v2" {
+		t.Errorf("schema = %v, want v2", metrics["schema"])
+	}
+	tokens, _ := metrics["tokens"].(map[string]interface{})
+	if tokens["cached"] != 1.0 {
+		t.Errorf("tokens.cached = %v, want 1", tokens["cached"])
+	}
+	if cache, _ := tokens["cache"].(map[string]interface{}); cache["misses"] != 1.0 {
+		t.Errorf("tokens.cache = %v, want one miss", tokens["cache"])
+	}
+	for _, category := range []string{"upstreams", "proxy", "response_cache", "connections"} {
+		if _, ok := metrics[category].(map[string]interface{}); !ok {
+			t.Errorf("missing category %q", category)
+		}
+	}
+	if _, exists := metrics["tokens_cached"]; exists {
+		t.Error("v2 should not carry flat v1 keys")
+	}
+}
+
+func TestMetricsJSONSchemaSelection(t *testing.T) {
+	srv := newTestServerWithConfig(t, &config.Config{
+		Server:    config.ServerConfig{MetricsSchema: config.MetricsSchemaV2},
+		Upstreams: []config.UpstreamConfig{{Name: "api", URL: "http://127.0.0.1:1", Audience: "a"}},
+	})
+
+	if metrics := getMetricsJSON(t, srv, ""); metrics["schema"] != "v2" {
+		t.Errorf("configured default: schema = %v, want v2", metrics["schema"])
+	}
+	if metrics := getMetricsJSON(t, srv, "?schema=v1"); metrics["tokens_cached"] == nil {
+		t.Error("?schema=v1 should override the configured default")
+	}
+	if rec := serve(srv, httptest.NewRequest(http.MethodGet, "/metrics?schema=v9", nil)); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown schema: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestExpvarCounters(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	srv := newTestServerWithConfig(t, &config.Config{
+		Server:    config.ServerConfig{Expvar: true},
+		Admin:     config.AdminConfig{Token: "s3cret"},
+		Upstreams: []config.UpstreamConfig{{Name: "api", URL: upstream.URL, Audience: "a"}},
+	})
+
+	if rec := serve(srv, adminRequest(http.MethodGet, "/debug/vars", "")); rec.Code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated /debug/vars status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	vars := func() map[string]int64 {
+		t.Helper()
+		rec := serve(srv, adminRequest(http.MethodGet, "/debug/vars", "s3cret"))
+		var body struct {
+			Gateway map[string]int64 `json:"gateway"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatalf("decode /debug/vars: %v", err)
+		}
+		return body.Gateway
+	}
+
+	if got := vars(); got["requests"] != 0 || got["tokens_cached"] != 0 {
+		t.Errorf("initial vars = %v, want zero counters", got)
+	}
+
+	serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+	srv.tokenManager.MarkRejected("a")
+	srv.metrics.proxyErrors.Add(2)
+
+	want := map[string]int64{
+		"requests":         1,
+		"proxy_errors":     2,
+		"token_refreshes":  1,
+		"token_rejections": 1,
+		"tokens_cached":    1,
+	}
+	got := vars()
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %d, want %d", key, got[key], value)
+		}
+	}
+}
+
+func TestMetricsJSONAndExpvarBoundedByAudiences(t *testing.T) {
+	srv := newTestServerWithConfig(t, &config.Config{
+		Server:    config.ServerConfig{Expvar: true},
+		Upstreams: []config.UpstreamConfig{{Name: "api", URL: "http://127.0.0.1:1", Audience: "a"}},
+	})
+	for i := 0; i < 20; i++ {
+		if _, err := srv.tokenManager.GetToken(fmt.Sprintf("https://tenant-%d.example", i)); err != nil {
+			t.Fatalf("GetToken() error = %v", err)
+		}
+	}
+
+	// Per-audience series live only in the OpenMetrics output, where
+	// metrics_max_audiences caps them; the JSON and expvar outputs carry
+	// aggregates whose size does not grow with the audiences seen
+	for _, query := range []string{"?schema=v1", "?schema=v2"} {
+		body := serve(srv, httptest.NewRequest(http.MethodGet, "/metrics"+query, nil)).Body.String()
+		if strings.Contains(body, "tenant-") {
+			t.Errorf("/metrics%s lists audiences: %s", query, body)
+		}
+	}
+	if vars := expvar.Get(expvarName).String(); strings.Contains(vars, "tenant-") {
+		t.Errorf("expvar lists audiences: %s", vars)
+	}
+}
+
+func TestUpstreamLatencySplit(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(60 * time.Millisecond)
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: "a"})
+	srv.tokenManager.SetSourceFunc(func(ctx context.Context, audience string) (oauth2.TokenSource, error) {
+		time.Sleep(30 * time.Millisecond)
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "t", Expiry: time.Now().Add(time.Hour)}), nil
+	})
+	serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	latency := srv.metrics.latencySnapshot()["api"]
+	tokenWait, upstreamTime := latency["token_wait"], latency["upstream"]
+	if tokenWait.Count != 1 || upstreamTime.Count != 1 {
+		t.Fatalf("counts = %d/%d, want 1/1", tokenWait.Count, upstreamTime.Count)
+	}
+	if tokenWait.Sum < 0.03 || tokenWait.Sum >= 0.06 {
+		t.Errorf("token wait = %.3fs, want the ~30ms mint only", tokenWait.Sum)
+	}
+	if upstreamTime.Sum < 0.06 {
+		t.Errorf("upstream time = %.3fs, want at least the 60ms upstream delay", upstreamTime.Sum)
+	}
+	if got := upstreamTime.Buckets["0.05"]; got != 0 {
+		t.Errorf("upstream le=0.05 bucket = %d, want 0", got)
+	}
+	if got := upstreamTime.Buckets["+Inf"]; got != 1 {
+		t.Errorf("upstream +Inf bucket = %d, want 1", got)
+	}
+
+	metrics := getMetricsJSON(t, srv, "")
+	if _, ok := metrics["upstream_latency"].(map[string]interface{})["api"]; !ok {
+		t.Errorf("upstream_latency missing api: %v", metrics["upstream_latency"])
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text")
+	body := serve(srv, req).Body.String()
+	parseOpenMetrics(t, body)
+	for _, want := range []string{
+		`gateway_token_wait_seconds_count{upstream="api"} 1`,
+		`gateway_upstream_duration_seconds_bucket{upstream="api",le="0.05"} 0`,
+		`gateway_upstream_duration_seconds_bucket{upstream="api",le="+Inf"} 1`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("missing sample %s", want)
+		}
+	}
+}
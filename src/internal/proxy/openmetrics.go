@@ -0,0 +1,405 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-oauth2-proxy/src/internal/lifecycle"
+	"go-oauth2-proxy/src/internal/logger"
+	"go-oauth2-proxy/src/internal/token"
+)
+
+// Version is the gateway version reported in build_info; set at build time
+// with -ldflags "-X go-oauth2-proxy/src/internal/proxy.Version=..."
+var Version = "dev"
+
+const (
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	prometheusContentType  = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// tokenStates are the members of the gateway_token_state stateset
+var tokenStates = []token.TokenState{
+	token.StateNew,
+	token.StateCached,
+	token.StateRefreshed,
+	token.StateExpiring,
+	token.StateExpired,
+	token.StateRejected,
+	token.StateError,
+}
+
+// otherLabel is the label value shared by series folded past a labelCap
+const otherLabel = "other"
+
+// labelCap bounds the distinct values of a metric label. The first max
+// values seen keep their own series for the life of the process, so a
+// series never flips between its value and "other"; later values fold into
+// "other". A zero max is unlimited.
+type labelCap struct {
+	name string
+	max  int
+
+	mu     sync.Mutex
+	seen   map[string]bool
+	warned bool
+}
+
+func newLabelCap(name string, max int) *labelCap {
+	return &labelCap{name: name, max: max, seen: make(map[string]bool)}
+}
+
+// label returns the value to emit for value, logging the first fold
+func (c *labelCap) label(value string) string {
+	if c.max <= 0 {
+		return value
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seen[value] {
+		return value
+	}
+	if len(c.seen) < c.max {
+		c.seen[value] = true
+		return value
+	}
+	if !c.warned {
+		c.warned = true
+		logger.Warn("Metrics label cap reached, folding further values into \"other\"",
+			"label", c.name, "max", c.max, "value", value)
+	}
+	return otherLabel
+}
+
+// refreshTotals turns the token manager's per-audience refresh counts, which
+// drop when stats are reset or an entry is evicted, into monotonic totals
+// per audience label
+type refreshTotals struct {
+	mu     sync.Mutex
+	last   map[string]int   // refresh count per audience at the last scrape
+	totals map[string]int64 // refreshes per label since the process started
+}
+
+func newRefreshTotals() *refreshTotals {
+	return &refreshTotals{last: make(map[string]int), totals: make(map[string]int64)}
+}
+
+// observe adds the refreshes made since the last scrape, given each cached
+// audience's label and current count, and returns the totals of every label
+// seen so far. A count below the last one was reset (or its entry evicted
+// and recreated) and counts in full.
+func (r *refreshTotals) observe(labels map[string]string, counts map[string]int) map[string]int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for audience, count := range counts {
+		delta := count
+		if last, ok := r.last[audience]; ok && count >= last {
+			delta = count - last
+		}
+		r.totals[labels[audience]] += int64(delta)
+		r.last[audience] = count
+	}
+	// Forget evicted audiences; a later entry for one starts from zero
+	for audience := range r.last {
+		if _, ok := counts[audience]; !ok {
+			delete(r.last, audience)
+		}
+	}
+
+	totals := make(map[string]int64, len(r.totals))
+	for label, total := range r.totals {
+		totals[label] = total
+	}
+	return totals
+}
+
+// wantsOpenMetrics reports whether the client negotiated OpenMetrics
+func wantsOpenMetrics(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+}
+
+// wantsPrometheus reports whether the client asked for the Prometheus text
+// format, with ?format=prometheus or the version=0.0.4 text/plain media type
+// older scrapers send. A plain text/plain Accept keeps the JSON default.
+func wantsPrometheus(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "prometheus" {
+		return true
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if strings.HasPrefix(strings.TrimSpace(accept), "text/plain") && strings.Contains(accept, "version=0.0.4") {
+			return true
+		}
+	}
+	return false
+}
+
+// openMetricsWriter emits metric families in the OpenMetrics text format,
+// or in the Prometheus text format it extends when prometheus is set
+type openMetricsWriter struct {
+	w          io.Writer
+	prometheus bool
+}
+
+// family writes the metadata lines for a metric family. The Prometheus
+// format has no units or statesets (sent as gauges) and names counter
+// families with their _total suffix.
+func (o *openMetricsWriter) family(name, typ, unit, help string) {
+	if o.prometheus {
+		switch typ {
+		case "counter":
+			name += "_total"
+		case "stateset":
+			typ = "gauge"
+		}
+		unit = ""
+	}
+	fmt.Fprintf(o.w, "# TYPE %s %s\n", name, typ)
+	if unit != "" {
+		fmt.Fprintf(o.w, "# UNIT %s %s\n", name, unit)
+	}
+	fmt.Fprintf(o.w, "# HELP %s %s\n", name, help)
+}
+
+// sample writes one sample; labels alternate name and value
+func (o *openMetricsWriter) sample(name string, value float64, labels ...string) {
+	io.WriteString(o.w, name)
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, labels[i]+`="`+escapeLabelValue(labels[i+1])+`"`)
+		}
+		io.WriteString(o.w, "{"+strings.Join(pairs, ",")+"}")
+	}
+	io.WriteString(o.w, " "+strconv.FormatFloat(value, 'g', -1, 64)+"\n")
+}
+
+// counter writes a single-sample counter family
+func (o *openMetricsWriter) counter(name, help string, value int64) {
+	o.family(name, "counter", "", help)
+	o.sample(name+"_total", float64(value))
+}
+
+// gauge writes a single-sample gauge family
+func (o *openMetricsWriter) gauge(name, help string, value int64) {
+	o.family(name, "gauge", "", help)
+	o.sample(name, float64(value))
+}
+
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// writeOpenMetrics renders the gateway metrics and per-audience token
+// series in the OpenMetrics text format, or the Prometheus text format
+func (s *Server) writeOpenMetrics(w http.ResponseWriter, prometheus bool) {
+	stats := s.tokenManager.GetStats()
+	allMetadata := s.tokenManager.GetAllMetadata()
+
+	o := &openMetricsWriter{w: w, prometheus: prometheus}
+	if prometheus {
+		w.Header().Set("Content-Type", prometheusContentType)
+	} else {
+		w.Header().Set("Content-Type", openMetricsContentType)
+	}
+
+	o.family("gateway_build_info", "gauge", "", "Gateway build information.")
+	o.sample("gateway_build_info", 1, "version", Version, "go_version", runtime.Version())
+
+	o.family("gateway_lifecycle_state", "stateset", "", "Current lifecycle state of the gateway.")
+	current, _ := s.lifecycle.Current()
+	for _, event := range lifecycle.Events {
+		value := 0.0
+		if current.Event == event {
+			value = 1
+		}
+		o.sample("gateway_lifecycle_state", value, "gateway_lifecycle_state", string(event))
+	}
+	o.family("gateway_lifecycle_event_timestamp_seconds", "gauge", "seconds", "Time each lifecycle event was emitted.")
+	for _, record := range s.lifecycle.History() {
+		o.sample("gateway_lifecycle_event_timestamp_seconds",
+			float64(record.Time.UnixNano())/1e9, "event", string(record.Event))
+	}
+
+	o.gauge("gateway_tokens_cached", "Audiences with a cached token entry.", int64(stats.TotalCached))
+	o.counter("gateway_token_refreshes", "Tokens minted or refreshed.", int64(stats.TotalRefreshed))
+	o.counter("gateway_token_rejections", "Tokens rejected by upstreams.", int64(stats.TotalRejected))
+	o.counter("gateway_token_errors", "Failed token mints or refreshes.", int64(stats.TotalErrors))
+	o.counter("gateway_token_cache_hits", "Token lookups served from cache.", stats.CacheHits)
+	o.counter("gateway_token_cache_misses", "Token lookups that triggered a refresh.", stats.CacheMisses)
+	o.counter("gateway_token_cache_evictions", "Token cache entries evicted to stay within max_entries.", stats.Evictions)
+	o.gauge("gateway_upstreams", "Configured upstreams.", int64(len(s.config.Upstreams)))
+	o.counter("gateway_proxy_errors", "Upstream failures.", s.metrics.proxyErrors.Load())
+	o.counter("gateway_client_disconnects", "Requests aborted by the client.", s.metrics.clientDisconnects.Load())
+	o.counter("gateway_connect_retries", "Requests retried after a refused or reset connection.", s.metrics.connectRetries.Load())
+	o.counter("gateway_retries_denied", "Retries refused by an exhausted retry budget.", s.metrics.retriesDenied.Load())
+	o.counter("gateway_default_routed", "Requests no routing rule matched, sent to the default upstream or rejected.", s.metrics.defaultRouted.Load())
+	o.counter("gateway_slow_requests", "Requests slower than logging.slow_request_threshold.", s.metrics.slowRequests.Load())
+	o.counter("gateway_response_cache_hits", "Responses served from the response cache.", s.metrics.cacheHits.Load())
+	o.counter("gateway_response_cache_misses", "Cacheable requests forwarded upstream.", s.metrics.cacheMisses.Load())
+	o.gauge("gateway_connections_active", "Open client connections.", s.metrics.activeConnections.Load())
+	o.gauge("gateway_requests_in_flight", "Proxy requests currently being processed.", s.metrics.inFlight.Load())
+	if s.requestSlots != nil {
+		o.gauge("gateway_requests_max_concurrent", "Limit on concurrently processed proxy requests.", int64(cap(s.requestSlots)))
+	}
+	o.counter("gateway_concurrency_rejections", "Proxy requests rejected by the concurrency limit.", s.metrics.concurrencyRejections.Load())
+
+	traffic := s.metrics.trafficSnapshot()
+	upstreams := make([]string, 0, len(traffic))
+	for name := range traffic {
+		upstreams = append(upstreams, name)
+	}
+	sort.Strings(upstreams)
+
+	for _, f := range []struct{ name, key, unit, help string }{
+		{"gateway_upstream_requests", "requests", "", "Requests proxied per upstream."},
+		{"gateway_upstream_request_bytes", "bytes_in", "bytes", "Request body bytes received per upstream."},
+		{"gateway_upstream_response_bytes", "bytes_out", "bytes", "Response body bytes sent per upstream."},
+	} {
+		o.family(f.name, "counter", f.unit, f.help)
+		for _, name := range upstreams {
+			o.sample(f.name+"_total", float64(traffic[name][f.key]), "upstream", name)
+		}
+	}
+
+	for _, f := range []struct {
+		name, help string
+		hist       func(t *upstreamTraffic) *histogram
+	}{
+		{"gateway_token_wait_seconds", "Time spent acquiring the upstream token per attempt.",
+			func(t *upstreamTraffic) *histogram { return t.tokenWait }},
+		{"gateway_upstream_duration_seconds", "Time spent proxying to the upstream per attempt.",
+			func(t *upstreamTraffic) *histogram { return t.upstreamTime }},
+	} {
+		o.family(f.name, "histogram", "seconds", f.help)
+		for _, name := range upstreams {
+			snap := f.hist(s.metrics.traffic[name]).snapshot()
+			for i := range latencyBuckets {
+				o.sample(f.name+"_bucket", float64(snap.Buckets[bucketLabel(i)]), "upstream", name, "le", bucketLabel(i))
+			}
+			o.sample(f.name+"_bucket", float64(snap.Count), "upstream", name, "le", "+Inf")
+			o.sample(f.name+"_count", float64(snap.Count), "upstream", name)
+			o.sample(f.name+"_sum", snap.Sum, "upstream", name)
+		}
+	}
+
+	if len(s.limiters) > 0 {
+		limits := s.rateLimitSnapshot()
+		limited := make([]string, 0, len(limits))
+		for name := range limits {
+			limited = append(limited, name)
+		}
+		sort.Strings(limited)
+
+		for _, f := range []struct {
+			name, typ, help string
+			value           func(snap rateLimitSnapshot) float64
+		}{
+			{"gateway_upstream_rate_limit_rps", "gauge", "Configured outbound requests per second per upstream.",
+				func(snap rateLimitSnapshot) float64 { return snap.RPS }},
+			{"gateway_upstream_rate_limit_tokens", "gauge", "Outbound rate limit tokens available per upstream; negative while attempts wait.",
+				func(snap rateLimitSnapshot) float64 { return snap.Tokens }},
+			{"gateway_upstream_rate_limit_waiting", "gauge", "Attempts queued for an outbound rate limit token per upstream.",
+				func(snap rateLimitSnapshot) float64 { return float64(snap.Waiting) }},
+			{"gateway_upstream_rate_limit_admitted", "counter", "Attempts admitted by the outbound rate limit per upstream.",
+				func(snap rateLimitSnapshot) float64 { return float64(snap.Admitted) }},
+			{"gateway_upstream_rate_limit_rejected", "counter", "Attempts rejected by the outbound rate limit per upstream.",
+				func(snap rateLimitSnapshot) float64 { return float64(snap.Rejected) }},
+		} {
+			o.family(f.name, f.typ, "", f.help)
+			sample := f.name
+			if f.typ == "counter" {
+				sample += "_total"
+			}
+			for _, name := range limited {
+				o.sample(sample, f.value(limits[name]), "upstream", name)
+			}
+		}
+	}
+
+	audiences := make([]string, 0, len(allMetadata))
+	for audience := range allMetadata {
+		audiences = append(audiences, audience)
+	}
+	sort.Strings(audiences)
+
+	// Audiences past server.metrics_max_audiences share the "other" series:
+	// it is in every state one of them is in, counts all their refreshes,
+	// and takes the earliest expiry and the oldest token
+	now := time.Now()
+	states := make(map[string]map[token.TokenState]bool)
+	expiry := make(map[string]time.Time)
+	audienceLabels := make(map[string]string, len(audiences))
+	refreshCounts := make(map[string]int, len(audiences))
+	age := make(map[string]time.Duration)
+	var labels []string
+	for _, audience := range audiences {
+		meta := allMetadata[audience]
+		label := s.audienceLabels.label(audience)
+		audienceLabels[audience] = label
+		refreshCounts[audience] = meta.RefreshCount
+		if states[label] == nil {
+			states[label] = make(map[token.TokenState]bool)
+			labels = append(labels, label)
+		}
+		states[label][meta.State] = true
+		if !meta.ExpiresAt.IsZero() && (expiry[label].IsZero() || meta.ExpiresAt.Before(expiry[label])) {
+			expiry[label] = meta.ExpiresAt
+		}
+		if meta.Token != "" && !meta.LastRefreshed.IsZero() {
+			if tokenAge := now.Sub(meta.LastRefreshed); tokenAge > age[label] {
+				age[label] = tokenAge
+			}
+		}
+	}
+	sort.Strings(labels)
+
+	o.family("gateway_token_state", "stateset", "", "Current state of each audience's token.")
+	for _, label := range labels {
+		for _, state := range tokenStates {
+			value := 0.0
+			if states[label][state] {
+				value = 1
+			}
+			o.sample("gateway_token_state", value, "audience", label, "gateway_token_state", string(state))
+		}
+	}
+
+	o.family("gateway_token_expiry_timestamp_seconds", "gauge", "seconds", "Expiry time of each audience's token.")
+	for _, label := range labels {
+		if expiresAt, ok := expiry[label]; ok {
+			o.sample("gateway_token_expiry_timestamp_seconds", float64(expiresAt.Unix()), "audience", label)
+		}
+	}
+
+	// Labels whose audiences have all been evicted keep their series, so
+	// the counter never goes backwards
+	refreshes := s.refreshes.observe(audienceLabels, refreshCounts)
+	refreshLabels := make([]string, 0, len(refreshes))
+	for label := range refreshes {
+		refreshLabels = append(refreshLabels, label)
+	}
+	sort.Strings(refreshLabels)
+	o.family("gateway_audience_token_refreshes", "counter", "", "Tokens minted or refreshed per audience.")
+	for _, label := range refreshLabels {
+		o.sample("gateway_audience_token_refreshes_total", float64(refreshes[label]), "audience", label)
+	}
+
+	o.family("gateway_token_age_seconds", "gauge", "seconds", "Time since each audience's cached token was minted.")
+	for _, label := range labels {
+		if tokenAge, ok := age[label]; ok {
+			o.sample("gateway_token_age_seconds", tokenAge.Seconds(), "audience", label)
+		}
+	}
+
+	if !prometheus {
+		io.WriteString(w, "# EOF\n")
+	}
+}
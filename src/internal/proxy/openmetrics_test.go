@@ -0,0 +1,258 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"go-oauth2-proxy/src/internal/config"
+	"go-oauth2-proxy/src/internal/lifecycle"
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+var (
+	metadataLine  = regexp.MustCompile(`^# (TYPE|UNIT|HELP) ([a-zA-Z_:][a-zA-Z0-9_:]*) (.+)$`)
+	sampleLine    = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{([a-zA-Z_][a-zA-Z0-9_]*="(\\.|[^"\\])*"(,[a-zA-Z_][a-zA-Z0-9_]*="(\\.|[^"\\])*")*)\})? (\S+)$`)
+	audienceLabel = regexp.MustCompile(`audience="([^"]*)"`)
+)
+
+// parseOpenMetrics checks the exposition against the OpenMetrics text format
+// rules the gateway relies on and returns the samples by name
+func parseOpenMetrics(t *testing.T, body string) map[string][]string {
+	t.Helper()
+	if !strings.HasSuffix(body, "# EOF\n") {
+		t.Fatal("exposition does not end with # EOF")
+	}
+
+	types := make(map[string]string)
+	samples := make(map[string][]string)
+	lines := strings.Split(strings.TrimSuffix(body, "# EOF\n"), "\n")
+	for _, line := range lines[:len(lines)-1] {
+		if m := metadataLine.FindStringSubmatch(line); m != nil {
+			if m[1] == "TYPE" {
+				if _, dup := types[m[2]]; dup {
+					t.Errorf("family %s declared twice", m[2])
+				}
+				types[m[2]] = m[3]
+			}
+			if m[1] == "UNIT" && !strings.HasSuffix(m[2], "_"+m[3]) {
+				t.Errorf("family %s does not end with its unit %s", m[2], m[3])
+			}
+			continue
+		}
+		m := sampleLine.FindStringSubmatch(line)
+		if m == nil {
+			t.Errorf("invalid line: %q", line)
+			continue
+		}
+		if types[m[1]] == "" && !hasSuffixFamily(types, m[1], "counter", "_total") &&
+			!hasSuffixFamily(types, m[1], "histogram", "_bucket", "_count", "_sum") {
+			t.Errorf("sample %s has no declared family", m[1])
+		}
+		samples[m[1]] = append(samples[m[1]], line)
+	}
+	return samples
+}
+
+// hasSuffixFamily reports whether sample belongs to a family of type typ
+// through one of the type's sample suffixes
+func hasSuffixFamily(types map[string]string, sample, typ string, suffixes ...string) bool {
+	for _, suffix := range suffixes {
+		if family, ok := strings.CutSuffix(sample, suffix); ok && types[family] == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMetricsOpenMetrics(t *testing.T) {
+	upstream, _ := newStatusUpstream(t, http.StatusOK, "ok")
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: `https://svc"quoted`})
+	serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0, text/plain;q=0.5")
+	rec := serve(srv, req)
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/openmetrics-text") {
+		t.Fatalf("Content-Type = %q, want OpenMetrics", ct)
+	}
+	samples := parseOpenMetrics(t, rec.Body.String())
+
+	if len(samples["gateway_build_info"]) != 1 {
+		t.Error("missing build_info")
+	}
+	if got := samples["gateway_token_refreshes_total"]; len(got) != 1 || !strings.HasSuffix(got[0], " 1") {
+		t.Errorf("token refreshes = %v, want 1", got)
+	}
+	if got := samples["gateway_lifecycle_state"]; len(got) != len(lifecycle.Events) {
+		t.Errorf("lifecycle state samples = %d, want one per event (%d)", len(got), len(lifecycle.Events))
+	}
+	if got := samples["gateway_token_cache_evictions_total"]; len(got) != 1 || !strings.HasSuffix(got[0], " 0") {
+		t.Errorf("token cache evictions = %v, want 0", got)
+	}
+	wantState := `gateway_token_state{audience="https://svc\"quoted",gateway_token_state="CACHED"} 1`
+	if !strings.Contains(rec.Body.String(), wantState+"\n") {
+		t.Errorf("missing state sample %s", wantState)
+	}
+	if len(samples["gateway_token_state"]) != len(tokenStates) {
+		t.Errorf("state samples = %d, want one per state (%d)", len(samples["gateway_token_state"]), len(tokenStates))
+	}
+	if len(samples["gateway_token_expiry_timestamp_seconds"]) != 1 {
+		t.Error("missing token expiry gauge")
+	}
+	if got := samples["gateway_audience_token_refreshes_total"]; len(got) != 1 || !strings.HasSuffix(got[0], " 1") {
+		t.Errorf("audience token refreshes = %v, want 1", got)
+	}
+	if len(samples["gateway_token_age_seconds"]) != 1 {
+		t.Error("missing token age gauge")
+	}
+}
+
+func TestMetricsPrometheus(t *testing.T) {
+	upstream, _ := newStatusUpstream(t, http.StatusOK, "ok")
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: "https://svc"})
+	serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	for name, req := range map[string]*http.Request{
+		"query":  httptest.NewRequest(http.MethodGet, "/metrics?format=prometheus", nil),
+		"accept": httptest.NewRequest(http.MethodGet, "/metrics", nil),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if name == "accept" {
+				req.Header.Set("Accept", "text/plain;version=0.0.4;q=0.3,*/*;q=0.1")
+			}
+			rec := serve(srv, req)
+
+			if ct := rec.Header().Get("Content-Type"); ct != prometheusContentType {
+				t.Fatalf("Content-Type = %q, want %q", ct, prometheusContentType)
+			}
+			body := rec.Body.String()
+			if strings.Contains(body, "# EOF") || strings.Contains(body, "# UNIT") {
+				t.Error("Prometheus exposition has OpenMetrics-only lines")
+			}
+			if strings.Contains(body, " stateset\n") {
+				t.Error("Prometheus exposition declares a stateset")
+			}
+			if !strings.Contains(body, "# TYPE gateway_audience_token_refreshes_total counter\n") {
+				t.Error("counter family not declared with its _total name")
+			}
+			if !strings.Contains(body, `gateway_audience_token_refreshes_total{audience="https://svc"} 1`+"\n") {
+				t.Error("missing per-audience refresh counter")
+			}
+			if !strings.Contains(body, `gateway_token_age_seconds{audience="https://svc"} `) {
+				t.Error("missing per-audience token age gauge")
+			}
+		})
+	}
+}
+
+func TestMetricsAudienceCardinalityCap(t *testing.T) {
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: "http://127.0.0.1:1", Audience: "aud-a"})
+	srv.audienceLabels = newLabelCap("audience", 2)
+	logger.SetLevel("warn")
+	t.Cleanup(func() { logger.SetLevel("error") })
+	buf := captureLogs(t)
+
+	scrape := func(audiences ...string) map[string][]string {
+		t.Helper()
+		for _, audience := range audiences {
+			if _, err := srv.tokenManager.GetToken(audience); err != nil {
+				t.Fatalf("GetToken(%s) error = %v", audience, err)
+			}
+		}
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Accept", "application/openmetrics-text")
+		return parseOpenMetrics(t, serve(srv, req).Body.String())
+	}
+	audienceLabels := func(samples []string) map[string]bool {
+		labels := make(map[string]bool)
+		for _, sample := range samples {
+			if match := audienceLabel.FindStringSubmatch(sample); match != nil {
+				labels[match[1]] = true
+			}
+		}
+		return labels
+	}
+
+	samples := scrape("aud-a", "aud-b", "aud-c", "aud-d")
+	labels := audienceLabels(samples["gateway_token_state"])
+	if len(labels) != 3 || !labels["aud-a"] || !labels["aud-b"] || !labels[otherLabel] {
+		t.Errorf("audience labels = %v, want aud-a, aud-b and other", labels)
+	}
+	if got := audienceLabels(samples["gateway_token_expiry_timestamp_seconds"]); len(got) != 3 {
+		t.Errorf("expiry audience labels = %v, want 3", got)
+	}
+	if len(samples["gateway_token_state"]) != 3*len(tokenStates) {
+		t.Errorf("state samples = %d, want one per state for each of 3 labels", len(samples["gateway_token_state"]))
+	}
+	if !strings.Contains(strings.Join(samples["gateway_token_state"], "\n"),
+		`gateway_token_state{audience="other",gateway_token_state="CACHED"} 1`) {
+		t.Error("folded audiences not reported as CACHED under other")
+	}
+
+	// Admitted labels are sticky: a new audience sorting first still folds
+	labels = audienceLabels(scrape("aud-0")["gateway_token_state"])
+	if labels["aud-0"] || !labels["aud-a"] || !labels["aud-b"] {
+		t.Errorf("audience labels = %v after a new audience, want the first two kept", labels)
+	}
+
+	if got := strings.Count(buf.String(), "Metrics label cap reached"); got != 1 {
+		t.Errorf("cap warnings = %d, want 1:\n%s", got, buf.String())
+	}
+}
+
+func TestAudienceRefreshesNeverDecrease(t *testing.T) {
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: "http://127.0.0.1:1", Audience: "aud-a"})
+	refreshes := func() string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Accept", "application/openmetrics-text")
+		samples := parseOpenMetrics(t, serve(srv, req).Body.String())
+		return strings.Join(samples["gateway_audience_token_refreshes_total"], "\n")
+	}
+	mint := func(audience string) {
+		t.Helper()
+		if _, err := srv.tokenManager.Refresh(audience); err != nil {
+			t.Fatalf("Refresh(%s) error = %v", audience, err)
+		}
+	}
+
+	mint("aud-a")
+	mint("aud-a")
+	if got, want := refreshes(), `gateway_audience_token_refreshes_total{audience="aud-a"} 2`; got != want {
+		t.Fatalf("refreshes = %q, want %q", got, want)
+	}
+
+	// Resetting the manager's stats zeroes its count, not the counter
+	srv.tokenManager.ResetStats()
+	mint("aud-a")
+	if got, want := refreshes(), `gateway_audience_token_refreshes_total{audience="aud-a"} 3`; got != want {
+		t.Errorf("refreshes after reset = %q, want %q", got, want)
+	}
+
+	// Nor does evicting the audience
+	srv.tokenManager.SetMaxEntries(1)
+	mint("aud-b")
+	got := refreshes()
+	for _, want := range []string{`{audience="aud-a"} 3`, `{audience="aud-b"} 1`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("refreshes after eviction = %q, want %s", got, want)
+		}
+	}
+}
+
+func TestMetricsDefaultsToJSON(t *testing.T) {
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: "http://127.0.0.1:1", Audience: "a"})
+
+	for _, accept := range []string{"", "text/plain", "*/*"} {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Accept", accept)
+		rec := serve(srv, req)
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Accept %q: Content-Type = %q, want application/json", accept, ct)
+		}
+	}
+}
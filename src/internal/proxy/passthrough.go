@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+// resolvePassThroughTarget returns the destination of a pass-through
+// request, taken from the absolute request URL or the upstream's target
+// header. Only hosts on the upstream's allow-list are accepted, so the
+// gateway cannot be used to reach arbitrary (e.g., internal) addresses.
+// The returned status is the one to reject the request with on error.
+func (s *Server) resolvePassThroughTarget(r *http.Request, upstream *config.UpstreamConfig) (*url.URL, int, error) {
+	var raw string
+	switch {
+	case r.URL.IsAbs():
+		raw = r.URL.String()
+	case upstream.TargetHeader != "":
+		raw = r.Header.Get(upstream.TargetHeader)
+	}
+	if raw == "" {
+		return nil, http.StatusBadRequest, fmt.Errorf("no target URL in request")
+	}
+
+	target, err := url.Parse(raw)
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid target URL")
+	}
+	if target.Scheme != "https" && !(target.Scheme == "http" && upstream.AllowHTTP) {
+		return nil, http.StatusForbidden, fmt.Errorf("target scheme %q not allowed", target.Scheme)
+	}
+	if target.User != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("target URL must not contain credentials")
+	}
+	if target.Host == "" {
+		return nil, http.StatusBadRequest, fmt.Errorf("target URL has no host")
+	}
+	if !isHostAllowed(target, upstream.AllowedHosts) {
+		return nil, http.StatusForbidden, fmt.Errorf("target host %q not allowed", target.Host)
+	}
+
+	return target, http.StatusOK, nil
+}
+
+// isHostAllowed reports whether the target's host matches an allow-list
+// entry. Entries match the hostname exactly, "*.example.com" matches any
+// subdomain (but not example.com itself), and entries with a port must
+// match host:port exactly.
+func isHostAllowed(target *url.URL, allowed []string) bool {
+	hostname := strings.ToLower(target.Hostname())
+	hostport := strings.ToLower(target.Host)
+
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(pattern)
+		switch {
+		case strings.HasPrefix(pattern, "*."):
+			if strings.HasSuffix(hostname, pattern[1:]) {
+				return true
+			}
+		case strings.Contains(pattern, ":"):
+			if hostport == pattern {
+				return true
+			}
+		default:
+			if hostname == pattern {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+// normalizePath resolves . and .. segments and collapses duplicate slashes
+// in p, keeping a trailing slash. ok is false when a .. segment climbs above
+// the root.
+func normalizePath(p string) (cleaned string, ok bool) {
+	depth := 0
+	for _, segment := range strings.Split(p, "/") {
+		switch segment {
+		case "", ".":
+		case "..":
+			if depth--; depth < 0 {
+				return "", false
+			}
+		default:
+			depth++
+		}
+	}
+
+	cleaned = path.Clean("/" + p)
+	if cleaned != "/" && (strings.HasSuffix(p, "/") || strings.HasSuffix(p, "/.") || strings.HasSuffix(p, "/..")) {
+		cleaned += "/"
+	}
+	return cleaned, true
+}
+
+// normalizePaths cleans the request path before anything matches on it
+// (see server.normalize_paths). A path that climbs above the root, or out of
+// an upstream's path_prefix it started under, is answered with 400; a path
+// that only needed cleaning continues with the cleaned form, which is also
+// what is forwarded upstream.
+func (s *Server) normalizePaths(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		original := r.URL.Path
+		cleaned, ok := normalizePath(original)
+		if ok {
+			for _, upstream := range s.config.Upstreams {
+				if hasPathPrefix(original, upstream.PathPrefix) && !hasPathPrefix(cleaned, upstream.PathPrefix) {
+					ok = false
+					break
+				}
+			}
+		}
+		if !ok {
+			logger.Warn("Path traversal rejected", "path", original, "remote_addr", r.RemoteAddr)
+			http.Error(w, "Bad Request: path escapes its prefix", http.StatusBadRequest)
+			return
+		}
+
+		if cleaned != original {
+			logger.Debug("Normalized request path", "path", original, "normalized", cleaned)
+			r.URL.Path = cleaned
+			r.URL.RawPath = ""
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{"/apps/x", "/apps/x", true},
+		{"/apps//x", "/apps/x", true},
+		{"//apps///x//", "/apps/x/", true},
+		{"/apps/./x", "/apps/x", true},
+		{"/apps/a/../x", "/apps/x", true},
+		{"/apps/..", "/", true},
+		{"/apps/x/.", "/apps/x/", true},
+		{"/", "/", true},
+		{"/..", "", false},
+		{"/apps/../../etc/passwd", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := normalizePath(tt.path)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("normalizePath(%q) = %q, %v; want %q, %v", tt.path, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestNormalizePathsBeforeAllowList(t *testing.T) {
+	var forwarded string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.URL.Path
+	}))
+	defer upstream.Close()
+
+	srv := newTestServerWithConfig(t, &config.Config{
+		Server: config.ServerConfig{
+			NormalizePaths: true,
+			AllowedPaths:   []string{"/apps/*", "/public/*"},
+		},
+		Upstreams: []config.UpstreamConfig{
+			{Name: "public", URL: upstream.URL, Audience: "p"},
+			{Name: "apps", URL: upstream.URL, Audience: "a", PathPrefix: "/apps", PreservePathPrefix: true},
+		},
+	})
+
+	tests := []struct {
+		name      string
+		path      string
+		want      int
+		forwarded string
+	}{
+		{"double slash", "/apps//x", http.StatusOK, "/apps/x"},
+		{"dot segment", "/apps/./x", http.StatusOK, "/apps/x"},
+		{"dot-dot within prefix", "/apps/a/../b", http.StatusOK, "/apps/b"},
+		{"dot-dot out of allow-list", "/public/../admin", http.StatusNotFound, ""},
+		{"dot-dot out of prefix", "/apps/../public/x", http.StatusBadRequest, ""},
+		{"encoded dot-dot out of prefix", "/apps/%2e%2e/public/x", http.StatusBadRequest, ""},
+		{"above root", "/../etc/passwd", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarded = ""
+			rec := serve(srv, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if forwarded != tt.forwarded {
+				t.Errorf("forwarded path = %q, want %q", forwarded, tt.forwarded)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"net/url"
+	"strings"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+// filterQuery removes query parameters per the upstream's strip-list or
+// allow-list. Parameters are matched on their decoded names, and those kept
+// are passed through byte-for-byte in their original order and encoding.
+func filterQuery(rawQuery string, upstream *config.UpstreamConfig) string {
+	if rawQuery == "" || (len(upstream.StripQueryParams) == 0 && len(upstream.AllowQueryParams) == 0) {
+		return rawQuery
+	}
+
+	kept := make([]string, 0)
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		rawName, _, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(rawName)
+		if err != nil {
+			name = rawName
+		}
+
+		if len(upstream.AllowQueryParams) > 0 {
+			if containsString(upstream.AllowQueryParams, name) {
+				kept = append(kept, pair)
+			}
+		} else if !containsString(upstream.StripQueryParams, name) {
+			kept = append(kept, pair)
+		}
+	}
+	return strings.Join(kept, "&")
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
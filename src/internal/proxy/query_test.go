@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+func TestFilterQuery(t *testing.T) {
+	strip := &config.UpstreamConfig{StripQueryParams: []string{"utm_source", "debug", "a b"}}
+	allow := &config.UpstreamConfig{AllowQueryParams: []string{"q", "page"}}
+
+	tests := []struct {
+		name     string
+		upstream *config.UpstreamConfig
+		query    string
+		want     string
+	}{
+		{"strip none configured", &config.UpstreamConfig{}, "utm_source=x&q=1", "utm_source=x&q=1"},
+		{"strip named", strip, "q=go&utm_source=mail&page=2", "q=go&page=2"},
+		{"strip repeated", strip, "debug=1&q=go&debug=2", "q=go"},
+		{"strip encoded name", strip, "a+b=1&a%20b=2&ab=3", "ab=3"},
+		{"strip keeps encoded values", strip, "q=a%26b%3Dc&debug", "q=a%26b%3Dc"},
+		{"strip all", strip, "debug=1", ""},
+		{"allow only listed", allow, "q=go&token=secret&page=2", "q=go&page=2"},
+		{"allow keeps order and encoding", allow, "page=2&x=1&q=caf%C3%A9+bar", "page=2&q=caf%C3%A9+bar"},
+		{"allow bare key", allow, "q&other", "q"},
+		{"empty", allow, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := filterQuery(tt.query, tt.upstream); got != tt.want {
+				t.Errorf("filterQuery(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryParamsStrippedBeforeProxying(t *testing.T) {
+	var gotQuery string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.RawQuery
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: "a",
+		StripQueryParams: []string{"fbclid"}})
+
+	serve(srv, httptest.NewRequest(http.MethodGet, "/search?q=a%2Bb&fbclid=abc123&lang=en", nil))
+	if gotQuery != "q=a%2Bb&lang=en" {
+		t.Errorf("upstream query = %q, want %q", gotQuery, "q=a%2Bb&lang=en")
+	}
+}
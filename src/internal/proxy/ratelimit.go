@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+// outboundLimiter is a token bucket capping the rate of attempts sent to
+// one upstream. Waiters reserve a token up front, so they are admitted in
+// arrival order and the bucket may run negative by the queued tokens.
+type outboundLimiter struct {
+	rate    float64 // tokens per second
+	burst   float64
+	maxWait time.Duration
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+
+	waiting  atomic.Int64 // attempts currently queued for a token
+	admitted atomic.Int64
+	rejected atomic.Int64
+}
+
+func newOutboundLimiter(settings config.OutboundRateLimitConfig) *outboundLimiter {
+	burst := float64(max(1, settings.Burst))
+	return &outboundLimiter{
+		rate:    settings.RPS,
+		burst:   burst,
+		maxWait: time.Duration(settings.MaxWait) * time.Millisecond,
+		tokens:  burst,
+		last:    time.Now(),
+		now:     time.Now,
+	}
+}
+
+// reserve takes a token and returns how long the caller must wait before
+// using it. When that would exceed maxWait nothing is taken, ok is false,
+// and wait is how long until a token frees up.
+func (l *outboundLimiter) reserve() (wait time.Duration, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0, true
+	}
+	wait = time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+	if wait > l.maxWait {
+		return wait, false
+	}
+	l.tokens--
+	return wait, true
+}
+
+// unreserve returns a token taken by reserve but never used
+func (l *outboundLimiter) unreserve() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = min(l.burst, l.tokens+1)
+}
+
+// acquire admits an attempt, waiting for a token when the bucket is empty.
+// It reports false with the suggested retry delay when the wait would
+// exceed maxWait, or with zero when ctx ends first.
+func (l *outboundLimiter) acquire(ctx context.Context) (time.Duration, bool) {
+	wait, ok := l.reserve()
+	if !ok {
+		l.rejected.Add(1)
+		return wait, false
+	}
+	if wait > 0 {
+		l.waiting.Add(1)
+		defer l.waiting.Add(-1)
+
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			l.unreserve()
+			return 0, false
+		}
+	}
+	l.admitted.Add(1)
+	return 0, true
+}
+
+// rateLimitSnapshot is a limiter's configuration and current state
+type rateLimitSnapshot struct {
+	RPS      float64 `json:"rps"`
+	Burst    int     `json:"burst"`
+	Tokens   float64 `json:"tokens"`  // available now; negative while attempts are queued
+	Waiting  int64   `json:"waiting"` // attempts queued for a token
+	Admitted int64   `json:"admitted"`
+	Rejected int64   `json:"rejected"`
+}
+
+func (l *outboundLimiter) snapshot() rateLimitSnapshot {
+	l.mu.Lock()
+	tokens := min(l.burst, l.tokens+l.now().Sub(l.last).Seconds()*l.rate)
+	l.mu.Unlock()
+
+	return rateLimitSnapshot{
+		RPS:      l.rate,
+		Burst:    int(l.burst),
+		Tokens:   tokens,
+		Waiting:  l.waiting.Load(),
+		Admitted: l.admitted.Load(),
+		Rejected: l.rejected.Load(),
+	}
+}
+
+// rateLimitSnapshot returns the state of every upstream's outbound limiter
+func (s *Server) rateLimitSnapshot() map[string]rateLimitSnapshot {
+	snapshot := make(map[string]rateLimitSnapshot, len(s.limiters))
+	for name, limiter := range s.limiters {
+		snapshot[name] = limiter.snapshot()
+	}
+	return snapshot
+}
@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+// newTestLimiter returns a limiter driven by a fake clock
+func newTestLimiter(rps float64, burst, maxWait int) (*outboundLimiter, *time.Time) {
+	l := newOutboundLimiter(config.OutboundRateLimitConfig{RPS: rps, Burst: burst, MaxWait: maxWait})
+	now := time.Now()
+	l.last = now
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestLimiterBurstThenRefill(t *testing.T) {
+	l, now := newTestLimiter(2, 3, 0)
+
+	for i := 0; i < 3; i++ {
+		if _, ok := l.reserve(); !ok {
+			t.Fatalf("reserve %d rejected within the burst", i+1)
+		}
+	}
+	wait, ok := l.reserve()
+	if ok {
+		t.Fatal("reserve should be rejected once the burst is spent")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("wait = %v, want 500ms until the next token", wait)
+	}
+
+	*now = now.Add(500 * time.Millisecond)
+	if _, ok := l.reserve(); !ok {
+		t.Error("reserve should succeed after a refill")
+	}
+
+	// Idle time never accumulates more than the burst
+	*now = now.Add(time.Hour)
+	if got := l.snapshot().Tokens; got != 3 {
+		t.Errorf("tokens = %v after idling, want burst 3", got)
+	}
+}
+
+func TestLimiterQueuesWithinMaxWait(t *testing.T) {
+	l, _ := newTestLimiter(10, 1, 250)
+
+	l.reserve()
+	for i, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond} {
+		wait, ok := l.reserve()
+		if !ok || wait != want {
+			t.Fatalf("reserve %d = %v, %v; want %v, true", i+2, wait, ok, want)
+		}
+	}
+	// A third waiter would be queued past max_wait
+	if _, ok := l.reserve(); ok {
+		t.Error("reserve should be rejected beyond max_wait")
+	}
+	if got := l.snapshot().Tokens; got != -2 {
+		t.Errorf("tokens = %v, want -2 with two queued", got)
+	}
+}
+
+func TestLimiterAcquireCancelled(t *testing.T) {
+	l := newOutboundLimiter(config.OutboundRateLimitConfig{RPS: 1, Burst: 1, MaxWait: 5000})
+	l.acquire(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, ok := l.acquire(ctx); ok {
+		t.Fatal("acquire should fail when the context ends first")
+	}
+
+	// The cancelled waiter's token is returned to the bucket
+	snap := l.snapshot()
+	if snap.Tokens < -0.5 || snap.Waiting != 0 || snap.Admitted != 1 {
+		t.Errorf("snapshot = %+v, want no queued tokens and one admitted", snap)
+	}
+}
+
+func TestOutboundRateLimitRejects(t *testing.T) {
+	var hits atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer upstream.Close()
+
+	srv := newTestServerWithConfig(t, &config.Config{
+		Upstreams: []config.UpstreamConfig{{
+			Name: "api", URL: upstream.URL, Audience: "a",
+			OutboundRateLimit: config.OutboundRateLimitConfig{RPS: 0.1, Burst: 2},
+		}},
+	})
+
+	for i := 0; i < 5; i++ {
+		rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+		if i < 2 && rec.Code != http.StatusOK {
+			t.Fatalf("request %d status = %d, want 200 within the burst", i+1, rec.Code)
+		}
+		if i >= 2 {
+			if rec.Code != http.StatusServiceUnavailable {
+				t.Fatalf("request %d status = %d, want 503 over the limit", i+1, rec.Code)
+			}
+			if got := rec.Header().Get("Retry-After"); got == "" {
+				t.Error("Retry-After should be set on rate limited requests")
+			}
+		}
+	}
+	if got := hits.Load(); got != 2 {
+		t.Errorf("upstream hits = %d, want 2", got)
+	}
+	if snap := srv.rateLimitSnapshot()["api"]; snap.Admitted != 2 || snap.Rejected != 3 {
+		t.Errorf("snapshot = %+v, want 2 admitted and 3 rejected", snap)
+	}
+}
+
+func TestOutboundRateLimitBoundsRate(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		times []time.Time
+	)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+	}))
+	defer upstream.Close()
+
+	srv := newTestServerWithConfig(t, &config.Config{
+		Upstreams: []config.UpstreamConfig{{
+			Name: "api", URL: upstream.URL, Audience: "a",
+			OutboundRateLimit: config.OutboundRateLimitConfig{RPS: 20, Burst: 1, MaxWait: 2000},
+		}},
+	})
+
+	// Concurrent requests wait their turn instead of being rejected
+	const requests = 6
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil)); rec.Code != http.StatusOK {
+				t.Errorf("status = %d, want 200", rec.Code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// One request goes immediately, the rest are spaced 50ms apart
+	if elapsed := time.Since(start); elapsed < (requests-1)*50*time.Millisecond-10*time.Millisecond {
+		t.Errorf("%d requests took %v, faster than 20 rps allows", requests, elapsed)
+	}
+	if len(times) != requests {
+		t.Fatalf("upstream hits = %d, want %d", len(times), requests)
+	}
+}
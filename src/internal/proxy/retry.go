@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"syscall"
+
+	"go-oauth2-proxy/src/internal/config"
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+// refusedRetryTransport retries a request once when the upstream refused or
+// reset the connection, as happens briefly during rolling restarts. Only
+// retryable requests (see isRetryable) whose body can be resent are retried;
+// timeouts and upstream responses (including 5xx) are never retried here.
+type refusedRetryTransport struct {
+	next     http.RoundTripper
+	upstream *config.UpstreamConfig
+	budget   *retryBudget
+	metrics  *proxyMetrics
+}
+
+func (t *refusedRetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err == nil || !isRefusedOrReset(err) || !canResend(req, t.upstream) {
+		return resp, err
+	}
+	if !t.budget.spend(t.metrics) {
+		logger.Warn("Retry budget exhausted, not retrying refused connection",
+			"upstream", t.upstream.Name,
+			"method", req.Method)
+		return nil, err
+	}
+
+	// A RoundTripper must not modify the caller's request, so the retry
+	// gets its own copy carrying the fresh body
+	if req.GetBody != nil {
+		body, bodyErr := req.GetBody()
+		if bodyErr != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+
+	t.metrics.connectRetries.Add(1)
+	logger.Info("Retrying refused upstream connection",
+		"upstream", t.upstream.Name,
+		"method", req.Method,
+		"error", err)
+	return t.next.RoundTrip(req)
+}
+
+// isRefusedOrReset reports whether err is a connection refused or reset
+func isRefusedOrReset(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// canResend reports whether the request may safely be sent again
+func canResend(req *http.Request, upstream *config.UpstreamConfig) bool {
+	if !isRetryable(upstream, req) || req.Context().Err() != nil {
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// retryBudget bounds the retries sent to one upstream (see
+// config.RetryBudgetConfig). A nil budget allows every retry.
+type retryBudget struct {
+	maxTokens float64
+	ratio     float64
+
+	mu     sync.Mutex
+	tokens float64
+}
+
+func newRetryBudget(settings config.RetryBudgetConfig) *retryBudget {
+	return &retryBudget{
+		maxTokens: float64(settings.MaxTokens),
+		ratio:     settings.TokenRatio,
+		tokens:    float64(settings.MaxTokens),
+	}
+}
+
+// spend takes a token for a retry, reporting false (and counting the denial
+// in metrics) when the budget is exhausted
+func (b *retryBudget) spend(metrics *proxyMetrics) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens <= b.maxTokens/2 {
+		metrics.retriesDenied.Add(1)
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// success returns part of a token for a response the upstream served
+func (b *retryBudget) success() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.maxTokens, b.tokens+b.ratio)
+}
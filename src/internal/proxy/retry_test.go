@@ -0,0 +1,232 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+// refuseFirstDials makes the upstream's transport refuse the first n dials
+// before connecting normally, like an upstream mid-restart
+func refuseFirstDials(srv *Server, name string, n int32) *int32 {
+	var dials int32
+	dialer := &net.Dialer{}
+	srv.transports[name].DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if atomic.AddInt32(&dials, 1) <= n {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: syscall.ECONNREFUSED}
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+	return &dials
+}
+
+func TestRetryOnRefusedSucceeds(t *testing.T) {
+	upstream, hits := newStatusUpstream(t, http.StatusOK, "ok")
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: "a", RetryOnRefused: true})
+	dials := refuseFirstDials(srv, "api", 1)
+
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := atomic.LoadInt32(dials); got != 2 {
+		t.Errorf("dials = %d, want 2", got)
+	}
+	if got := atomic.LoadInt32(hits); got != 1 {
+		t.Errorf("upstream hits = %d, want 1", got)
+	}
+	if got := srv.metrics.connectRetries.Load(); got != 1 {
+		t.Errorf("connect_retries = %d, want 1", got)
+	}
+}
+
+func TestRetryOnRefusedRetriesOnlyOnce(t *testing.T) {
+	upstream, _ := newStatusUpstream(t, http.StatusOK, "ok")
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: "a", RetryOnRefused: true})
+	dials := refuseFirstDials(srv, "api", 5)
+
+	if rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil)); rec.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadGateway)
+	}
+	if got := atomic.LoadInt32(dials); got != 2 {
+		t.Errorf("dials = %d, want 2 (one retry)", got)
+	}
+}
+
+func TestRetryOnRefusedDisabledOrIneligible(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		req     *http.Request
+	}{
+		{"disabled", false, httptest.NewRequest(http.MethodGet, "/", nil)},
+		{"non-idempotent", true, httptest.NewRequest(http.MethodPost, "/", nil)},
+		{"body over the replay cap", true, httptest.NewRequest(http.MethodPut, "/", strings.NewReader(strings.Repeat("x", maxReplayBodyBytes+1)))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream, _ := newStatusUpstream(t, http.StatusOK, "ok")
+			srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: "a", RetryOnRefused: tt.enabled})
+			dials := refuseFirstDials(srv, "api", 1)
+
+			if rec := serve(srv, tt.req); rec.Code != http.StatusBadGateway {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusBadGateway)
+			}
+			if got := atomic.LoadInt32(dials); got != 1 {
+				t.Errorf("dials = %d, want 1 (no retry)", got)
+			}
+		})
+	}
+}
+
+func TestRetryOnRefusedResendsBody(t *testing.T) {
+	var received atomic.Value
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received.Store(string(body))
+	}))
+	defer upstream.Close()
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: "a", RetryOnRefused: true})
+	dials := refuseFirstDials(srv, "api", 1)
+
+	req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader("payload"))
+	if rec := serve(srv, req); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := atomic.LoadInt32(dials); got != 2 {
+		t.Errorf("dials = %d, want 2", got)
+	}
+	if got := received.Load(); got != "payload" {
+		t.Errorf("upstream received body %q, want %q", got, "payload")
+	}
+}
+
+func TestRetryOnRefusedWithIdempotencyKey(t *testing.T) {
+	upstream, hits := newStatusUpstream(t, http.StatusOK, "ok")
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: "a",
+		RetryOnRefused: true, RetryIdempotencyKeys: true})
+	dials := refuseFirstDials(srv, "api", 1)
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set(idempotencyKeyHeader, "k-123")
+	if rec := serve(srv, req); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := atomic.LoadInt32(dials); got != 2 {
+		t.Errorf("dials = %d, want 2", got)
+	}
+	if got := atomic.LoadInt32(hits); got != 1 {
+		t.Errorf("upstream hits = %d, want 1", got)
+	}
+}
+
+// stubRoundTripper returns the queued errors before delegating to next
+type stubRoundTripper struct {
+	errs  []error
+	calls int
+}
+
+func (s *stubRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.calls++
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return nil, err
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+}
+
+func TestRefusedRetryTransportErrorClasses(t *testing.T) {
+	timeout := &net.OpError{Op: "dial", Err: context.DeadlineExceeded}
+
+	tests := []struct {
+		name      string
+		err       error
+		wantCalls int
+	}{
+		{"refused", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, 2},
+		{"reset", &net.OpError{Op: "read", Err: syscall.ECONNRESET}, 2},
+		{"timeout", timeout, 1},
+		{"other", errors.New("tls: bad certificate"), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &stubRoundTripper{errs: []error{tt.err}}
+			rt := &refusedRetryTransport{next: stub, upstream: &config.UpstreamConfig{Name: "api"}, metrics: &proxyMetrics{}}
+
+			rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://upstream/", nil))
+			if stub.calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", stub.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryBudgetExhaustedBySustainedFailures(t *testing.T) {
+	upstream, _ := newStatusUpstream(t, http.StatusOK, "ok")
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: "a", RetryOnRefused: true,
+		RetryBudget: config.RetryBudgetConfig{MaxTokens: 4, TokenRatio: 1}})
+	dials := refuseFirstDials(srv, "api", 100)
+
+	// The budget starts with 4 tokens and retries stop at half of that, so
+	// only the first two failures are retried
+	for i := 0; i < 5; i++ {
+		if rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil)); rec.Code != http.StatusBadGateway {
+			t.Fatalf("request %d: status = %d, want %d", i, rec.Code, http.StatusBadGateway)
+		}
+	}
+	if got := atomic.LoadInt32(dials); got != 7 {
+		t.Errorf("dials = %d, want 7 (5 requests, 2 retries)", got)
+	}
+	if got := srv.metrics.retriesDenied.Load(); got != 3 {
+		t.Errorf("retries_denied = %d, want 3", got)
+	}
+}
+
+func TestRetryBudgetRefilledBySuccesses(t *testing.T) {
+	budget := newRetryBudget(config.RetryBudgetConfig{MaxTokens: 2, TokenRatio: 0.5})
+	metrics := &proxyMetrics{}
+
+	if !budget.spend(metrics) {
+		t.Fatal("first retry denied, want allowed")
+	}
+	if budget.spend(metrics) {
+		t.Fatal("retry allowed at half the budget, want denied")
+	}
+	budget.success()
+	budget.success()
+	if !budget.spend(metrics) {
+		t.Error("retry denied after successes refilled the budget, want allowed")
+	}
+	if got := metrics.retriesDenied.Load(); got != 1 {
+		t.Errorf("retries_denied = %d, want 1", got)
+	}
+}
+
+func TestRefusedRetryTransportLeavesRequestUntouched(t *testing.T) {
+	stub := &stubRoundTripper{errs: []error{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}}
+	rt := &refusedRetryTransport{next: stub, upstream: &config.UpstreamConfig{Name: "api"}, metrics: &proxyMetrics{}}
+
+	req := httptest.NewRequest(http.MethodPut, "http://upstream/", strings.NewReader("payload"))
+	req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("payload")), nil }
+	body := req.Body
+
+	rt.RoundTrip(req)
+	if stub.calls != 2 {
+		t.Fatalf("calls = %d, want 2", stub.calls)
+	}
+	if req.Body != body {
+		t.Error("retry replaced the caller's request body")
+	}
+}
@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+const (
+	targetUpstreamHeader  = "X-Target-Upstream"
+	targetSignatureHeader = "X-Target-Upstream-Signature"
+)
+
+// routingClient is a parsed routing.allowed_clients entry
+type routingClient struct {
+	prefix    netip.Prefix
+	upstreams map[string]bool
+}
+
+// newRoutingClients parses the routing allow-list
+func newRoutingClients(clients []config.RoutingClientConfig) ([]routingClient, error) {
+	parsed := make([]routingClient, 0, len(clients))
+	for _, client := range clients {
+		prefix, err := client.Prefix()
+		if err != nil {
+			return nil, err
+		}
+		upstreams := make(map[string]bool, len(client.Upstreams))
+		for _, name := range client.Upstreams {
+			upstreams[name] = true
+		}
+		parsed = append(parsed, routingClient{prefix: prefix, upstreams: upstreams})
+	}
+	return parsed, nil
+}
+
+// signUpstream returns the X-Target-Upstream-Signature value selecting the
+// named upstream for a method and path request made at ts
+func signUpstream(secret string, ts time.Time, method, path, name string) string {
+	unix := strconv.FormatInt(ts.Unix(), 10)
+	return "t=" + unix + ",sig=" + hex.EncodeToString(routingMAC(secret, unix, method, path, name))
+}
+
+// routingMAC is the HMAC-SHA256 a routing signature carries
+func routingMAC(secret, unix, method, path, name string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unix + "\n" + method + "\n" + path + "\n" + name))
+	return mac.Sum(nil)
+}
+
+// checkRoutingSignature verifies the request's routing signature for the
+// named upstream, returning why it is refused: the MAC must match, its
+// timestamp must be within routing.signature_max_age of now, and it must not
+// have been presented before. Dry runs leave the signature unused.
+func (s *Server) checkRoutingSignature(r *http.Request, name string) string {
+	stamp, sig, _ := strings.Cut(r.Header.Get(targetSignatureHeader), ",")
+	unix, okStamp := strings.CutPrefix(stamp, "t=")
+	sig, okSig := strings.CutPrefix(sig, "sig=")
+	seconds, err := strconv.ParseInt(unix, 10, 64)
+	provided, hexErr := hex.DecodeString(sig)
+	if !okStamp || !okSig || err != nil || hexErr != nil ||
+		!hmac.Equal(provided, routingMAC(s.config.Routing.SigningSecret, unix, r.Method, r.URL.Path, name)) {
+		return "missing or invalid signature"
+	}
+
+	maxAge := time.Duration(s.config.Routing.SignatureMaxAge) * time.Second
+	now := time.Now()
+	signed := time.Unix(seconds, 0)
+	if now.Sub(signed) > maxAge || signed.Sub(now) > maxAge {
+		return "signature expired"
+	}
+	if dryRun, _ := r.Context().Value(dryRunKey{}).(bool); dryRun {
+		return ""
+	}
+	// Keyed on the decoded MAC, so re-encoding the hex cannot replay it
+	if !s.usedSignatures.claim(string(provided), now, signed.Add(maxAge)) {
+		return "signature already used"
+	}
+	return ""
+}
+
+// dryRunKey marks a request context as routed only to be explained, not
+// proxied
+type dryRunKey struct{}
+
+// usedSignatures remembers accepted routing signatures until they expire,
+// so each is accepted once
+type usedSignatures struct {
+	mu      sync.Mutex
+	expires map[string]time.Time
+	pruned  time.Time // expired entries are dropped at most once a second
+}
+
+func newUsedSignatures() *usedSignatures {
+	return &usedSignatures{expires: make(map[string]time.Time)}
+}
+
+// claim records sig as used until expiry, reporting false if it already was
+func (u *usedSignatures) claim(sig string, now, expiry time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if _, used := u.expires[sig]; used {
+		return false
+	}
+	if now.Sub(u.pruned) >= time.Second {
+		for seen, at := range u.expires {
+			if now.After(at) {
+				delete(u.expires, seen)
+			}
+		}
+		u.pruned = now
+	}
+	u.expires[sig] = expiry
+	return true
+}
+
+// routingAllowed reports whether the client may select the named upstream:
+// the signature must be valid when a signing secret is configured (see
+// checkRoutingSignature), and the client's address must be allowed that
+// upstream when an allow-list is
+func (s *Server) routingAllowed(r *http.Request, name string) bool {
+	if s.config.Routing.SigningSecret != "" {
+		if reason := s.checkRoutingSignature(r, name); reason != "" {
+			logger.Warn("Ignoring unsigned or invalid routing header",
+				"upstream", name, "reason", reason, "remote_addr", r.RemoteAddr)
+			return false
+		}
+	}
+
+	if s.config.Routing.AllowedClients != nil {
+		addr, err := netip.ParseAddr(clientIP(r))
+		if err == nil {
+			addr = addr.Unmap()
+			for _, client := range s.routingClients {
+				if client.prefix.Contains(addr) && client.upstreams[name] {
+					return true
+				}
+			}
+		}
+		logger.Warn("Ignoring routing header not allowed for client",
+			"upstream", name, "remote_addr", r.RemoteAddr)
+		return false
+	}
+
+	return true
+}
+
+// hostUpstreams returns the upstreams whose host override or URL host
+// matches the request's Host, ignoring any port
+func (s *Server) hostUpstreams(r *http.Request) []*config.UpstreamConfig {
+	host := stripPort(r.Host)
+	if host == "" {
+		return nil
+	}
+	var matched []*config.UpstreamConfig
+	for i := range s.config.Upstreams {
+		upstream := &s.config.Upstreams[i]
+		candidate := stripPort(upstream.Host)
+		if candidate == "" {
+			if u, err := url.Parse(upstream.URL); err == nil {
+				candidate = u.Hostname()
+			}
+		}
+		if candidate != "" && strings.EqualFold(candidate, host) {
+			matched = append(matched, upstream)
+		}
+	}
+	return matched
+}
+
+// allowHostConflict applies routing.host_conflict to a request routed by
+// X-Target-Upstream, reporting whether it may still be proxied to upstream.
+// There is a conflict when the Host matches other upstreams but not this one.
+func (s *Server) allowHostConflict(w http.ResponseWriter, r *http.Request, upstream *config.UpstreamConfig) bool {
+	matched := s.hostUpstreams(r)
+	if len(matched) == 0 {
+		return true
+	}
+	names := make([]string, 0, len(matched))
+	for _, m := range matched {
+		if m.Name == upstream.Name {
+			return true
+		}
+		names = append(names, m.Name)
+	}
+
+	attrs := []any{
+		"upstream", upstream.Name,
+		"host", r.Host,
+		"host_upstreams", strings.Join(names, ","),
+		"path", r.URL.Path,
+		"remote_addr", r.RemoteAddr,
+	}
+	switch s.config.Routing.HostConflict {
+	case config.HostConflictReject:
+		logger.Warn("Routing header conflicts with Host, rejecting request", attrs...)
+		http.Error(w, "Bad Request: X-Target-Upstream conflicts with Host", http.StatusBadRequest)
+		return false
+	case config.HostConflictWarn:
+		logger.Warn("Routing header conflicts with Host, using header", attrs...)
+	default:
+		logger.Info("Routing header conflicts with Host, using header", attrs...)
+	}
+	return true
+}
+
+// stripPort returns host without any port, unbracketing IPv6 literals
+func stripPort(host string) string {
+	return (&url.URL{Host: host}).Hostname()
+}
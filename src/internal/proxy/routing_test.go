@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+// newRoutingServer returns a server whose "default" and "internal" upstreams
+// answer with their own name and the signature header they received
+func newRoutingServer(t *testing.T, routing config.RoutingConfig) *Server {
+	t.Helper()
+	named := func(name string) string {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + r.Header.Get(targetSignatureHeader)))
+		}))
+		t.Cleanup(upstream.Close)
+		return upstream.URL
+	}
+
+	return newTestServerWithConfig(t, &config.Config{
+		Routing: routing,
+		Upstreams: []config.UpstreamConfig{
+			{Name: "default", URL: named("default"), Audience: "d"},
+			{Name: "internal", URL: named("internal"), Audience: "i"},
+		},
+	})
+}
+
+func TestSignedRoutingHeader(t *testing.T) {
+	srv := newRoutingServer(t, config.RoutingConfig{SigningSecret: "s3cret", SignatureMaxAge: 60})
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		signature string
+		want      string
+	}{
+		{"valid", signUpstream("s3cret", now, http.MethodGet, "/", "internal"), "internal"},
+		{"missing", "", "default"},
+		{"wrong secret", signUpstream("guess", now, http.MethodGet, "/", "internal"), "default"},
+		{"signed for another upstream", signUpstream("s3cret", now, http.MethodGet, "/", "default"), "default"},
+		{"signed for another method", signUpstream("s3cret", now, http.MethodPost, "/", "internal"), "default"},
+		{"signed for another path", signUpstream("s3cret", now, http.MethodGet, "/other", "internal"), "default"},
+		{"expired", signUpstream("s3cret", now.Add(-2*time.Minute), http.MethodGet, "/", "internal"), "default"},
+		{"from the future", signUpstream("s3cret", now.Add(2*time.Minute), http.MethodGet, "/", "internal"), "default"},
+		{"no timestamp", strings.TrimPrefix(signUpstream("s3cret", now, http.MethodGet, "/", "internal"), "t="), "default"},
+		{"not hex", "t=" + strconv.FormatInt(now.Unix(), 10) + ",sig=zz", "default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(targetUpstreamHeader, "internal")
+			if tt.signature != "" {
+				req.Header.Set(targetSignatureHeader, tt.signature)
+			}
+			// The signature is consumed by the gateway, never forwarded
+			if rec := serve(srv, req); rec.Body.String() != tt.want {
+				t.Errorf("routed to %q, want %q", rec.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestSignedRoutingHeaderReplayed(t *testing.T) {
+	srv := newRoutingServer(t, config.RoutingConfig{SigningSecret: "s3cret", SignatureMaxAge: 60})
+	srv.config.Admin.Token = "admin"
+	signature := signUpstream("s3cret", time.Now(), http.MethodGet, "/", "internal")
+
+	route := func(signature string) string {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(targetUpstreamHeader, "internal")
+		req.Header.Set(targetSignatureHeader, signature)
+		return serve(srv, req).Body.String()
+	}
+
+	// Explaining a signed request does not use up its signature
+	req := adminRequest(http.MethodPost, "/admin/explain", "admin")
+	req.Body = io.NopCloser(strings.NewReader(`{"path":"/","headers":{"X-Target-Upstream":"internal","X-Target-Upstream-Signature":"` + signature + `"}}`))
+	if body := serve(srv, req).Body.String(); !strings.Contains(body, `"upstream":"internal"`) {
+		t.Errorf("explain = %s, want the signed upstream", body)
+	}
+
+	if got := route(signature); got != "internal" {
+		t.Fatalf("first use routed to %q, want internal", got)
+	}
+	if got := route(signature); got != "default" {
+		t.Errorf("replayed signature routed to %q, want default", got)
+	}
+	stamp, sig, _ := strings.Cut(signature, ",sig=")
+	if got := route(stamp + ",sig=" + strings.ToUpper(sig)); got != "default" {
+		t.Errorf("re-encoded replay routed to %q, want default", got)
+	}
+}
+
+func TestUsedSignaturesForgetExpired(t *testing.T) {
+	used := newUsedSignatures()
+	now := time.Now()
+	if !used.claim("a", now, now.Add(time.Minute)) || used.claim("a", now, now.Add(time.Minute)) {
+		t.Fatal("claim() should accept a signature once")
+	}
+
+	later := now.Add(2 * time.Minute)
+	used.claim("b", later, later.Add(time.Minute))
+	if _, kept := used.expires["a"]; kept {
+		t.Error("expired signature not forgotten")
+	}
+}
+
+func TestRoutingAllowedClients(t *testing.T) {
+	srv := newRoutingServer(t, config.RoutingConfig{
+		AllowedClients: []config.RoutingClientConfig{
+			{CIDR: "10.0.0.0/8", Upstreams: []string{"internal"}},
+			{CIDR: "192.0.2.7", Upstreams: []string{"default"}},
+		},
+	})
+
+	tests := []struct {
+		remoteAddr string
+		want       string
+	}{
+		{"10.1.2.3:5000", "internal"},
+		{"[::ffff:10.1.2.3]:5000", "internal"},
+		{"192.0.2.7:5000", "default"}, // allowed, but not this upstream
+		{"203.0.113.9:5000", "default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.remoteAddr, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set(targetUpstreamHeader, "internal")
+			if rec := serve(srv, req); rec.Body.String() != tt.want {
+				t.Errorf("routed to %q, want %q", rec.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestRoutingUnrestrictedByDefault(t *testing.T) {
+	srv := newRoutingServer(t, config.RoutingConfig{})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(targetUpstreamHeader, "internal")
+	if rec := serve(srv, req); rec.Body.String() != "internal" {
+		t.Errorf("routed to %q, want %q", rec.Body.String(), "internal")
+	}
+}
+
+func TestRoutingHostConflict(t *testing.T) {
+	named := func(name string) string {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+		t.Cleanup(upstream.Close)
+		return upstream.URL
+	}
+	urlA, urlB := named("a"), named("b")
+
+	tests := []struct {
+		mode       string
+		wantStatus int
+		wantBody   string
+		wantLog    string
+	}{
+		{"", http.StatusOK, "a", "[INFO]"},
+		{config.HostConflictHonor, http.StatusOK, "a", "[INFO]"},
+		{config.HostConflictWarn, http.StatusOK, "a", "[WARN]"},
+		{config.HostConflictReject, http.StatusBadRequest, "", "[WARN]"},
+	}
+
+	for _, tt := range tests {
+		t.Run("mode="+tt.mode, func(t *testing.T) {
+			srv := newTestServerWithConfig(t, &config.Config{
+				Routing: config.RoutingConfig{HostConflict: tt.mode},
+				Upstreams: []config.UpstreamConfig{
+					{Name: "a", URL: urlA, Audience: "a", Host: "a.example.com"},
+					{Name: "b", URL: urlB, Audience: "b", Host: "b.example.com"},
+				},
+			})
+			logger.SetLevel("info")
+			buf := captureLogs(t)
+
+			// The Host agrees with the header: no conflict
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = "A.example.com:8080"
+			req.Header.Set(targetUpstreamHeader, "a")
+			if rec := serve(srv, req); rec.Body.String() != "a" {
+				t.Fatalf("matching Host routed to %q, want a", rec.Body.String())
+			}
+			if strings.Contains(buf.String(), "conflicts with Host") {
+				t.Fatalf("conflict logged for a matching Host; logs:\n%s", buf.String())
+			}
+
+			req = httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = "b.example.com"
+			req.Header.Set(targetUpstreamHeader, "a")
+			rec := serve(srv, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("routed to %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+			logs := buf.String()
+			if !strings.Contains(logs, tt.wantLog+" Routing header conflicts with Host") ||
+				!strings.Contains(logs, "host_upstreams=b") {
+				t.Errorf("conflict not logged at %s; logs:\n%s", tt.wantLog, logs)
+			}
+		})
+	}
+}
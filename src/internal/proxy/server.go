@@ -1,28 +1,74 @@
 package proxy
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
+	"io"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"golang.org/x/oauth2"
+
 	"go-oauth2-proxy/src/internal/config"
+	"go-oauth2-proxy/src/internal/lifecycle"
 	"go-oauth2-proxy/src/internal/logger"
 	"go-oauth2-proxy/src/internal/token"
 )
 
 // Server represents the proxy server
 type Server struct {
-	config       *config.Config
-	tokenManager *token.Manager
-	httpServer   *http.Server
-	upstreamMap  map[string]*config.UpstreamConfig
+	config         *config.Config
+	tokenManager   *token.Manager
+	httpServer     *http.Server
+	upstreamMap    map[string]*config.UpstreamConfig
+	targets        map[string]*url.URL // parsed upstream URLs; pass-through upstreams have none
+	coalescer      *coalescer
+	caches         map[string]*responseCache
+	breakers       map[string]*circuitBreaker
+	limiters       map[string]*outboundLimiter // upstreams with an outbound_rate_limit
+	retryBudgets   map[string]*retryBudget     // upstreams with a retry_budget
+	transports     map[string]*http.Transport
+	skipped        map[string]string  // upstreams failing their startup checks under fail_open, with the error
+	upstreamMu     sync.RWMutex       // guards transports and skipped as skipped upstreams recover
+	stopRecheck    context.CancelFunc // stops rechecking skipped upstreams; nil if none were skipped
+	stopStats      context.CancelFunc // stops the periodic stats log; nil unless logging.stats_interval is set
+	discovery      *discoveryCache    // nil unless discovery.upstream is set
+	audienceLabels *labelCap          // audience label values in the OpenMetrics output
+	refreshes      *refreshTotals     // monotonic per-audience refresh counters
+	routingClients []routingClient
+	usedSignatures *usedSignatures
+	requestSlots   chan struct{} // semaphore for max_concurrent_requests; nil if unlimited
+	tee            *teeSink      // debug copy of sampled traffic; nil unless enabled
+	metrics        *proxyMetrics
+	started        time.Time
+	draining       atomic.Bool
+	drainRequested chan struct{} // closed by POST /admin/drain
+	activeStreams  atomic.Int64  // in-flight requests to streaming upstreams
+	requestsCtx    context.Context
+	cancelRequests context.CancelFunc // cancels in-flight requests once shutdown_timeout passes
+	streamsCtx     context.Context
+	cancelStreams  context.CancelFunc // cancels in-flight streams once streaming_shutdown_timeout passes
+	lifecycle      *lifecycle.Recorder
+	drainOnce      sync.Once
 }
 
+// statusClientClosedRequest is the non-standard status (popularized by nginx)
+// recorded when the client disconnects before the upstream responds
+const statusClientClosedRequest = 499
+
 // NewServer creates a new proxy server
 func NewServer(cfg *config.Config) (*Server, error) {
 	// Create token manager
@@ -31,6 +77,39 @@ func NewServer(cfg *config.Config) (*Server, error) {
 		"", // Will use GOOGLE_APPLICATION_CREDENTIALS env var
 		cfg.Token.RefreshBeforeExpiry,
 	)
+	tm.SetExpiryBoundaries(
+		time.Duration(cfg.Token.ClockSkew)*time.Second,
+		time.Duration(cfg.Token.ExpiryGrace)*time.Second)
+
+	var creds map[string]string
+	if cfg.Token.CredentialsMap != "" {
+		var err error
+		creds, err = token.LoadCredentialsMap(cfg.Token.CredentialsMap)
+		if err != nil {
+			tm.Close()
+			return nil, err
+		}
+		tm.SetCredentialsMap(creds)
+		logger.Info("Loaded credentials map", "path", cfg.Token.CredentialsMap, "audiences", len(creds))
+	}
+
+	tm.SetMaxEntries(cfg.Token.MaxEntries)
+	tm.SetMaxTokenAge(time.Duration(cfg.Token.MaxTokenAge) * time.Minute)
+
+	// Apply per-upstream token refresh windows and file token sources
+	for _, upstream := range cfg.Upstreams {
+		if upstream.RefreshBeforeExpiry > 0 && !upstream.PassThrough {
+			tm.SetRefreshBeforeExpiry(upstream.Audience, time.Duration(upstream.RefreshBeforeExpiry)*time.Minute)
+		}
+		if upstream.MinTokenLifetime > 0 && !upstream.PassThrough {
+			tm.SetMinTokenLifetime(upstream.Audience, time.Duration(upstream.MinTokenLifetime)*time.Second)
+		}
+		if tf := upstream.TokenFile; tf.Path != "" {
+			tm.SetAudienceSource(upstream.Audience, func(ctx context.Context, audience string) (oauth2.TokenSource, error) {
+				return token.NewFileSource(tf.Path, tf.ExpiryPath, time.Duration(tf.TTL)*time.Second), nil
+			})
+		}
+	}
 
 	// Build upstream map
 	upstreamMap := make(map[string]*config.UpstreamConfig)
@@ -38,10 +117,130 @@ func NewServer(cfg *config.Config) (*Server, error) {
 		upstreamMap[cfg.Upstreams[i].Name] = &cfg.Upstreams[i]
 	}
 
+	// Parse upstream URLs once; pass-through upstreams resolve theirs per request
+	targets := make(map[string]*url.URL)
+	for _, upstream := range cfg.Upstreams {
+		if upstream.PassThrough {
+			continue
+		}
+		target, err := parseUpstreamURL(upstream.URL)
+		if err != nil {
+			tm.Close()
+			return nil, fmt.Errorf("upstream %s: %w", upstream.Name, err)
+		}
+		targets[upstream.Name] = target
+	}
+
+	// Build response caches for upstreams that enable them
+	caches := make(map[string]*responseCache)
+	for _, upstream := range cfg.Upstreams {
+		if upstream.Cache.Enabled {
+			caches[upstream.Name] = newResponseCache(upstream.Cache)
+		}
+	}
+
+	// Build circuit breakers with per-upstream overrides
+	breakers := make(map[string]*circuitBreaker)
+	if cfg.CircuitBreaker.Enabled {
+		for i := range cfg.Upstreams {
+			upstream := &cfg.Upstreams[i]
+			breakers[upstream.Name] = newCircuitBreaker(upstream.Name, upstream.BreakerSettings(cfg.CircuitBreaker))
+		}
+	}
+
+	// Build outbound rate limiters for upstreams that set one
+	limiters := make(map[string]*outboundLimiter)
+	for _, upstream := range cfg.Upstreams {
+		if upstream.OutboundRateLimit.RPS > 0 {
+			limiters[upstream.Name] = newOutboundLimiter(upstream.OutboundRateLimit)
+		}
+	}
+	retryBudgets := make(map[string]*retryBudget)
+	for _, upstream := range cfg.Upstreams {
+		if upstream.RetryBudget.MaxTokens > 0 {
+			retryBudgets[upstream.Name] = newRetryBudget(upstream.RetryBudget)
+		}
+	}
+
+	// Build per-upstream transports with their own connection timeouts,
+	// skipping upstreams that fail their startup checks when failing open
+	transports := make(map[string]*http.Transport)
+	skipped := make(map[string]string)
+	for _, upstream := range cfg.Upstreams {
+		transport, err := checkUpstream(upstream, creds)
+		if err != nil {
+			if cfg.Server.StartupFailure != config.StartupFailOpen {
+				tm.Close()
+				return nil, fmt.Errorf("upstream %s: %w", upstream.Name, err)
+			}
+			logger.Error("Upstream failed its startup checks, skipping it until they pass",
+				"upstream", upstream.Name,
+				"error", err)
+			skipped[upstream.Name] = err.Error()
+			continue
+		}
+		transports[upstream.Name] = transport
+	}
+
+	routingClients, err := newRoutingClients(cfg.Routing.AllowedClients)
+	if err != nil {
+		tm.Close()
+		return nil, fmt.Errorf("routing: %w", err)
+	}
+
+	var tlsConfig *tls.Config
+	if cfg.Server.TLS.Enabled() {
+		if tlsConfig, err = cfg.Server.TLS.Config(); err != nil {
+			tm.Close()
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+	}
+
+	var tee *teeSink
+	if cfg.Tee.Enabled {
+		if tee, err = newTeeSink(cfg.Tee); err != nil {
+			tm.Close()
+			return nil, fmt.Errorf("tee: %w", err)
+		}
+		logger.Info("Traffic tee enabled", "sample_rate", cfg.Tee.SampleRate, "file", cfg.Tee.File, "url", cfg.Tee.URL)
+	}
+
 	srv := &Server{
-		config:       cfg,
-		tokenManager: tm,
-		upstreamMap:  upstreamMap,
+		config:         cfg,
+		tokenManager:   tm,
+		upstreamMap:    upstreamMap,
+		targets:        targets,
+		coalescer:      &coalescer{},
+		caches:         caches,
+		breakers:       breakers,
+		limiters:       limiters,
+		retryBudgets:   retryBudgets,
+		transports:     transports,
+		skipped:        skipped,
+		routingClients: routingClients,
+		usedSignatures: newUsedSignatures(),
+		tee:            tee,
+		metrics:        newProxyMetrics(upstreamNames(cfg.Upstreams)),
+		audienceLabels: newLabelCap("audience", cfg.Server.MetricsMaxAudiences),
+		refreshes:      newRefreshTotals(),
+		started:        time.Now(),
+		drainRequested: make(chan struct{}),
+		lifecycle:      lifecycle.NewRecorder(),
+	}
+	srv.requestsCtx, srv.cancelRequests = context.WithCancel(context.Background())
+	srv.streamsCtx, srv.cancelStreams = context.WithCancel(context.Background())
+	if cfg.Server.MaxConcurrentRequests > 0 {
+		srv.requestSlots = make(chan struct{}, cfg.Server.MaxConcurrentRequests)
+	}
+	if len(skipped) > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		srv.stopRecheck = cancel
+		go srv.recheckSkipped(ctx)
+	}
+	if interval := cfg.Logging.StatsInterval; interval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		srv.stopStats = cancel
+		go srv.logStats(ctx, time.Duration(interval)*time.Second)
 	}
 
 	// Setup HTTP server
@@ -50,14 +249,38 @@ func NewServer(cfg *config.Config) (*Server, error) {
 	mux.HandleFunc("/readyz", srv.handleReady)
 	mux.HandleFunc("/metrics", srv.handleMetrics)
 	mux.HandleFunc("/token-info", srv.handleTokenInfo)
+	mux.HandleFunc("/admin/metrics/reset", srv.requireAdmin(http.MethodPost, srv.handleMetricsReset))
+	mux.HandleFunc("/admin/drain", srv.requireAdmin(http.MethodPost, srv.handleDrain))
+	mux.HandleFunc("/admin/explain", srv.requireAdmin(http.MethodPost, srv.handleExplain))
+	mux.HandleFunc("/admin/preload", srv.requireAdmin(http.MethodPost, srv.handlePreload))
+	mux.HandleFunc("/debug/config", srv.requireAdmin(http.MethodGet, srv.handleDebugConfig))
+	if cfg.Server.Expvar {
+		publishExpvar(srv)
+		// Go's own vars (cmdline, memstats) are served too, so admin only
+		mux.HandleFunc("/debug/vars", srv.requireAdmin(http.MethodGet, expvar.Handler().ServeHTTP))
+	}
+	for _, agg := range cfg.Aggregates {
+		mux.HandleFunc(agg.Path, srv.handleAggregate(agg))
+	}
+	if name := cfg.Discovery.Upstream; name != "" {
+		srv.discovery = newDiscoveryCache(srv.upstreamMap[name], time.Duration(cfg.Discovery.CacheTTL)*time.Second, cfg.Discovery.PublicURL)
+		mux.HandleFunc(discoveryPath, srv.handleDiscovery)
+		mux.HandleFunc(jwksPath, srv.handleDiscovery)
+	}
 	mux.HandleFunc("/", srv.handleProxy)
 
+	var handler http.Handler = mux
+	if cfg.Server.NormalizePaths {
+		handler = srv.normalizePaths(mux)
+	}
+
 	srv.httpServer = &http.Server{
 		Addr:         cfg.Server.GetAddress(),
-		Handler:      srv.loggingMiddleware(mux),
+		Handler:      srv.loggingMiddleware(handler),
 		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout) * time.Second,
 		WriteTimeout: time.Duration(cfg.Server.WriteTimeout) * time.Second,
 		IdleTimeout:  time.Duration(cfg.Server.IdleTimeout) * time.Second,
+		TLSConfig:    tlsConfig,
 	}
 
 	return srv, nil
@@ -76,14 +299,26 @@ func (s *Server) Start() error {
 			"audience", upstream.Audience)
 	}
 
-	return s.httpServer.ListenAndServe()
+	ln, err := s.listenConfig().Listen(context.Background(), "tcp", s.httpServer.Addr)
+	if err != nil {
+		return err
+	}
+	return s.serve(ln)
 }
 
-// Shutdown gracefully shuts down the server
-func (s *Server) Shutdown() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-	return s.httpServer.Shutdown(ctx)
+// serve accepts connections on ln, enforcing the configured connection limit
+func (s *Server) serve(ln net.Listener) error {
+	if s.config.Server.MaxConnections > 0 {
+		logger.Info("Connection limit enabled", "max_connections", s.config.Server.MaxConnections)
+	}
+	if s.requestSlots != nil {
+		logger.Info("Concurrency limit enabled", "max_concurrent_requests", cap(s.requestSlots))
+	}
+	s.lifecycle.Emit(lifecycle.Listening, "address", ln.Addr().String(), "tls", s.config.Server.TLS.Enabled())
+	if tlsCfg := s.config.Server.TLS; tlsCfg.Enabled() {
+		return s.httpServer.ServeTLS(s.wrapListener(ln), tlsCfg.CertFile, tlsCfg.KeyFile)
+	}
+	return s.httpServer.Serve(s.wrapListener(ln))
 }
 
 // loggingMiddleware logs all HTTP requests
@@ -91,26 +326,51 @@ func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
+		// Propagate or assign a request ID
+		requestID := r.Header.Get(requestIDHeader)
+		if requestID == "" {
+			requestID = newRequestID()
+			r.Header.Set(requestIDHeader, requestID)
+		}
+		w.Header().Set(requestIDHeader, requestID)
+
+		// Track per-request details filled in by handlers
+		info := &requestInfo{requestID: requestID}
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+
+		s.logHeaders("Request header", r.Header)
+
+		// Count request body bytes as they are read
+		var body *countingBody
+		if r.Body != nil && r.Body != http.NoBody {
+			body = &countingBody{ReadCloser: r.Body}
+			r.Body = body
+		}
+
 		// Wrap response writer to capture status code
 		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 
 		next.ServeHTTP(wrapped, r)
 
-		duration := time.Since(start)
+		if info.upstream != "" {
+			var bytesIn int64
+			if body != nil {
+				bytesIn = body.n.Load()
+			}
+			s.metrics.recordTraffic(info.upstream, bytesIn, wrapped.bytesWritten)
+		}
 
-		logger.Info("Request",
-			"method", r.Method,
-			"path", r.URL.Path,
-			"remote_addr", r.RemoteAddr,
-			"status", wrapped.statusCode,
-			"duration_ms", duration.Milliseconds(),
-			"user_agent", r.Header.Get("User-Agent"))
+		duration := time.Since(start)
+		s.logAccess(r, wrapped, info, duration)
+		s.logSlowRequest(r, wrapped, info, duration)
 	})
 }
 
 type responseWriter struct {
 	http.ResponseWriter
-	statusCode int
+	statusCode   int
+	bytesWritten int64 // bytes the client connection accepted
+	writeErr     error // first write error (e.g., client disconnect)
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
@@ -118,8 +378,62 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Write counts only the bytes actually written, which may be fewer than
+// len(b) when the write fails partway
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytesWritten += int64(n)
+	if err != nil && rw.writeErr == nil {
+		rw.writeErr = err
+	}
+	return n, err
+}
+
+// Flush implements http.Flusher for handlers that type-assert instead of
+// using http.ResponseController
+func (rw *responseWriter) Flush() {
+	rw.FlushError()
+}
+
+// FlushError flushes buffered data to the client, used by
+// http.ResponseController; once a write has failed it returns that error
+// without flushing
+func (rw *responseWriter) FlushError() error {
+	if rw.writeErr != nil {
+		return rw.writeErr
+	}
+	return http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+// Hijack implements http.Hijacker when the underlying writer supports it
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(rw.ResponseWriter).Hijack()
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+// (flushing, connection deadlines)
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// countingBody counts the bytes read from a request body
+type countingBody struct {
+	io.ReadCloser
+	n atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
 // handleHealth handles health check requests
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if wantsJSON(r) {
+		s.writeHealthJSON(w, "ok", http.StatusOK)
+		return
+	}
 	w.Header().Set("Content-Type", "text/plain")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
@@ -127,26 +441,70 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 
 // handleReady handles readiness check requests
 func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if s.draining.Load() {
+		if wantsJSON(r) {
+			s.writeHealthJSON(w, "draining", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("DRAINING"))
+		return
+	}
+	// Critical upstreams gate readiness; the others never do
+	if unhealthy := s.unhealthyCritical(); len(unhealthy) > 0 {
+		logger.Debug("Not ready: critical upstreams unhealthy", "upstreams", strings.Join(unhealthy, ","))
+		if wantsJSON(r) {
+			s.writeHealthJSON(w, "unhealthy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("UNHEALTHY: " + strings.Join(unhealthy, ", ")))
+		return
+	}
+	if wantsJSON(r) {
+		s.writeHealthJSON(w, "ready", http.StatusOK)
+		return
+	}
 	w.Header().Set("Content-Type", "text/plain")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("READY"))
 }
 
-// handleMetrics returns server metrics
+// handleRoot serves the configured root response
+func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
+	root := s.config.Server.RootResponse
+	w.Header().Set("Content-Type", root.ContentType)
+	w.WriteHeader(root.Status)
+	if r.Method != http.MethodHead {
+		w.Write([]byte(root.Body))
+	}
+}
+
+// handleMetrics returns server metrics as JSON in the requested schema
+// (?schema=, else server.metrics_schema), or as OpenMetrics when the client
+// asks for it in Accept
 func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
-	stats := s.tokenManager.GetStats()
+	if wantsOpenMetrics(r) || wantsPrometheus(r) {
+		s.writeOpenMetrics(w, !wantsOpenMetrics(r))
+		return
+	}
 
-	metrics := map[string]interface{}{
-		"tokens_cached":    stats.TotalCached,
-		"tokens_refreshed": stats.TotalRefreshed,
-		"tokens_rejected":  stats.TotalRejected,
-		"tokens_errors":    stats.TotalErrors,
-		"upstreams_count":  len(s.config.Upstreams),
+	schema := r.URL.Query().Get("schema")
+	if schema == "" {
+		schema = s.config.Server.MetricsSchema
 	}
 
-	if stats.TotalCached > 0 {
-		metrics["oldest_token_age"] = time.Since(stats.OldestToken).String()
-		metrics["newest_token_age"] = time.Since(stats.NewestToken).String()
+	var metrics map[string]interface{}
+	switch schema {
+	case "", config.MetricsSchemaV1:
+		metrics = s.metricsV1()
+	case config.MetricsSchemaV2:
+		metrics = s.metricsV2()
+	default:
+		http.Error(w, fmt.Sprintf("unknown metrics schema %q (want v1 or v2)", schema), http.StatusBadRequest)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -167,6 +525,7 @@ func (s *Server) handleTokenInfo(w http.ResponseWriter, r *http.Request) {
 			"audience":       audience,
 			"state":          meta.State,
 			"issued_at":      meta.IssuedAt.Format(time.RFC3339),
+			"last_refreshed": meta.LastRefreshed.Format(time.RFC3339),
 			"expires_at":     meta.ExpiresAt.Format(time.RFC3339),
 			"expires_in":     time.Until(meta.ExpiresAt).String(),
 			"last_used":      meta.LastUsed.Format(time.RFC3339),
@@ -181,6 +540,19 @@ func (s *Server) handleTokenInfo(w http.ResponseWriter, r *http.Request) {
 	}
 	response["tokens"] = tokens
 
+	upstreams := make([]map[string]interface{}, 0, len(s.config.Upstreams))
+	for _, upstream := range s.config.Upstreams {
+		info := map[string]interface{}{
+			"name":     upstream.Name,
+			"audience": upstream.Audience,
+		}
+		if breaker := s.breakers[upstream.Name]; breaker != nil {
+			info["circuit_breaker"] = breaker.snapshot()
+		}
+		upstreams = append(upstreams, info)
+	}
+	response["upstreams"] = upstreams
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
@@ -189,6 +561,38 @@ func (s *Server) handleTokenInfo(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
 
+	// Answer root probes locally when configured
+	if r.URL.Path == "/" && s.config.Server.RootResponse.Enabled &&
+		(r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		s.handleRoot(w, r)
+		return
+	}
+
+	// Shed load once the global concurrency limit is reached
+	if !s.acquireRequestSlot() {
+		s.metrics.concurrencyRejections.Add(1)
+		logger.Warn("Concurrency limit reached, rejecting request",
+			"path", r.URL.Path,
+			"max_concurrent_requests", cap(s.requestSlots),
+			"remote_addr", r.RemoteAddr)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Service Unavailable: too many concurrent requests", http.StatusServiceUnavailable)
+		return
+	}
+	defer s.releaseRequestSlot()
+
+	// Reject requests that have already passed through too many gateways
+	hops := gatewayHops(r)
+	if maxHops := s.config.Server.MaxHops; maxHops > 0 && hops >= maxHops {
+		logger.Error("Request loop detected",
+			"path", r.URL.Path,
+			"hops", hops,
+			"max_hops", maxHops,
+			"remote_addr", r.RemoteAddr)
+		http.Error(w, "Loop Detected", http.StatusLoopDetected)
+		return
+	}
+
 	// Check if path is allowed (if filtering is enabled)
 	if !s.isPathAllowed(r.URL.Path) {
 		logger.Warn("Path not allowed", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
@@ -196,13 +600,87 @@ func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The gateway supplies the upstream's credentials; clients sending
+	// their own are refused when configured to
+	if s.config.Server.ClientAuthorization == config.ClientAuthorizationReject && len(r.Header.Values("Authorization")) > 0 {
+		logger.Warn("Client sent an Authorization header, rejecting request", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+		http.Error(w, "Bad Request: Authorization header not accepted", http.StatusBadRequest)
+		return
+	}
+
 	// Determine upstream
-	upstream := s.determineUpstream(r)
+	upstream, rule := s.routeUpstream(r)
 	if upstream == nil {
 		logger.Warn("No upstream found", "path", r.URL.Path)
 		http.Error(w, "No upstream configured for this request", http.StatusNotFound)
 		return
 	}
+	if rule == routeHeader && !s.allowHostConflict(w, r, upstream) {
+		return
+	}
+	if rule == routeDefault {
+		if !s.allowDefaultRoute(w, r, upstream) {
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), defaultRouteKey{}, true))
+	}
+
+	// Enforce the upstream's method allow-list before minting a token
+	if !upstreamAllowsMethod(upstream, r.Method) {
+		logger.Warn("Method not allowed for upstream",
+			"method", r.Method,
+			"upstream", upstream.Name,
+			"path", r.URL.Path)
+		w.Header().Set("Allow", strings.Join(upstream.AllowedMethods, ", "))
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Copy a sample of traffic to the debug tee
+	if s.tee != nil && s.tee.sample() {
+		capture := s.tee.capture(w, r)
+		defer capture.finish(r)
+		w = capture
+	}
+
+	// Let shutdown cancel the request once its drain timeout passes;
+	// streams drain on their own, longer timeout
+	drainCtx := s.requestsCtx
+	if upstream.Streaming {
+		drainCtx = s.streamsCtx
+		s.activeStreams.Add(1)
+		defer s.activeStreams.Add(-1)
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	defer context.AfterFunc(drainCtx, cancel)()
+	r = r.WithContext(ctx)
+
+	// Serve from the response cache when possible
+	scope := s.tokenScope(r, upstream)
+	cache := s.caches[upstream.Name]
+	if cache != nil && isCacheableRequest(r) && s.isCacheablePath(upstream, r.URL.Path) {
+		if resp, ok := cache.get(r, scope); ok {
+			s.metrics.cacheHits.Add(1)
+			logger.Debug("Serving cached response", "upstream", upstream.Name, "path", r.URL.Path)
+			resp.writeTo(w, r)
+			return
+		}
+		s.metrics.cacheMisses.Add(1)
+
+		rec := newTeeRecorder(w, cache.maxBytes)
+		defer func() { cache.put(r, scope, rec.result(r)) }()
+		w = rec
+	}
+
+	if info := getRequestInfo(r); info != nil {
+		info.upstream = upstream.Name
+	}
+
+	// Long-lived streams are exempt from the server's read/write timeouts
+	if upstream.Streaming {
+		clearConnDeadlines(w, upstream.Name)
+	}
 
 	logger.Debug("Proxying request",
 		"method", r.Method,
@@ -210,106 +688,667 @@ func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
 		"upstream", upstream.Name,
 		"target", upstream.URL)
 
-	// Get token for upstream
-	token, err := s.tokenManager.GetToken(upstream.Audience)
+	// Fallbacks and refused-connection retries are only attempted when the
+	// request can safely be replayed
+	var body []byte
+	chain := s.upstreamChain(upstream, r.Method)
+	if len(chain) > 1 || upstream.RetryOnRefused {
+		var replayable bool
+		var err error
+		body, replayable, err = prepareReplay(r, upstream)
+		if err != nil {
+			// Part of the body is gone; proxying the rest would truncate it
+			if isClientDisconnect(r, err) {
+				s.metrics.clientDisconnects.Add(1)
+				w.WriteHeader(statusClientClosedRequest)
+				return
+			}
+			logger.Warn("Failed to read request body",
+				"upstream", upstream.Name,
+				"path", r.URL.Path,
+				"error", err)
+			http.Error(w, "Bad Request: failed to read request body", http.StatusBadRequest)
+			return
+		}
+		if !replayable && len(chain) > 1 {
+			logger.Debug("Request not replayable, fallbacks disabled",
+				"upstream", upstream.Name,
+				"method", r.Method)
+			chain = chain[:1]
+		}
+	}
+
+	serve := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.serveChain(w, r, chain, body, hops, startTime)
+	})
+
+	if upstream.Coalesce && isCoalescable(r) {
+		s.coalescer.serve(w, r, upstream, scope, serve)
+		return
+	}
+
+	serve(w, r)
+}
+
+// acquireRequestSlot claims a slot under max_concurrent_requests without
+// waiting, reporting false when the server is saturated
+func (s *Server) acquireRequestSlot() bool {
+	if s.requestSlots != nil {
+		select {
+		case s.requestSlots <- struct{}{}:
+		default:
+			return false
+		}
+	}
+	s.metrics.inFlight.Add(1)
+	return true
+}
+
+// releaseRequestSlot frees a slot claimed by acquireRequestSlot
+func (s *Server) releaseRequestSlot() {
+	s.metrics.inFlight.Add(-1)
+	if s.requestSlots != nil {
+		<-s.requestSlots
+	}
+}
+
+// serveChain proxies the request to each upstream in the chain in turn
+// until one of them produces a response for the client. body is the
+// buffered request body, replayed on each fallback attempt while the
+// fallback's retry budget allows. If the primary upstream has a degraded
+// response, it is served when every attempt fails.
+func (s *Server) serveChain(w http.ResponseWriter, r *http.Request, chain []*config.UpstreamConfig, body []byte, hops int, startTime time.Time) {
+	degraded := chain[0].DegradedResponse
+	attempts := 0
+	for i, upstream := range chain {
+		attempts++
+		last := i == len(chain)-1
+		if i > 0 {
+			resetBody(r, body)
+		}
+
+		if !s.proxyToUpstream(w, r, upstream, hops, startTime, !last || degraded.Enabled) {
+			if i > 0 {
+				logger.Info("Request served by fallback upstream",
+					"primary", chain[0].Name,
+					"upstream", upstream.Name,
+					"attempt", i+1)
+				if info := getRequestInfo(r); info != nil {
+					info.upstream = upstream.Name
+				}
+			}
+			return
+		}
+
+		if !last {
+			if !s.retryBudgets[chain[i+1].Name].spend(s.metrics) {
+				logger.Warn("Retry budget exhausted, not trying fallback",
+					"upstream", upstream.Name,
+					"fallback", chain[i+1].Name,
+					"path", r.URL.Path)
+				break
+			}
+			logger.Warn("Upstream failed, trying fallback",
+				"upstream", upstream.Name,
+				"fallback", chain[i+1].Name,
+				"path", r.URL.Path)
+		}
+	}
+
+	// Without a degraded response, only a spent retry budget ends up here:
+	// the final attempt otherwise writes its own error
+	if !degraded.Enabled {
+		http.Error(w, "Service Unavailable: upstream retry budget exhausted", http.StatusServiceUnavailable)
+		return
+	}
+
+	logger.Warn("All upstream attempts failed, serving degraded response",
+		"upstream", chain[0].Name,
+		"attempts", attempts,
+		"path", r.URL.Path)
+	contentType := degraded.ContentType
+	if contentType == "" {
+		// Configs not built by config.Load may leave it unset
+		contentType = http.DetectContentType([]byte(degraded.Body))
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(degraded.Status)
+	if r.Method != http.MethodHead {
+		w.Write([]byte(degraded.Body))
+	}
+}
+
+// proxyToUpstream proxies the request to a single upstream. When canFallback
+// is set, failures (circuit open, token errors, proxy errors and 5xx
+// responses) are not written to the client and true is returned so the
+// caller can try the next upstream.
+func (s *Server) proxyToUpstream(w http.ResponseWriter, r *http.Request, upstream *config.UpstreamConfig, hops int, startTime time.Time, canFallback bool) bool {
+	// Hold the attempt to the upstream's outbound rate. This comes before
+	// the breaker so a rejected attempt never takes its half-open trial.
+	if limiter := s.limiters[upstream.Name]; limiter != nil {
+		if wait, ok := limiter.acquire(r.Context()); !ok {
+			logger.Warn("Outbound rate limit reached, rejecting request", "upstream", upstream.Name, "path", r.URL.Path)
+			if canFallback {
+				return true
+			}
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			http.Error(w, "Service Unavailable: upstream rate limit reached", http.StatusServiceUnavailable)
+			return false
+		}
+	}
+
+	// Upstreams skipped at startup answer 503 until their checks pass
+	if reason, skipped := s.skipReason(upstream.Name); skipped {
+		logger.Warn("Upstream skipped at startup, rejecting request", "upstream", upstream.Name, "error", reason)
+		if canFallback {
+			return true
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(s.startupRetryInterval().Seconds())))
+		http.Error(w, "Service Unavailable: upstream failed its startup checks", http.StatusServiceUnavailable)
+		return false
+	}
+
+	// Fail fast while the upstream's circuit is open
+	breaker := s.breakers[upstream.Name]
+	if breaker != nil && !breaker.allow() {
+		logger.Warn("Circuit open, rejecting request", "upstream", upstream.Name, "path", r.URL.Path)
+		if canFallback {
+			return true
+		}
+		retryAfter := int(math.Ceil(breaker.retryAfter().Seconds()))
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		http.Error(w, "Service Unavailable: upstream circuit open", http.StatusServiceUnavailable)
+		return false
+	}
+
+	// Resolve the target URL and token audience
+	var targetURL *url.URL
+	audience := upstream.Audience
+	if upstream.PassThrough {
+		target, status, err := s.resolvePassThroughTarget(r, upstream)
+		if err != nil {
+			logger.Warn("Pass-through target rejected",
+				"upstream", upstream.Name,
+				"error", err)
+			http.Error(w, http.StatusText(status)+": "+err.Error(), status)
+			return false
+		}
+		targetURL = target
+		audience = target.Scheme + "://" + target.Host
+	} else {
+		targetURL = s.upstreamURL(upstream)
+	}
+
+	// Requests no routing rule matched get the default route's token
+	mint := true
+	if isDefaultRouted(r) {
+		audience, mint = s.defaultRouteAudience(audience)
+	}
+
+	// Get token for upstream, timing auth separately from the upstream call
+	tokenStart := time.Now()
+	var accessToken string
+	var err error
+	if mint {
+		accessToken, err = s.tokenManager.GetToken(audience)
+	}
+	tokenAcquired := time.Now()
+	if err == nil && mint && accessToken == "" {
+		// Never forward an empty bearer; the upstream's 401 would be confusing
+		err = token.ErrEmptyToken
+	}
 	if err != nil {
+		s.recordLatency(r, upstream.Name, tokenAcquired.Sub(tokenStart), 0)
 		logger.Error("Failed to get token",
 			"upstream", upstream.Name,
-			"audience", upstream.Audience,
+			"audience", audience,
 			"error", err)
+		if canFallback {
+			return true
+		}
 		http.Error(w, fmt.Sprintf("Authentication error: %v", err), http.StatusInternalServerError)
-		return
+		return false
 	}
 
-	// Parse upstream URL
-	targetURL, err := url.Parse(upstream.URL)
-	if err != nil {
-		logger.Error("Invalid upstream URL",
-			"upstream", upstream.Name,
-			"url", upstream.URL,
-			"error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+	fallback := false
+
+	// Bound the whole attempt, body included, by the upstream's response timeout
+	if upstream.ResponseTimeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(upstream.ResponseTimeout)*time.Second)
+		defer cancel()
+		r = r.WithContext(ctx)
 	}
 
+	// Logs from the proxy callbacks honor the upstream's log_level override
+	log := logger.WithLevel(upstream.LogLevel)
+
 	// Create reverse proxy
 	proxy := &httputil.ReverseProxy{
+		Transport: s.transport(upstream),
+		// Streaming upstreams are flushed to the client after every write
+		FlushInterval: streamFlushInterval(upstream),
 		Director: func(req *http.Request) {
+			// Strip the headers the client named in Connection before the
+			// gateway sets its own, so a client cannot use Connection to drop
+			// the minted token or the hop counter
+			removeConnectionHeaders(req.Header)
+			applyRequestTransforms(req, upstream.Transform.Request)
 			req.URL.Scheme = targetURL.Scheme
 			req.URL.Host = targetURL.Host
-			req.URL.Path = singleJoiningSlash(targetURL.Path, req.URL.Path)
+			if upstream.PassThrough {
+				// The resolved target is the complete destination URL
+				req.URL.Path = targetURL.Path
+				req.URL.RawPath = targetURL.RawPath
+				req.URL.RawQuery = targetURL.RawQuery
+				if upstream.TargetHeader != "" {
+					req.Header.Del(upstream.TargetHeader)
+				}
+			} else {
+				req.URL.Path = singleJoiningSlash(targetURL.Path, stripPathPrefix(req.URL.Path, upstream))
+			}
+			req.URL.RawQuery = filterQuery(req.URL.RawQuery, upstream)
+			req.Header.Del(targetSignatureHeader)
 			if upstream.Host != "" {
-		        req.Host = upstream.Host
-		        logger.Debug("Setting custom Host header", "host", upstream.Host)
-		    } else {
-		        req.Host = targetURL.Host
-		    }
+				req.Host = upstream.Host
+				log.Debug("Setting custom Host header", "host", upstream.Host)
+			} else {
+				req.Host = targetURL.Host
+			}
 
-			// Add authorization header
-			req.Header.Set("Authorization", "Bearer "+token)
+			// Add authorization header; the Director runs per request, so a
+			// reused keep-alive connection still carries this request's token.
+			// Any Authorization the client sent is dropped first, so the
+			// gateway's token is the only one the upstream sees.
+			req.Header.Del("Authorization")
+			if mint {
+				req.Header.Set("Authorization", "Bearer "+accessToken)
+			}
 
 			// Set forwarded headers
 			if clientIP := req.Header.Get("X-Forwarded-For"); clientIP == "" {
 				req.Header.Set("X-Forwarded-For", req.RemoteAddr)
 			}
 			req.Header.Set("X-Forwarded-Proto", "https")
+			req.Header.Set(hopsHeader, strconv.Itoa(hops+1))
+			if name := s.config.Server.UpstreamNameHeader; name != "" {
+				req.Header.Set(name, upstream.Name)
+			}
 
-			// Remove hop-by-hop headers
+			// Remove hop-by-hop headers; Connection goes too, so ReverseProxy
+			// has no list left to strip the gateway's headers with
 			for _, h := range hopHeaders {
 				req.Header.Del(h)
 			}
+			setExactCaseHeaders(req.Header, upstream.ExactCaseHeaders)
+
+			// The outgoing request has its own copy of the client's trailer
+			// map; share the original so trailer values, only known once the
+			// body has been read, reach the upstream
+			req.Trailer = r.Trailer
 
-			logger.Debug("Upstream request",
+			if compressRequestBody(req, upstream.CompressRequests) {
+				log.Debug("Compressing request body", "upstream", upstream.Name)
+			}
+
+			log.Debug("Upstream request",
 				"method", req.Method,
 				"url", req.URL.String(),
 				"upstream", upstream.Name)
+			s.logHeaders("Upstream request header", req.Header)
 		},
 		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
-			logger.Error("Proxy error",
+			if errors.Is(err, errFallback) {
+				fallback = true
+				return
+			}
+
+			if isClientDisconnect(r, err) {
+				s.metrics.clientDisconnects.Add(1)
+				log.Debug("Client disconnected",
+					"upstream", upstream.Name,
+					"path", r.URL.Path,
+					"duration_ms", time.Since(startTime).Milliseconds())
+				w.WriteHeader(statusClientClosedRequest)
+				return
+			}
+
+			s.metrics.proxyErrors.Add(1)
+			if breaker != nil {
+				breaker.failure()
+			}
+			log.Error("Proxy error",
 				"upstream", upstream.Name,
 				"error", err,
 				"duration_ms", time.Since(startTime).Milliseconds())
-			http.Error(w, fmt.Sprintf("Bad Gateway: %v", err), http.StatusBadGateway)
+			if canFallback {
+				fallback = true
+				return
+			}
+			status := proxyErrorStatus(err)
+			http.Error(w, fmt.Sprintf("%s: %v", http.StatusText(status), err), status)
 		},
 		ModifyResponse: func(resp *http.Response) error {
+			// Refuse responses with excessive headers before anything else
+			// touches them
+			if err := checkResponseHeaderLimits(resp.Header, upstream); err != nil {
+				return err
+			}
+
+			// Hold the response until its first body byte so a stall before
+			// it still becomes a 504
+			if upstream.ResponseTimeout > 0 {
+				if err := awaitResponseBody(resp, upstream.Name); err != nil {
+					return err
+				}
+			}
+
+			if breaker != nil {
+				if resp.StatusCode >= 500 {
+					breaker.failure()
+				} else {
+					breaker.success()
+				}
+			}
+			if resp.StatusCode < 500 {
+				s.retryBudgets[upstream.Name].success()
+			}
+
+			applyResponseTransforms(resp.Header, upstream.Transform.Response)
+			applySetCookie(resp.Header, upstream)
+			s.applyResponseHeaders(resp.Header)
+
 			// Check for authentication errors
-			if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
-				logger.Warn("Upstream rejected token",
+			if mint && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+				log.Warn("Upstream rejected token",
 					"upstream", upstream.Name,
 					"status", resp.StatusCode,
 					"duration_ms", time.Since(startTime).Milliseconds())
-				s.tokenManager.MarkRejected(upstream.Audience)
+				s.tokenManager.MarkRejected(audience)
 			}
 
-			logger.Debug("Upstream response",
+			// Keep unexpected content types from flowing through
+			if !responseTypeAllowed(resp, upstream.ResponseTypes) {
+				log.Warn("Upstream response content type not allowed",
+					"upstream", upstream.Name,
+					"status", resp.StatusCode,
+					"content_type", resp.Header.Get("Content-Type"),
+					"path", r.URL.Path)
+				replaceDisallowedResponse(resp, upstream.ResponseTypes)
+			}
+
+			// Re-encode content the client cannot decode
+			if upstream.TranscodeResponses {
+				encoding := resp.Header.Get("Content-Encoding")
+				if transcodeResponse(resp, r.Header.Get("Accept-Encoding")) {
+					log.Debug("Transcoding response",
+						"upstream", upstream.Name,
+						"from", encoding,
+						"to", resp.Header.Get("Content-Encoding"))
+				}
+			}
+
+			log.Debug("Upstream response",
 				"upstream", upstream.Name,
 				"status", resp.StatusCode,
 				"duration_ms", time.Since(startTime).Milliseconds())
 
+			// Discard server errors in favour of the next upstream
+			if canFallback && resp.StatusCode >= 500 {
+				return errFallback
+			}
+
 			return nil
 		},
 	}
 
 	proxy.ServeHTTP(w, r)
+	s.recordLatency(r, upstream.Name, tokenAcquired.Sub(tokenStart), time.Since(tokenAcquired))
+	return fallback
+}
+
+// recordLatency feeds an attempt's token wait and upstream time into the
+// upstream's histograms and the request's slow-request breakdown
+func (s *Server) recordLatency(r *http.Request, upstream string, tokenWait, upstreamTime time.Duration) {
+	s.metrics.recordLatency(upstream, tokenWait, upstreamTime)
+	if info := getRequestInfo(r); info != nil {
+		info.tokenWait += tokenWait
+		info.upstreamTime += upstreamTime
+	}
+}
+
+// clearConnDeadlines removes the read and write deadlines the server set on
+// this request's connection, for this request only
+func clearConnDeadlines(w http.ResponseWriter, upstream string) {
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		logger.Warn("Cannot clear write deadline for streaming upstream", "upstream", upstream, "error", err)
+	}
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		logger.Warn("Cannot clear read deadline for streaming upstream", "upstream", upstream, "error", err)
+	}
+}
+
+// streamFlushInterval returns the ReverseProxy flush interval for an upstream
+func streamFlushInterval(upstream *config.UpstreamConfig) time.Duration {
+	if upstream.Streaming {
+		return -1
+	}
+	return 0
 }
 
+// hopsHeader counts how many gateways a request has passed through
+const hopsHeader = "X-Gateway-Hops"
+
+// gatewayHops returns the hop count carried by the request
+func gatewayHops(r *http.Request) int {
+	hops, err := strconv.Atoi(r.Header.Get(hopsHeader))
+	if err != nil || hops < 0 {
+		return 0
+	}
+	return hops
+}
+
+// isClientDisconnect reports whether a proxy error was caused by the client
+// going away rather than an upstream failure
+func isClientDisconnect(r *http.Request, err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(r.Context().Err(), context.Canceled)
+}
+
+// proxyErrorStatus maps a proxy error to the status returned to the client:
+// 504 when the upstream timed out (dial, TLS handshake, response headers or
+// deadline), 502 for any other connection or protocol failure
+func proxyErrorStatus(err error) int {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// Routing rules reported by routeUpstream
+const (
+	routeHeader   = "header"
+	routeHostless = "hostless"
+	routePrefix   = "prefix"
+	routeDefault  = "default"
+)
+
 // determineUpstream selects the appropriate upstream for the request
 func (s *Server) determineUpstream(r *http.Request) *config.UpstreamConfig {
-	// Check X-Target-Upstream header
-	targetName := r.Header.Get("X-Target-Upstream")
+	upstream, _ := s.routeUpstream(r)
+	return upstream
+}
+
+// routeUpstream selects the upstream for the request and reports which
+// routing rule chose it
+func (s *Server) routeUpstream(r *http.Request) (*config.UpstreamConfig, string) {
+	// The longest matching path prefix comes first; of equal prefixes, the
+	// first configured wins
+	var matched *config.UpstreamConfig
+	for i := range s.config.Upstreams {
+		upstream := &s.config.Upstreams[i]
+		if hasPathPrefix(r.URL.Path, upstream.PathPrefix) &&
+			(matched == nil || len(upstream.PathPrefix) > len(matched.PathPrefix)) {
+			matched = upstream
+		}
+	}
+	if matched != nil {
+		return matched, routePrefix
+	}
+
+	// Then the X-Target-Upstream header
+	targetName := r.Header.Get(targetUpstreamHeader)
 	if targetName != "" {
-		if upstream, exists := s.upstreamMap[targetName]; exists {
-			return upstream
+		if upstream, exists := s.upstreamMap[targetName]; !exists {
+			logger.Warn("Upstream not found", "name", targetName)
+		} else if s.routingAllowed(r, targetName) {
+			return upstream, routeHeader
 		}
-		logger.Warn("Upstream not found", "name", targetName)
 	}
 
-	// Default to first upstream
+	// Legacy clients that sent no Host header
+	if r.Host == "" && s.config.Server.HostlessUpstream != "" {
+		if upstream, exists := s.upstreamMap[s.config.Server.HostlessUpstream]; exists {
+			return upstream, routeHostless
+		}
+	}
+
+	// Fall back to the configured default, else the first upstream
+	if upstream, exists := s.upstreamMap[s.config.Server.DefaultUpstream]; exists {
+		return upstream, routeDefault
+	}
 	if len(s.config.Upstreams) > 0 {
-		return &s.config.Upstreams[0]
+		return &s.config.Upstreams[0], routeDefault
+	}
+
+	return nil, ""
+}
+
+// allowDefaultRoute applies the default_route policy to a request no routing
+// rule matched, reporting whether it may still be proxied to upstream
+func (s *Server) allowDefaultRoute(w http.ResponseWriter, r *http.Request, upstream *config.UpstreamConfig) bool {
+	s.metrics.defaultRouted.Add(1)
+	attrs := []any{
+		"path", r.URL.Path,
+		"upstream", upstream.Name,
+		"target_header", r.Header.Get(targetUpstreamHeader),
+		"remote_addr", r.RemoteAddr,
+	}
+
+	switch s.config.Server.DefaultRoute {
+	case config.DefaultRouteReject:
+		logger.Warn("No routing rule matched, rejecting request", attrs...)
+		http.Error(w, "No upstream configured for this request", http.StatusNotFound)
+		return false
+	case config.DefaultRouteWarn:
+		logger.Warn("No routing rule matched, using default upstream", attrs...)
+	default:
+		logger.Debug("No routing rule matched, using default upstream", attrs...)
+	}
+	return true
+}
+
+// defaultRouteKey marks a request context as default-routed
+type defaultRouteKey struct{}
+
+// isDefaultRouted reports whether no routing rule matched the request
+func isDefaultRouted(r *http.Request) bool {
+	routed, _ := r.Context().Value(defaultRouteKey{}).(bool)
+	return routed
+}
+
+// defaultRouteAudience returns the audience to mint a default-routed
+// request's token for in place of audience, and false when the request is
+// to be forwarded without a token
+func (s *Server) defaultRouteAudience(audience string) (string, bool) {
+	if s.config.Server.DefaultRouteToken == config.DefaultRouteTokenNone {
+		return "", false
+	}
+	if fallback := s.config.Server.DefaultRouteAudience; fallback != "" {
+		return fallback, true
+	}
+	return audience, true
+}
+
+// tokenScope names the token a request to upstream is proxied with: the
+// audience minted for it, or "" when it is forwarded without one. A
+// default-routed request may carry another audience's token than a routed
+// one (see defaultRouteAudience), so cached and coalesced responses are
+// only shared within a scope.
+func (s *Server) tokenScope(r *http.Request, upstream *config.UpstreamConfig) string {
+	if !isDefaultRouted(r) {
+		return upstream.Audience
+	}
+	if audience, mint := s.defaultRouteAudience(upstream.Audience); mint {
+		return audience
+	}
+	return ""
+}
+
+// parseUpstreamURL parses and checks an upstream's url
+func parseUpstreamURL(raw string) (*url.URL, error) {
+	target, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
 	}
+	if target.Scheme != "http" && target.Scheme != "https" || target.Host == "" {
+		return nil, fmt.Errorf("invalid url %q: must be an absolute http or https URL", raw)
+	}
+	return target, nil
+}
 
-	return nil
+// upstreamURL returns a copy of the upstream's URL, parsed at startup
+func (s *Server) upstreamURL(upstream *config.UpstreamConfig) *url.URL {
+	target := *s.targets[upstream.Name]
+	return &target
+}
+
+// hasPathPrefix reports whether path is prefix or lies beneath it, as the
+// allowed_paths pattern prefix/* would; an empty prefix matches nothing
+func hasPathPrefix(path, prefix string) bool {
+	return prefix != "" && matchPath(prefix+"/*", path)
+}
+
+// stripPathPrefix removes the upstream's routing prefix from path unless the
+// upstream preserves it
+func stripPathPrefix(path string, upstream *config.UpstreamConfig) string {
+	if upstream.PreservePathPrefix || !hasPathPrefix(path, upstream.PathPrefix) {
+		return path
+	}
+	if path = path[len(upstream.PathPrefix):]; path == "" {
+		return "/"
+	}
+	return path
+}
+
+// upstreamNames returns the names of the configured upstreams
+func upstreamNames(upstreams []config.UpstreamConfig) []string {
+	names := make([]string, 0, len(upstreams))
+	for _, upstream := range upstreams {
+		names = append(names, upstream.Name)
+	}
+	return names
+}
+
+// upstreamAllowsMethod reports whether the upstream accepts the method; an
+// empty allow-list accepts every method
+func upstreamAllowsMethod(upstream *config.UpstreamConfig, method string) bool {
+	if len(upstream.AllowedMethods) == 0 {
+		return true
+	}
+	for _, allowed := range upstream.AllowedMethods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
 }
 
 // isPathAllowed checks if the request path is allowed based on configured patterns
@@ -321,7 +1360,7 @@ func (s *Server) isPathAllowed(path string) bool {
 
 	// Check each allowed pattern
 	for _, pattern := range s.config.Server.AllowedPaths {
-		if matchPath(pattern, path) {
+		if matchPathPolicy(pattern, path, s.config.Server.TrailingSlash) {
 			return true
 		}
 	}
@@ -329,6 +1368,20 @@ func (s *Server) isPathAllowed(path string) bool {
 	return false
 }
 
+// isCacheablePath reports whether the upstream's cache.paths admit the path;
+// an empty list admits every path
+func (s *Server) isCacheablePath(upstream *config.UpstreamConfig, path string) bool {
+	if len(upstream.Cache.Paths) == 0 {
+		return true
+	}
+	for _, pattern := range upstream.Cache.Paths {
+		if matchPathPolicy(pattern, path, s.config.Server.TrailingSlash) {
+			return true
+		}
+	}
+	return false
+}
+
 // matchPath checks if a path matches a pattern
 // Supports exact matches and wildcard patterns (e.g., /apps/*)
 func matchPath(pattern, path string) bool {
@@ -352,7 +1405,31 @@ func matchPath(pattern, path string) bool {
 	return false
 }
 
-// Hop-by-hop headers to remove
+// matchPathPolicy matches a path against a pattern using the configured
+// trailing slash policy. Under "normalize", a trailing slash is ignored on
+// both sides (wildcard patterns are left intact); otherwise matching is strict.
+func matchPathPolicy(pattern, path, policy string) bool {
+	if policy == config.TrailingSlashNormalize {
+		path = trimTrailingSlash(path)
+		if !strings.HasSuffix(pattern, "/*") {
+			pattern = trimTrailingSlash(pattern)
+		}
+	}
+	return matchPath(pattern, path)
+}
+
+// trimTrailingSlash removes a trailing slash, keeping the root path intact
+func trimTrailingSlash(path string) string {
+	if len(path) > 1 {
+		return strings.TrimSuffix(path, "/")
+	}
+	return path
+}
+
+// Hop-by-hop headers to remove from upstream requests. Trailers survive
+// the removal of Te and Trailer: the transport announces the request's
+// trailers from req.Trailer, and ReverseProxy forwards "Te: trailers" and
+// the response's trailers itself.
 var hopHeaders = []string{
 	"Connection",
 	"Proxy-Connection",
@@ -365,6 +1442,18 @@ var hopHeaders = []string{
 	"Upgrade",
 }
 
+// removeConnectionHeaders removes headers listed in the Connection header,
+// which are hop-by-hop per RFC 7230 section 6.1
+func removeConnectionHeaders(h http.Header) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+}
+
 // singleJoiningSlash joins two URL paths
 func singleJoiningSlash(a, b string) string {
 	aslash := strings.HasSuffix(a, "/")
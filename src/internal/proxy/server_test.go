@@ -1,9 +1,63 @@
 package proxy
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"go-oauth2-proxy/src/internal/config"
+	"go-oauth2-proxy/src/internal/logger"
+	"go-oauth2-proxy/src/internal/token"
 )
 
+// newTestServer creates a proxy server for the given upstreams that mints
+// static tokens instead of calling Google
+func newTestServer(t *testing.T, upstreams ...config.UpstreamConfig) *Server {
+	t.Helper()
+	return newTestServerWithConfig(t, &config.Config{Upstreams: upstreams})
+}
+
+// newTestServerWithConfig creates a proxy server from a full configuration
+// that mints static tokens instead of calling Google
+func newTestServerWithConfig(t *testing.T, cfg *config.Config) *Server {
+	t.Helper()
+	logger.Init("error")
+
+	cfg.Server.Address = "127.0.0.1"
+	cfg.Server.Port = 8080
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	srv.tokenManager.SetSourceFunc(func(ctx context.Context, audience string) (oauth2.TokenSource, error) {
+		return oauth2.StaticTokenSource(&oauth2.Token{
+			AccessToken: "test-token",
+			Expiry:      time.Now().Add(time.Hour),
+		}), nil
+	})
+	return srv
+}
+
+// serve runs a request through the server's full handler chain
+func serve(srv *Server, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	srv.httpServer.Handler.ServeHTTP(rec, req)
+	return rec
+}
+
 func TestMatchPath(t *testing.T) {
 	tests := []struct {
 		pattern string
@@ -45,3 +99,929 @@ func TestMatchPath(t *testing.T) {
 		})
 	}
 }
+
+func TestClientDisconnectCountedSeparately(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	srv := newTestServer(t, config.UpstreamConfig{
+		Name:     "api",
+		URL:      upstream.URL,
+		Audience: upstream.URL,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/slow", nil).WithContext(ctx)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- serve(srv, req)
+	}()
+
+	<-started
+	cancel()
+	rec := <-done
+
+	if rec.Code != statusClientClosedRequest {
+		t.Errorf("status = %d, want %d", rec.Code, statusClientClosedRequest)
+	}
+	if got := srv.metrics.clientDisconnects.Load(); got != 1 {
+		t.Errorf("client_disconnects = %d, want 1", got)
+	}
+	if got := srv.metrics.proxyErrors.Load(); got != 0 {
+		t.Errorf("proxy_errors = %d, want 0", got)
+	}
+}
+
+func TestUpstreamFailureCountedAsProxyError(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	upstream.Close() // nothing listening
+
+	srv := newTestServer(t, config.UpstreamConfig{
+		Name:     "api",
+		URL:      upstream.URL,
+		Audience: upstream.URL,
+	})
+
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadGateway)
+	}
+	if got := srv.metrics.proxyErrors.Load(); got != 1 {
+		t.Errorf("proxy_errors = %d, want 1", got)
+	}
+	if got := srv.metrics.clientDisconnects.Load(); got != 0 {
+		t.Errorf("client_disconnects = %d, want 0", got)
+	}
+}
+
+func TestProxyErrorStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"deadline", context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{"wrapped deadline", fmt.Errorf("round trip: %w", context.DeadlineExceeded), http.StatusGatewayTimeout},
+		{"net timeout", &net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}, http.StatusGatewayTimeout},
+		{"connection refused", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, http.StatusBadGateway},
+		{"other", errors.New("malformed HTTP response"), http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := proxyErrorStatus(tt.err); got != tt.want {
+				t.Errorf("proxyErrorStatus(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTLSFailureIsBadGateway(t *testing.T) {
+	// The default transport does not trust the test server's certificate
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: upstream.URL})
+
+	if rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil)); rec.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadGateway)
+	}
+}
+
+func TestEmptyTokenNotForwarded(t *testing.T) {
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: "a"})
+	srv.tokenManager.SetSourceFunc(func(ctx context.Context, audience string) (oauth2.TokenSource, error) {
+		return oauth2.StaticTokenSource(&oauth2.Token{Expiry: time.Now().Add(time.Hour)}), nil
+	})
+
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if !strings.Contains(rec.Body.String(), token.ErrEmptyToken.Error()) {
+		t.Errorf("body = %q, want it to mention the empty token", rec.Body.String())
+	}
+	if hits.Load() != 0 {
+		t.Error("upstream was called with an empty bearer token")
+	}
+}
+
+func TestUpstreamURLParsedOnce(t *testing.T) {
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL + "/base", Audience: "a"})
+
+	// Requests use the URL parsed at startup, never the raw config value
+	srv.config.Upstreams[0].URL = "://unparseable"
+	for range 2 {
+		if rec := serve(srv, httptest.NewRequest(http.MethodGet, "/x", nil)); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200 from the startup URL", rec.Code)
+		}
+	}
+	if hits.Load() != 2 {
+		t.Errorf("upstream hits = %d, want 2", hits.Load())
+	}
+	if got := srv.targets["api"].Path; got != "/base" {
+		t.Errorf("cached target path = %q, want /base left untouched by requests", got)
+	}
+}
+
+func TestBadUpstreamURLFailsAtStartup(t *testing.T) {
+	logger.Init("error")
+	for _, raw := range []string{"://missing-scheme", "svc.run.app", "ftp://svc.run.app", "https://"} {
+		_, err := NewServer(&config.Config{
+			Server:    config.ServerConfig{Address: "127.0.0.1", Port: 8080},
+			Upstreams: []config.UpstreamConfig{{Name: "api", URL: raw, Audience: "a"}},
+		})
+		if err == nil || !strings.Contains(err.Error(), "upstream api") {
+			t.Errorf("NewServer(url %q) error = %v, want a startup error naming the upstream", raw, err)
+		}
+	}
+}
+
+func TestIAPModeMintsClientIDToken(t *testing.T) {
+	const clientID = "1234567890-abcdef.apps.googleusercontent.com"
+	var authorization string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, config.UpstreamConfig{Name: "app", URL: upstream.URL, Audience: clientID, TokenMode: config.TokenModeIAP})
+	var minted []string
+	srv.tokenManager.SetSourceFunc(func(ctx context.Context, audience string) (oauth2.TokenSource, error) {
+		minted = append(minted, audience)
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "id-token-for-" + audience, Expiry: time.Now().Add(time.Hour)}), nil
+	})
+
+	if rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil)); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if len(minted) != 1 || minted[0] != clientID {
+		t.Errorf("minted audiences = %v, want only the client ID", minted)
+	}
+	if authorization != "Bearer id-token-for-"+clientID {
+		t.Errorf("Authorization = %q, want the client ID token", authorization)
+	}
+}
+
+func TestMatchPathPolicy(t *testing.T) {
+	tests := []struct {
+		pattern       string
+		path          string
+		wantStrict    bool
+		wantNormalize bool
+	}{
+		// Exact matches
+		{"/run_sse", "/run_sse", true, true},
+		{"/run_sse", "/run_sse/", false, true},
+		{"/run_sse/", "/run_sse", false, true},
+		{"/run_sse", "/run_sse/other", false, false},
+		{"/apps", "/apps", true, true},
+		{"/apps", "/apps/", false, true},
+		{"/", "/", true, true},
+
+		// Wildcard matches
+		{"/apps/*", "/apps", true, true},
+		{"/apps/*", "/apps/", true, true},
+		{"/apps/*", "/apps/foo", true, true},
+		{"/apps/*", "/apps/foo/", true, true},
+		{"/apps/*", "/other", false, false},
+
+		// Double wildcard matches
+		{"/apps/**", "/apps", true, true},
+		{"/apps/**", "/apps/", true, true},
+		{"/apps/**", "/apps/foo/bar/baz", true, true},
+		{"/apps/**", "/other/", false, false},
+
+		// No match cases
+		{"/run_sse", "/inform", false, false},
+		{"/api/*", "/apps/test/", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+"_"+tt.path, func(t *testing.T) {
+			if got := matchPathPolicy(tt.pattern, tt.path, config.TrailingSlashStrict); got != tt.wantStrict {
+				t.Errorf("strict: matchPathPolicy(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.wantStrict)
+			}
+			if got := matchPathPolicy(tt.pattern, tt.path, config.TrailingSlashNormalize); got != tt.wantNormalize {
+				t.Errorf("normalize: matchPathPolicy(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.wantNormalize)
+			}
+		})
+	}
+}
+
+func TestPathPrefixRouting(t *testing.T) {
+	newUpstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + " " + r.URL.Path))
+		}))
+	}
+	def, billing, legacy := newUpstream("default"), newUpstream("billing"), newUpstream("legacy")
+	defer def.Close()
+	defer billing.Close()
+	defer legacy.Close()
+
+	srv := newTestServer(t,
+		config.UpstreamConfig{Name: "default", URL: def.URL, Audience: "a"},
+		config.UpstreamConfig{Name: "billing", URL: billing.URL + "/v1", Audience: "a", PathPrefix: "/billing"},
+		config.UpstreamConfig{Name: "legacy", URL: legacy.URL, Audience: "a", PathPrefix: "/legacy", PreservePathPrefix: true},
+	)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/billing/invoices", "billing /v1/invoices"},
+		{"/billing", "billing /v1/"},
+		{"/legacy/orders", "legacy /legacy/orders"},
+		{"/billingx", "default /billingx"},
+		{"/other", "default /other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := serve(srv, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if got := rec.Body.String(); got != tt.want {
+				t.Errorf("response = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathPrefixRoutingOverlapping(t *testing.T) {
+	// Declared in both orders: the longest prefix wins regardless
+	for _, order := range [][]string{{"/api", "/api/v2"}, {"/api/v2", "/api"}} {
+		upstreams := []config.UpstreamConfig{{Name: "default", URL: "http://default.internal", Audience: "a"}}
+		for _, prefix := range order {
+			upstreams = append(upstreams, config.UpstreamConfig{
+				Name: "up" + strings.ReplaceAll(prefix, "/", "-"), URL: "http://svc.internal", Audience: "a", PathPrefix: prefix,
+			})
+		}
+		srv := newTestServer(t, upstreams...)
+
+		tests := []struct {
+			path string
+			want string
+		}{
+			{"/api", "up-api"},
+			{"/api/users", "up-api"},
+			{"/api/v2", "up-api-v2"},
+			{"/api/v2/users", "up-api-v2"},
+			{"/api/v20", "up-api"},
+			{"/apiv2", "default"},
+		}
+		for _, tt := range tests {
+			upstream, rule := srv.routeUpstream(httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if upstream.Name != tt.want {
+				t.Errorf("order %v: %s routed to %s (%s), want %s", order, tt.path, upstream.Name, rule, tt.want)
+			}
+		}
+	}
+
+	// Of equal prefixes, the first configured wins
+	srv := newTestServer(t,
+		config.UpstreamConfig{Name: "default", URL: "http://default.internal", Audience: "a"},
+		config.UpstreamConfig{Name: "first", URL: "http://svc.internal", Audience: "a", PathPrefix: "/api"},
+		config.UpstreamConfig{Name: "second", URL: "http://svc.internal", Audience: "a", PathPrefix: "/api"},
+	)
+	if upstream, _ := srv.routeUpstream(httptest.NewRequest(http.MethodGet, "/api/users", nil)); upstream.Name != "first" {
+		t.Errorf("equal prefixes routed to %s, want first", upstream.Name)
+	}
+}
+
+func TestPathPrefixRoutingPrecedesHeader(t *testing.T) {
+	srv := newTestServer(t,
+		config.UpstreamConfig{Name: "default", URL: "http://default.internal", Audience: "a"},
+		config.UpstreamConfig{Name: "legacy", URL: "http://legacy.internal", Audience: "a"},
+		config.UpstreamConfig{Name: "v2", URL: "http://svc.internal", Audience: "a", PathPrefix: "/api/v2"},
+	)
+
+	tests := []struct {
+		path     string
+		want     string
+		wantRule string
+	}{
+		{"/api/v2/users", "v2", routePrefix},
+		{"/other", "legacy", routeHeader},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Header.Set(targetUpstreamHeader, "legacy")
+		if upstream, rule := srv.routeUpstream(req); upstream.Name != tt.want || rule != tt.wantRule {
+			t.Errorf("%s with header legacy routed to %s (%s), want %s (%s)", tt.path, upstream.Name, rule, tt.want, tt.wantRule)
+		}
+	}
+}
+
+func TestDefaultRoute(t *testing.T) {
+	newUpstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+	}
+	api, fallback := newUpstream("api"), newUpstream("fallback")
+	defer api.Close()
+	defer fallback.Close()
+
+	tests := []struct {
+		mode       string
+		wantStatus int
+		wantBody   string
+		wantWarn   bool
+	}{
+		{"", http.StatusOK, "fallback", false},
+		{config.DefaultRouteAllow, http.StatusOK, "fallback", false},
+		{config.DefaultRouteWarn, http.StatusOK, "fallback", true},
+		{config.DefaultRouteReject, http.StatusNotFound, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run("mode="+tt.mode, func(t *testing.T) {
+			srv := newTestServerWithConfig(t, &config.Config{
+				Server: config.ServerConfig{DefaultUpstream: "fallback", DefaultRoute: tt.mode},
+				Upstreams: []config.UpstreamConfig{
+					{Name: "api", URL: api.URL, Audience: "a", PathPrefix: "/api"},
+					{Name: "fallback", URL: fallback.URL, Audience: "b"},
+				},
+			})
+			logger.SetLevel("warn")
+			buf := captureLogs(t)
+
+			// Matched by a routing rule: never counted or logged
+			if rec := serve(srv, httptest.NewRequest(http.MethodGet, "/api/x", nil)); rec.Body.String() != "api" {
+				t.Fatalf("prefix route body = %q, want api", rec.Body.String())
+			}
+
+			// A typo in X-Target-Upstream falls through to the default
+			req := httptest.NewRequest(http.MethodGet, "/other", nil)
+			req.Header.Set(targetUpstreamHeader, "aip")
+			rec := serve(srv, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+			if got := srv.metrics.defaultRouted.Load(); got != 1 {
+				t.Errorf("default_routed = %d, want 1", got)
+			}
+			if warned := strings.Contains(buf.String(), "No routing rule matched"); warned != tt.wantWarn {
+				t.Errorf("warning logged = %v, want %v; logs:\n%s", warned, tt.wantWarn, buf.String())
+			}
+		})
+	}
+}
+
+func TestDefaultRouteAudience(t *testing.T) {
+	var authorization atomic.Value
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization.Store(r.Header.Get("Authorization"))
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name     string
+		token    string
+		audience string
+		want     string // Authorization of the default-routed request
+		explain  string // audience /admin/explain reports for it
+	}{
+		{"upstream audience", "", "", "Bearer token-for-fallback", "fallback"},
+		{"fallback audience", config.DefaultRouteTokenMint, "public", "Bearer token-for-public", "public"},
+		{"no token", config.DefaultRouteTokenNone, "", "", "none"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServerWithConfig(t, &config.Config{
+				Server: config.ServerConfig{
+					DefaultUpstream:      "fallback",
+					DefaultRouteToken:    tt.token,
+					DefaultRouteAudience: tt.audience,
+				},
+				Upstreams: []config.UpstreamConfig{
+					{Name: "api", URL: upstream.URL, Audience: "api", PathPrefix: "/api"},
+					{Name: "fallback", URL: upstream.URL, Audience: "fallback"},
+				},
+			})
+			srv.tokenManager.SetSourceFunc(func(ctx context.Context, audience string) (oauth2.TokenSource, error) {
+				return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token-for-" + audience, Expiry: time.Now().Add(time.Hour)}), nil
+			})
+
+			// Routed requests keep their upstream's audience
+			serve(srv, httptest.NewRequest(http.MethodGet, "/api/x", nil))
+			if got := authorization.Load(); got != "Bearer token-for-api" {
+				t.Errorf("routed Authorization = %q, want the upstream's token", got)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/other", nil)
+			req.Header.Set("Authorization", "Bearer client-token")
+			if rec := serve(srv, req); rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+			if got := authorization.Load(); got != tt.want {
+				t.Errorf("default-routed Authorization = %q, want %q", got, tt.want)
+			}
+
+			// The explain endpoint reports the same selection
+			srv.config.Admin.Token = "s3cret"
+			for path, want := range map[string]string{"/api/x": "api", "/other": tt.explain} {
+				req := adminRequest(http.MethodPost, "/admin/explain", "s3cret")
+				req.Body = io.NopCloser(strings.NewReader(`{"path":"` + path + `"}`))
+				var got map[string]interface{}
+				json.Unmarshal(serve(srv, req).Body.Bytes(), &got)
+				if got["audience"] != want {
+					t.Errorf("explain %s: audience = %v, want %q", path, got["audience"], want)
+				}
+			}
+		})
+	}
+}
+
+func TestIsPathAllowedTrailingSlash(t *testing.T) {
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: "http://127.0.0.1:1", Audience: "aud"})
+	srv.config.Server.AllowedPaths = []string{"/run_sse"}
+
+	srv.config.Server.TrailingSlash = config.TrailingSlashStrict
+	if srv.isPathAllowed("/run_sse/") {
+		t.Error("strict: /run_sse/ should not be allowed")
+	}
+
+	srv.config.Server.TrailingSlash = config.TrailingSlashNormalize
+	if !srv.isPathAllowed("/run_sse/") {
+		t.Error("normalize: /run_sse/ should be allowed")
+	}
+}
+
+func TestConnectionListedHeadersStripped(t *testing.T) {
+	var received http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, config.UpstreamConfig{
+		Name:     "api",
+		URL:      upstream.URL,
+		Audience: upstream.URL,
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Add("Connection", "X-Hop-One, keep-alive")
+	req.Header.Add("Connection", "x-hop-two")
+	req.Header.Set("X-Hop-One", "secret")
+	req.Header.Set("X-Hop-Two", "secret")
+	req.Header.Set("X-End-To-End", "kept")
+	req.Header.Set("Keep-Alive", "timeout=5")
+
+	if rec := serve(srv, req); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	for _, h := range []string{"Connection", "X-Hop-One", "X-Hop-Two", "Keep-Alive"} {
+		if v := received.Get(h); v != "" {
+			t.Errorf("upstream received %s = %q, want it stripped", h, v)
+		}
+	}
+	if v := received.Get("X-End-To-End"); v != "kept" {
+		t.Errorf("upstream received X-End-To-End = %q, want %q", v, "kept")
+	}
+	if v := received.Get("Authorization"); v != "Bearer test-token" {
+		t.Errorf("upstream received Authorization = %q, want %q", v, "Bearer test-token")
+	}
+}
+
+func TestConnectionCannotStripGatewayHeaders(t *testing.T) {
+	var received atomic.Value
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Store(r.Header.Clone())
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, config.UpstreamConfig{
+		Name:     "api",
+		URL:      upstream.URL,
+		Audience: upstream.URL,
+	})
+	srv.config.Server.UpstreamNameHeader = "X-Gateway-Upstream"
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Connection", "Authorization, X-Gateway-Hops, X-Gateway-Upstream")
+	req.Header.Set(hopsHeader, "1")
+
+	if rec := serve(srv, req); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	h := received.Load().(http.Header)
+	if v := h.Get("Authorization"); v != "Bearer test-token" {
+		t.Errorf("upstream received Authorization = %q, want %q", v, "Bearer test-token")
+	}
+	if v := h.Get(hopsHeader); v != "2" {
+		t.Errorf("upstream received %s = %q, want %q", hopsHeader, v, "2")
+	}
+	if v := h.Get("X-Gateway-Upstream"); v != "api" {
+		t.Errorf("upstream received X-Gateway-Upstream = %q, want %q", v, "api")
+	}
+}
+
+func TestRemoveConnectionHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Connection", " Foo ,, bar")
+	h.Set("Foo", "1")
+	h.Set("Bar", "2")
+	h.Set("Baz", "3")
+
+	removeConnectionHeaders(h)
+
+	if h.Get("Foo") != "" || h.Get("Bar") != "" {
+		t.Errorf("headers named in Connection not removed: %v", h)
+	}
+	if h.Get("Baz") != "3" {
+		t.Errorf("unrelated header removed: %v", h)
+	}
+}
+
+func TestLoopDetection(t *testing.T) {
+	var hits int32
+	loop := httptest.NewUnstartedServer(nil)
+	defer loop.Close()
+
+	// The upstream points back at the gateway itself
+	srv := newTestServer(t, config.UpstreamConfig{
+		Name:     "self",
+		URL:      "http://" + loop.Listener.Addr().String(),
+		Audience: "self",
+	})
+	srv.config.Server.MaxHops = 3
+	loop.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		srv.httpServer.Handler.ServeHTTP(w, r)
+	})
+	loop.Start()
+
+	resp, err := http.Get(loop.URL + "/anything")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusLoopDetected {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusLoopDetected)
+	}
+	// The original request plus MaxHops proxied copies
+	if got := atomic.LoadInt32(&hits); got != 4 {
+		t.Errorf("gateway hits = %d, want 4", got)
+	}
+}
+
+func TestMaxConcurrentRequests(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			entered <- struct{}{}
+			<-release
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	srv := newTestServerWithConfig(t, &config.Config{
+		Server:    config.ServerConfig{MaxConcurrentRequests: 1},
+		Upstreams: []config.UpstreamConfig{{Name: "api", URL: upstream.URL, Audience: "a"}},
+	})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- serve(srv, httptest.NewRequest(http.MethodGet, "/slow", nil)) }()
+	<-entered
+
+	// The only slot is taken: proxy requests are shed, local endpoints are not
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("saturated status = %d, want 503", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+	if rec := serve(srv, httptest.NewRequest(http.MethodGet, "/healthz", nil)); rec.Code != http.StatusOK {
+		t.Errorf("/healthz status = %d while saturated, want 200", rec.Code)
+	}
+	if got := srv.metrics.inFlight.Load(); got != 1 {
+		t.Errorf("in flight = %d, want 1", got)
+	}
+
+	close(release)
+	if rec := <-done; rec.Code != http.StatusOK {
+		t.Fatalf("slow request status = %d, want 200", rec.Code)
+	}
+
+	// The slot is released once the request completes
+	if rec := serve(srv, httptest.NewRequest(http.MethodGet, "/fast", nil)); rec.Code != http.StatusOK {
+		t.Errorf("status after release = %d, want 200", rec.Code)
+	}
+	if got := srv.metrics.inFlight.Load(); got != 0 {
+		t.Errorf("in flight = %d after completion, want 0", got)
+	}
+	if got := srv.metrics.concurrencyRejections.Load(); got != 1 {
+		t.Errorf("concurrency rejections = %d, want 1", got)
+	}
+}
+
+func TestGatewayHops(t *testing.T) {
+	tests := []struct {
+		header string
+		want   int
+	}{
+		{"", 0},
+		{"3", 3},
+		{"-1", 0},
+		{"junk", 0},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.header != "" {
+			r.Header.Set(hopsHeader, tt.header)
+		}
+		if got := gatewayHops(r); got != tt.want {
+			t.Errorf("gatewayHops(%q) = %d, want %d", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestUpstreamNameHeader(t *testing.T) {
+	var got atomic.Value
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.Store(r.Header.Values("X-Gateway-Upstream"))
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, config.UpstreamConfig{Name: "primary", URL: upstream.URL, Audience: upstream.URL})
+
+	serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+	if values := got.Load().([]string); len(values) != 0 {
+		t.Errorf("X-Gateway-Upstream = %q, want none when upstream_name_header is unset", values)
+	}
+
+	srv.config.Server.UpstreamNameHeader = "X-Gateway-Upstream"
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Gateway-Upstream", "spoofed")
+	serve(srv, req)
+	if values := got.Load().([]string); len(values) != 1 || values[0] != "primary" {
+		t.Errorf("X-Gateway-Upstream = %q, want [primary]", values)
+	}
+}
+
+func TestClientAuthorization(t *testing.T) {
+	var got atomic.Value
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.Store(r.Header.Values("Authorization"))
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: upstream.URL})
+
+	// replace (default): every client value is dropped for the gateway's token
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Add("Authorization", "Bearer client-one")
+	req.Header.Add("Authorization", "Basic Y2xpZW50OnR3bw==")
+	if rec := serve(srv, req); rec.Code != http.StatusOK {
+		t.Fatalf("replace: status = %d, want 200", rec.Code)
+	}
+	if values := got.Load().([]string); len(values) != 1 || values[0] != "Bearer test-token" {
+		t.Errorf("upstream Authorization = %q, want only the gateway's token", values)
+	}
+
+	// reject: requests carrying their own Authorization never reach the upstream
+	srv.config.Server.ClientAuthorization = config.ClientAuthorizationReject
+	got.Store([]string(nil))
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer client-one")
+	if rec := serve(srv, req); rec.Code != http.StatusBadRequest {
+		t.Errorf("reject: status = %d, want 400", rec.Code)
+	}
+	if values := got.Load().([]string); values != nil {
+		t.Errorf("reject: upstream reached with Authorization %q", values)
+	}
+
+	if rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil)); rec.Code != http.StatusOK {
+		t.Errorf("reject without Authorization: status = %d, want 200", rec.Code)
+	}
+	if values := got.Load().([]string); len(values) != 1 || values[0] != "Bearer test-token" {
+		t.Errorf("upstream Authorization = %q, want the gateway's token", values)
+	}
+}
+
+func TestRootResponse(t *testing.T) {
+	var hits int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: upstream.URL})
+	srv.config.Server.AllowedPaths = []string{"/api/*"}
+	srv.config.Server.RootResponse = config.RootResponseConfig{
+		Enabled:     true,
+		Status:      http.StatusOK,
+		ContentType: "application/json",
+		Body:        `{"service":"gateway"}`,
+	}
+
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"service":"gateway"}` {
+		t.Errorf("GET / = %d %q, want 200 with configured body", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+
+	rec = serve(srv, httptest.NewRequest(http.MethodHead, "/", nil))
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("HEAD / = %d with %d body bytes, want 200 and empty", rec.Code, rec.Body.Len())
+	}
+
+	// Unmatched proxy paths still 404
+	rec = serve(srv, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /unknown = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	if got := atomic.LoadInt32(&hits); got != 0 {
+		t.Errorf("upstream hits = %d, want 0", got)
+	}
+}
+
+func TestRootResponseDisabledProxies(t *testing.T) {
+	var hits int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: upstream.URL})
+
+	serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := atomic.LoadInt32(&hits); got != 1 {
+		t.Errorf("upstream hits = %d, want 1", got)
+	}
+}
+
+func TestUpstreamAllowedMethods(t *testing.T) {
+	upstream, hits := newStatusUpstream(t, http.StatusOK, "ok")
+	srv := newTestServer(t,
+		config.UpstreamConfig{Name: "readonly", URL: upstream.URL, Audience: "ro", AllowedMethods: []string{"GET", "head"}},
+		config.UpstreamConfig{Name: "open", URL: upstream.URL, Audience: "rw"},
+	)
+
+	tests := []struct {
+		upstream string
+		method   string
+		want     int
+	}{
+		{"readonly", http.MethodGet, http.StatusOK},
+		{"readonly", http.MethodHead, http.StatusOK},
+		{"readonly", http.MethodDelete, http.StatusMethodNotAllowed},
+		{"readonly", http.MethodPost, http.StatusMethodNotAllowed},
+		{"open", http.MethodDelete, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.upstream+" "+tt.method, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			req.Header.Set("X-Target-Upstream", tt.upstream)
+			rec := serve(srv, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusMethodNotAllowed && rec.Header().Get("Allow") != "GET, head" {
+				t.Errorf("Allow = %q, want %q", rec.Header().Get("Allow"), "GET, head")
+			}
+		})
+	}
+
+	if got := atomic.LoadInt32(hits); got != 3 {
+		t.Errorf("upstream hits = %d, want 3", got)
+	}
+	// Rejected requests never mint a token for the read-only audience
+	if meta := srv.tokenManager.GetMetadata("ro"); meta == nil || meta.RefreshCount != 1 {
+		t.Errorf("read-only token metadata = %+v, want a single mint from the allowed requests", meta)
+	}
+}
+
+// failingWriter accepts limit bytes and then fails every write
+type failingWriter struct {
+	*httptest.ResponseRecorder
+	limit int
+}
+
+func (f *failingWriter) Write(b []byte) (int, error) {
+	if len(b) > f.limit {
+		n, _ := f.ResponseRecorder.Write(b[:f.limit])
+		f.limit = 0
+		return n, syscall.EPIPE
+	}
+	f.limit -= len(b)
+	return f.ResponseRecorder.Write(b)
+}
+
+func TestResponseWriterFlushPassThrough(t *testing.T) {
+	rec := httptest.NewRecorder()
+	var w http.ResponseWriter = &responseWriter{ResponseWriter: rec, statusCode: http.StatusOK}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		t.Fatal("responseWriter does not implement http.Flusher")
+	}
+	w.Write([]byte("event: ping\n\n"))
+	flusher.Flush()
+	if !rec.Flushed {
+		t.Error("Flush was not passed through to the underlying writer")
+	}
+
+	if err := http.NewResponseController(w).Flush(); err != nil {
+		t.Errorf("ResponseController.Flush() error = %v", err)
+	}
+
+	if _, _, err := w.(http.Hijacker).Hijack(); !errors.Is(err, http.ErrNotSupported) {
+		t.Errorf("Hijack() error = %v, want ErrNotSupported from a recorder", err)
+	}
+}
+
+func TestResponseWriterPartialWrite(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := &responseWriter{ResponseWriter: &failingWriter{ResponseRecorder: rec, limit: 3}, statusCode: http.StatusOK}
+
+	n, err := rw.Write([]byte("hello"))
+	if n != 3 || !errors.Is(err, syscall.EPIPE) {
+		t.Fatalf("Write() = %d, %v; want 3, EPIPE", n, err)
+	}
+	if rw.bytesWritten != 3 {
+		t.Errorf("bytesWritten = %d, want 3", rw.bytesWritten)
+	}
+
+	// Flushing after a failed write reports the failure instead of flushing
+	if err := http.NewResponseController(rw).Flush(); !errors.Is(err, syscall.EPIPE) {
+		t.Errorf("Flush() error = %v, want EPIPE", err)
+	}
+	if rec.Flushed {
+		t.Error("flushed after a failed write")
+	}
+}
+
+func TestResponseWriterInterfaces(t *testing.T) {
+	var w http.ResponseWriter = &responseWriter{}
+	if _, ok := w.(http.Flusher); !ok {
+		t.Error("responseWriter does not implement http.Flusher")
+	}
+	if _, ok := w.(http.Hijacker); !ok {
+		t.Error("responseWriter does not implement http.Hijacker")
+	}
+
+	// Upgrades hijack the client connection through the logging middleware
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: "http://127.0.0.1:1", Audience: "a"})
+	gateway := httptest.NewServer(srv.loggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Hijack() error = %v", err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+		buf.Flush()
+	})))
+	defer gateway.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, gateway.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "test")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("upgrade request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+}
@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go-oauth2-proxy/src/internal/lifecycle"
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+// SetLifecycle makes the server emit its lifecycle events (listening,
+// draining, stopped) on r, continuing the sequence begun by the caller
+func (s *Server) SetLifecycle(r *lifecycle.Recorder) {
+	s.lifecycle = r
+}
+
+// ShutdownRequested is closed when a drain is requested over the admin API;
+// the caller should then run Shutdown just as it would on SIGTERM
+func (s *Server) ShutdownRequested() <-chan struct{} {
+	return s.drainRequested
+}
+
+// Shutdown stops the server in order: readiness flips to not-ready (then
+// waits drain_delay for load balancers to notice), the listener closes and
+// in-flight requests drain within shutdown_timeout (streams within
+// streaming_shutdown_timeout, see awaitDrain), skipped upstreams stop
+// being rechecked, the token manager stops its background refreshes, queued
+// tee records are written, and finally the response caches and idle upstream
+// connections are released. It returns the drain error, if any.
+func (s *Server) Shutdown() error {
+	cfg := s.config.Server
+
+	logger.Info("Shutdown: marking not ready", "drain_delay", cfg.DrainDelay)
+	s.draining.Store(true)
+	s.lifecycle.Emit(lifecycle.Draining)
+	if cfg.DrainDelay > 0 {
+		time.Sleep(time.Duration(cfg.DrainDelay) * time.Second)
+	}
+
+	timeout := time.Duration(cfg.ShutdownTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	streamingTimeout := time.Duration(cfg.StreamingShutdownTimeout) * time.Second
+	if streamingTimeout < timeout {
+		streamingTimeout = timeout
+	}
+	logger.Info("Shutdown: closing listener and draining in-flight requests",
+		"timeout", timeout,
+		"streaming_timeout", streamingTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), streamingTimeout)
+	defer cancel()
+	drained := make(chan error, 1)
+	go func() { drained <- s.httpServer.Shutdown(ctx) }()
+	err := s.awaitDrain(drained, timeout)
+	if err != nil {
+		logger.Warn("Shutdown: drain incomplete", "error", err)
+	}
+
+	if s.stopRecheck != nil {
+		s.stopRecheck()
+	}
+	if s.stopStats != nil {
+		s.stopStats()
+	}
+
+	logger.Info("Shutdown: closing token manager")
+	s.tokenManager.Close()
+
+	if s.tee != nil {
+		logger.Info("Shutdown: flushing traffic tee")
+		s.tee.close()
+	}
+
+	logger.Info("Shutdown: flushing caches")
+	for _, cache := range s.caches {
+		cache.purge()
+	}
+	s.upstreamMu.RLock()
+	for _, transport := range s.transports {
+		transport.CloseIdleConnections()
+	}
+	s.upstreamMu.RUnlock()
+
+	logger.Info("Shutdown: complete")
+	s.lifecycle.Emit(lifecycle.Stopped)
+	return err
+}
+
+// drainProgressInterval is how often the streams still draining are logged
+var drainProgressInterval = 5 * time.Second
+
+// awaitDrain waits for the listener's drain to report on drained. Requests
+// still in flight once timeout passes are cancelled, except those to
+// streaming upstreams: they may run until the drain's own deadline
+// (streaming_shutdown_timeout), and are cancelled if they outlast it. While
+// streams are awaited their count is logged every drainProgressInterval.
+func (s *Server) awaitDrain(drained <-chan error, timeout time.Duration) error {
+	defer s.cancelStreams()
+	defer s.cancelRequests()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-drained:
+		return err
+	case <-timer.C:
+	}
+
+	streams := s.activeStreams.Load()
+	cancelled := s.metrics.inFlight.Load() - streams
+	if cancelled > 0 {
+		logger.Warn("Shutdown: timeout reached, cancelling in-flight requests", "requests", cancelled)
+	}
+	s.cancelRequests()
+	if streams > 0 {
+		logger.Info("Shutdown: waiting for streaming requests", "streams", streams)
+	}
+
+	ticker := time.NewTicker(drainProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-drained:
+			if err == nil && cancelled > 0 {
+				err = fmt.Errorf("%d requests cancelled at shutdown_timeout", cancelled)
+			}
+			return err
+		case <-ticker.C:
+			logger.Info("Shutdown: waiting for streaming requests", "streams", s.activeStreams.Load())
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+	"go-oauth2-proxy/src/internal/lifecycle"
+	"go-oauth2-proxy/src/internal/logger"
+	"go-oauth2-proxy/src/internal/token"
+)
+
+func TestShutdownDrainsInOrder(t *testing.T) {
+	arrived := make(chan struct{})
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-release
+		w.Write([]byte("completed"))
+	}))
+	defer upstream.Close()
+
+	srv := newTestServerWithConfig(t, &config.Config{
+		Server: config.ServerConfig{ShutdownTimeout: 5},
+		Upstreams: []config.UpstreamConfig{{
+			Name: "api", URL: upstream.URL, Audience: "a",
+			Cache: config.CacheConfig{Enabled: true, MaxBytes: 1 << 20, MaxTTL: 60},
+		}},
+	})
+	logger.SetLevel("info")
+	t.Cleanup(func() { logger.SetLevel("error") })
+	logs := captureLogs(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go srv.serve(ln)
+	addr := "http://" + ln.Addr().String()
+
+	// Start a request and shut down while the upstream is still working on it
+	type result struct {
+		body string
+		err  error
+	}
+	inFlight := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(addr + "/slow")
+		if err != nil {
+			inFlight <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		inFlight <- result{string(body), err}
+	}()
+	<-arrived
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown() }()
+
+	// Readiness flips while the request is still draining
+	deadline := time.Now().Add(2 * time.Second)
+	for !srv.draining.Load() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if rec := serve(srv, httptest.NewRequest(http.MethodGet, "/readyz", nil)); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("readyz during drain = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown() returned before the in-flight request finished: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	if res := <-inFlight; res.err != nil || res.body != "completed" {
+		t.Fatalf("in-flight request = %q, %v; want it completed", res.body, res.err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	if _, err := http.Get(addr + "/"); err == nil {
+		t.Error("new connections accepted after shutdown")
+	}
+	if _, err := srv.tokenManager.GetToken("a"); !errors.Is(err, token.ErrClosed) {
+		t.Errorf("GetToken() after shutdown error = %v, want ErrClosed", err)
+	}
+
+	var events []lifecycle.Event
+	for _, record := range srv.lifecycle.History() {
+		events = append(events, record.Event)
+	}
+	if want := []lifecycle.Event{lifecycle.Listening, lifecycle.Draining, lifecycle.Stopped}; !slices.Equal(events, want) {
+		t.Errorf("lifecycle events = %v, want %v", events, want)
+	}
+
+	phases := []string{
+		"Shutdown: marking not ready",
+		"Shutdown: closing listener and draining",
+		"Shutdown: closing token manager",
+		"Shutdown: flushing caches",
+		"Shutdown: complete",
+	}
+	output, last := logs.String(), -1
+	for _, phase := range phases {
+		i := strings.Index(output, phase)
+		if i < 0 {
+			t.Fatalf("missing phase %q in logs:\n%s", phase, output)
+		}
+		if i < last {
+			t.Errorf("phase %q logged out of order", phase)
+		}
+		last = i
+	}
+}
+
+// newDrainServer serves a streaming upstream under /events, which sends one
+// event and a second once release closes, and a default upstream whose
+// requests hang until cancelled. It returns the gateway's address.
+func newDrainServer(t *testing.T, shutdownTimeout, streamingTimeout int, release chan struct{}) (*Server, string, chan string) {
+	t.Helper()
+	arrived := make(chan string, 2)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- r.URL.Path
+		if r.URL.Path != "/events" {
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "event: 1\n\n")
+		http.NewResponseController(w).Flush()
+		select {
+		case <-release:
+			io.WriteString(w, "event: 2\n\n")
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	srv := newTestServerWithConfig(t, &config.Config{
+		Server: config.ServerConfig{ShutdownTimeout: shutdownTimeout, StreamingShutdownTimeout: streamingTimeout},
+		Upstreams: []config.UpstreamConfig{
+			{Name: "api", URL: upstream.URL, Audience: "a"},
+			{Name: "events", URL: upstream.URL, Audience: "e", PathPrefix: "/events", PreservePathPrefix: true, Streaming: true},
+		},
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go srv.serve(ln)
+	return srv, "http://" + ln.Addr().String(), arrived
+}
+
+// get fetches url in the background, reporting the status and body read
+func get(url string) <-chan string {
+	result := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			result <- "error: " + err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		result <- resp.Status + " " + string(body)
+	}()
+	return result
+}
+
+func TestShutdownDrainsStreamsLonger(t *testing.T) {
+	drainProgressInterval = 50 * time.Millisecond
+	t.Cleanup(func() { drainProgressInterval = 5 * time.Second })
+	release := make(chan struct{})
+	srv, addr, arrived := newDrainServer(t, 1, 10, release)
+	logger.SetLevel("info")
+	t.Cleanup(func() { logger.SetLevel("error") })
+	logs := captureLogs(t)
+	request, stream := get(addr+"/slow"), get(addr+"/events")
+	<-arrived
+	<-arrived
+
+	start := time.Now()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown() }()
+
+	// The ordinary request is cut at shutdown_timeout...
+	if got := <-request; strings.HasPrefix(got, "200") {
+		t.Errorf("ordinary request = %q, want it cancelled", got)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("ordinary request cancelled after %v, want shutdown_timeout (1s)", elapsed)
+	}
+
+	// ...while the stream keeps draining until it completes
+	select {
+	case got := <-stream:
+		t.Fatalf("stream ended at shutdown_timeout: %q", got)
+	case err := <-shutdown:
+		t.Fatalf("Shutdown() returned with a stream in flight: %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+	close(release)
+	if got := <-stream; got != "200 OK event: 1\n\nevent: 2\n\n" {
+		t.Errorf("stream = %q, want both events", got)
+	}
+
+	err := <-shutdown
+	if err == nil || !strings.Contains(err.Error(), "1 requests cancelled") {
+		t.Errorf("Shutdown() error = %v, want the cancelled request reported", err)
+	}
+	if !strings.Contains(logs.String(), "Shutdown: waiting for streaming requests") {
+		t.Errorf("active streams not logged during drain:\n%s", logs.String())
+	}
+}
+
+func TestShutdownCancelsStreamsAfterStreamingTimeout(t *testing.T) {
+	srv, addr, arrived := newDrainServer(t, 1, 2, make(chan struct{}))
+	stream := get(addr + "/events")
+	<-arrived
+
+	start := time.Now()
+	if err := srv.Shutdown(); err == nil {
+		t.Error("Shutdown() error = nil, want the streaming drain reported incomplete")
+	}
+	if got := <-stream; strings.Contains(got, "event: 2") {
+		t.Errorf("stream = %q, want it cut short", got)
+	}
+	if elapsed := time.Since(start); elapsed < 2*time.Second || elapsed > 5*time.Second {
+		t.Errorf("shutdown took %v, want streaming_shutdown_timeout (2s)", elapsed)
+	}
+}
@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+	"go-oauth2-proxy/src/internal/logger"
+	"go-oauth2-proxy/src/internal/token"
+)
+
+// checkUpstream runs an upstream's startup checks and returns its transport.
+// The transport must build (custom CAs, dialer overrides) and the files the
+// upstream's tokens come from (its credentials map entry, its token file)
+// must be readable.
+func checkUpstream(upstream config.UpstreamConfig, creds map[string]string) (*http.Transport, error) {
+	transport, err := newUpstreamTransport(upstream)
+	if err != nil {
+		return nil, err
+	}
+	if !upstream.PassThrough {
+		if path, ok := creds[token.NormalizeAudience(upstream.Audience)]; ok {
+			if err := checkReadable(path); err != nil {
+				return nil, fmt.Errorf("credentials: %w", err)
+			}
+		}
+	}
+	if path := upstream.TokenFile.Path; path != "" {
+		if err := checkReadable(path); err != nil {
+			return nil, fmt.Errorf("token_file: %w", err)
+		}
+	}
+	return transport, nil
+}
+
+func checkReadable(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// skipReason reports why an upstream was skipped at startup, if it was
+func (s *Server) skipReason(name string) (string, bool) {
+	s.upstreamMu.RLock()
+	defer s.upstreamMu.RUnlock()
+	reason, skipped := s.skipped[name]
+	return reason, skipped
+}
+
+// skippedUpstreams returns the skipped upstreams and why they failed
+func (s *Server) skippedUpstreams() map[string]string {
+	s.upstreamMu.RLock()
+	defer s.upstreamMu.RUnlock()
+	skipped := make(map[string]string, len(s.skipped))
+	for name, reason := range s.skipped {
+		skipped[name] = reason
+	}
+	return skipped
+}
+
+// startupRetryInterval is how often skipped upstreams are rechecked
+func (s *Server) startupRetryInterval() time.Duration {
+	if interval := s.config.Server.StartupRetryInterval; interval > 0 {
+		return time.Duration(interval) * time.Second
+	}
+	return 30 * time.Second
+}
+
+// recheckSkipped retries the startup checks of skipped upstreams until they
+// have all recovered or ctx ends
+func (s *Server) recheckSkipped(ctx context.Context) {
+	ticker := time.NewTicker(s.startupRetryInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if s.retrySkipped() == 0 {
+			return
+		}
+	}
+}
+
+// retrySkipped reruns the startup checks of each skipped upstream against
+// the token manager's current credentials map, putting those that pass back
+// into service. It returns how many remain skipped.
+func (s *Server) retrySkipped() int {
+	creds := s.tokenManager.CredentialsMap()
+	skipped := s.skippedUpstreams()
+	names := make([]string, 0, len(skipped))
+	for name := range skipped {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	remaining := 0
+	for _, name := range names {
+		transport, err := checkUpstream(*s.upstreamMap[name], creds)
+
+		s.upstreamMu.Lock()
+		if err != nil {
+			s.skipped[name] = err.Error()
+			remaining++
+		} else {
+			s.transports[name] = transport
+			delete(s.skipped, name)
+		}
+		s.upstreamMu.Unlock()
+
+		if err != nil {
+			logger.Debug("Skipped upstream still failing its startup checks", "upstream", name, "error", err)
+		} else {
+			logger.Info("Skipped upstream recovered, now serving", "upstream", name)
+		}
+	}
+	return remaining
+}
+
+// MintStartupToken mints the first token of the first upstream minting its
+// own, retrying for up to token.startup_mint_window so a metadata server
+// still warming up delays startup rather than failing early requests. It
+// reports whether a token was minted: nothing is done when the window is
+// unset or no upstream mints its own tokens.
+func (s *Server) MintStartupToken(ctx context.Context) (bool, error) {
+	window := time.Duration(s.config.Token.StartupMintWindow) * time.Second
+	if window <= 0 {
+		return false, nil
+	}
+	for _, upstream := range s.config.Upstreams {
+		if upstream.PassThrough || upstream.TokenFile.Path != "" {
+			continue
+		}
+		if _, skipped := s.skipReason(upstream.Name); skipped {
+			continue
+		}
+		logger.Info("Waiting for the first token", "upstream", upstream.Name, "window", window.String())
+		if err := s.tokenManager.AwaitFirstToken(ctx, upstream.Audience, window); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// handleDebugConfig summarizes the running configuration: the startup
+// failure policy and each upstream, with its credentials map entry and the
+// error that got it skipped
+func (s *Server) handleDebugConfig(w http.ResponseWriter, r *http.Request) {
+	creds := s.tokenManager.CredentialsMap()
+	skipped := s.skippedUpstreams()
+	upstreams := make([]map[string]interface{}, 0, len(s.config.Upstreams))
+	skippedNames := make([]string, 0, len(skipped))
+	for _, upstream := range s.config.Upstreams {
+		entry := map[string]interface{}{
+			"name":         upstream.Name,
+			"url":          upstream.URL,
+			"audience":     upstream.Audience,
+			"pass_through": upstream.PassThrough,
+			"status":       "active",
+		}
+		if file, ok := creds[token.NormalizeAudience(upstream.Audience)]; ok && !upstream.PassThrough {
+			entry["credentials"] = file
+		}
+		if reason, ok := skipped[upstream.Name]; ok {
+			entry["status"] = "skipped"
+			entry["error"] = reason
+			skippedNames = append(skippedNames, upstream.Name)
+		}
+		upstreams = append(upstreams, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"startup_failure":   s.config.Server.StartupFailure,
+		"skipped_upstreams": skippedNames,
+		"upstreams":         upstreams,
+	})
+}
@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+// startupConfig returns a config with a healthy "api" upstream and a
+// "broken" one whose token file does not exist yet
+func startupConfig(t *testing.T, policy string) (*config.Config, string) {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(upstream.Close)
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	return &config.Config{
+		Server: config.ServerConfig{StartupFailure: policy, StartupRetryInterval: 3600},
+		Admin:  config.AdminConfig{Token: "s3cret"},
+		Upstreams: []config.UpstreamConfig{
+			{Name: "api", URL: upstream.URL, Audience: "a"},
+			{Name: "broken", URL: upstream.URL, Audience: "b", TokenFile: config.TokenFileConfig{Path: tokenPath, TTL: 60}},
+		},
+	}, tokenPath
+}
+
+func TestStartupFailClosed(t *testing.T) {
+	cfg, _ := startupConfig(t, config.StartupFailClosed)
+	cfg.Server.Address = "127.0.0.1"
+	if srv, err := NewServer(cfg); err == nil {
+		srv.Shutdown()
+		t.Fatal("NewServer() should fail when an upstream fails its startup checks")
+	} else if !strings.Contains(err.Error(), "upstream broken") {
+		t.Errorf("error = %v, want it to name the broken upstream", err)
+	}
+}
+
+func TestStartupFailOpen(t *testing.T) {
+	cfg, tokenPath := startupConfig(t, config.StartupFailOpen)
+	srv := newTestServerWithConfig(t, cfg)
+	defer srv.Shutdown()
+
+	route := func(name string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(targetUpstreamHeader, name)
+		return req
+	}
+
+	if rec := serve(srv, route("api")); rec.Code != http.StatusOK {
+		t.Errorf("healthy upstream status = %d, want 200", rec.Code)
+	}
+	rec := serve(srv, route("broken"))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("skipped upstream status = %d, want 503", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "3600" {
+		t.Errorf("Retry-After = %q, want the retry interval", got)
+	}
+
+	rec = serve(srv, adminRequest(http.MethodGet, "/debug/config", "s3cret"))
+	var debug struct {
+		StartupFailure   string   `json:"startup_failure"`
+		SkippedUpstreams []string `json:"skipped_upstreams"`
+		Upstreams        []struct {
+			Name   string `json:"name"`
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		} `json:"upstreams"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &debug); err != nil {
+		t.Fatalf("decode /debug/config %q: %v", rec.Body.String(), err)
+	}
+	if debug.StartupFailure != config.StartupFailOpen ||
+		len(debug.SkippedUpstreams) != 1 || debug.SkippedUpstreams[0] != "broken" {
+		t.Errorf("debug config = %+v, want broken skipped under fail_open", debug)
+	}
+	for _, upstream := range debug.Upstreams {
+		if upstream.Name == "broken" && (upstream.Status != "skipped" || !strings.Contains(upstream.Error, "token_file")) {
+			t.Errorf("broken upstream = %+v, want skipped with its error", upstream)
+		}
+	}
+
+	// Once the token file appears the next recheck restores the upstream
+	if err := os.WriteFile(tokenPath, []byte("file-token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if remaining := srv.retrySkipped(); remaining != 0 {
+		t.Fatalf("remaining skipped = %d, want 0", remaining)
+	}
+	if rec := serve(srv, route("broken")); rec.Code != http.StatusOK {
+		t.Errorf("recovered upstream status = %d, want 200", rec.Code)
+	}
+}
+
+func TestRetrySkippedUsesCurrentCredentialsMap(t *testing.T) {
+	cfg, tokenPath := startupConfig(t, config.StartupFailOpen)
+	srv := newTestServerWithConfig(t, cfg)
+	defer srv.Shutdown()
+
+	missing := filepath.Join(t.TempDir(), "missing-sa.json")
+	srv.tokenManager.SetCredentialsMap(map[string]string{"b": missing})
+	if err := os.WriteFile(tokenPath, []byte("file-token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if remaining := srv.retrySkipped(); remaining != 1 {
+		t.Fatalf("remaining skipped = %d, want 1", remaining)
+	}
+	if reason, _ := srv.skipReason("broken"); !strings.Contains(reason, "credentials") {
+		t.Errorf("skip reason = %q, want the credentials map entry to be checked", reason)
+	}
+
+	rec := serve(srv, adminRequest(http.MethodGet, "/debug/config", "s3cret"))
+	if !strings.Contains(rec.Body.String(), `"credentials":"`+missing+`"`) {
+		t.Errorf("debug config = %s, want the current credentials map entry", rec.Body.String())
+	}
+}
+
+func TestMintStartupTokenReportsWarmup(t *testing.T) {
+	tests := []struct {
+		name     string
+		window   int
+		upstream config.UpstreamConfig
+		want     bool
+	}{
+		{"window unset", 0, config.UpstreamConfig{Name: "api", URL: "http://127.0.0.1:1", Audience: "a"}, false},
+		{"minted", 5, config.UpstreamConfig{Name: "api", URL: "http://127.0.0.1:1", Audience: "a"}, true},
+		{"no upstream mints", 5, config.UpstreamConfig{Name: "api", PassThrough: true, AllowedHosts: []string{"example.com"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServerWithConfig(t, &config.Config{
+				Token:     config.TokenConfig{StartupMintWindow: tt.window},
+				Upstreams: []config.UpstreamConfig{tt.upstream},
+			})
+			defer srv.Shutdown()
+
+			minted, err := srv.MintStartupToken(context.Background())
+			if err != nil {
+				t.Fatalf("MintStartupToken() error = %v", err)
+			}
+			if minted != tt.want {
+				t.Errorf("minted = %v, want %v", minted, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"go-oauth2-proxy/src/internal/logger"
+	"go-oauth2-proxy/src/internal/token"
+)
+
+// logStats logs the gateway's aggregate stats at info level every interval
+// until ctx ends (see logging.stats_interval)
+func (s *Server) logStats(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		logger.Info("Gateway stats", s.statsAttrs()...)
+	}
+}
+
+// statsAttrs collects the cumulative request, error, token and cache
+// counters for the periodic stats log
+func (s *Server) statsAttrs() []any {
+	var requests int64
+	for _, t := range s.metrics.traffic {
+		requests += t.requests.Load()
+	}
+	var cacheEntries int
+	var cacheBytes int64
+	for _, cache := range s.caches {
+		entries, bytes := cache.usage()
+		cacheEntries += entries
+		cacheBytes += bytes
+	}
+	stats := s.tokenManager.GetStats()
+
+	attrs := []any{
+		"requests", requests,
+		"requests_in_flight", s.metrics.inFlight.Load(),
+		"proxy_errors", s.metrics.proxyErrors.Load(),
+		"client_disconnects", s.metrics.clientDisconnects.Load(),
+		"concurrency_rejections", s.metrics.concurrencyRejections.Load(),
+		"token_refreshes", stats.TotalRefreshed,
+		"token_errors", stats.TotalErrors,
+		"token_rejections", stats.TotalRejected,
+		"tokens_cached", stats.TotalCached,
+		"response_cache_entries", cacheEntries,
+		"response_cache_bytes", cacheBytes,
+	}
+
+	// One attribute per token state present, in a stable order
+	states := make([]string, 0, len(stats.States))
+	for state := range stats.States {
+		states = append(states, string(state))
+	}
+	sort.Strings(states)
+	for _, state := range states {
+		attrs = append(attrs, "tokens_state_"+strings.ToLower(state), stats.States[token.TokenState(state)])
+	}
+	return attrs
+}
@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+func TestStatsLoggedOnInterval(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("hello"))
+	}))
+	defer upstream.Close()
+
+	srv := newTestServerWithConfig(t, &config.Config{
+		Upstreams: []config.UpstreamConfig{{
+			Name: "api", URL: upstream.URL, Audience: "a",
+			Cache: config.CacheConfig{Enabled: true, MaxBytes: 1024, MaxTTL: 60},
+		}},
+	})
+	serve(srv, httptest.NewRequest(http.MethodGet, "/data", nil))
+
+	logger.SetLevel("info")
+	buf := captureLogs(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		srv.logStats(ctx, 20*time.Millisecond)
+		close(done)
+	}()
+	time.Sleep(110 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("logStats did not stop when its context ended")
+	}
+
+	logs := buf.String()
+	if n := strings.Count(logs, "Gateway stats"); n < 2 {
+		t.Fatalf("stats logged %d times, want one per interval; logs:\n%s", n, logs)
+	}
+	for _, want := range []string{"requests=1", "proxy_errors=0", "tokens_cached=1", "tokens_state_cached=1", "response_cache_entries=1", "response_cache_bytes=5"} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("stats log missing %s; logs:\n%s", want, logs)
+		}
+	}
+}
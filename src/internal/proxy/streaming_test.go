@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+// patternReader yields n bytes without holding them in memory
+type patternReader struct {
+	remaining int64
+}
+
+func (p *patternReader) Read(b []byte) (int, error) {
+	if p.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > p.remaining {
+		b = b[:p.remaining]
+	}
+	for i := range b {
+		b[i] = 'x'
+	}
+	p.remaining -= int64(len(b))
+	return len(b), nil
+}
+
+// newCountingUpstream returns an upstream that discards the request body
+// and replies with the number of bytes it received
+func newCountingUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(io.Discard, r.Body)
+		w.Write([]byte(strconv.FormatInt(n, 10)))
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+// uploadAllocs sends a streamed upload of size bytes through the server and
+// returns the bytes the upstream received and the bytes allocated meanwhile
+func uploadAllocs(t *testing.T, srv *Server, method string, size int64) (string, uint64) {
+	t.Helper()
+	req := httptest.NewRequest(method, "/upload", &patternReader{remaining: size})
+	req.ContentLength = -1
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	rec := serve(srv, req)
+	runtime.ReadMemStats(&after)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	return rec.Body.String(), after.TotalAlloc - before.TotalAlloc
+}
+
+func TestLargeUploadStreamsWithoutBuffering(t *testing.T) {
+	const size = 64 << 20
+	upstream := newCountingUpstream(t)
+
+	tests := []struct {
+		name      string
+		method    string
+		fallbacks []string
+	}{
+		// No retry-eligible feature: the body is streamed as-is
+		{"no fallback", http.MethodPut, nil},
+		// Fallbacks configured but POST is never retried, so never buffered
+		{"fallback non-idempotent", http.MethodPost, []string{"backup"}},
+		// Retry-eligible but over the replay cap: at most the cap is buffered
+		{"fallback over cap", http.MethodPut, []string{"backup"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t,
+				config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: "a", FallbackUpstreams: tt.fallbacks},
+				config.UpstreamConfig{Name: "backup", URL: upstream.URL, Audience: "b"},
+			)
+
+			received, allocated := uploadAllocs(t, srv, tt.method, size)
+			if received != strconv.Itoa(size) {
+				t.Errorf("upstream received %s bytes, want %d", received, size)
+			}
+			if allocated > size/4 {
+				t.Errorf("allocated %d bytes proxying a %d byte upload, want streaming", allocated, size)
+			}
+		})
+	}
+}
+
+// newEventStream returns an upstream that emits events at an interval,
+// flushing each one, for longer than the gateway's write timeout
+func newEventStream(t *testing.T, events int, interval time.Duration) *httptest.Server {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < events; i++ {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(interval)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+// readStream fetches url through a real connection and returns the body
+// received before the connection ended
+func readStream(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestStreamingUpstreamOutlivesWriteTimeout(t *testing.T) {
+	const events = 4
+	upstream := newEventStream(t, events, 250*time.Millisecond)
+
+	for _, streaming := range []bool{true, false} {
+		srv := newTestServer(t, config.UpstreamConfig{Name: "sse", URL: upstream.URL, Audience: "a", Streaming: streaming})
+		gateway := httptest.NewUnstartedServer(srv.httpServer.Handler)
+		gateway.Config.WriteTimeout = 500 * time.Millisecond
+		gateway.Start()
+
+		body := readStream(t, gateway.URL)
+		complete := strings.Count(body, "data: ") == events
+		if complete != streaming {
+			t.Errorf("streaming=%v: received %q, complete = %v", streaming, body, complete)
+		}
+		gateway.Close()
+	}
+}
+
+func TestStreamingFlushesEachEvent(t *testing.T) {
+	upstream := newEventStream(t, 2, time.Second)
+	srv := newTestServer(t, config.UpstreamConfig{Name: "sse", URL: upstream.URL, Audience: "a", Streaming: true})
+	gateway := httptest.NewServer(srv.httpServer.Handler)
+	defer gateway.Close()
+
+	resp, err := http.Get(gateway.URL)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// The first event arrives well before the upstream finishes
+	start := time.Now()
+	buf := make([]byte, len("data: 0\n\n"))
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		t.Fatalf("read first event: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("first event took %v, want it flushed immediately", elapsed)
+	}
+}
@@ -0,0 +1,308 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+// teeWriteTimeout bounds each POST to an HTTP tee sink
+const teeWriteTimeout = 5 * time.Second
+
+// teeRecord is one request/response pair written to the tee sink
+type teeRecord struct {
+	Timestamp  string     `json:"ts"`
+	RequestID  string     `json:"request_id"`
+	Upstream   string     `json:"upstream"`
+	DurationMs int64      `json:"duration_ms"`
+	Request    teeMessage `json:"request"`
+	Response   teeMessage `json:"response"`
+}
+
+// teeMessage is the captured half of an exchange; bodies beyond
+// max_body_bytes are cut short and flagged
+type teeMessage struct {
+	Method        string      `json:"method,omitempty"`
+	URL           string      `json:"url,omitempty"`
+	Host          string      `json:"host,omitempty"`
+	Status        int         `json:"status,omitempty"`
+	Header        http.Header `json:"header"`
+	Body          string      `json:"body"`
+	BodyTruncated bool        `json:"body_truncated,omitempty"`
+}
+
+// teeSink samples proxied traffic and writes the captured pairs to the
+// configured file or URL from a background goroutine
+type teeSink struct {
+	settings config.TeeConfig
+	redact   map[string]bool // configured redact_headers, canonicalized
+	random   func() float64
+	write    func(data []byte) error
+
+	queue    chan *teeRecord
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+	file     *os.File // nil for URL sinks
+
+	written atomic.Int64
+	dropped atomic.Int64
+}
+
+func newTeeSink(settings config.TeeConfig) (*teeSink, error) {
+	t := &teeSink{
+		settings: settings,
+		redact:   make(map[string]bool),
+		random:   rand.Float64,
+		queue:    make(chan *teeRecord, settings.QueueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, name := range settings.RedactHeaders {
+		t.redact[http.CanonicalHeaderKey(name)] = true
+	}
+
+	if settings.File != "" {
+		file, err := os.OpenFile(settings.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		t.file = file
+		t.write = func(data []byte) error {
+			_, err := file.Write(append(data, '\n'))
+			return err
+		}
+	} else {
+		client := &http.Client{Timeout: teeWriteTimeout}
+		t.write = func(data []byte) error {
+			resp, err := client.Post(settings.URL, "application/json", bytes.NewReader(data))
+			if err != nil {
+				return err
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				return fmt.Errorf("sink returned %s", resp.Status)
+			}
+			return nil
+		}
+	}
+
+	go t.run()
+	return t, nil
+}
+
+// run writes queued records until close, then drains what is left
+func (t *teeSink) run() {
+	defer close(t.done)
+	for {
+		select {
+		case record := <-t.queue:
+			t.writeRecord(record)
+		case <-t.stop:
+			for {
+				select {
+				case record := <-t.queue:
+					t.writeRecord(record)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (t *teeSink) writeRecord(record *teeRecord) {
+	data, err := json.Marshal(record)
+	if err == nil {
+		err = t.write(data)
+	}
+	if err != nil {
+		logger.Warn("Failed to write tee record", "request_id", record.RequestID, "error", err)
+		return
+	}
+	t.written.Add(1)
+}
+
+// close stops accepting records and waits for the queue to be written
+func (t *teeSink) close() {
+	t.stopOnce.Do(func() {
+		close(t.stop)
+		<-t.done
+		if t.file != nil {
+			t.file.Close()
+		}
+	})
+}
+
+// sample reports whether this request should be teed
+func (t *teeSink) sample() bool {
+	return t.random() < t.settings.SampleRate
+}
+
+// enqueue hands a record to the writer without ever blocking; the record is
+// dropped when the queue is full or the sink is closed
+func (t *teeSink) enqueue(record *teeRecord) {
+	select {
+	case <-t.stop:
+		t.dropped.Add(1)
+		return
+	default:
+	}
+	select {
+	case t.queue <- record:
+	default:
+		t.dropped.Add(1)
+		logger.Debug("Tee queue full, dropping record", "request_id", record.RequestID)
+	}
+}
+
+// redactHeaders returns a copy of h with credential headers and the
+// configured redact_headers masked
+func (t *teeSink) redactHeaders(h http.Header) http.Header {
+	redacted := h.Clone()
+	if redacted == nil {
+		redacted = make(http.Header)
+	}
+	for name, values := range redacted {
+		canonical := http.CanonicalHeaderKey(name)
+		if sensitiveHeaders[canonical] || t.redact[canonical] {
+			masked := make([]string, len(values))
+			for i := range masked {
+				masked[i] = "[REDACTED]"
+			}
+			redacted[name] = masked
+		}
+	}
+	return redacted
+}
+
+// capture starts recording the exchange: the request body is copied as the
+// upstream reads it, and the returned writer copies the response
+func (t *teeSink) capture(w http.ResponseWriter, r *http.Request) *teeCapture {
+	c := &teeCapture{
+		ResponseWriter: w,
+		sink:           t,
+		start:          time.Now(),
+		request: teeMessage{
+			Method: r.Method,
+			URL:    r.URL.RequestURI(),
+			Host:   r.Host,
+			Header: t.redactHeaders(r.Header),
+		},
+		requestBody:  &cappedBuffer{max: t.settings.MaxBodyBytes},
+		responseBody: &cappedBuffer{max: t.settings.MaxBodyBytes},
+		status:       http.StatusOK,
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = &teeBody{ReadCloser: r.Body, copy: c.requestBody}
+	}
+	return c
+}
+
+// teeCapture records a response on its way to the client
+type teeCapture struct {
+	http.ResponseWriter
+	sink         *teeSink
+	start        time.Time
+	request      teeMessage
+	requestBody  *cappedBuffer
+	responseBody *cappedBuffer
+	status       int
+	header       http.Header
+	wroteHeader  bool
+}
+
+func (c *teeCapture) WriteHeader(code int) {
+	if !c.wroteHeader {
+		c.wroteHeader = true
+		c.status = code
+		c.header = c.ResponseWriter.Header().Clone()
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *teeCapture) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	c.responseBody.write(b)
+	return c.ResponseWriter.Write(b)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (c *teeCapture) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// finish queues the captured pair for the sink
+func (c *teeCapture) finish(r *http.Request) {
+	if !c.wroteHeader {
+		c.header = c.ResponseWriter.Header().Clone()
+	}
+
+	record := &teeRecord{
+		Timestamp:  c.start.UTC().Format(time.RFC3339Nano),
+		DurationMs: time.Since(c.start).Milliseconds(),
+		Request:    c.request,
+		Response: teeMessage{
+			Status: c.status,
+			Header: c.sink.redactHeaders(c.header),
+		},
+	}
+	if info := getRequestInfo(r); info != nil {
+		record.RequestID = info.requestID
+		record.Upstream = info.upstream
+	}
+	record.Request.Body, record.Request.BodyTruncated = c.requestBody.contents()
+	record.Response.Body, record.Response.BodyTruncated = c.responseBody.contents()
+	c.sink.enqueue(record)
+}
+
+// teeBody copies a request body into a cappedBuffer as it is read
+type teeBody struct {
+	io.ReadCloser
+	copy *cappedBuffer
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.copy.write(p[:n])
+	return n, err
+}
+
+// cappedBuffer keeps the first max bytes written to it. It is locked since
+// the transport may still be reading a request body when the exchange is
+// recorded.
+type cappedBuffer struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	max       int64
+	truncated bool
+}
+
+func (c *cappedBuffer) write(p []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if room := c.max - int64(c.buf.Len()); int64(len(p)) > room {
+		p = p[:max(room, 0)]
+		c.truncated = true
+	}
+	c.buf.Write(p)
+}
+
+func (c *cappedBuffer) contents() (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.String(), c.truncated
+}
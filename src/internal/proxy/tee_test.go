@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+func TestTeeSampling(t *testing.T) {
+	sink, err := newTeeSink(config.TeeConfig{SampleRate: 0.25, File: filepath.Join(t.TempDir(), "tee.jsonl"), QueueSize: 1})
+	if err != nil {
+		t.Fatalf("newTeeSink() error = %v", err)
+	}
+	defer sink.close()
+
+	draws := []float64{0.1, 0.3, 0.24, 0.9, 0.25}
+	sink.random = func() float64 {
+		v := draws[0]
+		draws = draws[1:]
+		return v
+	}
+	var sampled []bool
+	for range 5 {
+		sampled = append(sampled, sink.sample())
+	}
+	want := []bool{true, false, true, false, false}
+	for i := range want {
+		if sampled[i] != want[i] {
+			t.Errorf("sample %d = %v, want %v", i, sampled[i], want[i])
+		}
+	}
+}
+
+// readTeeRecords closes the server's tee and returns the records it wrote
+func readTeeRecords(t *testing.T, srv *Server, path string) []teeRecord {
+	t.Helper()
+	srv.tee.close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open tee file: %v", err)
+	}
+	defer file.Close()
+
+	var records []teeRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record teeRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("decode tee record %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestTeeRecordsRedactedPair(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "secret-key" {
+			t.Errorf("upstream X-Api-Key = %q, want the real value", r.Header.Get("X-Api-Key"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Set-Cookie", "session=abc")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created: " + string(body)))
+	}))
+	defer upstream.Close()
+
+	path := filepath.Join(t.TempDir(), "tee.jsonl")
+	srv := newTestServerWithConfig(t, &config.Config{
+		Upstreams: []config.UpstreamConfig{{Name: "api", URL: upstream.URL, Audience: "a"}},
+		Tee: config.TeeConfig{
+			Enabled: true, SampleRate: 1, File: path, MaxBodyBytes: 1024, QueueSize: 10,
+			RedactHeaders: []string{"x-api-key"},
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/items?id=1", strings.NewReader(`{"name":"widget"}`))
+	req.Header.Set("Authorization", "Bearer client-token")
+	req.Header.Set("X-Api-Key", "secret-key")
+	req.Header.Set("Content-Type", "application/json")
+	if rec := serve(srv, req); rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want 201", rec.Code)
+	}
+
+	records := readTeeRecords(t, srv, path)
+	if len(records) != 1 {
+		t.Fatalf("tee records = %d, want 1", len(records))
+	}
+	record := records[0]
+	if record.Upstream != "api" || record.RequestID == "" {
+		t.Errorf("upstream = %q, request_id = %q", record.Upstream, record.RequestID)
+	}
+	if record.Request.Method != http.MethodPost || record.Request.URL != "/items?id=1" ||
+		record.Request.Body != `{"name":"widget"}` {
+		t.Errorf("request = %+v", record.Request)
+	}
+	if record.Response.Status != http.StatusCreated || record.Response.Body != `created: {"name":"widget"}` {
+		t.Errorf("response = %+v", record.Response)
+	}
+
+	for _, h := range []struct {
+		header http.Header
+		name   string
+	}{
+		{record.Request.Header, "Authorization"},
+		{record.Request.Header, "X-Api-Key"},
+		{record.Response.Header, "Set-Cookie"},
+	} {
+		if got := h.header.Get(h.name); got != "[REDACTED]" {
+			t.Errorf("%s = %q, want redacted", h.name, got)
+		}
+	}
+	if got := record.Request.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want kept", got)
+	}
+}
+
+func TestTeeTruncatesBodies(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("0123456789"))
+	}))
+	defer upstream.Close()
+
+	path := filepath.Join(t.TempDir(), "tee.jsonl")
+	srv := newTestServerWithConfig(t, &config.Config{
+		Upstreams: []config.UpstreamConfig{{Name: "api", URL: upstream.URL, Audience: "a"}},
+		Tee:       config.TeeConfig{Enabled: true, SampleRate: 1, File: path, MaxBodyBytes: 4, QueueSize: 10},
+	})
+
+	rec := serve(srv, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("abcdefgh")))
+	if rec.Body.String() != "0123456789" {
+		t.Fatalf("client body = %q, want the full response", rec.Body.String())
+	}
+
+	records := readTeeRecords(t, srv, path)
+	if len(records) != 1 {
+		t.Fatalf("tee records = %d, want 1", len(records))
+	}
+	if req := records[0].Request; req.Body != "abcd" || !req.BodyTruncated {
+		t.Errorf("request body = %q (truncated %v), want abcd truncated", req.Body, req.BodyTruncated)
+	}
+	if resp := records[0].Response; resp.Body != "0123" || !resp.BodyTruncated {
+		t.Errorf("response body = %q (truncated %v), want 0123 truncated", resp.Body, resp.BodyTruncated)
+	}
+}
+
+func TestTeeNeverBlocks(t *testing.T) {
+	sink, err := newTeeSink(config.TeeConfig{SampleRate: 1, File: filepath.Join(t.TempDir(), "tee.jsonl"), QueueSize: 1})
+	if err != nil {
+		t.Fatalf("newTeeSink() error = %v", err)
+	}
+	release := make(chan struct{})
+	sink.write = func(data []byte) error {
+		<-release
+		return nil
+	}
+
+	// The writer stalls on the first record, the second fills the queue,
+	// and the rest are dropped without waiting
+	done := make(chan struct{})
+	go func() {
+		for range 5 {
+			sink.enqueue(&teeRecord{})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("enqueue blocked on a stalled sink")
+	}
+	if sink.dropped.Load() < 3 {
+		t.Errorf("dropped = %d, want at least 3", sink.dropped.Load())
+	}
+
+	close(release)
+	sink.close()
+	sink.enqueue(&teeRecord{}) // after close: dropped, no panic
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+// errResponseTimeout is returned by reads of an upstream response body once
+// the upstream's response_timeout has passed; it wraps DeadlineExceeded so
+// proxyErrorStatus maps it to 504
+var errResponseTimeout = fmt.Errorf("upstream response timeout: %w", context.DeadlineExceeded)
+
+// deadlineBody is an upstream response body bounded by its request's
+// context deadline. The transport aborts a read stalled past the deadline;
+// the resulting error is reported as errResponseTimeout.
+type deadlineBody struct {
+	io.ReadCloser
+	ctx        context.Context
+	upstream   string
+	forwarding bool // headers have been released to the client
+	read       int64
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if err != nil && err != io.EOF && errors.Is(b.ctx.Err(), context.DeadlineExceeded) {
+		if b.forwarding {
+			logger.Warn("Upstream response timed out mid-body, closing client connection",
+				"upstream", b.upstream,
+				"bytes_read", b.read)
+		}
+		return n, errResponseTimeout
+	}
+	return n, err
+}
+
+// awaitResponseBody bounds resp.Body by the request's deadline and waits for
+// its first byte, so a response that stalls before any body arrives fails
+// with errResponseTimeout while it can still be answered with a 504.
+// Protocol upgrades and responses without a body are left alone.
+func awaitResponseBody(resp *http.Response, upstream string) error {
+	if !hasResponseBody(resp) {
+		return nil
+	}
+
+	body := &deadlineBody{ReadCloser: resp.Body, ctx: resp.Request.Context(), upstream: upstream}
+	buffered := bufio.NewReader(body)
+	if _, err := buffered.Peek(1); err != nil && err != io.EOF {
+		return err
+	}
+	body.forwarding = true
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{buffered, body}
+	return nil
+}
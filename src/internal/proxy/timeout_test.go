@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+// stallingUpstream sends headers and prefix, then stalls until the gateway
+// gives up on the request
+func stallingUpstream(prefix string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, prefix)
+		http.NewResponseController(w).Flush()
+		<-r.Context().Done()
+	}))
+}
+
+func TestResponseTimeoutBeforeBody(t *testing.T) {
+	upstream := stallingUpstream("")
+	defer upstream.Close()
+
+	srv := newTestServerWithConfig(t, &config.Config{
+		Upstreams: []config.UpstreamConfig{{Name: "api", URL: upstream.URL, Audience: "a", ResponseTimeout: 1}},
+	})
+
+	start := time.Now()
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want 504 when no body byte arrives in time", rec.Code)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("request took %v, want the 1s response timeout to apply", elapsed)
+	}
+	if got := srv.metrics.proxyErrors.Load(); got != 1 {
+		t.Errorf("proxy errors = %d, want 1", got)
+	}
+}
+
+func TestResponseTimeoutMidBody(t *testing.T) {
+	upstream := stallingUpstream("partial")
+	defer upstream.Close()
+
+	srv := newTestServerWithConfig(t, &config.Config{
+		Upstreams: []config.UpstreamConfig{{Name: "api", URL: upstream.URL, Audience: "a", ResponseTimeout: 1}},
+	})
+	// A real server, so the aborted response closes the client connection
+	gateway := httptest.NewServer(srv.httpServer.Handler)
+	defer gateway.Close()
+
+	resp, err := http.Get(gateway.URL)
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want the upstream's 200 already sent", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err == nil {
+		t.Fatalf("body read %q without error, want the truncated response to fail", body)
+	}
+	if string(body) != "partial" {
+		t.Errorf("body = %q, want the bytes sent before the stall", body)
+	}
+}
+
+func TestResponseTimeoutNotHitByCompleteResponse(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "complete")
+	}))
+	defer upstream.Close()
+
+	srv := newTestServerWithConfig(t, &config.Config{
+		Upstreams: []config.UpstreamConfig{{Name: "api", URL: upstream.URL, Audience: "a", ResponseTimeout: 1}},
+	})
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		rec := serve(srv, httptest.NewRequest(method, "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s status = %d, want 200", method, rec.Code)
+		}
+	}
+	if rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil)); rec.Body.String() != "complete" {
+		t.Errorf("body = %q, want complete", rec.Body.String())
+	}
+}
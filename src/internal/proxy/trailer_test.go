@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+// writeTrailers writes a gRPC-style response with a declared trailer and
+// one set with http.TrailerPrefix
+func writeTrailers(w http.ResponseWriter) {
+	w.Header().Set("Trailer", "Grpc-Status")
+	w.Header().Set("Content-Type", "application/grpc")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, "data")
+	w.Header().Set("Grpc-Status", "0")
+	w.Header().Set(http.TrailerPrefix+"Grpc-Message", "ok")
+}
+
+func TestResponseTrailersForwarded(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Te") != "trailers" {
+			t.Errorf("upstream Te = %q, want trailers", r.Header.Get("Te"))
+		}
+		writeTrailers(w)
+	}))
+	defer upstream.Close()
+
+	srv := newTestServerWithConfig(t, &config.Config{
+		Upstreams: []config.UpstreamConfig{{Name: "api", URL: upstream.URL, Audience: "a"}},
+	})
+	gateway := httptest.NewServer(srv.httpServer.Handler)
+	defer gateway.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, gateway.URL, nil)
+	req.Header.Set("Te", "trailers")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request error = %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "data" {
+		t.Errorf("body = %q, want data", body)
+	}
+
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("Grpc-Status trailer = %q, want 0", got)
+	}
+	if got := resp.Trailer.Get("Grpc-Message"); got != "ok" {
+		t.Errorf("Grpc-Message trailer = %q, want ok", got)
+	}
+}
+
+// checksumBody sets the request's X-Checksum trailer once fully read, as a
+// client computing it while streaming would
+type checksumBody struct {
+	io.Reader
+	req *http.Request
+}
+
+func (b *checksumBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err == io.EOF {
+		b.req.Trailer.Set("X-Checksum", "abc123")
+	}
+	return n, err
+}
+
+func (b *checksumBody) Close() error { return nil }
+
+func TestRequestTrailersForwarded(t *testing.T) {
+	got := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		got <- r.Trailer.Get("X-Checksum")
+	}))
+	defer upstream.Close()
+
+	srv := newTestServerWithConfig(t, &config.Config{
+		Upstreams: []config.UpstreamConfig{{Name: "api", URL: upstream.URL, Audience: "a"}},
+	})
+	gateway := httptest.NewServer(srv.httpServer.Handler)
+	defer gateway.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, gateway.URL, nil)
+	req.Body = &checksumBody{Reader: strings.NewReader("payload"), req: req}
+	req.ContentLength = -1
+	req.Trailer = http.Header{"X-Checksum": nil}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request error = %v", err)
+	}
+	resp.Body.Close()
+
+	if checksum := <-got; checksum != "abc123" {
+		t.Errorf("upstream X-Checksum trailer = %q, want abc123", checksum)
+	}
+}
+
+func TestBufferedResponseReplaysTrailers(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	tee := newTeeRecorder(httptest.NewRecorder(), 1024)
+	writeTrailers(tee)
+	buffered := tee.result(req)
+
+	// Coalesced waiters and cache hits replay the buffered copy
+	rec := httptest.NewRecorder()
+	buffered.writeTo(rec, req)
+	trailer := rec.Result().Trailer
+	if trailer.Get("Grpc-Status") != "0" || trailer.Get("Grpc-Message") != "ok" {
+		t.Errorf("replayed trailers = %v, want Grpc-Status and Grpc-Message", trailer)
+	}
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+// applyRequestTransforms applies an upstream's request rules to the outgoing
+// request. It must run before the path is rewritten so path segments refer
+// to the client's request path. A rule's destination header is always
+// dropped first, so a client cannot supply it when the source is missing.
+func applyRequestTransforms(req *http.Request, rules []config.TransformRule) {
+	if len(rules) == 0 {
+		return
+	}
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+
+	for _, rule := range rules {
+		from, to, ok := parseTransformRule(rule)
+		if !ok {
+			continue
+		}
+		switch from.Kind {
+		case config.TransformHeader:
+			values := slices.Clone(req.Header.Values(from.Name))
+			req.Header.Del(to.Name)
+			if len(values) > 0 {
+				req.Header[to.Name] = values
+			}
+		case config.TransformPath:
+			req.Header.Del(to.Name)
+			if from.Segment > len(segments) {
+				continue
+			}
+			// Path segments are decoded and may hold bytes a header cannot
+			if value := segments[from.Segment-1]; value != "" && httpguts.ValidHeaderFieldValue(value) {
+				req.Header.Set(to.Name, value)
+			}
+		}
+	}
+}
+
+// applyResponseTransforms applies an upstream's response rules
+func applyResponseTransforms(h http.Header, rules []config.TransformRule) {
+	for _, rule := range rules {
+		if from, to, ok := parseTransformRule(rule); ok && from.Kind == config.TransformHeader {
+			copyHeader(h, from.Name, to.Name)
+		}
+	}
+}
+
+// parseTransformRule parses both ends of a rule; rules are validated at load
+// time, so a failure here only skips the rule
+func parseTransformRule(rule config.TransformRule) (from, to config.TransformRef, ok bool) {
+	from, err := config.ParseTransformRef(rule.From)
+	if err != nil {
+		return from, to, false
+	}
+	to, err = config.ParseTransformRef(rule.To)
+	return from, to, err == nil && to.Kind == config.TransformHeader
+}
+
+// copyHeader replaces dst with every value of src, if src is present
+func copyHeader(h http.Header, src, dst string) {
+	if values := h.Values(src); len(values) > 0 {
+		h[dst] = slices.Clone(values)
+	}
+}
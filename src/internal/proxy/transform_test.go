@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+func TestApplyRequestTransforms(t *testing.T) {
+	rules := []config.TransformRule{
+		{From: "path:2", To: "header:X-Tenant-ID"},
+		{From: "header:x-client", To: "header:X-Upstream-Client"},
+		{From: "path:9", To: "header:X-Missing-Segment"},
+		{From: "header:X-Absent", To: "header:X-Missing-Header"},
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		want   string
+	}{
+		{"path segment", "/tenants/acme/orders", "X-Tenant-ID", "acme"},
+		{"escaped segment", "/tenants/acme%20corp/orders", "X-Tenant-ID", "acme corp"},
+		{"header copy", "/tenants/acme", "X-Upstream-Client", "mobile"},
+		{"header copy keeps source", "/tenants/acme", "X-Client", "mobile"},
+		{"segment out of range", "/tenants/acme", "X-Missing-Segment", ""},
+		{"absent header", "/tenants/acme", "X-Missing-Header", ""},
+		{"unsafe segment skipped", "/tenants/a%0Ab", "X-Tenant-ID", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("X-Client", "mobile")
+			applyRequestTransforms(req, rules)
+			if got := req.Header.Get(tt.header); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyRequestTransformsDropsSpoofedDestination(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/tenants", nil)
+	req.Header.Set("X-Upstream-Client", "spoofed")
+	req.Header.Set("X-Tenant-ID", "spoofed")
+
+	applyRequestTransforms(req, []config.TransformRule{
+		{From: "header:X-Client", To: "header:X-Upstream-Client"},
+		{From: "path:2", To: "header:X-Tenant-ID"},
+	})
+
+	for _, name := range []string{"X-Upstream-Client", "X-Tenant-ID"} {
+		if got, exists := req.Header[name]; exists {
+			t.Errorf("%s = %v, want client value dropped", name, got)
+		}
+	}
+}
+
+func TestApplyResponseTransforms(t *testing.T) {
+	h := http.Header{}
+	h.Add("X-Request-Cost", "3")
+	h.Add("X-Request-Cost", "4")
+	h.Set("X-Cost", "stale")
+
+	applyResponseTransforms(h, []config.TransformRule{
+		{From: "header:X-Request-Cost", To: "header:X-Cost"},
+		{From: "header:X-Absent", To: "header:X-Other"},
+	})
+
+	if got := h.Values("X-Cost"); len(got) != 2 || got[0] != "3" || got[1] != "4" {
+		t.Errorf("X-Cost = %v, want every source value", got)
+	}
+	if _, exists := h["X-Other"]; exists {
+		t.Error("absent source should not create the destination")
+	}
+}
+
+func TestTransformsAppliedByProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream-Version", "v7")
+		w.Write([]byte(r.URL.Path + " tenant=" + r.Header.Get("X-Tenant-ID")))
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, config.UpstreamConfig{
+		Name: "api", URL: upstream.URL + "/base", Audience: "a",
+		Transform: config.TransformConfig{
+			Request:  []config.TransformRule{{From: "path:2", To: "header:X-Tenant-ID"}},
+			Response: []config.TransformRule{{From: "header:X-Upstream-Version", To: "header:X-API-Version"}},
+		},
+	})
+
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/tenants/acme/orders", nil))
+	// Segments are taken from the client's path, not the rewritten one
+	if got, want := rec.Body.String(), "/base/tenants/acme/orders tenant=acme"; got != want {
+		t.Errorf("upstream saw %q, want %q", got, want)
+	}
+	if got := rec.Header().Get("X-API-Version"); got != "v7" {
+		t.Errorf("X-API-Version = %q, want v7", got)
+	}
+}
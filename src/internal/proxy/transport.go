@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+// newUpstreamTransport builds an upstream's transport with its own dial,
+// TLS handshake and response header timeouts (zero leaves a phase unbounded),
+// dial and source address overrides, and any custom CAs it trusts
+func newUpstreamTransport(upstream config.UpstreamConfig) (*http.Transport, error) {
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(upstream.DialTimeout) * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if upstream.SourceAddress != "" {
+		ip := net.ParseIP(upstream.SourceAddress)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source_address %q", upstream.SourceAddress)
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	if dialAddress := upstream.DialAddress; dialAddress != "" {
+		// The transport still derives the TLS server name from the URL. The
+		// override is the endpoint itself, so environment proxies are skipped.
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, dialAddress)
+		}
+		transport.Proxy = nil
+	}
+	transport.TLSHandshakeTimeout = time.Duration(upstream.TLSHandshakeTimeout) * time.Second
+	transport.ResponseHeaderTimeout = time.Duration(upstream.ResponseHeaderTimeout) * time.Second
+	if upstream.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = time.Duration(upstream.IdleConnTimeout) * time.Second
+	}
+	if upstream.MaxIdleConns > 0 {
+		transport.MaxIdleConns = upstream.MaxIdleConns
+	}
+	if upstream.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = upstream.MaxIdleConnsPerHost
+	}
+
+	pool, err := upstream.CertPool()
+	if err != nil {
+		return nil, err
+	}
+	if pool != nil {
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return transport, nil
+}
+
+// transport returns the upstream's transport, retrying refused connections
+// when the upstream enables it
+func (s *Server) transport(upstream *config.UpstreamConfig) http.RoundTripper {
+	var transport http.RoundTripper = http.DefaultTransport
+	s.upstreamMu.RLock()
+	if t, exists := s.transports[upstream.Name]; exists {
+		transport = t
+	}
+	s.upstreamMu.RUnlock()
+	if upstream.RetryOnRefused {
+		transport = &refusedRetryTransport{next: transport, upstream: upstream, budget: s.retryBudgets[upstream.Name], metrics: s.metrics}
+	}
+	return transport
+}
@@ -0,0 +1,257 @@
+package proxy
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"go-oauth2-proxy/src/internal/config"
+)
+
+func TestUpstreamTransportTimeouts(t *testing.T) {
+	transport, err := newUpstreamTransport(config.UpstreamConfig{
+		TLSHandshakeTimeout:   3,
+		ResponseHeaderTimeout: 7,
+	})
+	if err != nil {
+		t.Fatalf("newUpstreamTransport() error = %v", err)
+	}
+	if transport.TLSHandshakeTimeout != 3*time.Second {
+		t.Errorf("TLSHandshakeTimeout = %v, want 3s", transport.TLSHandshakeTimeout)
+	}
+	if transport.ResponseHeaderTimeout != 7*time.Second {
+		t.Errorf("ResponseHeaderTimeout = %v, want 7s", transport.ResponseHeaderTimeout)
+	}
+	if transport.DialContext == nil {
+		t.Error("DialContext not set")
+	}
+}
+
+func TestUpstreamTransportIdleConns(t *testing.T) {
+	transport, err := newUpstreamTransport(config.UpstreamConfig{
+		IdleConnTimeout:     15,
+		MaxIdleConns:        40,
+		MaxIdleConnsPerHost: 20,
+	})
+	if err != nil {
+		t.Fatalf("newUpstreamTransport() error = %v", err)
+	}
+	if transport.IdleConnTimeout != 15*time.Second {
+		t.Errorf("IdleConnTimeout = %v, want 15s", transport.IdleConnTimeout)
+	}
+	if transport.MaxIdleConns != 40 || transport.MaxIdleConnsPerHost != 20 {
+		t.Errorf("MaxIdleConns = %d, MaxIdleConnsPerHost = %d, want 40 and 20",
+			transport.MaxIdleConns, transport.MaxIdleConnsPerHost)
+	}
+
+	// Zero values keep the stock transport's pooling; config.Load fills in
+	// the gateway's defaults (10 idle connections per host, not Go's 2)
+	// before transports are built
+	transport, err = newUpstreamTransport(config.UpstreamConfig{})
+	if err != nil {
+		t.Fatalf("newUpstreamTransport() error = %v", err)
+	}
+	stock := http.DefaultTransport.(*http.Transport)
+	if transport.IdleConnTimeout != stock.IdleConnTimeout || transport.MaxIdleConns != stock.MaxIdleConns ||
+		transport.MaxIdleConnsPerHost != stock.MaxIdleConnsPerHost {
+		t.Errorf("zero idle settings = %v, %d, %d, want the stock %v, %d, %d",
+			transport.IdleConnTimeout, transport.MaxIdleConns, transport.MaxIdleConnsPerHost,
+			stock.IdleConnTimeout, stock.MaxIdleConns, stock.MaxIdleConnsPerHost)
+	}
+}
+
+func TestResponseHeaderTimeoutEnforced(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(1500 * time.Millisecond)
+		w.Write([]byte("late"))
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: "a", ResponseHeaderTimeout: 1})
+
+	if rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil)); rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusGatewayTimeout)
+	}
+}
+
+func TestResponseHeaderTimeoutAllowsSlowBody(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(1500 * time.Millisecond)
+		w.Write([]byte("slow body"))
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: "a", ResponseHeaderTimeout: 1})
+
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "slow body" {
+		t.Errorf("got %d %q, want the slow body delivered", rec.Code, rec.Body.String())
+	}
+}
+
+func TestTLSHandshakeTimeoutEnforced(t *testing.T) {
+	// Accept connections but never speak TLS
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	srv := newTestServer(t, config.UpstreamConfig{
+		Name:                "api",
+		URL:                 "https://" + ln.Addr().String(),
+		Audience:            "a",
+		TLSHandshakeTimeout: 1,
+	})
+
+	start := time.Now()
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusGatewayTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("handshake timeout took %v, want about 1s", elapsed)
+	}
+}
+
+func TestUpstreamCustomCA(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("private pki"))
+	}))
+	defer upstream.Close()
+
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw}))
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, []byte(caPEM), 0o600); err != nil {
+		t.Fatalf("write ca file: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		upstream   config.UpstreamConfig
+		wantStatus int
+	}{
+		{"untrusted", config.UpstreamConfig{}, http.StatusBadGateway},
+		{"inline", config.UpstreamConfig{CAPEM: caPEM}, http.StatusOK},
+		{"file", config.UpstreamConfig{CAFile: caFile}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.upstream.Name, tt.upstream.URL, tt.upstream.Audience = "api", upstream.URL, "a"
+			srv := newTestServer(t, tt.upstream)
+
+			if rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil)); rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestKeepAliveConnectionGetsFreshTokenPerRequest(t *testing.T) {
+	var remotes, auths []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remotes = append(remotes, r.RemoteAddr)
+		auths = append(auths, r.Header.Get("Authorization"))
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, config.UpstreamConfig{Name: "api", URL: upstream.URL, Audience: "a"})
+	var minted atomic.Int32
+	srv.tokenManager.SetSourceFunc(func(ctx context.Context, audience string) (oauth2.TokenSource, error) {
+		return tokenSourceFunc(func() (*oauth2.Token, error) {
+			n := minted.Add(1)
+			return &oauth2.Token{AccessToken: fmt.Sprintf("token-%d", n), Expiry: time.Now().Add(time.Hour)}, nil
+		}), nil
+	})
+
+	for i := 0; i < 2; i++ {
+		if rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil)); rec.Code != http.StatusOK {
+			t.Fatalf("request %d status = %d", i, rec.Code)
+		}
+		// Force the next request to mint a new token
+		srv.tokenManager.MarkRejected("a")
+	}
+
+	if len(remotes) != 2 || remotes[0] != remotes[1] {
+		t.Fatalf("upstream connections = %v, want one reused connection", remotes)
+	}
+	if auths[0] != "Bearer token-1" || auths[1] != "Bearer token-2" {
+		t.Errorf("Authorization = %v, want a fresh token per request", auths)
+	}
+}
+
+// tokenSourceFunc adapts a function to oauth2.TokenSource
+type tokenSourceFunc func() (*oauth2.Token, error)
+
+func (f tokenSourceFunc) Token() (*oauth2.Token, error) { return f() }
+
+func TestUpstreamDialAddress(t *testing.T) {
+	var host string
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+		w.Write([]byte("private endpoint"))
+	}))
+	defer upstream.Close()
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw}))
+
+	// example.com is in the test certificate but never resolves to the test
+	// server, so the request only succeeds if the override address is dialed
+	// and the TLS server name still comes from the URL
+	srv := newTestServer(t, config.UpstreamConfig{
+		Name: "api", URL: "https://example.com", Audience: "a", CAPEM: caPEM,
+		DialAddress:   upstream.Listener.Addr().String(),
+		SourceAddress: "127.0.0.1",
+	})
+
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "private endpoint" {
+		t.Fatalf("response = %d %q, want the override endpoint's 200", rec.Code, rec.Body.String())
+	}
+	if host != "example.com" {
+		t.Errorf("upstream Host = %q, want example.com from the url", host)
+	}
+}
+
+func TestUpstreamSourceAddress(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("binding to 127.0.0.2 relies on Linux routing all of 127/8 to loopback")
+	}
+	var remote string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote = r.RemoteAddr
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, config.UpstreamConfig{
+		Name: "api", URL: upstream.URL, Audience: "a", SourceAddress: "127.0.0.2",
+	})
+	if rec := serve(srv, httptest.NewRequest(http.MethodGet, "/", nil)); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if ip, _, _ := net.SplitHostPort(remote); ip != "127.0.0.2" {
+		t.Errorf("upstream saw connection from %q, want 127.0.0.2", remote)
+	}
+}
@@ -0,0 +1,110 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+
+	"golang.org/x/oauth2/google"
+	"gopkg.in/yaml.v3"
+
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+// LoadCredentialsMap reads a YAML mapping of audience to credentials file
+// path, so upstreams needing distinct service accounts keep that (sensitive)
+// mapping out of the main config. Audiences are normalized, and relative
+// paths are kept as written. A warning is logged when the file is readable by
+// group or others.
+func LoadCredentialsMap(path string) (map[string]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials map: %w", err)
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		logger.Warn("Credentials map is accessible to group or others",
+			"path", path, "mode", info.Mode().Perm().String())
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials map: %w", err)
+	}
+	var raw map[string]string
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials map: %w", err)
+	}
+
+	creds := make(map[string]string, len(raw))
+	for audience, file := range raw {
+		if file == "" {
+			return nil, fmt.Errorf("credentials map: empty path for audience %q", audience)
+		}
+		normalized := NormalizeAudience(audience)
+		if existing, dup := creds[normalized]; dup && existing != file {
+			return nil, fmt.Errorf("credentials map: conflicting paths for audience %q", normalized)
+		}
+		creds[normalized] = file
+	}
+	return creds, nil
+}
+
+// SetCredentialsMap sets per-audience credentials files; audiences without
+// an entry use the manager's default credentials
+func (m *Manager) SetCredentialsMap(creds map[string]string) {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+	m.credsMap = creds
+}
+
+// CredentialsMap returns the per-audience credentials files currently set,
+// keyed by normalized audience
+func (m *Manager) CredentialsMap() map[string]string {
+	m.cacheMu.RLock()
+	defer m.cacheMu.RUnlock()
+	return maps.Clone(m.credsMap)
+}
+
+// credentialsFor returns the credentials file used to mint tokens for an
+// (already normalized) audience; the caller must hold m.cacheMu
+func (m *Manager) credentialsFor(audience string) string {
+	if file, exists := m.credsMap[audience]; exists {
+		return file
+	}
+	return m.credsFile
+}
+
+// ErrNoCredentials is returned when no credentials file is configured and
+// Application Default Credentials cannot be found either
+var ErrNoCredentials = errors.New("no credentials configured and Application Default Credentials unavailable: " +
+	"set GOOGLE_APPLICATION_CREDENTIALS (or -credentials) to a service account key file, " +
+	"run `gcloud auth application-default login`, " +
+	"or run on GCP with a service account attached")
+
+// findDefaultCredentials looks up Application Default Credentials; tests
+// replace it to simulate their absence
+var findDefaultCredentials = google.FindDefaultCredentials
+
+// CheckDefaultCredentials reports ErrNoCredentials when Application Default
+// Credentials cannot be found, so a gateway without a credentials file can
+// say so at startup rather than on its first proxied request
+func CheckDefaultCredentials(ctx context.Context) error {
+	if _, err := findDefaultCredentials(ctx); err != nil {
+		return fmt.Errorf("%w (%v)", ErrNoCredentials, err)
+	}
+	return nil
+}
+
+// credentialsError makes a token source error actionable when it comes from
+// having no credentials anywhere: no file configured and no ADC found
+func credentialsError(ctx context.Context, file string, err error) error {
+	if file != "" {
+		return err
+	}
+	if probeErr := CheckDefaultCredentials(ctx); probeErr != nil {
+		return probeErr
+	}
+	return err
+}
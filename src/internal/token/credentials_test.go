@@ -0,0 +1,165 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2/google"
+
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+// writeCredentialsMap writes the YAML mapping to a private temp file
+func writeCredentialsMap(t *testing.T, yaml string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "credentials.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatalf("failed to write credentials map: %v", err)
+	}
+	return path
+}
+
+func TestCredentialsMapLookup(t *testing.T) {
+	path := writeCredentialsMap(t, `
+https://Billing.run.app/: /secrets/billing-sa.json
+https://reports.run.app: /secrets/reports-sa.json
+`)
+	creds, err := LoadCredentialsMap(path)
+	if err != nil {
+		t.Fatalf("LoadCredentialsMap() error = %v", err)
+	}
+
+	m := newTestManager(t, nil)
+	m.credsFile = "/secrets/default-sa.json"
+	m.SetCredentialsMap(creds)
+
+	tests := []struct {
+		audience string
+		want     string
+	}{
+		{"https://billing.run.app", "/secrets/billing-sa.json"},
+		{"https://reports.run.app/", "/secrets/reports-sa.json"},
+		{"https://unmapped.run.app", "/secrets/default-sa.json"},
+	}
+	for _, tt := range tests {
+		if got := m.credentialsFor(NormalizeAudience(tt.audience)); got != tt.want {
+			t.Errorf("credentialsFor(%q) = %q, want %q", tt.audience, got, tt.want)
+		}
+	}
+}
+
+func TestCredentialsMapDefaultsWithoutMap(t *testing.T) {
+	m := newTestManager(t, nil)
+	m.credsFile = "/secrets/default-sa.json"
+	if got := m.credentialsFor("https://svc.run.app"); got != "/secrets/default-sa.json" {
+		t.Errorf("credentialsFor() = %q, want the default credentials", got)
+	}
+}
+
+func TestLoadCredentialsMapErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"missing file", filepath.Join(t.TempDir(), "missing.yaml")},
+		{"not a mapping", writeCredentialsMap(t, "- /secrets/a.json\n")},
+		{"empty path", writeCredentialsMap(t, "https://svc.run.app: \"\"\n")},
+		{"conflicting duplicates", writeCredentialsMap(t, "https://svc.run.app: /a.json\nhttps://SVC.run.app/: /b.json\n")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadCredentialsMap(tt.path); err == nil {
+				t.Error("LoadCredentialsMap() expected error")
+			}
+		})
+	}
+}
+
+func TestNoCredentialsAnywhere(t *testing.T) {
+	// No credentials file, and nowhere for ADC to come from
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("CLOUDSDK_CONFIG", t.TempDir())
+
+	logger.Init("error")
+	m := NewManager(context.Background(), "", 5)
+	defer m.Close()
+
+	_, err := m.GetToken("https://svc.run.app")
+	if err == nil {
+		t.Skip("Application Default Credentials are available in this environment")
+	}
+	if !errors.Is(err, ErrNoCredentials) {
+		t.Fatalf("GetToken() error = %v, want ErrNoCredentials", err)
+	}
+	if !strings.Contains(err.Error(), "GOOGLE_APPLICATION_CREDENTIALS") {
+		t.Errorf("error %q carries no guidance", err)
+	}
+}
+
+// stubDefaultCredentials makes the ADC probe report err for the rest of the
+// test, or find credentials when err is nil
+func stubDefaultCredentials(t *testing.T, err error) {
+	t.Helper()
+	orig := findDefaultCredentials
+	findDefaultCredentials = func(context.Context, ...string) (*google.Credentials, error) {
+		if err != nil {
+			return nil, err
+		}
+		return &google.Credentials{}, nil
+	}
+	t.Cleanup(func() { findDefaultCredentials = orig })
+}
+
+func TestCheckDefaultCredentials(t *testing.T) {
+	ctx := context.Background()
+
+	stubDefaultCredentials(t, errors.New("no ADC here"))
+	if err := CheckDefaultCredentials(ctx); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("without ADC: error = %v, want ErrNoCredentials", err)
+	}
+
+	stubDefaultCredentials(t, nil)
+	if err := CheckDefaultCredentials(ctx); err != nil {
+		t.Errorf("with ADC: error = %v, want nil", err)
+	}
+}
+
+func TestCredentialsErrorKeepsOtherFailures(t *testing.T) {
+	ctx := context.Background()
+	failure := errors.New("unsupported credentials type")
+
+	stubDefaultCredentials(t, errors.New("no ADC here"))
+	if err := credentialsError(ctx, "", failure); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("no file, no ADC: error = %v, want ErrNoCredentials", err)
+	}
+	// A configured file that fails is a different problem
+	if err := credentialsError(ctx, "/secrets/sa.json", failure); err != failure {
+		t.Errorf("configured file: error = %v, want it left as is", err)
+	}
+
+	// So is a failure while ADC is available
+	stubDefaultCredentials(t, nil)
+	if err := credentialsError(ctx, "", failure); err != failure {
+		t.Errorf("ADC available: error = %v, want it left as is", err)
+	}
+}
+
+func TestCredentialsMapUsedWhenMinting(t *testing.T) {
+	logger.Init("error")
+	missing := filepath.Join(t.TempDir(), "billing-sa.json")
+	m := NewManager(context.Background(), "", 5)
+	defer m.Close()
+	m.SetCredentialsMap(map[string]string{"https://billing.run.app": missing})
+
+	// The mapped file is resolved before the entry is locked and handed to
+	// the source; minting fails on it rather than on the default credentials
+	_, err := m.GetToken("https://billing.run.app")
+	if err == nil || !strings.Contains(err.Error(), "billing-sa.json") {
+		t.Errorf("GetToken() error = %v, want a failure reading the mapped file", err)
+	}
+}
@@ -0,0 +1,111 @@
+package token
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// fileCheckInterval is how often a FileSource stats its files for changes;
+// in between, Changed answers from the last check so token lookups do not
+// hit the filesystem every time
+var fileCheckInterval = time.Second
+
+// FileSource is a token source for tokens provisioned out-of-band (e.g.,
+// written by a sidecar for a non-GCP identity). The token is read from a
+// file and re-read whenever the file, or its companion expiry file, changes.
+type FileSource struct {
+	path       string
+	expiryPath string        // optional; holds an RFC 3339 time or Unix seconds
+	ttl        time.Duration // token lifetime when expiryPath is empty
+
+	mu      sync.Mutex
+	seen    string    // stat fingerprint of the files at the last read
+	checked time.Time // when the files were last statted
+	changed bool      // result of that check
+}
+
+// NewFileSource returns a source reading the token from path. The expiry
+// comes from expiryPath when set, otherwise the token is considered valid
+// for ttl after each read.
+func NewFileSource(path, expiryPath string, ttl time.Duration) *FileSource {
+	return &FileSource{path: path, expiryPath: expiryPath, ttl: ttl}
+}
+
+// Token reads the current token from disk
+func (f *FileSource) Token() (*oauth2.Token, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fingerprint := f.fingerprint()
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token file: %w", err)
+	}
+
+	expiry := time.Now().Add(f.ttl)
+	if f.expiryPath != "" {
+		if expiry, err = readExpiry(f.expiryPath); err != nil {
+			return nil, err
+		}
+	}
+
+	f.seen, f.checked, f.changed = fingerprint, time.Now(), false
+	return &oauth2.Token{
+		AccessToken: strings.TrimSpace(string(data)),
+		TokenType:   "Bearer",
+		Expiry:      expiry,
+	}, nil
+}
+
+// Changed reports whether the token or expiry file changed since the last
+// read, so the manager refreshes before the cached token expires. The files
+// are checked at most once per fileCheckInterval.
+func (f *FileSource) Changed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if time.Since(f.checked) >= fileCheckInterval {
+		f.checked = time.Now()
+		f.changed = f.fingerprint() != f.seen
+	}
+	return f.changed
+}
+
+// fingerprint identifies the current version of the files by modification
+// time and size; missing files yield a distinct value
+func (f *FileSource) fingerprint() string {
+	var b strings.Builder
+	for _, path := range []string{f.path, f.expiryPath} {
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil {
+			fmt.Fprintf(&b, "%d/%d;", info.ModTime().UnixNano(), info.Size())
+		} else {
+			b.WriteString("missing;")
+		}
+	}
+	return b.String()
+}
+
+// readExpiry parses an expiry file holding an RFC 3339 time or Unix seconds
+func readExpiry(path string) (time.Time, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read token expiry file: %w", err)
+	}
+	value := strings.TrimSpace(string(data))
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	expiry, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid token expiry %q: want RFC 3339 or Unix seconds", value)
+	}
+	return expiry, nil
+}
@@ -0,0 +1,126 @@
+package token
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+// writeFile writes data to path and moves its modification time forward so
+// the change is visible even on filesystems with coarse timestamps
+func writeFile(t *testing.T, path, data string, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatalf("chtimes %s: %v", path, err)
+	}
+}
+
+// checkFilesEvery sets how often FileSources stat their files for the test
+func checkFilesEvery(t *testing.T, interval time.Duration) {
+	t.Helper()
+	prev := fileCheckInterval
+	fileCheckInterval = interval
+	t.Cleanup(func() { fileCheckInterval = prev })
+}
+
+func TestFileSourceRead(t *testing.T) {
+	dir := t.TempDir()
+	tokenPath := filepath.Join(dir, "token")
+	expiryPath := filepath.Join(dir, "expiry")
+	writeFile(t, tokenPath, "secret-token\n", time.Now())
+
+	tok, err := NewFileSource(tokenPath, "", time.Hour).Token()
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if tok.AccessToken != "secret-token" {
+		t.Errorf("AccessToken = %q, want trimmed file contents", tok.AccessToken)
+	}
+	if until := time.Until(tok.Expiry); until < 59*time.Minute || until > time.Hour {
+		t.Errorf("expiry in %s, want the 1h TTL", until)
+	}
+
+	want := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, expiry := range []string{want.Format(time.RFC3339), "1893553445"} {
+		writeFile(t, expiryPath, expiry+"\n", time.Now())
+		tok, err := NewFileSource(tokenPath, expiryPath, time.Hour).Token()
+		if err != nil {
+			t.Fatalf("Token() with expiry %q error = %v", expiry, err)
+		}
+		if !tok.Expiry.Equal(want) {
+			t.Errorf("expiry %q parsed as %s, want %s", expiry, tok.Expiry, want)
+		}
+	}
+
+	writeFile(t, expiryPath, "tomorrow", time.Now())
+	if _, err := NewFileSource(tokenPath, expiryPath, time.Hour).Token(); err == nil {
+		t.Error("Token() expected error for an invalid expiry")
+	}
+	if _, err := NewFileSource(filepath.Join(dir, "missing"), "", time.Hour).Token(); err == nil {
+		t.Error("Token() expected error for a missing token file")
+	}
+}
+
+func TestFileSourceRefreshOnChange(t *testing.T) {
+	logger.Init("error")
+	checkFilesEvery(t, 0)
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	start := time.Now().Add(-time.Minute)
+	writeFile(t, tokenPath, "first", start)
+
+	m := NewManager(context.Background(), "", 5)
+	defer m.Close()
+	m.SetAudienceSource("sidecar", func(ctx context.Context, audience string) (oauth2.TokenSource, error) {
+		return NewFileSource(tokenPath, "", time.Hour), nil
+	})
+
+	for i := 0; i < 2; i++ {
+		if tok, err := m.GetToken("sidecar"); err != nil || tok != "first" {
+			t.Fatalf("GetToken() = %q, %v; want first", tok, err)
+		}
+	}
+
+	// The sidecar rotates the token long before the TTL runs out
+	writeFile(t, tokenPath, "second", start.Add(time.Second))
+	if tok, err := m.GetToken("sidecar"); err != nil || tok != "second" {
+		t.Fatalf("GetToken() after rewrite = %q, %v; want second", tok, err)
+	}
+	if meta := m.GetMetadata("sidecar"); meta.RefreshCount != 2 {
+		t.Errorf("refresh count = %d, want 2 (initial read and one change)", meta.RefreshCount)
+	}
+}
+
+func TestFileSourceChecksFilesPerInterval(t *testing.T) {
+	checkFilesEvery(t, time.Hour)
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	start := time.Now().Add(-time.Minute)
+	writeFile(t, tokenPath, "first", start)
+
+	source := NewFileSource(tokenPath, "", time.Hour)
+	if _, err := source.Token(); err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+
+	// Within the interval the last check is reused, without a stat
+	writeFile(t, tokenPath, "second", start.Add(time.Second))
+	if source.Changed() {
+		t.Error("Changed() = true within the check interval")
+	}
+
+	// Once the interval has passed the files are checked again
+	source.mu.Lock()
+	source.checked = time.Now().Add(-2 * time.Hour)
+	source.mu.Unlock()
+	if !source.Changed() {
+		t.Error("Changed() = false after the interval, want the rewrite noticed")
+	}
+}
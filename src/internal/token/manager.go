@@ -2,8 +2,13 @@ package token
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -16,13 +21,13 @@ import (
 type TokenState string
 
 const (
-	StateNew       TokenState = "NEW"        // Token not yet created
-	StateCached    TokenState = "CACHED"     // Token cached and valid
-	StateRefreshed TokenState = "REFRESHED"  // Token was refreshed
-	StateExpiring  TokenState = "EXPIRING"   // Token expiring soon
-	StateExpired   TokenState = "EXPIRED"    // Token expired
-	StateRejected  TokenState = "REJECTED"   // Token rejected by upstream
-	StateError     TokenState = "ERROR"      // Error getting token
+	StateNew       TokenState = "NEW"       // Token not yet created
+	StateCached    TokenState = "CACHED"    // Token cached and valid
+	StateRefreshed TokenState = "REFRESHED" // Token was refreshed
+	StateExpiring  TokenState = "EXPIRING"  // Token expiring soon
+	StateExpired   TokenState = "EXPIRED"   // Token expired
+	StateRejected  TokenState = "REJECTED"  // Token rejected by upstream
+	StateError     TokenState = "ERROR"     // Error getting token
 )
 
 // TokenMetadata holds metadata about a cached token
@@ -31,6 +36,7 @@ type TokenMetadata struct {
 	State         TokenState
 	Token         string
 	IssuedAt      time.Time
+	LastRefreshed time.Time // when the current token was minted
 	ExpiresAt     time.Time
 	LastUsed      time.Time
 	RefreshCount  int
@@ -41,62 +47,252 @@ type TokenMetadata struct {
 
 // TokenEntry represents a cached token with its source
 type TokenEntry struct {
-	tokenSource oauth2.TokenSource
-	metadata    *TokenMetadata
-	mu          sync.RWMutex
+	tokenSource         oauth2.TokenSource
+	metadata            *TokenMetadata
+	audience            string // minted verbatim; metadata.Audience is the cache key
+	refreshBeforeExpiry time.Duration
+	minLifetime         time.Duration // refresh when less life than this is left
+	evicted             bool          // removed from the cache; callers must look up again
+	mu                  sync.RWMutex
+}
+
+// ErrClosed is returned by GetToken once the manager has been closed
+var ErrClosed = errors.New("token manager closed")
+
+// ErrEmptyToken is returned when a token source yields an empty access token
+var ErrEmptyToken = errors.New("token source returned an empty token")
+
+// NormalizeAudience returns the canonical form used to key the token cache,
+// so equivalent audiences share one token: URL audiences get a lowercase
+// scheme and host and lose a trailing slash. Audiences that are not URLs
+// (e.g., OAuth client IDs) are used as-is. It is only a cache key: tokens
+// are minted for the audience exactly as it was configured.
+func NormalizeAudience(audience string) string {
+	u, err := url.Parse(audience)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return audience
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = strings.TrimSuffix(u.RawPath, "/")
+	return u.String()
 }
 
+// SourceFunc creates a token source for the given audience
+type SourceFunc func(ctx context.Context, audience string) (oauth2.TokenSource, error)
+
 // Manager handles token creation, caching, and refresh
 type Manager struct {
-	cache              map[string]*TokenEntry
-	cacheMu            sync.RWMutex
-	ctx                context.Context
-	credsFile          string
+	cache               map[string]*TokenEntry
+	cacheMu             sync.RWMutex
+	ctx                 context.Context
+	cancel              context.CancelFunc
+	wg                  sync.WaitGroup // background goroutines
+	refreshing          sync.Map       // audiences with a background refresh running
+	closeOnce           sync.Once
+	closed              atomic.Bool
+	credsFile           string
+	credsMap            map[string]string // per-audience credentials files
 	refreshBeforeExpiry time.Duration
+	refreshWindows      map[string]time.Duration // per-audience overrides
+	minLifetimes        map[string]time.Duration // per-audience minimum remaining lifetimes
+	clockSkew           atomic.Int64             // time.Duration; read under entry.mu, so not guarded by cacheMu
+	expiryGrace         atomic.Int64             // time.Duration
+	newSource           SourceFunc               // nil = Google ID tokens (see idTokenSource)
+	audienceSources     map[string]SourceFunc    // per-audience overrides of newSource
+	cacheHits           atomic.Int64
+	cacheMisses         atomic.Int64
+	maxEntries          int          // 0 = unlimited
+	maxTokenAge         atomic.Int64 // time.Duration, 0 = tokens live until their expiry; read under entry.mu
+	evictions           atomic.Int64
 }
 
 // NewManager creates a new token manager
 func NewManager(ctx context.Context, credsFile string, refreshBeforeMinutes int) *Manager {
-	return &Manager{
-		cache:              make(map[string]*TokenEntry),
-		ctx:                ctx,
-		credsFile:          credsFile,
+	ctx, cancel := context.WithCancel(ctx)
+	m := &Manager{
+		cache:               make(map[string]*TokenEntry),
+		ctx:                 ctx,
+		cancel:              cancel,
+		credsFile:           credsFile,
 		refreshBeforeExpiry: time.Duration(refreshBeforeMinutes) * time.Minute,
+		refreshWindows:      make(map[string]time.Duration),
+		minLifetimes:        make(map[string]time.Duration),
+		audienceSources:     make(map[string]SourceFunc),
 	}
+	return m
 }
 
-// GetToken returns a valid token for the given audience
-func (m *Manager) GetToken(audience string) (string, error) {
+// goBackground runs fn in a goroutine that Close cancels (via ctx) and waits for
+func (m *Manager) goBackground(fn func(ctx context.Context)) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		fn(m.ctx)
+	}()
+}
+
+// Close cancels the manager's context, stopping background goroutines and
+// token sources, and waits for them to exit. It is safe to call more than
+// once; GetToken fails with ErrClosed afterwards.
+func (m *Manager) Close() error {
+	m.closeOnce.Do(func() {
+		m.closed.Store(true)
+		m.cancel()
+		m.wg.Wait()
+		logger.Info("Token manager closed")
+	})
+	return nil
+}
+
+// SetSourceFunc overrides how token sources are created (e.g., for tests)
+func (m *Manager) SetSourceFunc(fn SourceFunc) {
 	m.cacheMu.Lock()
-	entry, exists := m.cache[audience]
-	if !exists {
-		// Create new entry
-		entry = &TokenEntry{
-			metadata: &TokenMetadata{
-				Audience:  audience,
-				State:     StateNew,
-				IssuedAt:  time.Now(),
-			},
-		}
-		m.cache[audience] = entry
+	defer m.cacheMu.Unlock()
+	m.newSource = fn
+}
+
+// SetAudienceSource overrides how the token source for one audience is
+// created (e.g., a FileSource for a pre-provisioned token), taking
+// precedence over SetSourceFunc
+func (m *Manager) SetAudienceSource(audience string, fn SourceFunc) {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+	m.audienceSources[NormalizeAudience(audience)] = fn
+}
+
+// SetRefreshBeforeExpiry overrides how long before expiry the token for an
+// audience is refreshed. If several callers set a window for the same
+// audience, the longest one is kept.
+func (m *Manager) SetRefreshBeforeExpiry(audience string, window time.Duration) {
+	audience = NormalizeAudience(audience)
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+
+	if window <= m.refreshWindows[audience] {
+		return
 	}
-	m.cacheMu.Unlock()
+	m.refreshWindows[audience] = window
 
-	entry.mu.Lock()
+	if entry, exists := m.cache[audience]; exists {
+		entry.mu.Lock()
+		entry.refreshBeforeExpiry = window
+		entry.mu.Unlock()
+	}
+}
+
+// SetMinTokenLifetime makes GetToken refresh the audience's token whenever
+// less than min of its life remains, so a slow upstream is never handed a
+// token that expires mid-request. If several callers set a minimum for the
+// same audience, the longest one is kept.
+func (m *Manager) SetMinTokenLifetime(audience string, min time.Duration) {
+	audience = NormalizeAudience(audience)
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+
+	if min <= m.minLifetimes[audience] {
+		return
+	}
+	m.minLifetimes[audience] = min
+
+	if entry, exists := m.cache[audience]; exists {
+		entry.mu.Lock()
+		entry.minLifetime = min
+		entry.mu.Unlock()
+	}
+}
+
+// SetExpiryBoundaries configures the clock controls applied at token expiry.
+// clockSkew allows for upstream clocks running ahead of ours: tokens are
+// treated as expiring that much earlier. grace allows for our clock running
+// ahead: a token is only marked EXPIRED once grace has passed its (skewed)
+// expiry, and until then it is still served if a refresh fails.
+func (m *Manager) SetExpiryBoundaries(clockSkew, grace time.Duration) {
+	m.clockSkew.Store(int64(clockSkew))
+	m.expiryGrace.Store(int64(grace))
+}
+
+// expiryPhase classifies a token at now against its expiry boundaries:
+//
+//	refreshAt = ExpiresAt - clockSkew - refresh window  (EXPIRING from here)
+//	expiredAt = ExpiresAt - clockSkew + expiryGrace     (EXPIRED from here)
+//
+// It returns StateCached while the token needs no refresh. The caller holds
+// entry.mu, so the boundaries are read without m.cacheMu.
+func (m *Manager) expiryPhase(entry *TokenEntry, now time.Time) TokenState {
+	skew, grace := time.Duration(m.clockSkew.Load()), time.Duration(m.expiryGrace.Load())
+
+	expiry := entry.metadata.ExpiresAt.Add(-skew)
+	switch {
+	case !now.Before(expiry.Add(grace)):
+		return StateExpired
+	case !now.Before(expiry.Add(-entry.refreshBeforeExpiry)):
+		return StateExpiring
+	default:
+		return StateCached
+	}
+}
+
+// refreshWindow returns the effective refresh window for an audience; the
+// caller must hold m.cacheMu
+func (m *Manager) refreshWindow(audience string) time.Duration {
+	if window, exists := m.refreshWindows[audience]; exists {
+		return window
+	}
+	return m.refreshBeforeExpiry
+}
+
+// idTokenSource creates a Google ID token source for the audience from the
+// credentials file. Without one, Application Default Credentials are used.
+func idTokenSource(ctx context.Context, audience, file string) (oauth2.TokenSource, error) {
+	ts, err := idtoken.NewTokenSource(ctx, audience, idtoken.WithCredentialsFile(file))
+	if err != nil {
+		return nil, credentialsError(ctx, file, err)
+	}
+	return ts, nil
+}
+
+// GetToken returns a valid token for the given audience. Equivalent
+// audiences share one token (see NormalizeAudience).
+func (m *Manager) GetToken(audience string) (string, error) {
+	if m.closed.Load() {
+		return "", ErrClosed
+	}
+	key := NormalizeAudience(audience)
+	newSource := m.sourceFunc(key)
+
+	entry := m.lockEntry(key, audience)
 	defer entry.mu.Unlock()
 
 	// Check if we need to refresh
+	cacheHit := true
 	if m.shouldRefresh(entry) {
-		if err := m.refreshToken(entry, audience); err != nil {
-			entry.metadata.State = StateError
+		cacheHit = false
+		m.cacheMisses.Add(1)
+		if err := m.refreshToken(entry, newSource); err != nil {
 			entry.metadata.ErrorCount++
 			entry.metadata.LastError = err.Error()
+
+			// A token that has not passed its expiry boundary is still usable
+			if entry.metadata.State == StateExpiring && entry.metadata.Token != "" {
+				logger.Warn("Token refresh failed, serving cached token",
+					"audience", audience,
+					"error", err,
+					"expires_in", time.Until(entry.metadata.ExpiresAt).String())
+				entry.metadata.LastUsed = time.Now()
+				return entry.metadata.Token, nil
+			}
+
+			entry.metadata.State = StateError
 			logger.Error("Failed to get/refresh token",
 				"audience", audience,
 				"error", err,
 				"error_count", entry.metadata.ErrorCount)
 			return "", err
 		}
+	} else {
+		m.cacheHits.Add(1)
 	}
 
 	// Update last used
@@ -104,6 +300,7 @@ func (m *Manager) GetToken(audience string) (string, error) {
 
 	logger.Debug("Token retrieved",
 		"audience", audience,
+		"cache_hit", cacheHit,
 		"state", entry.metadata.State,
 		"expires_in", time.Until(entry.metadata.ExpiresAt).String(),
 		"refresh_count", entry.metadata.RefreshCount)
@@ -111,6 +308,157 @@ func (m *Manager) GetToken(audience string) (string, error) {
 	return entry.metadata.Token, nil
 }
 
+// Refresh mints a new token for the audience right away, whether or not the
+// cached one is due for refresh, and returns the resulting metadata. The
+// token source is recreated so a source caching its own token cannot hand
+// back the old one. On failure the cached token and its source are kept.
+func (m *Manager) Refresh(audience string) (*TokenMetadata, error) {
+	if m.closed.Load() {
+		return nil, ErrClosed
+	}
+	key := NormalizeAudience(audience)
+	newSource := m.sourceFunc(key)
+
+	entry := m.lockEntry(key, audience)
+	defer entry.mu.Unlock()
+
+	previous := entry.tokenSource
+	entry.tokenSource = nil
+	if err := m.refreshToken(entry, newSource); err != nil {
+		entry.tokenSource = previous
+		entry.metadata.ErrorCount++
+		entry.metadata.LastError = err.Error()
+		if entry.metadata.Token == "" {
+			entry.metadata.State = StateError
+		}
+		logger.Error("Forced token refresh failed",
+			"audience", audience,
+			"error", err,
+			"error_count", entry.metadata.ErrorCount)
+		meta := *entry.metadata
+		return &meta, err
+	}
+
+	meta := *entry.metadata
+	return &meta, nil
+}
+
+// RefreshInBackground starts a Refresh of the audience's token in a
+// goroutine that Close waits for, unless one is already running for it. It
+// reports whether a refresh was started.
+func (m *Manager) RefreshInBackground(audience string) bool {
+	if m.closed.Load() {
+		return false
+	}
+	key := NormalizeAudience(audience)
+	if _, running := m.refreshing.LoadOrStore(key, struct{}{}); running {
+		return false
+	}
+	m.goBackground(func(ctx context.Context) {
+		defer m.refreshing.Delete(key)
+		if _, err := m.Refresh(audience); err == nil {
+			logger.Info("Background token refresh succeeded", "audience", audience)
+		}
+	})
+	return true
+}
+
+// lockEntry returns the cache entry for key with its lock held. An entry
+// evicted while we waited for its lock is detached from the cache; it is
+// looked up (or created) again so the caller's result is not lost.
+func (m *Manager) lockEntry(key, audience string) *TokenEntry {
+	entry := m.cacheEntry(key, audience)
+	entry.mu.Lock()
+	for entry.evicted {
+		entry.mu.Unlock()
+		entry = m.cacheEntry(key, audience)
+		entry.mu.Lock()
+	}
+	return entry
+}
+
+// cacheEntry returns the cache entry for key, creating it (and evicting the
+// least recently used entry if the cache is full) if needed. A new entry
+// mints tokens for audience, the form its first caller configured.
+func (m *Manager) cacheEntry(key, audience string) *TokenEntry {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+
+	entry, exists := m.cache[key]
+	if !exists {
+		if m.maxEntries > 0 && len(m.cache) >= m.maxEntries {
+			m.evictLRU()
+		}
+		// Create new entry
+		entry = &TokenEntry{
+			metadata: &TokenMetadata{
+				Audience: key,
+				State:    StateNew,
+				IssuedAt: time.Now(),
+			},
+			audience:            audience,
+			refreshBeforeExpiry: m.refreshWindow(key),
+			minLifetime:         m.minLifetimes[key],
+		}
+		m.cache[key] = entry
+	}
+	return entry
+}
+
+// evictLRU removes the least recently used entry (never-used entries count
+// from their creation). Entries locked by an in-flight refresh are skipped,
+// so the cache may briefly exceed its cap when all are busy. The caller must
+// hold m.cacheMu.
+func (m *Manager) evictLRU() {
+	var victim *TokenEntry
+	var victimUsed time.Time
+
+	for _, entry := range m.cache {
+		if !entry.mu.TryLock() {
+			continue
+		}
+		used := entry.metadata.LastUsed
+		if used.IsZero() {
+			used = entry.metadata.IssuedAt
+		}
+		if victim == nil || used.Before(victimUsed) {
+			if victim != nil {
+				victim.mu.Unlock()
+			}
+			victim, victimUsed = entry, used
+			continue
+		}
+		entry.mu.Unlock()
+	}
+
+	if victim == nil {
+		logger.Debug("Token cache full but every entry is busy", "entries", len(m.cache))
+		return
+	}
+	victim.evicted = true
+	delete(m.cache, victim.metadata.Audience)
+	victim.mu.Unlock()
+	m.evictions.Add(1)
+
+	logger.Debug("Evicted token cache entry",
+		"audience", victim.metadata.Audience,
+		"last_used", victimUsed)
+}
+
+// SetMaxEntries caps the number of cached audiences (0 = unlimited); the
+// least recently used entry is evicted to make room for a new one
+func (m *Manager) SetMaxEntries(n int) {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+	m.maxEntries = n
+}
+
+// SetMaxTokenAge caps how long a token is served after it was minted,
+// whatever its expiry (0 = no cap)
+func (m *Manager) SetMaxTokenAge(age time.Duration) {
+	m.maxTokenAge.Store(int64(age))
+}
+
 // shouldRefresh determines if a token needs to be refreshed
 func (m *Manager) shouldRefresh(entry *TokenEntry) bool {
 	meta := entry.metadata
@@ -125,14 +473,47 @@ func (m *Manager) shouldRefresh(entry *TokenEntry) bool {
 		return true
 	}
 
-	// Token expired
-	if time.Now().After(meta.ExpiresAt) {
-		meta.State = StateExpired
+	// Sources whose token can be replaced early (e.g., a rewritten file)
+	if src, ok := entry.tokenSource.(interface{ Changed() bool }); ok && src.Changed() {
+		logger.Info("Token source changed, will refresh", "audience", meta.Audience)
 		return true
 	}
 
-	// Token expiring soon
-	if time.Now().Add(m.refreshBeforeExpiry).After(meta.ExpiresAt) {
+	// Tokens past the maximum age are replaced however long they remain
+	// valid; the source is recreated since it may hand back its cached token
+	if maxAge := time.Duration(m.maxTokenAge.Load()); maxAge > 0 && time.Since(meta.LastRefreshed) >= maxAge {
+		logger.Info("Token reached max age, will refresh",
+			"audience", meta.Audience,
+			"age", time.Since(meta.LastRefreshed).String())
+		entry.tokenSource = nil
+		return true
+	}
+
+	// Tokens with less life left than the audience's minimum are replaced
+	// now. They count as expiring, so one is still served if the refresh
+	// fails; the source is recreated since it may hand back the same token.
+	if entry.minLifetime > 0 {
+		skew := time.Duration(m.clockSkew.Load())
+		if remaining := time.Until(meta.ExpiresAt.Add(-skew)); remaining < entry.minLifetime {
+			logger.Info("Token below minimum lifetime, will refresh",
+				"audience", meta.Audience,
+				"expires_in", remaining.String(),
+				"min_lifetime", entry.minLifetime.String())
+			if m.expiryPhase(entry, time.Now()) == StateExpired {
+				meta.State = StateExpired
+			} else {
+				meta.State = StateExpiring
+			}
+			entry.tokenSource = nil
+			return true
+		}
+	}
+
+	switch m.expiryPhase(entry, time.Now()) {
+	case StateExpired:
+		meta.State = StateExpired
+		return true
+	case StateExpiring:
 		if meta.State != StateExpiring {
 			logger.Info("Token expiring soon, will refresh",
 				"audience", meta.Audience,
@@ -140,13 +521,15 @@ func (m *Manager) shouldRefresh(entry *TokenEntry) bool {
 			meta.State = StateExpiring
 		}
 		return true
+	default:
+		return false
 	}
-
-	return false
 }
 
-// refreshToken creates or refreshes a token
-func (m *Manager) refreshToken(entry *TokenEntry, audience string) error {
+// refreshToken creates or refreshes a token, creating the source with
+// newSource if the entry has none
+func (m *Manager) refreshToken(entry *TokenEntry, newSource SourceFunc) error {
+	audience := entry.audience
 	meta := entry.metadata
 	startTime := time.Now()
 
@@ -156,25 +539,32 @@ func (m *Manager) refreshToken(entry *TokenEntry, audience string) error {
 		"refresh_count", meta.RefreshCount)
 
 	// Create token source if needed
-	if entry.tokenSource == nil {
-		ts, err := idtoken.NewTokenSource(m.ctx, audience,
-			idtoken.WithCredentialsFile(m.credsFile))
-		if err != nil {
-			return fmt.Errorf("failed to create token source: %w", err)
-		}
-		entry.tokenSource = ts
-		logger.Debug("Token source created", "audience", audience)
+	reused := entry.tokenSource != nil
+	if err := m.ensureSource(entry, newSource); err != nil {
+		return err
 	}
 
-	// Get token
-	token, err := entry.tokenSource.Token()
+	// Get token; a reused source failing in a way a fresh one may not is
+	// recreated once before giving up
+	token, err := sourceToken(entry.tokenSource)
+	if err != nil && reused && shouldRecreateSource(err) {
+		logger.Warn("Token source failed, recreating it",
+			"audience", audience,
+			"error", err)
+		entry.tokenSource = nil
+		if err := m.ensureSource(entry, newSource); err != nil {
+			return err
+		}
+		token, err = sourceToken(entry.tokenSource)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to get token: %w", err)
+		return err
 	}
 
 	// Update metadata
 	meta.Token = token.AccessToken
 	meta.ExpiresAt = token.Expiry
+	meta.LastRefreshed = time.Now()
 	meta.RefreshCount++
 	meta.LastError = ""
 
@@ -198,8 +588,75 @@ func (m *Manager) refreshToken(entry *TokenEntry, audience string) error {
 	return nil
 }
 
+// sourceFunc returns how the audience's token source is created, with its
+// credentials file already resolved. It takes m.cacheMu, so callers resolve
+// it before locking the entry: cacheMu is always taken before an entry's
+// lock, never under it.
+func (m *Manager) sourceFunc(audience string) SourceFunc {
+	m.cacheMu.RLock()
+	defer m.cacheMu.RUnlock()
+	if fn, exists := m.audienceSources[audience]; exists {
+		return fn
+	}
+	if m.newSource != nil {
+		return m.newSource
+	}
+	file := m.credentialsFor(audience)
+	return func(ctx context.Context, audience string) (oauth2.TokenSource, error) {
+		return idTokenSource(ctx, audience, file)
+	}
+}
+
+// ensureSource creates the entry's token source with newSource if it has none
+func (m *Manager) ensureSource(entry *TokenEntry, newSource SourceFunc) error {
+	if entry.tokenSource != nil {
+		return nil
+	}
+
+	ts, err := newSource(m.ctx, entry.audience)
+	if err != nil {
+		return fmt.Errorf("failed to create token source: %w", err)
+	}
+	entry.tokenSource = ts
+	logger.Debug("Token source created", "audience", entry.audience)
+	return nil
+}
+
+// sourceToken fetches a token from ts, treating an empty access token as
+// an error
+func sourceToken(ts oauth2.TokenSource) (*oauth2.Token, error) {
+	token, err := ts.Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+	if token.AccessToken == "" {
+		return nil, ErrEmptyToken
+	}
+	return token, nil
+}
+
+// shouldRecreateSource reports whether a token error may be cured by a new
+// token source: the token endpoint rejecting the source's credentials or
+// assertion (400, 401, 403, e.g. invalid_grant after a key rotation), or an
+// empty token. Transient errors (network failures, 429, 5xx) would fail a
+// fresh source just the same and are not retried.
+func shouldRecreateSource(err error) bool {
+	if errors.Is(err, ErrEmptyToken) {
+		return true
+	}
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) && retrieveErr.Response != nil {
+		switch retrieveErr.Response.StatusCode {
+		case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+			return true
+		}
+	}
+	return false
+}
+
 // MarkRejected marks a token as rejected (e.g., 401/403 from upstream)
 func (m *Manager) MarkRejected(audience string) {
+	audience = NormalizeAudience(audience)
 	m.cacheMu.RLock()
 	entry, exists := m.cache[audience]
 	m.cacheMu.RUnlock()
@@ -224,6 +681,7 @@ func (m *Manager) MarkRejected(audience string) {
 
 // GetMetadata returns metadata for a specific audience
 func (m *Manager) GetMetadata(audience string) *TokenMetadata {
+	audience = NormalizeAudience(audience)
 	m.cacheMu.RLock()
 	entry, exists := m.cache[audience]
 	m.cacheMu.RUnlock()
@@ -242,42 +700,68 @@ func (m *Manager) GetMetadata(audience string) *TokenMetadata {
 
 // GetAllMetadata returns metadata for all cached tokens
 func (m *Manager) GetAllMetadata() map[string]*TokenMetadata {
-	m.cacheMu.RLock()
-	defer m.cacheMu.RUnlock()
-
 	result := make(map[string]*TokenMetadata)
-	for audience, entry := range m.cache {
+	for _, entry := range m.entries() {
 		entry.mu.RLock()
 		meta := *entry.metadata
 		entry.mu.RUnlock()
-		result[audience] = &meta
+		result[meta.Audience] = &meta
 	}
 
 	return result
 }
 
+// entries returns the cached entries. Callers lock each entry after cacheMu
+// is released, so an entry busy refreshing does not hold up lookups of other
+// audiences waiting on cacheMu.
+func (m *Manager) entries() []*TokenEntry {
+	m.cacheMu.RLock()
+	defer m.cacheMu.RUnlock()
+	entries := make([]*TokenEntry, 0, len(m.cache))
+	for _, entry := range m.cache {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
 // Stats returns aggregate statistics
 type Stats struct {
-	TotalCached     int
-	TotalRefreshed  int
-	TotalRejected   int
-	TotalErrors     int
-	OldestToken     time.Time
-	NewestToken     time.Time
+	TotalCached    int
+	TotalRefreshed int
+	TotalRejected  int
+	TotalErrors    int
+	OldestToken    time.Time
+	NewestToken    time.Time
+	CacheHits      int64              // GetToken calls served from a valid cached token
+	CacheMisses    int64              // GetToken calls that triggered a refresh
+	Evictions      int64              // entries evicted to stay within the size cap
+	States         map[TokenState]int // cached tokens per state
 }
 
-func (m *Manager) GetStats() Stats {
-	m.cacheMu.RLock()
-	defer m.cacheMu.RUnlock()
+// HitRatio returns the fraction of GetToken calls served from cache
+func (s Stats) HitRatio() float64 {
+	total := s.CacheHits + s.CacheMisses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.CacheHits) / float64(total)
+}
 
-	stats := Stats{}
+func (m *Manager) GetStats() Stats {
+	stats := Stats{
+		CacheHits:   m.cacheHits.Load(),
+		CacheMisses: m.cacheMisses.Load(),
+		Evictions:   m.evictions.Load(),
+		States:      make(map[TokenState]int),
+	}
 	first := true
 
-	for _, entry := range m.cache {
+	for _, entry := range m.entries() {
 		entry.mu.RLock()
 		meta := entry.metadata
 
 		stats.TotalCached++
+		stats.States[meta.State]++
 		stats.TotalRefreshed += meta.RefreshCount
 		stats.TotalRejected += meta.RejectedCount
 		stats.TotalErrors += meta.ErrorCount
@@ -300,3 +784,34 @@ func (m *Manager) GetStats() Stats {
 
 	return stats
 }
+
+// ResetStats zeroes the cumulative per-audience counters and returns the
+// aggregate values from before the reset. Cached tokens are kept.
+func (m *Manager) ResetStats() Stats {
+	stats := Stats{
+		CacheHits:   m.cacheHits.Swap(0),
+		CacheMisses: m.cacheMisses.Swap(0),
+		Evictions:   m.evictions.Swap(0),
+	}
+	for _, entry := range m.entries() {
+		entry.mu.Lock()
+		meta := entry.metadata
+
+		stats.TotalCached++
+		stats.TotalRefreshed += meta.RefreshCount
+		stats.TotalRejected += meta.RejectedCount
+		stats.TotalErrors += meta.ErrorCount
+
+		meta.RefreshCount = 0
+		meta.RejectedCount = 0
+		meta.ErrorCount = 0
+		entry.mu.Unlock()
+	}
+
+	logger.Info("Token stats reset",
+		"refreshed", stats.TotalRefreshed,
+		"rejected", stats.TotalRejected,
+		"errors", stats.TotalErrors)
+
+	return stats
+}
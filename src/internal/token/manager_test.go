@@ -0,0 +1,849 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+// fakeSource is a token source returning a fixed token or error
+type fakeSource struct {
+	token string
+	ttl   time.Duration
+	err   error
+}
+
+func (f *fakeSource) Token() (*oauth2.Token, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &oauth2.Token{AccessToken: f.token, Expiry: time.Now().Add(f.ttl)}, nil
+}
+
+// newTestManager returns a manager whose sources are created by newSource
+func newTestManager(t *testing.T, newSource func(audience string) oauth2.TokenSource) *Manager {
+	t.Helper()
+	logger.Init("error")
+	m := NewManager(context.Background(), "", 5)
+	m.SetSourceFunc(func(ctx context.Context, audience string) (oauth2.TokenSource, error) {
+		return newSource(audience), nil
+	})
+	return m
+}
+
+func TestResetStats(t *testing.T) {
+	m := newTestManager(t, func(audience string) oauth2.TokenSource {
+		if audience == "bad" {
+			return &fakeSource{err: errors.New("boom")}
+		}
+		return &fakeSource{token: "tok-" + audience, ttl: time.Hour}
+	})
+
+	m.GetToken("a")
+	m.GetToken("b")
+	m.GetToken("bad")
+	m.MarkRejected("a")
+
+	prev := m.ResetStats()
+	if prev.TotalRefreshed != 2 || prev.TotalRejected != 1 || prev.TotalErrors != 1 {
+		t.Errorf("previous stats = %+v, want refreshed=2 rejected=1 errors=1", prev)
+	}
+
+	stats := m.GetStats()
+	if stats.TotalRefreshed != 0 || stats.TotalRejected != 0 || stats.TotalErrors != 0 {
+		t.Errorf("stats after reset = %+v, want zero counters", stats)
+	}
+	if stats.TotalCached != 3 {
+		t.Errorf("TotalCached = %d, want 3 (entries are kept)", stats.TotalCached)
+	}
+	for audience, meta := range m.GetAllMetadata() {
+		if meta.RefreshCount != 0 || meta.RejectedCount != 0 || meta.ErrorCount != 0 {
+			t.Errorf("%s: counters not reset: %+v", audience, meta)
+		}
+	}
+}
+
+func TestCacheHitMissAccounting(t *testing.T) {
+	m := newTestManager(t, func(audience string) oauth2.TokenSource {
+		return &fakeSource{token: "tok", ttl: time.Hour}
+	})
+
+	for i := 0; i < 4; i++ {
+		if _, err := m.GetToken("aud"); err != nil {
+			t.Fatalf("GetToken() error = %v", err)
+		}
+	}
+
+	stats := m.GetStats()
+	if stats.CacheMisses != 1 || stats.CacheHits != 3 {
+		t.Errorf("hits/misses = %d/%d, want 3/1", stats.CacheHits, stats.CacheMisses)
+	}
+	if got := stats.HitRatio(); got != 0.75 {
+		t.Errorf("HitRatio() = %v, want 0.75", got)
+	}
+
+	// A rejection forces the next call to refresh
+	m.MarkRejected("aud")
+	m.GetToken("aud")
+	stats = m.GetStats()
+	if stats.CacheMisses != 2 || stats.CacheHits != 3 {
+		t.Errorf("after rejection hits/misses = %d/%d, want 3/2", stats.CacheHits, stats.CacheMisses)
+	}
+}
+
+func TestCacheMissOnExpiringToken(t *testing.T) {
+	// Tokens valid for less than the refresh window are refreshed every call
+	m := newTestManager(t, func(audience string) oauth2.TokenSource {
+		return &fakeSource{token: "tok", ttl: time.Minute}
+	})
+
+	m.GetToken("aud")
+	m.GetToken("aud")
+
+	stats := m.GetStats()
+	if stats.CacheMisses != 2 || stats.CacheHits != 0 {
+		t.Errorf("hits/misses = %d/%d, want 0/2", stats.CacheHits, stats.CacheMisses)
+	}
+}
+
+func TestHitRatioEmpty(t *testing.T) {
+	if got := (Stats{}).HitRatio(); got != 0 {
+		t.Errorf("HitRatio() = %v, want 0", got)
+	}
+}
+
+func TestPerAudienceRefreshWindow(t *testing.T) {
+	m := newTestManager(t, func(audience string) oauth2.TokenSource {
+		return &fakeSource{token: "tok-" + audience, ttl: 10 * time.Minute}
+	})
+	// 10-minute tokens: the global 5-minute window keeps them cached, while
+	// a 15-minute window treats them as always expiring
+	m.SetRefreshBeforeExpiry("short-lived", 15*time.Minute)
+
+	for i := 0; i < 3; i++ {
+		m.GetToken("long-lived")
+		m.GetToken("short-lived")
+	}
+
+	if got := m.GetMetadata("long-lived").RefreshCount; got != 1 {
+		t.Errorf("long-lived refreshes = %d, want 1 (global window)", got)
+	}
+	if got := m.GetMetadata("short-lived").RefreshCount; got != 3 {
+		t.Errorf("short-lived refreshes = %d, want 3 (per-audience window)", got)
+	}
+}
+
+func TestSetRefreshBeforeExpiryKeepsLongest(t *testing.T) {
+	m := newTestManager(t, func(audience string) oauth2.TokenSource {
+		return &fakeSource{token: "tok", ttl: 10 * time.Minute}
+	})
+	m.GetToken("aud")
+
+	// Applies to an existing entry, and a shorter window does not win
+	m.SetRefreshBeforeExpiry("aud", 15*time.Minute)
+	m.SetRefreshBeforeExpiry("aud", time.Minute)
+
+	m.GetToken("aud")
+	if got := m.GetMetadata("aud").RefreshCount; got != 2 {
+		t.Errorf("refreshes = %d, want 2 with the 15-minute window", got)
+	}
+}
+
+func TestCloseStopsBackgroundGoroutines(t *testing.T) {
+	m := newTestManager(t, func(audience string) oauth2.TokenSource {
+		return &fakeSource{token: "tok", ttl: time.Hour}
+	})
+
+	var stopped atomic.Int32
+	for i := 0; i < 3; i++ {
+		m.goBackground(func(ctx context.Context) {
+			<-ctx.Done()
+			stopped.Add(1)
+		})
+	}
+
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if got := stopped.Load(); got != 3 {
+		t.Errorf("stopped goroutines = %d, want 3 before Close returns", got)
+	}
+
+	// Idempotent, and the manager refuses further work
+	if err := m.Close(); err != nil {
+		t.Errorf("second Close() error = %v", err)
+	}
+	if _, err := m.GetToken("aud"); !errors.Is(err, ErrClosed) {
+		t.Errorf("GetToken() after Close error = %v, want ErrClosed", err)
+	}
+}
+
+func TestRefreshInBackground(t *testing.T) {
+	slow := &blockingSource{minting: make(chan struct{}), release: make(chan struct{})}
+	m := newTestManager(t, func(audience string) oauth2.TokenSource { return slow })
+
+	if !m.RefreshInBackground("aud") {
+		t.Fatal("RefreshInBackground() = false, want a refresh started")
+	}
+	<-slow.minting
+	if m.RefreshInBackground("aud") {
+		t.Error("RefreshInBackground() started a second refresh while one is running")
+	}
+
+	// Close waits for the refresh, which has minted by the time it returns
+	close(slow.release)
+	m.Close()
+	if meta := m.GetMetadata("aud"); meta == nil || meta.Token != "slow" {
+		t.Errorf("metadata = %+v, want the token minted in the background", meta)
+	}
+	if m.RefreshInBackground("aud") {
+		t.Error("RefreshInBackground() started a refresh after Close")
+	}
+}
+
+func TestExpiryPhaseBoundaries(t *testing.T) {
+	m := newTestManager(t, nil)
+	m.SetExpiryBoundaries(30*time.Second, 10*time.Second)
+
+	expiresAt := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	entry := &TokenEntry{
+		metadata:            &TokenMetadata{ExpiresAt: expiresAt},
+		refreshBeforeExpiry: 5 * time.Minute,
+	}
+	// Skewed expiry is 11:59:30; refresh from 11:54:30; expired from 11:59:40
+	tests := []struct {
+		at   time.Time
+		want TokenState
+	}{
+		{expiresAt.Add(-5*time.Minute - 30*time.Second - time.Nanosecond), StateCached},
+		{expiresAt.Add(-5*time.Minute - 30*time.Second), StateExpiring},
+		{expiresAt.Add(-30 * time.Second), StateExpiring},
+		{expiresAt.Add(-20*time.Second - time.Nanosecond), StateExpiring},
+		{expiresAt.Add(-20 * time.Second), StateExpired},
+		{expiresAt.Add(time.Hour), StateExpired},
+	}
+
+	for _, tt := range tests {
+		if got := m.expiryPhase(entry, tt.at); got != tt.want {
+			t.Errorf("expiryPhase(%s) = %s, want %s", tt.at.Format("15:04:05.000000000"), got, tt.want)
+		}
+	}
+}
+
+func TestExpiryPhaseDefaults(t *testing.T) {
+	m := newTestManager(t, nil)
+
+	expiresAt := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	entry := &TokenEntry{metadata: &TokenMetadata{ExpiresAt: expiresAt}}
+
+	// Without skew, grace or a refresh window, the token is valid until the
+	// instant it expires
+	if got := m.expiryPhase(entry, expiresAt.Add(-time.Nanosecond)); got != StateCached {
+		t.Errorf("just before expiry = %s, want %s", got, StateCached)
+	}
+	if got := m.expiryPhase(entry, expiresAt); got != StateExpired {
+		t.Errorf("at expiry = %s, want %s", got, StateExpired)
+	}
+}
+
+// yieldingSource gives up the processor when checked for changes, which
+// shouldRefresh does with the entry locked, widening lock-order races
+type yieldingSource struct{ fakeSource }
+
+func (y *yieldingSource) Changed() bool {
+	runtime.Gosched()
+	return false
+}
+
+// TestLockOrder runs token lookups against GetStats and ResetStats, which
+// take cacheMu before an entry's lock. Reading manager settings under
+// cacheMu from inside an entry's lock deadlocked once a writer queued.
+func TestLockOrder(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(m *Manager)
+	}{
+		{"max token age", func(m *Manager) { m.SetMaxTokenAge(time.Nanosecond) }},
+		{"expiry boundaries", func(m *Manager) { m.SetExpiryBoundaries(time.Second, time.Second) }},
+		{"min token lifetime", func(m *Manager) {
+			m.SetExpiryBoundaries(time.Second, 0)
+			m.SetMinTokenLifetime("aud", 2*time.Hour)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, func(audience string) oauth2.TokenSource {
+				return &yieldingSource{fakeSource{token: "tok", ttl: time.Hour}}
+			})
+			tt.configure(m)
+
+			// Stats are read and reset for as long as the lookups run
+			done := make(chan struct{})
+			var wg sync.WaitGroup
+			for _, fn := range []func(){
+				func() { m.GetStats() },
+				func() { m.ResetStats() },
+			} {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						select {
+						case <-done:
+							return
+						default:
+							fn()
+							runtime.Gosched()
+						}
+					}
+				}()
+			}
+			lookups := make(chan struct{})
+			go func() {
+				defer close(lookups)
+				for i := 0; i < 1000; i++ {
+					m.GetToken("aud")
+				}
+			}()
+
+			select {
+			case <-lookups:
+				close(done)
+				wg.Wait()
+			case <-time.After(10 * time.Second):
+				t.Fatal("GetToken, GetStats and ResetStats deadlocked")
+			}
+		})
+	}
+}
+
+// blockingSource mints once release is closed, signalling minting first
+type blockingSource struct {
+	minting chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingSource) Token() (*oauth2.Token, error) {
+	close(b.minting)
+	<-b.release
+	return &oauth2.Token{AccessToken: "slow", Expiry: time.Now().Add(time.Hour)}, nil
+}
+
+func TestStatsDoNotHoldUpOtherAudiences(t *testing.T) {
+	slow := &blockingSource{minting: make(chan struct{}), release: make(chan struct{})}
+	m := newTestManager(t, func(audience string) oauth2.TokenSource {
+		if audience == "slow" {
+			return slow
+		}
+		return &fakeSource{token: "tok", ttl: time.Hour}
+	})
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		m.GetToken("slow")
+	}()
+	<-slow.minting
+	defer close(slow.release)
+
+	// Stats wait for the entry being refreshed, but without holding cacheMu,
+	// so a new audience can still be added and minted meanwhile
+	go func() {
+		defer wg.Done()
+		m.GetStats()
+	}()
+	for i := 0; i < 10; i++ {
+		runtime.Gosched()
+	}
+	fast := make(chan struct{})
+	go func() {
+		m.GetToken("fast")
+		close(fast)
+	}()
+	select {
+	case <-fast:
+	case <-time.After(5 * time.Second):
+		t.Fatal("GetToken(fast) blocked behind stats waiting on another audience's refresh")
+	}
+}
+
+func TestRefreshFailureServesUnexpiredToken(t *testing.T) {
+	source := &fakeSource{token: "tok", ttl: 2 * time.Minute}
+	m := newTestManager(t, func(audience string) oauth2.TokenSource { return source })
+
+	// First mint succeeds; the token is inside the 5-minute refresh window
+	if _, err := m.GetToken("aud"); err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+
+	source.err = errors.New("idp unavailable")
+	token, err := m.GetToken("aud")
+	if err != nil || token != "tok" {
+		t.Fatalf("GetToken() = %q, %v; want cached token while unexpired", token, err)
+	}
+	if meta := m.GetMetadata("aud"); meta.ErrorCount != 1 || meta.State != StateExpiring {
+		t.Errorf("metadata = %+v, want one error and state EXPIRING", meta)
+	}
+
+	// Past the expiry boundary the failure surfaces
+	m.cache["aud"].metadata.ExpiresAt = time.Now().Add(-time.Second)
+	if _, err := m.GetToken("aud"); err == nil {
+		t.Error("GetToken() should fail once the token is expired")
+	}
+}
+
+func TestNormalizeAudience(t *testing.T) {
+	tests := []struct {
+		audience string
+		want     string
+	}{
+		{"https://svc.run.app", "https://svc.run.app"},
+		{"https://svc.run.app/", "https://svc.run.app"},
+		{"HTTPS://SVC.Run.App/", "https://svc.run.app"},
+		{"https://svc.run.app/API/", "https://svc.run.app/API"},
+		{"123-abc.apps.googleusercontent.com", "123-abc.apps.googleusercontent.com"},
+		{"/relative/", "/relative/"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeAudience(tt.audience); got != tt.want {
+			t.Errorf("NormalizeAudience(%q) = %q, want %q", tt.audience, got, tt.want)
+		}
+	}
+}
+
+func TestEquivalentAudiencesShareCacheEntry(t *testing.T) {
+	var created []string
+	m := newTestManager(t, func(audience string) oauth2.TokenSource {
+		created = append(created, audience)
+		return &fakeSource{token: "tok", ttl: time.Hour}
+	})
+
+	for _, audience := range []string{"https://svc.run.app/", "https://svc.run.app", "https://SVC.run.app"} {
+		if _, err := m.GetToken(audience); err != nil {
+			t.Fatalf("GetToken(%q) error = %v", audience, err)
+		}
+	}
+
+	// The token is minted for the audience as first configured; the
+	// normalized form only keys the cache
+	if len(created) != 1 || created[0] != "https://svc.run.app/" {
+		t.Errorf("token sources created for %v, want one for the configured audience", created)
+	}
+	if got := len(m.GetAllMetadata()); got != 1 {
+		t.Errorf("cache entries = %d, want 1", got)
+	}
+
+	m.MarkRejected("https://svc.run.app/")
+	if meta := m.GetMetadata("HTTPS://svc.run.app"); meta == nil || meta.State != StateRejected {
+		t.Errorf("metadata = %+v, want the shared entry marked rejected", meta)
+	}
+
+	if _, err := m.Refresh("HTTPS://svc.run.app"); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if len(created) != 2 || created[1] != "https://svc.run.app/" {
+		t.Errorf("token sources created for %v, want the refresh minted for the configured audience", created)
+	}
+}
+
+func TestMaxEntriesEvictsLeastRecentlyUsed(t *testing.T) {
+	m := newTestManager(t, func(audience string) oauth2.TokenSource {
+		return &fakeSource{token: audience, ttl: time.Hour}
+	})
+	m.SetMaxEntries(2)
+
+	m.GetToken("a")
+	m.GetToken("b")
+	m.GetToken("a")
+	// Backdate b's last use rather than rely on the clock ticking between calls
+	m.cacheMu.RLock()
+	b := m.cache["b"]
+	m.cacheMu.RUnlock()
+	b.mu.Lock()
+	b.metadata.LastUsed = b.metadata.LastUsed.Add(-time.Minute)
+	b.mu.Unlock()
+	m.GetToken("c")
+
+	if m.GetMetadata("b") != nil {
+		t.Error("least recently used audience b was not evicted")
+	}
+	if m.GetMetadata("a") == nil || m.GetMetadata("c") == nil {
+		t.Error("recently used audiences were evicted")
+	}
+
+	stats := m.GetStats()
+	if stats.TotalCached != 2 || stats.Evictions != 1 {
+		t.Errorf("cached = %d, evictions = %d; want 2 and 1", stats.TotalCached, stats.Evictions)
+	}
+}
+
+func TestMaxEntriesUnlimitedByDefault(t *testing.T) {
+	m := newTestManager(t, func(audience string) oauth2.TokenSource {
+		return &fakeSource{token: audience, ttl: time.Hour}
+	})
+	for _, audience := range []string{"a", "b", "c", "d"} {
+		m.GetToken(audience)
+	}
+	if stats := m.GetStats(); stats.TotalCached != 4 || stats.Evictions != 0 {
+		t.Errorf("cached = %d, evictions = %d; want 4 and 0", stats.TotalCached, stats.Evictions)
+	}
+}
+
+func TestEvictionSkipsInFlightRefresh(t *testing.T) {
+	minting, release := make(chan struct{}), make(chan struct{})
+	m := newTestManager(t, func(audience string) oauth2.TokenSource {
+		if audience == "slow" {
+			close(minting)
+			<-release
+		}
+		return &fakeSource{token: audience, ttl: time.Hour}
+	})
+	m.SetMaxEntries(1)
+
+	done := make(chan string)
+	go func() {
+		token, _ := m.GetToken("slow")
+		done <- token
+	}()
+	// Sources are created under the entry's lock, so the slow refresh now
+	// holds it
+	<-minting
+
+	// The only entry is busy, so the cap is briefly exceeded instead
+	if token, err := m.GetToken("fast"); err != nil || token != "fast" {
+		t.Fatalf("GetToken(fast) = %q, %v", token, err)
+	}
+	close(release)
+	if token := <-done; token != "slow" {
+		t.Errorf("in-flight GetToken = %q, want slow", token)
+	}
+	if meta := m.GetMetadata("slow"); meta == nil || meta.Token != "slow" {
+		t.Errorf("in-flight entry = %+v, want it kept with its token", meta)
+	}
+}
+
+func TestEvictedEntryIsLookedUpAgain(t *testing.T) {
+	m := newTestManager(t, func(audience string) oauth2.TokenSource {
+		return &fakeSource{token: audience, ttl: time.Hour}
+	})
+
+	// Simulate a caller that fetched the entry just before it was evicted
+	stale := m.cacheEntry("a", "a")
+	m.cacheMu.Lock()
+	stale.mu.Lock()
+	stale.evicted = true
+	delete(m.cache, "a")
+	stale.mu.Unlock()
+	m.cacheMu.Unlock()
+
+	if token, err := m.GetToken("a"); err != nil || token != "a" {
+		t.Fatalf("GetToken(a) = %q, %v", token, err)
+	}
+	if m.GetMetadata("a") == nil {
+		t.Error("token was stored on the detached entry instead of the cache")
+	}
+}
+
+func TestEmptyTokenIsError(t *testing.T) {
+	m := newTestManager(t, func(audience string) oauth2.TokenSource {
+		return &fakeSource{ttl: time.Hour}
+	})
+
+	if tok, err := m.GetToken("aud"); !errors.Is(err, ErrEmptyToken) {
+		t.Fatalf("GetToken() = %q, %v, want ErrEmptyToken", tok, err)
+	}
+	if meta := m.GetMetadata("aud"); meta.State != StateError {
+		t.Errorf("state = %s, want %s", meta.State, StateError)
+	}
+}
+
+// poisonedSource issues one short-lived token, then fails every later call
+// with err (or returns an empty token when err is nil), as a source holding
+// stale credentials would
+type poisonedSource struct {
+	err  error
+	used bool
+}
+
+func (p *poisonedSource) Token() (*oauth2.Token, error) {
+	if !p.used {
+		p.used = true
+		return &oauth2.Token{AccessToken: "tok-1", Expiry: time.Now().Add(time.Minute)}, nil
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &oauth2.Token{}, nil
+}
+
+func TestRefreshRecreatesPoisonedSource(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		recreate bool
+	}{
+		{"invalid grant", &oauth2.RetrieveError{Response: &http.Response{StatusCode: http.StatusBadRequest}, ErrorCode: "invalid_grant"}, true},
+		{"unauthorized", fmt.Errorf("wrapped: %w", &oauth2.RetrieveError{Response: &http.Response{StatusCode: http.StatusUnauthorized}}), true},
+		{"empty token", nil, true},
+		{"server error", &oauth2.RetrieveError{Response: &http.Response{StatusCode: http.StatusServiceUnavailable}}, false},
+		{"network error", errors.New("dial tcp: connection refused"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Only the first source is poisoned; any recreated one works
+			var created atomic.Int32
+			m := newTestManager(t, func(audience string) oauth2.TokenSource {
+				if created.Add(1) == 1 {
+					return &poisonedSource{err: tt.err}
+				}
+				return &fakeSource{token: "tok-2", ttl: time.Hour}
+			})
+
+			if tok, err := m.GetToken("aud"); err != nil || tok != "tok-1" {
+				t.Fatalf("first GetToken() = %q, %v", tok, err)
+			}
+			// The token is within the refresh window, so this call refreshes;
+			// a failed refresh still serves the unexpired token
+			tok, err := m.GetToken("aud")
+			if err != nil {
+				t.Fatalf("second GetToken() error = %v", err)
+			}
+
+			want, wantCreated := "tok-1", int32(1)
+			if tt.recreate {
+				want, wantCreated = "tok-2", 2
+			}
+			if tok != want || created.Load() != wantCreated {
+				t.Errorf("GetToken() = %q with %d sources created, want %q with %d",
+					tok, created.Load(), want, wantCreated)
+			}
+		})
+	}
+}
+
+func TestRefreshForcesNewToken(t *testing.T) {
+	var created atomic.Int64
+	var failing atomic.Bool
+	m := newTestManager(t, func(audience string) oauth2.TokenSource {
+		n := created.Add(1)
+		if failing.Load() {
+			return &fakeSource{err: errors.New("boom")}
+		}
+		return &fakeSource{token: fmt.Sprintf("tok-%d", n), ttl: time.Hour}
+	})
+
+	if tok, _ := m.GetToken("aud"); tok != "tok-1" {
+		t.Fatalf("GetToken() = %q, want tok-1", tok)
+	}
+
+	// The cached token is far from expiry, yet Refresh mints a new one
+	meta, err := m.Refresh("aud")
+	if err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if meta.Token != "tok-2" || meta.RefreshCount != 2 {
+		t.Errorf("Refresh() = %q (refresh count %d), want tok-2 after 2 refreshes", meta.Token, meta.RefreshCount)
+	}
+	if tok, _ := m.GetToken("aud"); tok != "tok-2" {
+		t.Errorf("GetToken() after Refresh = %q, want tok-2", tok)
+	}
+
+	// A failed forced refresh keeps serving the cached token and its source
+	failing.Store(true)
+	if _, err := m.Refresh("aud"); err == nil {
+		t.Fatal("Refresh() expected error from a failing source")
+	}
+	if tok, err := m.GetToken("aud"); err != nil || tok != "tok-2" {
+		t.Errorf("GetToken() after failed Refresh = %q, %v; want tok-2", tok, err)
+	}
+	if got := created.Load(); got != 3 {
+		t.Errorf("sources created = %d, want 3", got)
+	}
+}
+
+func TestMaxTokenAgeForcesRefresh(t *testing.T) {
+	// Each source keeps handing back the token it minted first, as Google's
+	// caching sources do until expiry
+	var created atomic.Int64
+	m := newTestManager(t, func(audience string) oauth2.TokenSource {
+		return &fakeSource{token: fmt.Sprintf("tok-%d", created.Add(1)), ttl: 24 * time.Hour}
+	})
+	m.SetMaxTokenAge(30 * time.Minute)
+
+	if tok, _ := m.GetToken("aud"); tok != "tok-1" {
+		t.Fatalf("GetToken() = %q, want tok-1", tok)
+	}
+	age := func(d time.Duration) {
+		m.cacheMu.RLock()
+		entry := m.cache["aud"]
+		m.cacheMu.RUnlock()
+		entry.mu.Lock()
+		entry.metadata.LastRefreshed = time.Now().Add(-d)
+		entry.mu.Unlock()
+	}
+
+	// Just under the cap the token is still served from cache
+	age(29 * time.Minute)
+	if tok, _ := m.GetToken("aud"); tok != "tok-1" {
+		t.Errorf("GetToken() under max age = %q, want cached tok-1", tok)
+	}
+
+	// At the cap it is replaced, though it has hours left before expiry
+	age(30 * time.Minute)
+	if tok, _ := m.GetToken("aud"); tok != "tok-2" {
+		t.Errorf("GetToken() at max age = %q, want a new token from a new source", tok)
+	}
+	meta := m.GetMetadata("aud")
+	if meta.RefreshCount != 2 || time.Since(meta.LastRefreshed) > time.Minute {
+		t.Errorf("metadata = %+v, want a second, recent refresh", meta)
+	}
+}
+
+func TestMinTokenLifetimeBoundary(t *testing.T) {
+	// Sources hand back the token they minted first, as Google's caching
+	// sources do until expiry
+	var created atomic.Int64
+	m := newTestManager(t, func(audience string) oauth2.TokenSource {
+		return &fakeSource{token: fmt.Sprintf("tok-%d", created.Add(1)), ttl: time.Hour}
+	})
+	m.SetMinTokenLifetime("slow", 10*time.Minute)
+
+	expireIn := func(audience string, d time.Duration) {
+		m.cacheMu.RLock()
+		entry := m.cache[audience]
+		m.cacheMu.RUnlock()
+		entry.mu.Lock()
+		entry.metadata.ExpiresAt = time.Now().Add(d)
+		entry.mu.Unlock()
+	}
+
+	if tok, _ := m.GetToken("slow"); tok != "tok-1" {
+		t.Fatalf("GetToken() = %q, want tok-1", tok)
+	}
+
+	// Just over the minimum the cached token is still good enough
+	expireIn("slow", 10*time.Minute+5*time.Second)
+	if tok, _ := m.GetToken("slow"); tok != "tok-1" {
+		t.Errorf("GetToken() above minimum = %q, want cached tok-1", tok)
+	}
+
+	// Just under it the token is replaced, though outside the refresh window
+	expireIn("slow", 10*time.Minute-time.Second)
+	if tok, _ := m.GetToken("slow"); tok != "tok-2" {
+		t.Errorf("GetToken() below minimum = %q, want a new token from a new source", tok)
+	}
+
+	// Other audiences keep the default refresh window
+	if tok, _ := m.GetToken("fast"); tok != "tok-3" {
+		t.Fatalf("GetToken(fast) = %q, want tok-3", tok)
+	}
+	expireIn("fast", 9*time.Minute)
+	if tok, _ := m.GetToken("fast"); tok != "tok-3" {
+		t.Errorf("GetToken(fast) = %q, want cached tok-3 without a minimum", tok)
+	}
+}
+
+func TestMinTokenLifetimeServesCachedOnRefreshFailure(t *testing.T) {
+	fail := false
+	m := newTestManager(t, func(audience string) oauth2.TokenSource {
+		if fail {
+			return &fakeSource{err: errors.New("boom")}
+		}
+		return &fakeSource{token: "tok", ttl: time.Hour}
+	})
+	m.SetMinTokenLifetime("slow", 10*time.Minute)
+
+	if _, err := m.GetToken("slow"); err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+	m.cacheMu.RLock()
+	entry := m.cache["slow"]
+	m.cacheMu.RUnlock()
+	entry.mu.Lock()
+	entry.metadata.ExpiresAt = time.Now().Add(5 * time.Minute)
+	entry.mu.Unlock()
+
+	// The token is below the minimum but still valid, so a failed refresh
+	// falls back to it rather than failing the request
+	fail = true
+	if tok, err := m.GetToken("slow"); err != nil || tok != "tok" {
+		t.Errorf("GetToken() = %q, %v; want the cached token", tok, err)
+	}
+}
+
+// warmingSource fails like a metadata server that is still starting until
+// ready, then mints tokens
+type warmingSource struct {
+	ready time.Time
+	calls atomic.Int32
+}
+
+func (s *warmingSource) Token() (*oauth2.Token, error) {
+	s.calls.Add(1)
+	if time.Now().Before(s.ready) {
+		return nil, errors.New("metadata server not ready")
+	}
+	return &oauth2.Token{AccessToken: "tok", Expiry: time.Now().Add(time.Hour)}, nil
+}
+
+func shortStartupBackoff(t *testing.T) {
+	t.Helper()
+	prevMin, prevMax := startupRetryMin, startupRetryMax
+	startupRetryMin, startupRetryMax = 5*time.Millisecond, 20*time.Millisecond
+	t.Cleanup(func() { startupRetryMin, startupRetryMax = prevMin, prevMax })
+}
+
+func TestAwaitFirstTokenRetriesUntilReady(t *testing.T) {
+	shortStartupBackoff(t)
+	source := &warmingSource{ready: time.Now().Add(60 * time.Millisecond)}
+	m := newTestManager(t, func(audience string) oauth2.TokenSource { return source })
+
+	if err := m.AwaitFirstToken(context.Background(), "aud", time.Second); err != nil {
+		t.Fatalf("AwaitFirstToken() error = %v", err)
+	}
+	if calls := source.calls.Load(); calls < 2 {
+		t.Errorf("source calls = %d, want retries while warming up", calls)
+	}
+	if meta := m.GetMetadata("aud"); meta == nil || meta.Token != "tok" {
+		t.Errorf("metadata = %+v, want the minted token cached", meta)
+	}
+}
+
+func TestAwaitFirstTokenGivesUp(t *testing.T) {
+	shortStartupBackoff(t)
+	source := &warmingSource{ready: time.Now().Add(time.Hour)}
+	m := newTestManager(t, func(audience string) oauth2.TokenSource { return source })
+
+	start := time.Now()
+	err := m.AwaitFirstToken(context.Background(), "aud", 50*time.Millisecond)
+	if err == nil {
+		t.Fatal("AwaitFirstToken() succeeded, want an error once the window passes")
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Errorf("gave up after %s, want about the 50ms window", elapsed)
+	}
+	if calls := source.calls.Load(); calls < 3 {
+		t.Errorf("source calls = %d, want several attempts", calls)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.AwaitFirstToken(ctx, "aud", time.Hour); !errors.Is(err, context.Canceled) {
+		t.Errorf("AwaitFirstToken() with a cancelled context error = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,58 @@
+package token
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go-oauth2-proxy/src/internal/logger"
+)
+
+// Backoff between startup mint attempts, doubling from the first to the cap
+var (
+	startupRetryMin = 250 * time.Millisecond
+	startupRetryMax = 5 * time.Second
+)
+
+// AwaitFirstToken mints a token for audience, retrying failures with
+// backoff until one succeeds or window has passed. It is meant for startup,
+// when the metadata server may be slow to answer right after the instance
+// starts; requests keep their own retry behavior. The minted token is
+// cached like any other.
+func (m *Manager) AwaitFirstToken(ctx context.Context, audience string, window time.Duration) error {
+	start := time.Now()
+	deadline := start.Add(window)
+	backoff := startupRetryMin
+
+	for attempt := 1; ; attempt++ {
+		_, err := m.GetToken(audience)
+		if err == nil {
+			logger.Info("Startup token minted",
+				"audience", audience,
+				"attempts", attempt,
+				"elapsed", time.Since(start).Round(time.Millisecond).String())
+			return nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return fmt.Errorf("no token minted for %s within %s (%d attempts): %w", audience, window, attempt, err)
+		}
+		wait := min(backoff, remaining)
+		logger.Warn("Startup token mint failed, retrying",
+			"audience", audience,
+			"attempt", attempt,
+			"retry_in", wait.String(),
+			"remaining", remaining.Round(time.Second).String(),
+			"error", err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, startupRetryMax)
+	}
+}